@@ -12,18 +12,27 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
-	"log/slog"
 	"os"
 	"os/signal"
 	"sms-gateway/internal/api"
 	"sms-gateway/internal/billing"
+	"sms-gateway/internal/clients"
 	"sms-gateway/internal/config"
 	"sms-gateway/internal/db"
 	"sms-gateway/internal/delivery"
+	"sms-gateway/internal/dlq"
+	"sms-gateway/internal/leader"
+	"sms-gateway/internal/logging"
 	"sms-gateway/internal/messages"
+	"sms-gateway/internal/metrics"
+	"sms-gateway/internal/ops"
 	"sms-gateway/internal/otp"
 	"sms-gateway/internal/providers/mock"
+	"sms-gateway/internal/ratelimit"
+	"sms-gateway/internal/retry"
+	"sms-gateway/internal/tracing"
 	"syscall"
 	"time"
 
@@ -36,11 +45,19 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	logger, err := logging.New(os.Stdout, cfg.LogLevel)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
 	logger.Info("Starting SMS Gateway API", "version", "1.0.0")
 
-	// Database
 	ctx := context.Background()
+	shutdownTracing, err := tracing.Setup(ctx, "sms-gateway-api", cfg.TracingExporter, cfg.TracingSampleRatio)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+
+	// Database
 	database, err := db.NewPostgres(ctx, cfg.PostgresURL)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
@@ -54,14 +71,61 @@ func main() {
 	// Services
 	store := messages.NewStore(database, logger)
 	billingService := billing.NewService(database, logger)
-	deliveryService := delivery.NewService(logger, store, billingService)
+
+	// Metrics
+	metrics.RegisterDB(database.DB)
+	if cfg.MetricsClientLabels {
+		metrics.EnableClientLabels()
+	}
+	policies, err := retry.ParsePolicies(cfg.RetryPolicies)
+	if err != nil {
+		log.Fatalf("Failed to load retry policies: %v", err)
+	}
+	deliveryService := delivery.NewService(logger, store, billingService, policies)
+	dlrAuth, err := delivery.ParseAuth(cfg.DLRAuth)
+	if err != nil {
+		log.Fatalf("Failed to load DLR auth: %v", err)
+	}
+	switch {
+	case !dlrAuth.Enabled():
+		logger.Warn("DLR_AUTH is none, provider webhooks are unauthenticated")
+	case cfg.DLRAuth == "":
+		logger.Warn("DLR_AUTH is not set, provider webhooks are rejected")
+	}
+	pricing := billing.NewPricing(cfg.PricePerPartCents, cfg.ExpressSurchargeCents, cfg.PrioritySurchargeCents)
+	dlqService := dlq.NewService(logger, store, billingService, pricing)
+	opsStore := ops.NewStore(database)
+	opsService := ops.NewService(logger, opsStore, opsStore, store, messages.NewMachine(logger, store, billingService), dlqService)
 
 	// SMS Provider and OTP service
 	provider := mock.NewProvider()
 	otpService := otp.NewOTPService(logger, provider)
 
+	// Rate limits, counted in Postgres so every replica shares them
+	clientService := clients.NewService(logger, clients.NewStore(database))
+	var limits *api.RateLimits
+	if cfg.RateLimitEnabled {
+		var limitStore ratelimit.Store = ratelimit.NewPostgresStore(database.DB)
+		if cfg.RateLimitStore == "memory" {
+			limitStore = ratelimit.NewMemoryStore()
+		}
+		limits = api.NewRateLimits(logger, ratelimit.New(limitStore), clientService, api.RateDefaults{
+			IPPerMinute:       cfg.RateLimitRPM,
+			RequestsPerSecond: cfg.RateLimitClientRPS,
+			MessagesPerDay:    cfg.QuotaMessagesPerDay,
+			MessagesPerMonth:  cfg.QuotaMessagesPerMonth,
+		})
+		logger.Info("Rate limiting enabled", "store", cfg.RateLimitStore, "ip_rpm", cfg.RateLimitRPM,
+			"client_rps", cfg.RateLimitClientRPS, "messages_per_day", cfg.QuotaMessagesPerDay,
+			"messages_per_month", cfg.QuotaMessagesPerMonth)
+	}
+
 	// Handlers
-	handlers := api.NewHandlers(logger, store, billingService, deliveryService, otpService, cfg.PricePerPartCents, cfg.ExpressSurchargeCents)
+	handlers := api.NewHandlers(logger, store, billingService, deliveryService, otpService, pricing, limits)
+	adminHandlers := api.NewAdminHandlers(logger, dlqService, opsService, clientService)
+	if !cfg.APIKeysRequired {
+		logger.Warn("API_KEYS_REQUIRED is not set, requests without an API key act for the client_id they name")
+	}
 
 	// App with high-concurrency configuration
 	app := fiber.New(fiber.Config{
@@ -90,7 +154,7 @@ func main() {
 		DisableHeaderNormalizing: false,
 	})
 
-	api.SetupRoutes(app, logger, handlers, cfg)
+	api.SetupRoutes(app, logger, handlers, adminHandlers, dlrAuth, clientService, limits, cfg)
 
 	// Start server
 	go func() {
@@ -99,6 +163,27 @@ func main() {
 		}
 	}()
 
+	// Sweepers only need one API replica; each runs wherever its advisory lock is held
+	host, _ := os.Hostname()
+	coordinator := leader.NewCoordinator(logger, leader.NewPostgresLocker(database.DB),
+		fmt.Sprintf("api-%s-%d", host, os.Getpid()), cfg.LeaderCheckInterval)
+	// DLRs that beat the worker's record of the send wait until its provider ID is stored
+	coordinator.Register("dlr-resolver", func(ctx context.Context) {
+		deliveryService.Run(ctx, cfg.DLRResolveInterval, cfg.DLRPendingTTL)
+	})
+	resolveCtx, stopResolve := context.WithCancel(context.Background())
+	if limits != nil {
+		if cfg.RateLimitStore == "memory" {
+			// In-process counters are this replica's own to prune
+			go limits.Run(resolveCtx, time.Minute)
+		} else {
+			coordinator.Register("rate-limit-prune", func(ctx context.Context) {
+				limits.Run(ctx, time.Minute)
+			})
+		}
+	}
+	coordinator.Start()
+
 	logger.Info("SMS Gateway API started", "port", cfg.Port)
 
 	// Graceful shutdown
@@ -107,6 +192,8 @@ func main() {
 	<-quit
 
 	logger.Info("Shutting down...")
+	stopResolve()
+	coordinator.Stop()
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -114,5 +201,9 @@ func main() {
 		logger.Error("Failed to shutdown gracefully", "error", err)
 	}
 
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("Failed to flush traces", "error", err)
+	}
+
 	logger.Info("SMS Gateway stopped")
 }
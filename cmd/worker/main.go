@@ -3,17 +3,23 @@ package main
 import (
 	"context"
 	"log"
-	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"sms-gateway/internal/billing"
 	"sms-gateway/internal/config"
 	"sms-gateway/internal/db"
+	"sms-gateway/internal/leader"
+	"sms-gateway/internal/logging"
 	"sms-gateway/internal/messages"
+	"sms-gateway/internal/metrics"
 	"sms-gateway/internal/providers/mock"
+	"sms-gateway/internal/queue"
+	"sms-gateway/internal/tracing"
 	"sms-gateway/internal/worker"
 	"syscall"
-	"time"
+
+	"github.com/nats-io/nats.go"
 )
 
 func main() {
@@ -22,11 +28,19 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	logger, err := logging.New(os.Stdout, cfg.LogLevel)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
 	logger.Info("Starting SMS Gateway Worker", "version", "1.0.0")
 
-	// Database
 	ctx := context.Background()
+	shutdownTracing, err := tracing.Setup(ctx, "sms-gateway-worker", cfg.TracingExporter, cfg.TracingSampleRatio)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+
+	// Database
 	database, err := db.NewPostgres(ctx, cfg.PostgresURL)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
@@ -37,16 +51,64 @@ func main() {
 	store := messages.NewStore(database, logger)
 	billingService := billing.NewService(database, logger)
 
+	// Metrics: the worker owns the queue, so it also reports its depth and the credits in flight
+	metrics.RegisterDB(database.DB)
+	metrics.RegisterState(logger, store, billingService)
+	if cfg.MetricsClientLabels {
+		metrics.EnableClientLabels()
+	}
+	metricsServer := &http.Server{Addr: ":" + cfg.MetricsPort, Handler: metrics.Handler()}
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("Metrics server failed", "error", err)
+		}
+	}()
+
 	// SMS Provider
 	provider := mock.NewProvider()
 
+	// Queue backend: Postgres polling by default, JetStream dispatch on request
+	workerID := worker.NewID()
+	pgQueue := queue.New(store, logger, workerID, provider.GetName(), cfg.WorkerLeaseTTL)
+	var backend queue.Backend = pgQueue
+	switch cfg.QueueBackend {
+	case "postgres":
+		if err := pgQueue.EnableOutbox(ctx, false); err != nil {
+			log.Fatalf("Failed to disable queue outbox: %v", err)
+		}
+	case "jetstream":
+		nc, err := nats.Connect(cfg.NATSURL)
+		if err != nil {
+			log.Fatalf("Failed to connect to NATS: %v", err)
+		}
+		defer nc.Close()
+
+		js, err := queue.NewJetStream(ctx, nc, pgQueue, logger, cfg.WorkerLeaseTTL, cfg.QueueRelayPeriod)
+		if err != nil {
+			log.Fatalf("Failed to set up JetStream queue: %v", err)
+		}
+		defer js.Close()
+		backend = js
+	default:
+		log.Fatalf("Unknown QUEUE_BACKEND %q", cfg.QueueBackend)
+	}
+	logger.Info("Queue backend ready", "backend", cfg.QueueBackend)
+
 	// Worker
-	w := worker.New(logger, store, billingService, provider, cfg)
+	w, err := worker.New(logger, backend, billingService, provider, cfg)
+	if err != nil {
+		log.Fatalf("Failed to create worker: %v", err)
+	}
+
+	// Singleton jobs run on whichever replica holds their advisory lock
+	coordinator := leader.NewCoordinator(logger, leader.NewPostgresLocker(database.DB), workerID, cfg.LeaderCheckInterval)
+	w.Coordinate(coordinator)
 
 	// Start worker
 	if err := w.Start(ctx); err != nil {
 		log.Fatalf("Failed to start worker: %v", err)
 	}
+	coordinator.Start()
 
 	logger.Info("SMS Gateway Worker started")
 
@@ -57,10 +119,20 @@ func main() {
 
 	logger.Info("Shutting down SMS Gateway Worker...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.WorkerStopTimeout)
 	defer cancel()
 
-	w.Stop()
+	// Hand leadership over first so another replica picks up the singleton jobs
+	coordinator.Stop()
+
+	if err := w.Stop(ctx); err != nil {
+		logger.Error("Worker did not stop cleanly; unfinished claims will be reaped", "error", err)
+	}
+	metricsServer.Shutdown(ctx)
+
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("Failed to flush traces", "error", err)
+	}
 
 	logger.Info("SMS Gateway Worker stopped")
 }
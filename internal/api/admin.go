@@ -0,0 +1,344 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"sms-gateway/internal/clients"
+	"sms-gateway/internal/dlq"
+	"sms-gateway/internal/messages"
+	"sms-gateway/internal/ops"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// AdminHandlers serves the operator endpoints under /admin
+type AdminHandlers struct {
+	logger  *slog.Logger
+	dlq     *dlq.Service
+	ops     *ops.Service
+	clients *clients.Service
+}
+
+func NewAdminHandlers(logger *slog.Logger, dlq *dlq.Service, ops *ops.Service, clients *clients.Service) *AdminHandlers {
+	return &AdminHandlers{
+		logger:  logger,
+		dlq:     dlq,
+		ops:     ops,
+		clients: clients,
+	}
+}
+
+// DeadLetterFilter is the query (or replay body) used to select dead letters
+type DeadLetterFilter struct {
+	Error    string `json:"error" query:"error"`
+	Provider string `json:"provider" query:"provider"`
+	ClientID string `json:"client_id" query:"client_id"`
+	From     string `json:"from" query:"from"` // RFC3339
+	To       string `json:"to" query:"to"`     // RFC3339
+	Limit    int    `json:"limit" query:"limit"`
+}
+
+func (f *DeadLetterFilter) parse() (messages.FailedFilter, error) {
+	out := messages.FailedFilter{Error: f.Error, Provider: f.Provider, Limit: f.Limit}
+	if f.ClientID != "" {
+		id, err := uuid.Parse(f.ClientID)
+		if err != nil {
+			return out, fiber.NewError(400, "invalid client_id format")
+		}
+		out.ClientID = &id
+	}
+	if f.From != "" {
+		t, err := time.Parse(time.RFC3339, f.From)
+		if err != nil {
+			return out, fiber.NewError(400, "from must be RFC3339")
+		}
+		out.From = &t
+	}
+	if f.To != "" {
+		t, err := time.Parse(time.RFC3339, f.To)
+		if err != nil {
+			return out, fiber.NewError(400, "to must be RFC3339")
+		}
+		out.To = &t
+	}
+	return out, nil
+}
+
+// ReplayRequest selects dead letters to replay, either by ID or by filter
+type ReplayRequest struct {
+	MessageIDs []uuid.UUID       `json:"message_ids"`
+	Filter     *DeadLetterFilter `json:"filter"`
+}
+
+// ListDeadLetters handles GET /admin/dlq
+//
+//	@Summary		List dead letters
+//	@Description	List permanently failed messages filtered by error, provider, client and failure time
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			error		query		string	false	"Substring of last_error"
+//	@Param			provider	query		string	false	"Provider name"
+//	@Param			client_id	query		string	false	"Client ID"
+//	@Param			from		query		string	false	"Failed at or after (RFC3339)"
+//	@Param			to			query		string	false	"Failed before (RFC3339)"
+//	@Param			limit		query		int		false	"Max results (default and max 1000)"
+//	@Success		200			{array}		messages.Message
+//	@Failure		400			{object}	map[string]string	"Bad request"
+//	@Router			/admin/dlq [get]
+func (h *AdminHandlers) ListDeadLetters(c *fiber.Ctx) error {
+	var q DeadLetterFilter
+	if err := c.QueryParser(&q); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid query"})
+	}
+	filter, err := q.parse()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	msgs, err := h.dlq.List(c.UserContext(), filter)
+	if err != nil {
+		h.logger.ErrorContext(c.UserContext(), "failed to list dead letters", "error", err)
+		return c.Status(500).JSON(fiber.Map{"error": "internal error"})
+	}
+	if msgs == nil {
+		msgs = []*messages.Message{}
+	}
+	return c.JSON(msgs)
+}
+
+// ReplayDeadLetters handles POST /admin/dlq/replay
+//
+//	@Summary		Replay dead letters
+//	@Description	Requeue permanently failed messages, holding their credits again
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		ReplayRequest			true	"Message IDs or filter"
+//	@Success		200		{object}	map[string]interface{}	"Per-message outcomes"
+//	@Failure		400		{object}	map[string]string		"Bad request"
+//	@Router			/admin/dlq/replay [post]
+func (h *AdminHandlers) ReplayDeadLetters(c *fiber.Ctx) error {
+	var req ReplayRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+	}
+	if len(req.MessageIDs) == 0 && req.Filter == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "message_ids or filter required"})
+	}
+	if len(req.MessageIDs) > dlq.MaxBatch {
+		return c.Status(400).JSON(fiber.Map{"error": "too many message_ids", "max": dlq.MaxBatch})
+	}
+
+	actor := "admin:" + c.IP()
+	var results []dlq.ReplayResult
+	if len(req.MessageIDs) > 0 {
+		results = h.dlq.Replay(c.UserContext(), req.MessageIDs, actor)
+	} else {
+		filter, err := req.Filter.parse()
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		results, err = h.dlq.ReplayFiltered(c.UserContext(), filter, actor)
+		if err != nil {
+			h.logger.ErrorContext(c.UserContext(), "failed to replay dead letters", "error", err)
+			return c.Status(500).JSON(fiber.Map{"error": "internal error"})
+		}
+	}
+
+	requeued := 0
+	for _, r := range results {
+		if r.Outcome == "requeued" {
+			requeued++
+		}
+	}
+	h.logger.InfoContext(c.UserContext(), "Dead letters replayed", "requested", len(results), "requeued", requeued, "actor", actor)
+
+	return c.JSON(fiber.Map{"requeued": requeued, "results": results})
+}
+
+// PauseRequest selects what to pause or resume. Target is a client ID for
+// the client scope, a provider name for the provider scope and empty for
+// the global scope.
+type PauseRequest struct {
+	Scope  ops.Scope `json:"scope"`
+	Target string    `json:"target"`
+	Reason string    `json:"reason"`
+}
+
+// DrainRequest says why a provider is being drained
+type DrainRequest struct {
+	Reason string `json:"reason"`
+}
+
+// Overview handles GET /admin/overview
+//
+//	@Summary		Operations overview
+//	@Description	Queue depth by status, priority and client, oldest queued age, throughput, provider health, top error codes and active pauses
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			window	query		string	false	"Look-back for throughput and provider health, e.g. 15m (default 5m, max 24h)"
+//	@Success		200		{object}	ops.Overview
+//	@Failure		400		{object}	map[string]string	"Bad request"
+//	@Router			/admin/overview [get]
+func (h *AdminHandlers) Overview(c *fiber.Ctx) error {
+	var window time.Duration
+	if w := c.Query("window"); w != "" {
+		d, err := time.ParseDuration(w)
+		if err != nil || d <= 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "window must be a positive duration, e.g. 15m"})
+		}
+		window = d
+	}
+
+	overview, err := h.ops.Overview(c.UserContext(), window)
+	if err != nil {
+		h.logger.ErrorContext(c.UserContext(), "failed to build overview", "error", err)
+		return c.Status(500).JSON(fiber.Map{"error": "internal error"})
+	}
+	return c.JSON(overview)
+}
+
+// ListPauses handles GET /admin/pauses
+//
+//	@Summary		List pauses
+//	@Description	List the global, client and provider pauses workers are honouring
+//	@Tags			Admin
+//	@Produce		json
+//	@Success		200	{array}	ops.Pause
+//	@Router			/admin/pauses [get]
+func (h *AdminHandlers) ListPauses(c *fiber.Ctx) error {
+	pauses, err := h.ops.Pauses(c.UserContext())
+	if err != nil {
+		h.logger.ErrorContext(c.UserContext(), "failed to list pauses", "error", err)
+		return c.Status(500).JSON(fiber.Map{"error": "internal error"})
+	}
+	if pauses == nil {
+		pauses = ops.Pauses{}
+	}
+	return c.JSON(pauses)
+}
+
+// Pause handles POST /admin/pause
+//
+//	@Summary		Pause sending
+//	@Description	Stop every worker claiming messages globally, for one client or for one provider. Messages stay queued.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		PauseRequest		true	"What to pause"
+//	@Success		200		{object}	ops.Pause
+//	@Failure		400		{object}	map[string]string	"Bad request"
+//	@Router			/admin/pause [post]
+func (h *AdminHandlers) Pause(c *fiber.Ctx) error {
+	var req PauseRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+	}
+
+	p := ops.Pause{Scope: req.Scope, Target: req.Target, Mode: ops.ModePause, Reason: req.Reason, Actor: "admin:" + c.IP()}
+	pause, err := h.ops.Pause(c.UserContext(), p)
+	if errors.Is(err, ops.ErrInvalidPause) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		h.logger.ErrorContext(c.UserContext(), "failed to pause", "error", err)
+		return c.Status(500).JSON(fiber.Map{"error": "internal error"})
+	}
+	return c.JSON(pause)
+}
+
+// Resume handles POST /admin/resume
+//
+//	@Summary		Resume sending
+//	@Description	Lift a global, client or provider pause or a provider drain
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		PauseRequest		true	"What to resume"
+//	@Success		200		{object}	map[string]bool		"Whether a pause was lifted"
+//	@Failure		400		{object}	map[string]string	"Bad request"
+//	@Router			/admin/resume [post]
+func (h *AdminHandlers) Resume(c *fiber.Ctx) error {
+	var req PauseRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+	}
+
+	resumed, err := h.ops.Resume(c.UserContext(), req.Scope, req.Target, "admin:"+c.IP())
+	if errors.Is(err, ops.ErrInvalidPause) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		h.logger.ErrorContext(c.UserContext(), "failed to resume", "error", err)
+		return c.Status(500).JSON(fiber.Map{"error": "internal error"})
+	}
+	return c.JSON(fiber.Map{"resumed": resumed})
+}
+
+// DrainProvider handles POST /admin/providers/:provider/drain
+//
+//	@Summary		Drain a provider
+//	@Description	Stop new sends through a provider and report the sends still in flight. Repeat to follow progress; resume the provider when done.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			provider	path		string			true	"Provider name"
+//	@Param			request		body		DrainRequest	false	"Reason"
+//	@Success		200			{object}	ops.DrainStatus
+//	@Router			/admin/providers/{provider}/drain [post]
+func (h *AdminHandlers) DrainProvider(c *fiber.Ctx) error {
+	var req DrainRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+		}
+	}
+
+	status, err := h.ops.Drain(c.UserContext(), c.Params("provider"), req.Reason, "admin:"+c.IP())
+	if errors.Is(err, ops.ErrInvalidPause) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		h.logger.ErrorContext(c.UserContext(), "failed to drain provider", "error", err)
+		return c.Status(500).JSON(fiber.Map{"error": "internal error"})
+	}
+	return c.JSON(status)
+}
+
+// RequeueMessage handles POST /admin/messages/:id/requeue
+//
+//	@Summary		Requeue a message
+//	@Description	Send a failed message back to the queue. FAILED_TEMP skips its backoff; FAILED_PERM is replayed with fresh credits.
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		string	true	"Message ID"
+//	@Success		200	{object}	ops.RequeueResult
+//	@Failure		400	{object}	map[string]string	"Bad request"
+//	@Failure		404	{object}	map[string]string	"Message not found"
+//	@Failure		409	{object}	ops.RequeueResult	"Message cannot be requeued"
+//	@Router			/admin/messages/{id}/requeue [post]
+func (h *AdminHandlers) RequeueMessage(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid message ID format"})
+	}
+
+	res, err := h.ops.Requeue(c.UserContext(), id, "admin:"+c.IP())
+	if errors.Is(err, ops.ErrNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "message not found"})
+	}
+	if err != nil {
+		h.logger.ErrorContext(c.UserContext(), "failed to requeue message", "error", err)
+		return c.Status(500).JSON(fiber.Map{"error": "internal error"})
+	}
+	switch res.Outcome {
+	case "requeued":
+		return c.JSON(res)
+	case "error":
+		return c.Status(500).JSON(res)
+	default:
+		return c.Status(409).JSON(res)
+	}
+}
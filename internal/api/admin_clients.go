@@ -0,0 +1,330 @@
+package api
+
+import (
+	"errors"
+	"sms-gateway/internal/clients"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// RotateKeyRequest sets how long the replaced key keeps working
+type RotateKeyRequest struct {
+	Overlap string `json:"overlap"` // Duration, default 24h
+}
+
+// clientError maps client service errors to responses
+func (h *AdminHandlers) clientError(c *fiber.Ctx, err error, action string) error {
+	switch {
+	case errors.Is(err, clients.ErrInvalid):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, clients.ErrNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": "client not found"})
+	case errors.Is(err, clients.ErrKeyNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, clients.ErrInUse):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error() + "; suspend it instead"})
+	}
+	h.logger.ErrorContext(c.UserContext(), "failed to "+action, "error", err)
+	return c.Status(500).JSON(fiber.Map{"error": "internal error"})
+}
+
+// CreateClient handles POST /admin/clients
+//
+//	@Summary		Create client
+//	@Description	Create a client and issue its first API key, with every scope. The key is shown only in this response.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		clients.CreateRequest	true	"Client"
+//	@Success		201		{object}	clients.Issued
+//	@Failure		400		{object}	map[string]string	"Bad request"
+//	@Router			/admin/clients [post]
+func (h *AdminHandlers) CreateClient(c *fiber.Ctx) error {
+	var req clients.CreateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+	}
+	issued, err := h.clients.Create(c.UserContext(), &req, "admin:"+c.IP())
+	if err != nil {
+		return h.clientError(c, err, "create client")
+	}
+	return c.Status(201).JSON(issued)
+}
+
+// ListClients handles GET /admin/clients
+//
+//	@Summary		List clients
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			limit	query	int	false	"Max results (default and max 500)"
+//	@Param			offset	query	int	false	"Offset"
+//	@Success		200		{array}	clients.Client
+//	@Router			/admin/clients [get]
+func (h *AdminHandlers) ListClients(c *fiber.Ctx) error {
+	list, err := h.clients.List(c.UserContext(), c.QueryInt("limit"), c.QueryInt("offset"))
+	if err != nil {
+		return h.clientError(c, err, "list clients")
+	}
+	if list == nil {
+		list = []*clients.Client{}
+	}
+	return c.JSON(list)
+}
+
+// GetClient handles GET /admin/clients/:id
+//
+//	@Summary		Get client
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		string	true	"Client ID"
+//	@Success		200	{object}	clients.Client
+//	@Failure		404	{object}	map[string]string	"Client not found"
+//	@Router			/admin/clients/{id} [get]
+func (h *AdminHandlers) GetClient(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid client ID format"})
+	}
+	client, err := h.clients.Get(c.UserContext(), id)
+	if err != nil {
+		return h.clientError(c, err, "get client")
+	}
+	return c.JSON(client)
+}
+
+// UpdateClient handles PATCH /admin/clients/:id
+//
+//	@Summary		Update client
+//	@Description	Change name, callback URL and secret, credit limit, suspension or unique references. Suspended clients get 403 on send.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string					true	"Client ID"
+//	@Param			request	body		clients.UpdateRequest	true	"Fields to change"
+//	@Success		200		{object}	clients.Client
+//	@Failure		400		{object}	map[string]string	"Bad request"
+//	@Failure		404		{object}	map[string]string	"Client not found"
+//	@Router			/admin/clients/{id} [patch]
+func (h *AdminHandlers) UpdateClient(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid client ID format"})
+	}
+	var req clients.UpdateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+	}
+	client, err := h.clients.Update(c.UserContext(), id, &req, "admin:"+c.IP())
+	if err != nil {
+		return h.clientError(c, err, "update client")
+	}
+	return c.JSON(client)
+}
+
+// DeleteClient handles DELETE /admin/clients/:id
+//
+//	@Summary		Delete client
+//	@Description	Delete a client that has never sent anything. Clients with history must be suspended instead.
+//	@Tags			Admin
+//	@Param			id	path	string	true	"Client ID"
+//	@Success		204
+//	@Failure		404	{object}	map[string]string	"Client not found"
+//	@Failure		409	{object}	map[string]string	"Client has history"
+//	@Router			/admin/clients/{id} [delete]
+func (h *AdminHandlers) DeleteClient(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid client ID format"})
+	}
+	if err := h.clients.Delete(c.UserContext(), id, "admin:"+c.IP()); err != nil {
+		return h.clientError(c, err, "delete client")
+	}
+	return c.SendStatus(204)
+}
+
+// clientAndKey parses the client and key IDs in the path
+func clientAndKey(c *fiber.Ctx) (clientID, keyID uuid.UUID, err error) {
+	if clientID, err = uuid.Parse(c.Params("id")); err != nil {
+		return clientID, keyID, fiber.NewError(400, "invalid client ID format")
+	}
+	if keyID, err = uuid.Parse(c.Params("key")); err != nil {
+		return clientID, keyID, fiber.NewError(400, "invalid key ID format")
+	}
+	return clientID, keyID, nil
+}
+
+// CreateClientKey handles POST /admin/clients/:id/keys
+//
+//	@Summary		Create API key
+//	@Description	Issue a scoped API key (send, send:otp, read, billing), optionally limited to addresses and with an expiry. The key is shown only in this response.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string				true	"Client ID"
+//	@Param			request	body		clients.KeyRequest	true	"Key"
+//	@Success		201		{object}	clients.Issued
+//	@Failure		400		{object}	map[string]string	"Bad request"
+//	@Failure		404		{object}	map[string]string	"Client not found"
+//	@Router			/admin/clients/{id}/keys [post]
+func (h *AdminHandlers) CreateClientKey(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid client ID format"})
+	}
+	var req clients.KeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+	}
+	issued, err := h.clients.CreateKey(c.UserContext(), id, &req, "admin:"+c.IP())
+	if err != nil {
+		return h.clientError(c, err, "create API key")
+	}
+	return c.Status(201).JSON(issued)
+}
+
+// ListClientKeys handles GET /admin/clients/:id/keys
+//
+//	@Summary		List API keys
+//	@Description	A client's keys with scopes, allowlist, expiry and last use. Revoked and expired keys are included.
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		string	true	"Client ID"
+//	@Success		200	{array}		clients.APIKey
+//	@Failure		404	{object}	map[string]string	"Client not found"
+//	@Router			/admin/clients/{id}/keys [get]
+func (h *AdminHandlers) ListClientKeys(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid client ID format"})
+	}
+	keys, err := h.clients.ListKeys(c.UserContext(), id)
+	if err != nil {
+		return h.clientError(c, err, "list API keys")
+	}
+	if keys == nil {
+		keys = []*clients.APIKey{}
+	}
+	return c.JSON(keys)
+}
+
+// RevokeClientKey handles DELETE /admin/clients/:id/keys/:key
+//
+//	@Summary		Revoke API key
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		string	true	"Client ID"
+//	@Param			key	path		string	true	"Key ID"
+//	@Success		200	{object}	clients.APIKey
+//	@Failure		404	{object}	map[string]string	"Key not found"
+//	@Router			/admin/clients/{id}/keys/{key} [delete]
+func (h *AdminHandlers) RevokeClientKey(c *fiber.Ctx) error {
+	clientID, keyID, err := clientAndKey(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	key, err := h.clients.RevokeKey(c.UserContext(), clientID, keyID, "admin:"+c.IP())
+	if err != nil {
+		return h.clientError(c, err, "revoke API key")
+	}
+	return c.JSON(key)
+}
+
+// RotateClientKey handles POST /admin/clients/:id/keys/:key/rotate
+//
+//	@Summary		Rotate API key
+//	@Description	Replace a key with a new one with the same scopes and allowlist, shown only in this response. The old key keeps working for the overlap.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string				true	"Client ID"
+//	@Param			key		path		string				true	"Key ID"
+//	@Param			request	body		RotateKeyRequest	false	"Overlap"
+//	@Success		200		{object}	clients.Issued
+//	@Failure		400		{object}	map[string]string	"Bad request"
+//	@Failure		404		{object}	map[string]string	"Key not found"
+//	@Router			/admin/clients/{id}/keys/{key}/rotate [post]
+func (h *AdminHandlers) RotateClientKey(c *fiber.Ctx) error {
+	clientID, keyID, err := clientAndKey(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	var req RotateKeyRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+		}
+	}
+	var overlap time.Duration
+	if req.Overlap != "" {
+		if overlap, err = time.ParseDuration(req.Overlap); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "overlap must be a duration, e.g. 24h"})
+		}
+	}
+
+	issued, err := h.clients.RotateKey(c.UserContext(), clientID, keyID, overlap, "admin:"+c.IP())
+	if err != nil {
+		return h.clientError(c, err, "rotate API key")
+	}
+	return c.JSON(issued)
+}
+
+// SetClientLimits handles PUT /admin/clients/:id/limits
+//
+//	@Summary		Set client limits
+//	@Description	Replace a client's requests per second and messages per day and month. Null falls back to the configured default, 0 is unlimited.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string			true	"Client ID"
+//	@Param			request	body		clients.Limits	true	"Limits"
+//	@Success		200		{object}	clients.Client
+//	@Failure		400		{object}	map[string]string	"Bad request"
+//	@Failure		404		{object}	map[string]string	"Client not found"
+//	@Router			/admin/clients/{id}/limits [put]
+func (h *AdminHandlers) SetClientLimits(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid client ID format"})
+	}
+	var limits clients.Limits
+	if err := c.BodyParser(&limits); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+	}
+	client, err := h.clients.SetLimits(c.UserContext(), id, limits, "admin:"+c.IP())
+	if err != nil {
+		return h.clientError(c, err, "set client limits")
+	}
+	return c.JSON(client)
+}
+
+// SetClientKeyLimits handles PUT /admin/clients/:id/keys/:key/limits
+//
+//	@Summary		Set API key limits
+//	@Description	Replace a key's requests per second and messages per day and month, enforced on top of its client's. Null or 0 is unlimited.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string			true	"Client ID"
+//	@Param			key		path		string			true	"Key ID"
+//	@Param			request	body		clients.Limits	true	"Limits"
+//	@Success		200		{object}	clients.APIKey
+//	@Failure		400		{object}	map[string]string	"Bad request"
+//	@Failure		404		{object}	map[string]string	"Key not found"
+//	@Router			/admin/clients/{id}/keys/{key}/limits [put]
+func (h *AdminHandlers) SetClientKeyLimits(c *fiber.Ctx) error {
+	clientID, keyID, err := clientAndKey(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	var limits clients.Limits
+	if err := c.BodyParser(&limits); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+	}
+	key, err := h.clients.SetKeyLimits(c.UserContext(), clientID, keyID, limits, "admin:"+c.IP())
+	if err != nil {
+		return h.clientError(c, err, "set API key limits")
+	}
+	return c.JSON(key)
+}
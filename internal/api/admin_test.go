@@ -0,0 +1,114 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"sms-gateway/internal/billing"
+	"sms-gateway/internal/dlq"
+	"sms-gateway/internal/messages"
+	"sms-gateway/internal/ops"
+	"sms-gateway/internal/queue"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+func TestAdminAuth(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	tests := []struct {
+		name     string
+		token    string
+		header   string
+		expected int
+	}{
+		{"disabled", "", "Bearer anything", 403},
+		{"missing", "secret", "", 401},
+		{"wrong", "secret", "Bearer nope", 401},
+		{"valid", "secret", "Bearer secret", 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Get("/admin/ping", AdminAuth(logger, tt.token), func(c *fiber.Ctx) error {
+				return c.SendStatus(200)
+			})
+
+			req := httptest.NewRequest("GET", "/admin/ping", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestAdminPauseAndRequeue(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	ctx := context.Background()
+	store := messages.NewMemoryStore()
+	ledger := billing.NewMemoryService()
+	table := queue.NewMemoryTable(store)
+	replay := dlq.NewService(logger, store, ledger, billing.NewPricing(5, 2, nil))
+	service := ops.NewService(logger, table, nil, store, messages.NewMachine(logger, store, ledger), replay)
+	admin := NewAdminHandlers(logger, replay, service, nil)
+
+	app := fiber.New()
+	app.Post("/admin/pause", admin.Pause)
+	app.Post("/admin/resume", admin.Resume)
+	app.Post("/admin/messages/:id/requeue", admin.RequeueMessage)
+
+	post := func(path string, body any) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", path, bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	queued := &messages.Message{ID: uuid.New(), ClientID: clientID, Status: messages.StatusQueued, CreatedAt: time.Now()}
+	store.Create(ctx, queued)
+
+	if status := post("/admin/pause", PauseRequest{Scope: "client", Target: "acme"}); status != 400 {
+		t.Errorf("Expected status 400 for a bad client target, got %d", status)
+	}
+	if status := post("/admin/pause", PauseRequest{Scope: "client", Target: clientID.String(), Reason: "abuse"}); status != 200 {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if msgs, _ := table.Queue("w", "mock", time.Minute).Poll(ctx, 10); len(msgs) != 0 {
+		t.Fatalf("Expected the paused client's message to stay queued, got %v", msgs)
+	}
+	if status := post("/admin/resume", PauseRequest{Scope: "client", Target: clientID.String()}); status != 200 {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if pauses, _ := table.Pauses(ctx); len(pauses) != 0 {
+		t.Errorf("Expected no pauses after resume, got %v", pauses)
+	}
+
+	// The message is QUEUED, so there is nothing to requeue
+	if status := post("/admin/messages/"+queued.ID.String()+"/requeue", nil); status != 409 {
+		t.Errorf("Expected status 409 for a queued message, got %d", status)
+	}
+	if status := post("/admin/messages/"+uuid.NewString()+"/requeue", nil); status != 404 {
+		t.Errorf("Expected status 404 for an unknown message, got %d", status)
+	}
+}
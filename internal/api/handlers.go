@@ -2,12 +2,18 @@ package api
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"sms-gateway/internal/billing"
+	"sms-gateway/internal/clients"
 	"sms-gateway/internal/delivery"
+	"sms-gateway/internal/logging"
 	"sms-gateway/internal/messages"
 	"sms-gateway/internal/otp"
+	"sms-gateway/internal/ratelimit"
+	"sms-gateway/internal/tracing"
 	"strings"
 	"time"
 
@@ -16,39 +22,45 @@ import (
 )
 
 type Handlers struct {
-	logger       *slog.Logger
-	store        *messages.Store
-	billing      *billing.Service
-	delivery     *delivery.Service
-	otpService   *otp.OTPService
-	pricePerPart int64
-	expressCost  int64
+	logger     *slog.Logger
+	store      messages.Repository
+	billing    billing.Ledger
+	machine    *messages.Machine
+	delivery   *delivery.Service
+	otpService *otp.OTPService
+	pricing    billing.Pricing
+	limits     *RateLimits
 }
 
-func NewHandlers(logger *slog.Logger, store *messages.Store, billing *billing.Service, delivery *delivery.Service, otpService *otp.OTPService, pricePerPart, expressCost int64) *Handlers {
+func NewHandlers(logger *slog.Logger, store messages.Repository, billing billing.Ledger, delivery *delivery.Service, otpService *otp.OTPService, pricing billing.Pricing, limits *RateLimits) *Handlers {
 	return &Handlers{
-		logger:       logger,
-		store:        store,
-		billing:      billing,
-		delivery:     delivery,
-		otpService:   otpService,
-		pricePerPart: pricePerPart,
-		expressCost:  expressCost,
+		logger:     logger,
+		store:      store,
+		billing:    billing,
+		machine:    messages.NewMachine(logger, store, billing),
+		delivery:   delivery,
+		otpService: otpService,
+		pricing:    pricing,
+		limits:     limits,
 	}
 }
 
 // SendMessage handles POST /v1/messages
 //
 //	@Summary		Send SMS
-//	@Description	Send SMS message (regular, OTP, or Express)
+//	@Description	Send SMS message (regular, OTP, or with a priority class: otp, transactional, express, standard, bulk)
 //	@Tags			Messages
 //	@Accept			json
 //	@Produce		json
 //	@Param			request	body		messages.SendRequest	true	"SMS request"
-//	@Success		200		{object}	messages.SendResponse	"OTP delivered immediately"
+//	@Success		200		{object}	messages.SendResponse	"OTP delivered immediately, or the original message of a reused reference"
 //	@Success		202		{object}	messages.SendResponse	"Message queued"
 //	@Failure		400		{object}	map[string]string		"Bad request"
 //	@Failure		402		{object}	map[string]interface{}	"Insufficient credits"
+//	@Failure		409		{object}	map[string]interface{}	"Reference already used for another recipient"
+//	@Failure		429		{object}	map[string]string		"Request rate or message quota exceeded"
+//	@Failure		401		{object}	map[string]string		"Missing or unknown API key"
+//	@Failure		403		{object}	map[string]string		"Client suspended, or API key not allowed to send this"
 //	@Failure		503		{object}	map[string]string		"OTP delivery failed"
 //	@Router			/v1/messages [post]
 func (h *Handlers) SendMessage(c *fiber.Ctx) error {
@@ -56,6 +68,11 @@ func (h *Handlers) SendMessage(c *fiber.Ctx) error {
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
 	}
+	clientID, ok := requestClient(c, req.ClientID)
+	if !ok {
+		return c.Status(403).JSON(fiber.Map{"error": "client_id does not match api key"})
+	}
+	req.ClientID = clientID
 
 	// Validate required fields
 	if req.ClientID.String() == "00000000-0000-0000-0000-000000000000" {
@@ -64,36 +81,60 @@ func (h *Handlers) SendMessage(c *fiber.Ctx) error {
 	if req.To == "" || req.From == "" || (!req.OTP && req.Text == "") {
 		return c.Status(400).JSON(fiber.Map{"error": "missing required fields"})
 	}
+	if req.Reference != nil && *req.Reference == "" {
+		req.Reference = nil
+	}
+	// The route admits send:otp keys, which may send nothing but OTPs
+	if key := apiKey(c); key != nil && !req.OTP && !key.Allows(clients.ScopeSend) {
+		return c.Status(403).JSON(fiber.Map{"error": "api key lacks scope", "required": []clients.Scope{clients.ScopeSend}})
+	}
+	c.SetUserContext(logging.WithClientID(c.UserContext(), req.ClientID))
+
+	priority := messages.ResolvePriority(req.Priority, req.Express)
+	if !req.OTP && !priority.Valid() {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid priority"})
+	}
+
+	// Count the message against the client's quotas; it is given back if the
+	// send is not accepted
+	release, err := h.limits.TakeMessage(c, req.ClientID)
+	if errors.Is(err, ratelimit.ErrLimited) {
+		return c.Status(429).JSON(fiber.Map{"error": "message quota exceeded"})
+	}
 
 	// Handle OTP with delivery guarantee (as per PDF requirement)
 	if req.OTP {
-		return h.handleOTPMessage(c, &req)
+		return h.handleOTPMessage(c, &req, release)
 	}
 
 	// Calculate cost
 	parts := messages.CalculateParts(req.Text)
-	cost := int64(parts) * h.pricePerPart
-	if req.Express {
-		cost += int64(parts) * h.expressCost
-	}
+	cost := h.pricing.Cost(parts, priority)
 
 	// Create message
 	msg := &messages.Message{
-		ID:        uuid.New(),
-		ClientID:  req.ClientID,
-		To:        req.To,
-		From:      req.From,
-		Text:      req.Text,
-		Parts:     parts,
-		Status:    messages.StatusQueued,
-		Reference: req.Reference,
-		Express:   req.Express,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-
-	if err := h.store.Create(c.Context(), msg); err != nil {
-		h.logger.Error("failed to create message", "error", err)
+		ID:          uuid.New(),
+		ClientID:    req.ClientID,
+		To:          req.To,
+		From:        req.From,
+		Text:        req.Text,
+		Parts:       parts,
+		Status:      messages.StatusQueued,
+		Reference:   req.Reference,
+		Express:     req.Express || priority == messages.PriorityExpress,
+		Priority:    priority,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+		TraceParent: tracing.TraceParent(c.UserContext()),
+	}
+	c.SetUserContext(logging.WithMessageID(c.UserContext(), msg.ID))
+
+	if err := h.store.Create(c.UserContext(), msg); err != nil {
+		release()
+		if errors.Is(err, messages.ErrDuplicateReference) {
+			return h.duplicateReference(c, req)
+		}
+		h.logger.ErrorContext(c.UserContext(), "failed to create message", "error", err)
 		// Check for foreign key constraint violation (invalid client_id)
 		if strings.Contains(err.Error(), "foreign key constraint") || strings.Contains(err.Error(), "client_id_fkey") {
 			return c.Status(400).JSON(fiber.Map{"error": "invalid client_id"})
@@ -102,12 +143,16 @@ func (h *Handlers) SendMessage(c *fiber.Ctx) error {
 	}
 
 	// Hold credits for the message
-	if _, err := h.billing.HoldCredits(c.Context(), req.ClientID, msg.ID, cost); err != nil {
-		h.store.Delete(c.Context(), msg.ID)
-		return c.Status(402).JSON(fiber.Map{"error": "insufficient credits", "required": cost})
+	if _, err := h.billing.HoldCredits(c.UserContext(), req.ClientID, msg.ID, cost); err != nil {
+		release()
+		if delErr := h.store.Delete(c.UserContext(), msg.ID); delErr != nil {
+			h.logger.ErrorContext(c.UserContext(), "failed to delete unpaid message", "error", delErr)
+		}
+		return holdFailed(c, err, cost)
 	}
+	h.recordCreated(c.UserContext(), msg)
 
-	h.logger.Info("Message queued", "id", msg.ID, "client", req.ClientID, "cost", cost)
+	h.logger.InfoContext(c.UserContext(), "Message queued", "priority", priority, "cost", cost)
 
 	return c.Status(202).JSON(&messages.SendResponse{
 		MessageID: msg.ID,
@@ -115,8 +160,28 @@ func (h *Handlers) SendMessage(c *fiber.Ctx) error {
 	})
 }
 
+// duplicateReference answers a send whose reference the client already used,
+// with unique references on. A resend to the same recipient gets the original
+// message back and is not charged again; anything else is a conflict.
+func (h *Handlers) duplicateReference(c *fiber.Ctx, req messages.SendRequest) error {
+	existing, err := h.store.GetByReference(c.UserContext(), req.ClientID, *req.Reference)
+	if err != nil {
+		h.logger.ErrorContext(c.UserContext(), "failed to get message by reference", "error", err)
+		return c.Status(500).JSON(fiber.Map{"error": "internal error"})
+	}
+	if existing.To != req.To {
+		return c.Status(409).JSON(fiber.Map{"error": "reference already used", "message_id": existing.ID})
+	}
+	h.logger.InfoContext(c.UserContext(), "Duplicate reference, returning original message", "message_id", existing.ID)
+	return c.Status(200).JSON(&messages.SendResponse{
+		MessageID: existing.ID,
+		Status:    existing.Status,
+		Duplicate: true,
+	})
+}
+
 // handleOTPMessage handles OTP messages with delivery guarantee (PDF requirement)
-func (h *Handlers) handleOTPMessage(c *fiber.Ctx, req *messages.SendRequest) error {
+func (h *Handlers) handleOTPMessage(c *fiber.Ctx, req *messages.SendRequest, release func()) error {
 	// Generate 6-digit OTP code
 	otpCode := fmt.Sprintf("%06d", time.Now().UnixNano()%1000000)
 	if req.Text == "" {
@@ -125,41 +190,52 @@ func (h *Handlers) handleOTPMessage(c *fiber.Ctx, req *messages.SendRequest) err
 
 	// Calculate cost
 	parts := messages.CalculateParts(req.Text)
-	cost := int64(parts) * h.pricePerPart
+	cost := h.pricing.Cost(parts, messages.PriorityOTP)
 
 	// Create message first
 	msg := &messages.Message{
-		ID:        uuid.New(),
-		ClientID:  req.ClientID,
-		To:        req.To,
-		From:      req.From,
-		Text:      req.Text,
-		Parts:     parts,
-		Status:    messages.StatusQueued,
-		Reference: req.Reference,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-
-	if err := h.store.Create(c.Context(), msg); err != nil {
-		h.logger.Error("failed to create OTP message", "error", err)
+		ID:          uuid.New(),
+		ClientID:    req.ClientID,
+		To:          req.To,
+		From:        req.From,
+		Text:        req.Text,
+		Parts:       parts,
+		Status:      messages.StatusQueued,
+		Reference:   req.Reference,
+		Priority:    messages.PriorityOTP,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+		TraceParent: tracing.TraceParent(c.UserContext()),
+	}
+	c.SetUserContext(logging.WithMessageID(c.UserContext(), msg.ID))
+
+	if err := h.store.Create(c.UserContext(), msg); err != nil {
+		release()
+		if errors.Is(err, messages.ErrDuplicateReference) {
+			return h.duplicateReference(c, *req)
+		}
+		h.logger.ErrorContext(c.UserContext(), "failed to create OTP message", "error", err)
 		return c.Status(500).JSON(fiber.Map{"error": "internal error"})
 	}
 
 	// Hold credits
-	if _, err := h.billing.HoldCredits(c.Context(), req.ClientID, msg.ID, cost); err != nil {
-		h.store.Delete(c.Context(), msg.ID)
-		return c.Status(402).JSON(fiber.Map{"error": "insufficient credits", "required": cost})
+	if _, err := h.billing.HoldCredits(c.UserContext(), req.ClientID, msg.ID, cost); err != nil {
+		release()
+		if delErr := h.store.Delete(c.UserContext(), msg.ID); delErr != nil {
+			h.logger.ErrorContext(c.UserContext(), "failed to delete unpaid message", "error", delErr)
+		}
+		return holdFailed(c, err, cost)
 	}
+	h.recordCreated(c.UserContext(), msg)
 
 	// Try immediate OTP delivery (PDF requirement: guaranteed delivery or error)
-	result, err := h.otpService.SendOTPImmediate(c.Context(), req.To, req.From, req.Text)
+	result, err := h.otpService.SendOTPImmediate(c.UserContext(), req.To, req.From, req.Text, parts)
 	if err != nil {
-		// Release held credits on failure
-		h.billing.ReleaseCredits(c.Context(), msg.ID)
-		h.store.Delete(c.Context(), msg.ID)
+		// Give up the message, its credits, its reference and the quota on failure
+		h.abandonOTP(c.UserContext(), msg.ID, err)
+		release()
 
-		h.logger.Warn("OTP delivery failed immediately", "error", err, "to", req.To)
+		h.logger.WarnContext(c.UserContext(), "OTP delivery failed immediately", "error", err, "to", req.To)
 
 		// Return immediate error as required by PDF
 		return c.Status(503).JSON(fiber.Map{
@@ -168,13 +244,23 @@ func (h *Handlers) handleOTPMessage(c *fiber.Ctx, req *messages.SendRequest) err
 		})
 	}
 
-	// Success - update message with provider info
-	h.store.UpdateStatus(c.Context(), msg.ID, messages.StatusSent, &result.ProviderMessageID, nil)
-
-	// Capture credits on successful delivery
-	h.billing.CaptureCredits(c.Context(), msg.ID)
+	// Success - update message with provider info; QUEUED -> SENT captures the credits
+	queued := messages.StatusQueued
+	ev := &messages.Event{
+		MessageID:  msg.ID,
+		Event:      messages.EventSent,
+		FromStatus: &queued,
+		Status:     messages.StatusSent,
+		Actor:      "api",
+		Provider:   &result.Provider,
+	}
+	if err := h.machine.Apply(c.UserContext(), ev, &result.ProviderMessageID, nil, nil); err != nil {
+		h.logger.ErrorContext(c.UserContext(), "failed to record OTP delivery", "error", err)
+	} else if err := h.store.SaveParts(c.UserContext(), msg.ID, result.PartIDs); err != nil {
+		h.logger.ErrorContext(c.UserContext(), "failed to record OTP parts", "error", err)
+	}
 
-	h.logger.Info("OTP delivered immediately", "id", msg.ID, "to", req.To, "provider_id", result.ProviderMessageID)
+	h.logger.InfoContext(c.UserContext(), "OTP delivered immediately", "to", req.To, "provider_id", result.ProviderMessageID)
 
 	// Return success with OTP code (200 OK for immediate delivery)
 	return c.Status(200).JSON(&messages.SendResponse{
@@ -184,38 +270,244 @@ func (h *Handlers) handleOTPMessage(c *fiber.Ctx, req *messages.SendRequest) err
 	})
 }
 
+// abandonOTP cancels an OTP message that could not be sent immediately, which
+// releases its credits, and frees its reference so the client can retry with
+// it. The message stays in the history, as its credit lock refers to it.
+func (h *Handlers) abandonOTP(ctx context.Context, messageID uuid.UUID, cause error) {
+	queued := messages.StatusQueued
+	detail := cause.Error()
+	ev := &messages.Event{
+		MessageID:  messageID,
+		Event:      messages.EventFailed,
+		FromStatus: &queued,
+		Status:     messages.StatusCancelled,
+		Actor:      "api",
+		Detail:     &detail,
+	}
+	if err := h.machine.Apply(ctx, ev, nil, &detail, nil); err != nil {
+		h.logger.ErrorContext(ctx, "failed to cancel undelivered OTP message", "error", err)
+	}
+	if err := h.store.FreeReference(ctx, messageID); err != nil {
+		h.logger.ErrorContext(ctx, "failed to free OTP message reference", "error", err)
+	}
+}
+
+// holdFailed answers a send whose credits could not be held
+func holdFailed(c *fiber.Ctx, err error, cost int64) error {
+	if errors.Is(err, billing.ErrSuspended) {
+		return c.Status(403).JSON(fiber.Map{"error": "client suspended"})
+	}
+	return c.Status(402).JSON(fiber.Map{"error": "insufficient credits", "required": cost})
+}
+
+// requestClient resolves the client a request acts for. With an API key it
+// is the key's client, and naming any other client_id is refused.
+func requestClient(c *fiber.Ctx, given uuid.UUID) (uuid.UUID, bool) {
+	key := apiKey(c)
+	if key == nil {
+		return given, true
+	}
+	if given != uuid.Nil && given != key.ClientID {
+		return uuid.Nil, false
+	}
+	return key.ClientID, true
+}
+
+// visible reports whether a message may be shown to the request's API key
+func visible(c *fiber.Ctx, msg *messages.Message) bool {
+	key := apiKey(c)
+	return key == nil || key.ClientID == msg.ClientID
+}
+
+// recordCreated starts the history of an accepted message
+func (h *Handlers) recordCreated(ctx context.Context, msg *messages.Message) {
+	ev := &messages.Event{MessageID: msg.ID, Event: messages.EventCreated, Status: msg.Status, Actor: "api"}
+	if err := h.store.AppendEvent(ctx, ev); err != nil {
+		h.logger.ErrorContext(ctx, "failed to record message creation", "error", err)
+	}
+}
+
+// GetMessageEvents handles GET /v1/messages/:id/events
+//
+//	@Summary		Message timeline
+//	@Description	Every status transition of a message, oldest first, with actor, provider and raw provider payloads
+//	@Tags			Messages
+//	@Produce		json
+//	@Param			id	path		string	true	"Message ID"
+//	@Success		200	{array}		messages.Event
+//	@Failure		400	{object}	map[string]string	"Invalid message ID"
+//	@Failure		404	{object}	map[string]string	"Message not found"
+//	@Router			/v1/messages/{id}/events [get]
+func (h *Handlers) GetMessageEvents(c *fiber.Ctx) error {
+	msgID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid message ID"})
+	}
+	c.SetUserContext(logging.WithMessageID(c.UserContext(), msgID))
+
+	if msg, err := h.store.GetByID(c.UserContext(), msgID); err != nil || !visible(c, msg) {
+		return c.Status(404).JSON(fiber.Map{"error": "message not found"})
+	}
+
+	events, err := h.store.ListEvents(c.UserContext(), msgID)
+	if err != nil {
+		h.logger.ErrorContext(c.UserContext(), "failed to list message events", "error", err)
+		return c.Status(500).JSON(fiber.Map{"error": "internal error"})
+	}
+	if events == nil {
+		events = []*messages.Event{}
+	}
+	return c.JSON(events)
+}
+
 // GetMessage handles GET /v1/messages/:id
 func (h *Handlers) GetMessage(c *fiber.Ctx) error {
 	msgID, err := uuid.Parse(c.Params("id"))
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid message ID"})
 	}
+	c.SetUserContext(logging.WithMessageID(c.UserContext(), msgID))
 
-	msg, err := h.store.GetByID(c.Context(), msgID)
-	if err != nil {
+	msg, err := h.store.GetByID(c.UserContext(), msgID)
+	if err != nil || !visible(c, msg) {
 		return c.Status(404).JSON(fiber.Map{"error": "message not found"})
 	}
 
-	cost := int64(msg.Parts) * h.pricePerPart
-	if msg.Express {
-		cost += int64(msg.Parts) * h.expressCost
+	cost := h.pricing.Cost(msg.Parts, msg.Priority)
+
+	parts, err := h.store.ListParts(c.UserContext(), msgID)
+	if err != nil {
+		h.logger.ErrorContext(c.UserContext(), "failed to list message parts", "error", err)
+		return c.Status(500).JSON(fiber.Map{"error": "internal error"})
 	}
 
-	return c.JSON(&messages.GetResponse{Message: msg, Cost: cost})
+	return c.JSON(&messages.GetResponse{Message: msg, Cost: cost, PartDetails: parts})
 }
 
+const (
+	// defaultListLimit and maxListLimit bound a page of GET /v1/messages
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
 // ListMessages handles GET /v1/messages
+//
+//	@Summary		List messages
+//	@Description	Page through a client's messages, newest first by default. Pass next_cursor back as cursor for the next page.
+//	@Tags			Messages
+//	@Produce		json
+//	@Param			client_id	query		string	false	"Client ID, implied by an API key"
+//	@Param			status		query		string	false	"Comma-separated statuses"
+//	@Param			to			query		string	false	"Recipient"
+//	@Param			from		query		string	false	"Sender"
+//	@Param			reference	query		string	false	"Client reference"
+//	@Param			express		query		bool	false	"Express messages only, or none"
+//	@Param			since		query		string	false	"Created at or after (RFC3339)"
+//	@Param			until		query		string	false	"Created before (RFC3339)"
+//	@Param			order		query		string	false	"desc (default) or asc"
+//	@Param			cursor		query		string	false	"next_cursor of the previous page"
+//	@Param			limit		query		int		false	"Page size (default 50, max 200)"
+//	@Success		200			{object}	messages.ListResponse
+//	@Failure		400			{object}	map[string]string	"Bad request"
+//	@Router			/v1/messages [get]
 func (h *Handlers) ListMessages(c *fiber.Ctx) error {
-	clientID, err := uuid.Parse(c.Query("client_id", ""))
-	if err != nil {
+	var given uuid.UUID
+	if raw := c.Query("client_id"); raw != "" {
+		var err error
+		if given, err = uuid.Parse(raw); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid client_id format"})
+		}
+	}
+	clientID, ok := requestClient(c, given)
+	if !ok {
+		return c.Status(403).JSON(fiber.Map{"error": "client_id does not match api key"})
+	}
+	if clientID == uuid.Nil {
 		return c.Status(400).JSON(fiber.Map{"error": "client_id required"})
 	}
+	c.SetUserContext(logging.WithClientID(c.UserContext(), clientID))
+
+	filter, err := parseMessageFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	filter.ClientID = clientID
 
-	msgs, err := h.store.ListByClient(c.Context(), clientID, 50, 0)
+	// One extra row tells whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	msgs, err := h.store.ListByClient(c.UserContext(), filter)
 	if err != nil {
+		h.logger.ErrorContext(c.UserContext(), "failed to list messages", "error", err)
 		return c.Status(500).JSON(fiber.Map{"error": "internal error"})
 	}
-	return c.JSON(msgs)
+
+	resp := &messages.ListResponse{Messages: msgs}
+	if len(msgs) > limit {
+		resp.Messages = msgs[:limit]
+		next := messages.CursorOf(msgs[limit-1]).String()
+		resp.NextCursor = &next
+	}
+	if resp.Messages == nil {
+		resp.Messages = []*messages.Message{}
+	}
+	return c.JSON(resp)
+}
+
+// parseMessageFilter reads the GET /v1/messages query
+func parseMessageFilter(c *fiber.Ctx) (messages.MessageFilter, error) {
+	f := messages.MessageFilter{
+		To:        c.Query("to"),
+		From:      c.Query("from"),
+		Reference: c.Query("reference"),
+		Limit:     c.QueryInt("limit", defaultListLimit),
+	}
+	if f.Limit <= 0 || f.Limit > maxListLimit {
+		return f, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+	}
+	if raw := c.Query("status"); raw != "" {
+		for _, s := range strings.Split(raw, ",") {
+			status := messages.Status(strings.ToUpper(strings.TrimSpace(s)))
+			if !status.Valid() {
+				return f, fmt.Errorf("unknown status %q", s)
+			}
+			f.Statuses = append(f.Statuses, status)
+		}
+	}
+	if raw := c.Query("express"); raw != "" {
+		express := c.QueryBool("express")
+		if raw != "true" && raw != "false" {
+			return f, errors.New("express must be true or false")
+		}
+		f.Express = &express
+	}
+	for _, bound := range []struct {
+		name string
+		dst  **time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		if raw := c.Query(bound.name); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return f, fmt.Errorf("%s must be RFC3339", bound.name)
+			}
+			*bound.dst = &t
+		}
+	}
+	switch c.Query("order", "desc") {
+	case "asc":
+		f.Ascending = true
+	case "desc":
+	default:
+		return f, errors.New("order must be asc or desc")
+	}
+	if raw := c.Query("cursor"); raw != "" {
+		cursor, err := messages.ParseCursor(raw)
+		if err != nil {
+			return f, err
+		}
+		f.After = &cursor
+	}
+	return f, nil
 }
 
 // GetClientInfo handles GET /v1/me
@@ -224,22 +516,29 @@ func (h *Handlers) ListMessages(c *fiber.Ctx) error {
 //	@Description	Get client credit balance and information
 //	@Tags			Client
 //	@Produce		json
-//	@Param			client_id	query		string					true	"Client ID"
+//	@Param			client_id	query		string					false	"Client ID, implied by an API key"
 //	@Success		200			{object}	map[string]interface{}	"Client info"
 //	@Failure		400			{object}	map[string]string		"Bad request"
 //	@Failure		500			{object}	map[string]string		"Internal error"
 //	@Router			/v1/me [get]
 func (h *Handlers) GetClientInfo(c *fiber.Ctx) error {
-	clientIDStr := c.Query("client_id")
-	if clientIDStr == "" {
-		return c.Status(400).JSON(fiber.Map{"error": "client_id query parameter required"})
+	var given uuid.UUID
+	if raw := c.Query("client_id"); raw != "" {
+		var err error
+		if given, err = uuid.Parse(raw); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid client_id format"})
+		}
 	}
-	clientID, err := uuid.Parse(clientIDStr)
-	if err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "invalid client_id format"})
+	clientID, ok := requestClient(c, given)
+	if !ok {
+		return c.Status(403).JSON(fiber.Map{"error": "client_id does not match api key"})
+	}
+	if clientID == uuid.Nil {
+		return c.Status(400).JSON(fiber.Map{"error": "client_id query parameter required"})
 	}
+	c.SetUserContext(logging.WithClientID(c.UserContext(), clientID))
 
-	credits, err := h.billing.GetCredits(c.Context(), clientID)
+	credits, err := h.billing.GetCredits(c.UserContext(), clientID)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "internal error"})
 	}
@@ -260,7 +559,7 @@ func (h *Handlers) Health(c *fiber.Ctx) error {
 }
 
 func (h *Handlers) Ready(c *fiber.Ctx) error {
-	ctx, cancel := context.WithTimeout(c.Context(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(c.UserContext(), 5*time.Second)
 	defer cancel()
 
 	if err := h.store.Health(ctx); err != nil {
@@ -269,7 +568,9 @@ func (h *Handlers) Ready(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{"status": "ready"})
 }
 
-// HandleDLR handles delivery receipts
+// HandleDLR handles delivery receipts, normalized with the table of the
+// provider in the path. Reports the state machine rejects, e.g. a late
+// FAILED_TEMP for a DELIVERED message, get 409.
 func (h *Handlers) HandleDLR(c *fiber.Ctx) error {
 	var req delivery.Request
 	if err := c.BodyParser(&req); err != nil {
@@ -279,9 +580,17 @@ func (h *Handlers) HandleDLR(c *fiber.Ctx) error {
 	if req.ProviderMessageID == "" || req.Status == "" {
 		return c.Status(400).JSON(fiber.Map{"error": "missing required fields"})
 	}
+	req.Provider = c.Params("provider")
+	req.Raw = append(json.RawMessage(nil), c.Body()...)
 
-	if err := h.delivery.Process(c.Context(), &req); err != nil {
-		h.logger.Error("failed to process DLR", "error", err)
+	if err := h.delivery.Process(c.UserContext(), &req); err != nil {
+		if errors.Is(err, delivery.ErrUnknownProvider) {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		if errors.Is(err, messages.ErrIllegalTransition) {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+		h.logger.ErrorContext(c.UserContext(), "failed to process DLR", "error", err)
 		return c.Status(500).JSON(fiber.Map{"error": "failed to process DLR"})
 	}
 
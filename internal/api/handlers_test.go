@@ -2,12 +2,20 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"log/slog"
+	"net/http"
 	"net/http/httptest"
 	"os"
+	"sms-gateway/internal/billing"
+	"sms-gateway/internal/delivery"
 	"sms-gateway/internal/messages"
+	"sms-gateway/internal/otp"
+	"sms-gateway/internal/providers/mock"
+	"sms-gateway/internal/retry"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -57,3 +65,342 @@ func TestSendMessageValidation(t *testing.T) {
 		t.Errorf("Expected status 400 for missing fields, got %d", resp.StatusCode)
 	}
 }
+
+func newTestHandlers(t *testing.T) (*Handlers, *messages.MemoryStore, *billing.MemoryService) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	store := messages.NewMemoryStore()
+	ledger := billing.NewMemoryService()
+	pricing := billing.NewPricing(5, 2, nil)
+	return NewHandlers(logger, store, ledger, delivery.NewService(logger, store, ledger, &retry.Policies{}), nil, pricing, nil), store, ledger
+}
+
+func TestSendMessageHoldsCredits(t *testing.T) {
+	handlers, store, ledger := newTestHandlers(t)
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	ledger.SetCredits(clientID, 12)
+
+	app := fiber.New()
+	app.Post("/messages", handlers.SendMessage)
+	app.Get("/messages/:id", handlers.GetMessage)
+
+	send := func() *http.Response {
+		body, _ := json.Marshal(messages.SendRequest{ClientID: clientID, To: "+15551234567", From: "TEST", Text: "hello"})
+		req := httptest.NewRequest("POST", "/messages", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Two messages at 5 cents fit in 12 cents of credit, the third does not
+	var sent messages.SendResponse
+	for i := 0; i < 2; i++ {
+		resp := send()
+		if resp.StatusCode != 202 {
+			t.Fatalf("Expected status 202, got %d", resp.StatusCode)
+		}
+		json.NewDecoder(resp.Body).Decode(&sent)
+	}
+	if resp := send(); resp.StatusCode != 402 {
+		t.Errorf("Expected status 402 when credits run out, got %d", resp.StatusCode)
+	}
+
+	credits, _ := ledger.GetCredits(context.Background(), clientID)
+	if credits != 2 {
+		t.Errorf("Expected 2 credits left, got %d", credits)
+	}
+	locks := ledger.Locks(sent.MessageID)
+	if len(locks) != 1 || locks[0].State != "HELD" || locks[0].Amount != 5 {
+		t.Errorf("Expected one HELD lock of 5, got %+v", locks)
+	}
+	if queued, _ := store.GetQueuedMessages(context.Background(), 10); len(queued) != 2 {
+		t.Errorf("Expected 2 queued messages, got %d", len(queued))
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/messages/"+sent.MessageID.String(), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected status 200 for GetMessage, got %d", resp.StatusCode)
+	}
+}
+
+func TestSendMessageSuspendedClient(t *testing.T) {
+	handlers, store, ledger := newTestHandlers(t)
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	ledger.SetCredits(clientID, 100)
+	ledger.SetSuspended(clientID, true)
+
+	app := fiber.New()
+	app.Post("/messages", handlers.SendMessage)
+
+	body, _ := json.Marshal(messages.SendRequest{ClientID: clientID, To: "+15551234567", From: "TEST", Text: "hello"})
+	req := httptest.NewRequest("POST", "/messages", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 403 {
+		t.Errorf("Expected status 403 for a suspended client, got %d", resp.StatusCode)
+	}
+	if queued, _ := store.GetQueuedMessages(context.Background(), 10); len(queued) != 0 {
+		t.Errorf("Expected nothing queued for a suspended client, got %d", len(queued))
+	}
+}
+
+func TestSendMessageUnknownClient(t *testing.T) {
+	handlers, _, _ := newTestHandlers(t)
+
+	app := fiber.New()
+	app.Post("/messages", handlers.SendMessage)
+
+	body, _ := json.Marshal(messages.SendRequest{ClientID: uuid.New(), To: "+15551234567", From: "TEST", Text: "hello"})
+	req := httptest.NewRequest("POST", "/messages", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 400 {
+		t.Errorf("Expected status 400 for unknown client, got %d", resp.StatusCode)
+	}
+}
+
+func TestGetMessageEvents(t *testing.T) {
+	handlers, store, ledger := newTestHandlers(t)
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	ledger.SetCredits(clientID, 100)
+
+	app := fiber.New()
+	app.Post("/messages", handlers.SendMessage)
+	app.Get("/messages/:id/events", handlers.GetMessageEvents)
+
+	body, _ := json.Marshal(messages.SendRequest{ClientID: clientID, To: "+15551234567", From: "TEST", Text: "hello"})
+	req := httptest.NewRequest("POST", "/messages", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent messages.SendResponse
+	json.NewDecoder(resp.Body).Decode(&sent)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/messages/"+sent.MessageID.String()+"/events", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	var events []messages.Event
+	json.NewDecoder(resp.Body).Decode(&events)
+	if len(events) != 1 || events[0].Event != messages.EventCreated || events[0].Status != messages.StatusQueued || events[0].Actor != "api" {
+		t.Errorf("Expected a single created event, got %+v", events)
+	}
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/messages/"+uuid.NewString()+"/events", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 404 {
+		t.Errorf("Expected status 404 for unknown message, got %d", resp.StatusCode)
+	}
+}
+
+func TestDeliveryEventsCarryReference(t *testing.T) {
+	handlers, store, ledger := newTestHandlers(t)
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	ledger.SetCredits(clientID, 100)
+
+	app := fiber.New()
+	app.Post("/messages", handlers.SendMessage)
+	app.Post("/providers/:provider/dlr", handlers.HandleDLR)
+	app.Get("/messages/:id/events", handlers.GetMessageEvents)
+
+	ref := "order-7"
+	body, _ := json.Marshal(messages.SendRequest{ClientID: clientID, To: "+15551234567", From: "TEST", Text: "hello", Reference: &ref})
+	req := httptest.NewRequest("POST", "/messages", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent messages.SendResponse
+	json.NewDecoder(resp.Body).Decode(&sent)
+
+	// As the worker leaves it once the provider took it
+	providerID := "mock_" + uuid.NewString()
+	store.Mutate(func(tx *messages.MemoryTx) {
+		m := tx.Messages[sent.MessageID]
+		m.Status = messages.StatusSent
+		m.ProviderMessageID = &providerID
+	})
+	req = httptest.NewRequest("POST", "/providers/mock/dlr", bytes.NewReader([]byte(`{"provider_message_id":"`+providerID+`","status":"DELIVERED"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	if resp, err = app.Test(req); err != nil || resp.StatusCode != 204 {
+		t.Fatalf("Expected the DLR to be accepted, got %v %v", resp.StatusCode, err)
+	}
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/messages/"+sent.MessageID.String()+"/events", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []messages.Event
+	json.NewDecoder(resp.Body).Decode(&events)
+	last := events[len(events)-1]
+	if last.Event != messages.EventDelivery || last.Reference == nil || *last.Reference != ref {
+		t.Errorf("Expected the delivery event to carry reference %q, got %+v", ref, last)
+	}
+}
+
+func TestListMessagesPaging(t *testing.T) {
+	handlers, store, _ := newTestHandlers(t)
+	clientID := uuid.New()
+	store.AddClient(clientID)
+
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		msg := &messages.Message{ID: uuid.New(), ClientID: clientID, To: "+15551234567", From: "TEST", Text: "hi",
+			Parts: 1, Status: messages.StatusQueued, CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+		if err := store.Create(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := fiber.New()
+	app.Get("/messages", handlers.ListMessages)
+
+	list := func(query string) (int, messages.ListResponse) {
+		resp, err := app.Test(httptest.NewRequest("GET", "/messages?client_id="+clientID.String()+query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body messages.ListResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	status, first := list("&limit=2")
+	if status != 200 || len(first.Messages) != 2 || first.NextCursor == nil {
+		t.Fatalf("Expected a full first page with a cursor, got %d %+v", status, first)
+	}
+	status, second := list("&limit=2&cursor=" + *first.NextCursor)
+	if status != 200 || len(second.Messages) != 1 || second.NextCursor != nil {
+		t.Fatalf("Expected a last page of one without a cursor, got %d %+v", status, second)
+	}
+	if !second.Messages[0].CreatedAt.Equal(base) {
+		t.Errorf("Expected the oldest message last, got %v", second.Messages[0].CreatedAt)
+	}
+
+	for _, query := range []string{"&limit=0", "&status=BOGUS", "&order=up", "&cursor=nope", "&since=yesterday", "&express=maybe"} {
+		if status, _ := list(query); status != 400 {
+			t.Errorf("Expected status 400 for %q, got %d", query, status)
+		}
+	}
+}
+
+func TestSendMessageDuplicateReference(t *testing.T) {
+	handlers, store, ledger := newTestHandlers(t)
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	store.SetUniqueReferences(clientID, true)
+	ledger.SetCredits(clientID, 100)
+
+	app := fiber.New()
+	app.Post("/messages", handlers.SendMessage)
+
+	send := func(to string) (int, messages.SendResponse) {
+		ref := "order-1"
+		body, _ := json.Marshal(messages.SendRequest{ClientID: clientID, To: to, From: "TEST", Text: "hello", Reference: &ref})
+		req := httptest.NewRequest("POST", "/messages", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sent messages.SendResponse
+		json.NewDecoder(resp.Body).Decode(&sent)
+		return resp.StatusCode, sent
+	}
+
+	status, first := send("+15551234567")
+	if status != 202 {
+		t.Fatalf("Expected status 202, got %d", status)
+	}
+	balance, _ := ledger.GetCredits(context.Background(), clientID)
+
+	status, again := send("+15551234567")
+	if status != 200 || !again.Duplicate || again.MessageID != first.MessageID {
+		t.Errorf("Expected the original message back, got %d %+v", status, again)
+	}
+	if after, _ := ledger.GetCredits(context.Background(), clientID); after != balance {
+		t.Errorf("Expected a duplicate not to be charged, balance went from %d to %d", balance, after)
+	}
+
+	if status, _ := send("+15557654321"); status != 409 {
+		t.Errorf("Expected status 409 for a reused reference, got %d", status)
+	}
+}
+
+func TestSendOTPRetryAfterFailure(t *testing.T) {
+	handlers, store, ledger := newTestHandlers(t)
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	store.SetUniqueReferences(clientID, true)
+	ledger.SetCredits(clientID, 100)
+
+	app := fiber.New()
+	app.Post("/messages", handlers.SendMessage)
+
+	send := func() (int, messages.SendResponse) {
+		ref := "login-1"
+		body, _ := json.Marshal(messages.SendRequest{ClientID: clientID, To: "+15551234567", From: "TEST", OTP: true, Reference: &ref})
+		req := httptest.NewRequest("POST", "/messages", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sent messages.SendResponse
+		json.NewDecoder(resp.Body).Decode(&sent)
+		return resp.StatusCode, sent
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	handlers.otpService = otp.NewOTPService(logger, mock.NewProviderWithOptions(mock.Options{SuccessRate: 0}))
+	if status, _ := send(); status != 503 {
+		t.Fatalf("Expected status 503 for a failed OTP, got %d", status)
+	}
+	if balance, _ := ledger.GetCredits(context.Background(), clientID); balance != 100 {
+		t.Errorf("Expected the credits back, got balance %d", balance)
+	}
+
+	// The retry with the same reference is a new OTP, not the failed one
+	handlers.otpService = otp.NewOTPService(logger, mock.NewProviderWithOptions(mock.Options{SuccessRate: 1}))
+	status, sent := send()
+	if status != 200 || sent.Duplicate || sent.OTPCode == nil || sent.Status != messages.StatusSent {
+		t.Fatalf("Expected a fresh OTP to be sent, got %d %+v", status, sent)
+	}
+	if balance, _ := ledger.GetCredits(context.Background(), clientID); balance != 95 {
+		t.Errorf("Expected only the sent OTP to be charged, got balance %d", balance)
+	}
+
+	// The failed OTP is kept, cancelled, so no worker sends it later
+	failed, _ := store.ListByClient(context.Background(), messages.MessageFilter{
+		ClientID: clientID, Statuses: []messages.Status{messages.StatusCancelled}, Limit: 10})
+	if len(failed) != 1 || failed[0].ID == sent.MessageID {
+		t.Errorf("Expected the failed OTP to be cancelled, got %+v", failed)
+	}
+	if queued, _ := store.GetQueuedMessages(context.Background(), 10); len(queued) != 0 {
+		t.Errorf("Expected nothing left queued, got %d messages", len(queued))
+	}
+}
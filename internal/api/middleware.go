@@ -1,17 +1,32 @@
 package api
 
 import (
+	"crypto/subtle"
+	"errors"
 	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/fiber/v2/middleware/requestid"
 
+	"sms-gateway/internal/clients"
 	"sms-gateway/internal/config"
+	"sms-gateway/internal/delivery"
+	"sms-gateway/internal/logging"
+	"sms-gateway/internal/metrics"
+	"sms-gateway/internal/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ConcurrencyLimiter manages concurrent requests using atomic operations
@@ -34,7 +49,7 @@ func (cl *ConcurrencyLimiter) Handler(logger *slog.Logger) fiber.Handler {
 		current := atomic.AddInt32(&cl.active, 1)
 		if current > cl.maxConcurrent {
 			atomic.AddInt32(&cl.active, -1)
-			logger.Warn("Concurrency limit reached", "active", current-1, "max", cl.maxConcurrent)
+			logger.WarnContext(c.UserContext(), "Concurrency limit reached", "active", current-1, "max", cl.maxConcurrent)
 			return c.Status(503).JSON(fiber.Map{
 				"error":  "Server temporarily overloaded, please retry",
 				"active": current - 1,
@@ -47,48 +62,196 @@ func (cl *ConcurrencyLimiter) Handler(logger *slog.Logger) fiber.Handler {
 	}
 }
 
+// AdminAuth protects operator endpoints with a static bearer token.
+// With no token configured the admin API is disabled entirely.
+func AdminAuth(logger *slog.Logger, token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if token == "" {
+			return c.Status(403).JSON(fiber.Map{"error": "admin API disabled"})
+		}
+		given := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			logger.WarnContext(c.UserContext(), "Admin authentication failed", "ip", c.IP(), "path", c.Path())
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		return c.Next()
+	}
+}
+
+// apiKeyLocal is where ClientAuth leaves the authenticated key
+const apiKeyLocal = "api_key"
+
+// ClientAuth authenticates client API keys sent as a bearer token or in
+// X-API-Key. Requests without a key get 401 when keys are required and pass
+// through otherwise; a key that is unknown, expired, revoked or used from an
+// address outside its allowlist is always refused.
+func ClientAuth(logger *slog.Logger, keys *clients.Service, required bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		given := c.Get("X-API-Key")
+		if auth := c.Get(fiber.HeaderAuthorization); given == "" && strings.HasPrefix(auth, "Bearer ") {
+			given = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if given == "" {
+			if required {
+				return c.Status(401).JSON(fiber.Map{"error": "api key required"})
+			}
+			return c.Next()
+		}
+
+		key, err := keys.Authenticate(c.UserContext(), given, c.IP())
+		switch {
+		case errors.Is(err, clients.ErrIPNotAllowed):
+			logger.WarnContext(c.UserContext(), "API key used from a disallowed address", "ip", c.IP(), "path", c.Path())
+			return c.Status(403).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, clients.ErrKeyNotFound):
+			logger.WarnContext(c.UserContext(), "API key authentication failed", "ip", c.IP(), "path", c.Path())
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		case err != nil:
+			logger.ErrorContext(c.UserContext(), "failed to authenticate API key", "error", err)
+			return c.Status(500).JSON(fiber.Map{"error": "internal error"})
+		}
+		c.Locals(apiKeyLocal, key)
+		c.SetUserContext(logging.WithClientID(c.UserContext(), key.ClientID))
+		return c.Next()
+	}
+}
+
+// RequireScope lets a request through when its API key grants any of
+// scopes. Requests without a key were already let through by ClientAuth.
+func RequireScope(scopes ...clients.Scope) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := apiKey(c)
+		if key == nil {
+			return c.Next()
+		}
+		for _, s := range scopes {
+			if key.Allows(s) {
+				return c.Next()
+			}
+		}
+		return c.Status(403).JSON(fiber.Map{"error": "api key lacks scope", "required": scopes})
+	}
+}
+
+// apiKey returns the key the request was authenticated with, if any
+func apiKey(c *fiber.Ctx) *clients.APIKey {
+	key, _ := c.Locals(apiKeyLocal).(*clients.APIKey)
+	return key
+}
+
+// DLRAuth authenticates provider webhooks with the policy of the provider in
+// the path. Rejected callbacks are logged, counted by the authenticator and
+// get 401.
+func DLRAuth(logger *slog.Logger, auth *delivery.Authenticator) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		provider := c.Params("provider")
+		err := auth.Verify(provider, delivery.Callback{
+			IP:     c.IP(),
+			Header: func(key string) string { return c.Get(key) },
+			Body:   c.Body(),
+		})
+		if err != nil {
+			logger.WarnContext(c.UserContext(), "Rejected provider callback", "error", err, "provider", provider,
+				"ip", c.IP(), "path", c.Path())
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		return c.Next()
+	}
+}
+
+// RequestContext puts the request ID set by the requestid middleware into
+// the user context, so every log line written while serving the request
+// carries it
+func RequestContext() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if id, ok := c.Locals(requestid.ConfigDefault.ContextKey).(string); ok {
+			c.SetUserContext(logging.WithRequestID(c.UserContext(), id))
+		}
+		return c.Next()
+	}
+}
+
+// RequestMetrics records request latency by route pattern, method and
+// status. Routes are labelled by pattern (/v1/messages/:id), never by path.
+func RequestMetrics() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		status := responseStatus(c, err)
+		metrics.HTTPDuration.WithLabelValues(c.Route().Path, c.Method(), strconv.Itoa(status)).
+			Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// Tracing starts a server span per request, continuing the caller's trace
+// from its traceparent header. Handlers reach the span through c.UserContext().
+func Tracing() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), propagation.HeaderCarrier(c.GetReqHeaders()))
+		ctx, span := tracing.Start(ctx, c.Method(), trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+
+		status := responseStatus(c, err)
+		span.SetName(c.Method() + " " + c.Route().Path)
+		span.SetAttributes(
+			attribute.String("http.request.method", c.Method()),
+			attribute.String("http.route", c.Route().Path),
+			attribute.Int("http.response.status_code", status),
+		)
+		if status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		return err
+	}
+}
+
+// responseStatus is the status code the request is answered with
+func responseStatus(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+	// The error handler has not written the response yet
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		return fe.Code
+	}
+	return fiber.StatusInternalServerError
+}
+
 // SetupMiddleware configures middleware in the right order
 func SetupMiddleware(app *fiber.App, logger *slog.Logger, cfg *config.Config) {
 	logger.Info("Setting up middleware", "rate_limit_enabled", cfg.RateLimitEnabled)
 
-	// 1. Recovery and request ID (always first)
+	// 1. Recovery and request ID (always first); the ID is put on every log line
 	app.Use(recover.New())
 	app.Use(requestid.New())
+	app.Use(RequestContext())
+
+	// 2. Metrics and tracing, ahead of the limiters so rejected requests are seen too
+	app.Use(RequestMetrics())
+	app.Use(Tracing())
 
-	// 2. CORS
+	// 3. CORS
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*",
 		AllowMethods: "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
-		AllowHeaders: "Origin,Content-Type,Accept,Authorization",
+		AllowHeaders: "Origin,Content-Type,Accept,Authorization,X-API-Key",
 	}))
 
-	// 3. Rate limiting (if enabled)
-	if cfg.RateLimitEnabled {
-		logger.Info("Rate limiting enabled", "rpm", cfg.RateLimitRPM, "concurrent", cfg.RateLimitConcurrent)
-
-		// Requests per minute limit
-		app.Use(limiter.New(limiter.Config{
-			Max:        cfg.RateLimitRPM,
-			Expiration: 1 * time.Minute,
-			KeyGenerator: func(c *fiber.Ctx) string {
-				return c.IP()
-			},
-			LimitReached: func(c *fiber.Ctx) error {
-				return c.Status(429).JSON(fiber.Map{
-					"error":       "Too many requests, please slow down",
-					"retry_after": "60 seconds",
-				})
-			},
-		}))
-
-		// Concurrent request limit
-		if cfg.RateLimitConcurrent > 0 {
-			concurrencyLimiter := NewConcurrencyLimiter(int32(cfg.RateLimitConcurrent))
-			app.Use(concurrencyLimiter.Handler(logger))
-		}
+	// 4. Concurrency limit (if rate limiting is enabled). Request rates and
+	// quotas are limited per client on the /v1 routes, see RateLimits.
+	if cfg.RateLimitEnabled && cfg.RateLimitConcurrent > 0 {
+		logger.Info("Concurrency limit enabled", "concurrent", cfg.RateLimitConcurrent)
+		concurrencyLimiter := NewConcurrencyLimiter(int32(cfg.RateLimitConcurrent))
+		app.Use(concurrencyLimiter.Handler(logger))
 	}
 
-	// 4. Request logging (always last)
+	// 5. Request logging (always last)
 	app.Use(func(c *fiber.Ctx) error {
 		start := time.Now()
 		err := c.Next()
@@ -104,7 +267,7 @@ func SetupMiddleware(app *fiber.App, logger *slog.Logger, cfg *config.Config) {
 			level = slog.LevelWarn
 		}
 
-		logger.Log(c.Context(), level, "request",
+		logger.Log(c.UserContext(), level, "request",
 			"method", c.Method(),
 			"path", c.Path(),
 			"status", status,
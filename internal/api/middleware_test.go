@@ -0,0 +1,228 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"sms-gateway/internal/billing"
+	"sms-gateway/internal/clients"
+	"sms-gateway/internal/logging"
+	"sms-gateway/internal/messages"
+	"sms-gateway/internal/metrics"
+	"sms-gateway/internal/ratelimit"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRequestMetrics(t *testing.T) {
+	app := fiber.New()
+	app.Use(RequestMetrics())
+	app.Get("/v1/things/:id", func(c *fiber.Ctx) error {
+		if c.Params("id") == "missing" {
+			return fiber.ErrNotFound
+		}
+		return c.SendStatus(200)
+	})
+
+	for _, path := range []string{"/v1/things/1", "/v1/things/2", "/v1/things/missing"} {
+		if _, err := app.Test(httptest.NewRequest("GET", path, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Requests are labelled by route pattern, so IDs don't create series
+	if n := testutil.CollectAndCount(metrics.HTTPDuration, "sms_http_request_duration_seconds"); n != 2 {
+		t.Errorf("Expected 2 series (200 and 404), got %d", n)
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	app := fiber.New()
+	app.Use(requestid.New(), RequestContext())
+	var seen string
+	app.Get("/", func(c *fiber.Ctx) error {
+		seen = logging.RequestID(c.UserContext())
+		return c.SendStatus(200)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := resp.Header.Get("X-Request-ID"); id == "" || seen != id {
+		t.Errorf("Expected the handler to see request ID %q, got %q", id, seen)
+	}
+}
+
+func TestClientAuthScopes(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	handlers, store, ledger := newTestHandlers(t)
+	keys := clients.NewService(logger, clients.NewMemoryStore())
+
+	issued, err := keys.Create(ctx, &clients.CreateRequest{Name: "Acme"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientID := issued.Client.ID
+	store.AddClient(clientID)
+	ledger.SetCredits(clientID, 1000)
+	issue := func(req clients.KeyRequest) string {
+		k, err := keys.CreateKey(ctx, clientID, &req, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k.APIKey
+	}
+	sendKey := issued.APIKey
+	otpKey := issue(clients.KeyRequest{Name: "otp", Scopes: []clients.Scope{clients.ScopeSendOTP}})
+	readKey := issue(clients.KeyRequest{Name: "dashboard", Scopes: []clients.Scope{clients.ScopeRead}})
+	officeKey := issue(clients.KeyRequest{Name: "office", Scopes: []clients.Scope{clients.ScopeSend}, AllowedIPs: []string{"10.0.0.0/8"}})
+
+	other := &messages.Message{ID: uuid.New(), ClientID: uuid.New(), Status: messages.StatusQueued}
+	store.AddClient(other.ClientID)
+	store.Create(ctx, other)
+
+	newApp := func(required bool) *fiber.App {
+		app := fiber.New()
+		msgs := app.Group("/v1/messages", ClientAuth(logger, keys, required))
+		msgs.Post("/", RequireScope(clients.ScopeSend, clients.ScopeSendOTP), handlers.SendMessage)
+		msgs.Get("/:id", RequireScope(clients.ScopeRead), handlers.GetMessage)
+		return app
+	}
+	send := func(body messages.SendRequest) []byte {
+		b, _ := json.Marshal(body)
+		return b
+	}
+	text := messages.SendRequest{To: "+15551234567", From: "TEST", Text: "hello"}
+	named := text
+	named.ClientID = clientID
+	otherClient := text
+	otherClient.ClientID = other.ClientID
+
+	tests := []struct {
+		name     string
+		required bool
+		method   string
+		path     string
+		key      string
+		body     []byte
+		want     int
+	}{
+		{"no key, naming the client", false, "POST", "/v1/messages", "", send(named), 202},
+		{"no key when required", true, "POST", "/v1/messages", "", send(named), 401},
+		{"unknown key", false, "POST", "/v1/messages", "sgw_nope", send(named), 401},
+		{"send key implies the client", true, "POST", "/v1/messages", sendKey, send(text), 202},
+		{"send key naming another client", true, "POST", "/v1/messages", sendKey, send(otherClient), 403},
+		{"read key cannot send", true, "POST", "/v1/messages", readKey, send(text), 403},
+		{"otp key cannot send text", true, "POST", "/v1/messages", otpKey, send(text), 403},
+		{"key outside its allowlist", true, "POST", "/v1/messages", officeKey, send(text), 403},
+		{"read key on another client's message", true, "GET", "/v1/messages/" + other.ID.String(), readKey, nil, 404},
+		{"send key cannot read", true, "GET", "/v1/messages/" + other.ID.String(), otpKey, nil, 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.key != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+			resp, err := newApp(tt.required).Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	_, store, ledger := newTestHandlers(t)
+	keys := clients.NewService(logger, clients.NewMemoryStore())
+	limits := NewRateLimits(logger, ratelimit.New(ratelimit.NewMemoryStore()), keys, RateDefaults{IPPerMinute: 2})
+	handlers := NewHandlers(logger, store, ledger, nil, nil, billing.NewPricing(5, 2, nil), limits)
+
+	perDay := int64(1)
+	issued, err := keys.Create(ctx, &clients.CreateRequest{Name: "Acme", Limits: clients.Limits{MessagesPerDay: &perDay}}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.AddClient(issued.Client.ID)
+
+	app := fiber.New()
+	msgs := app.Group("/v1/messages", ClientAuth(logger, keys, false), limits.Requests())
+	msgs.Post("/", handlers.SendMessage)
+	msgs.Get("/:id", handlers.GetMessage)
+
+	t.Run("requests without a key per address", func(t *testing.T) {
+		for i, want := range []int{404, 404, 429} {
+			resp, err := app.Test(httptest.NewRequest("GET", "/v1/messages/"+uuid.NewString(), nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != want {
+				t.Errorf("Request %d: expected status %d, got %d", i+1, want, resp.StatusCode)
+			}
+			if got := resp.Header.Get("RateLimit-Limit"); got != "2" {
+				t.Errorf("Request %d: expected RateLimit-Limit 2, got %q", i+1, got)
+			}
+			if want == 429 && resp.Header.Get("Retry-After") == "" {
+				t.Error("Expected Retry-After on 429")
+			}
+		}
+	})
+
+	t.Run("requests without a key per named client", func(t *testing.T) {
+		perSecond := int64(1)
+		named, err := keys.Create(ctx, &clients.CreateRequest{Name: "Keyless", Limits: clients.Limits{RequestsPerSecond: &perSecond}}, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The address is over its limit by now, the client is not
+		resp, err := app.Test(httptest.NewRequest("GET", "/v1/messages/"+uuid.NewString()+"?client_id="+named.Client.ID.String(), nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 404 || resp.Header.Get("RateLimit-Limit") != "1" {
+			t.Errorf("Expected 404 under the client's limit of 1, got %d with limit %q", resp.StatusCode, resp.Header.Get("RateLimit-Limit"))
+		}
+	})
+
+	t.Run("daily message quota", func(t *testing.T) {
+		send := func() int {
+			body, _ := json.Marshal(messages.SendRequest{To: "+15551234567", From: "TEST", Text: "hello"})
+			req := httptest.NewRequest("POST", "/v1/messages", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-API-Key", issued.APIKey)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return resp.StatusCode
+		}
+
+		// A send refused for credits does not use up the quota
+		if status := send(); status != 402 {
+			t.Fatalf("Expected 402 without credits, got %d", status)
+		}
+		ledger.SetCredits(issued.Client.ID, 100)
+		if status := send(); status != 202 {
+			t.Fatalf("Expected 202 within the quota, got %d", status)
+		}
+		if status := send(); status != 429 {
+			t.Errorf("Expected 429 past the quota, got %d", status)
+		}
+	})
+}
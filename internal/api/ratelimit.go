@@ -0,0 +1,210 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"sms-gateway/internal/clients"
+	"sms-gateway/internal/ratelimit"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// RateDefaults apply where a client or key has no limit of its own. Zero is
+// unlimited.
+type RateDefaults struct {
+	IPPerMinute       int64 // Requests that name no client, per address
+	RequestsPerSecond int64 // Per client
+	MessagesPerDay    int64 // Per client
+	MessagesPerMonth  int64 // Per client
+}
+
+// RateLimits enforces request rates and message quotas per client and per
+// API key, counted in a store shared by every replica. Keys have no default
+// limits; theirs apply on top of their client's. A nil RateLimits limits
+// nothing.
+type RateLimits struct {
+	logger   *slog.Logger
+	limiter  *ratelimit.Limiter
+	clients  *clients.Service
+	defaults RateDefaults
+}
+
+func NewRateLimits(logger *slog.Logger, limiter *ratelimit.Limiter, clients *clients.Service, defaults RateDefaults) *RateLimits {
+	return &RateLimits{logger: logger, limiter: limiter, clients: clients, defaults: defaults}
+}
+
+// Requests limits the request rate of the authenticated client and key.
+// Without a key the client is the one the request names, as for message
+// quotas; only requests that name none are limited by the caller's address.
+// It runs after ClientAuth. Counting failures let requests through.
+func (r *RateLimits) Requests() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if r == nil {
+			return c.Next()
+		}
+		var rules []ratelimit.Rule
+		if key := apiKey(c); key != nil {
+			rules = []ratelimit.Rule{
+				{Key: "client:" + key.ClientID.String() + ":requests", Max: limit(key.ClientLimits.RequestsPerSecond, r.defaults.RequestsPerSecond), Window: ratelimit.Second},
+				{Key: "key:" + key.ID.String() + ":requests", Max: limit(key.Limits.RequestsPerSecond, 0), Window: ratelimit.Second},
+			}
+		} else if clientID, ok := namedClient(c); ok {
+			own, err := r.clientLimits(c.UserContext(), clientID)
+			if err != nil {
+				r.logger.ErrorContext(c.UserContext(), "failed to load client limits", "error", err)
+				return c.Next()
+			}
+			rules = []ratelimit.Rule{
+				{Key: "client:" + clientID.String() + ":requests", Max: limit(own.RequestsPerSecond, r.defaults.RequestsPerSecond), Window: ratelimit.Second},
+			}
+		} else {
+			rules = []ratelimit.Rule{{Key: "ip:" + c.IP() + ":requests", Max: r.defaults.IPPerMinute, Window: ratelimit.Minute}}
+		}
+
+		res, err := r.limiter.Take(c.UserContext(), rules, 1)
+		if errors.Is(err, ratelimit.ErrLimited) {
+			setRateHeaders(c, res)
+			return c.Status(429).JSON(fiber.Map{"error": "rate limit exceeded", "retry_after": secondsUntil(res.Reset)})
+		}
+		if err != nil {
+			r.logger.ErrorContext(c.UserContext(), "failed to count request rate", "error", err)
+			return c.Next()
+		}
+		setRateHeaders(c, res)
+		return c.Next()
+	}
+}
+
+// TakeMessage counts one message against the daily and monthly quotas of
+// clientID and the request's key. A refused message gets ErrLimited with the
+// rate headers set. release gives the message back if it is not accepted
+// after all. Counting failures let the message through.
+func (r *RateLimits) TakeMessage(c *fiber.Ctx, clientID uuid.UUID) (release func(), err error) {
+	if r == nil {
+		return func() {}, nil
+	}
+	ctx := c.UserContext()
+	rules, err := r.messageRules(ctx, apiKey(c), clientID)
+	if err != nil {
+		r.logger.ErrorContext(ctx, "failed to load client limits", "error", err)
+		return func() {}, nil
+	}
+
+	res, err := r.limiter.Take(ctx, rules, 1)
+	if errors.Is(err, ratelimit.ErrLimited) {
+		setRateHeaders(c, res)
+		return nil, err
+	}
+	if err != nil {
+		r.logger.ErrorContext(ctx, "failed to count message quota", "error", err)
+		return func() {}, nil
+	}
+	return func() { r.limiter.Give(context.WithoutCancel(ctx), rules, 1) }, nil
+}
+
+// namedClient is the client_id a request without an API key names, in its
+// query or JSON body
+func namedClient(c *fiber.Ctx) (uuid.UUID, bool) {
+	raw := c.Query("client_id")
+	if raw == "" && strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON) {
+		var body struct {
+			ClientID string `json:"client_id"`
+		}
+		if json.Unmarshal(c.Body(), &body) == nil {
+			raw = body.ClientID
+		}
+	}
+	id, err := uuid.Parse(raw)
+	return id, err == nil && id != uuid.Nil
+}
+
+// clientLimits returns a client's own limits. An unknown client gets the
+// defaults; its request fails on its own later.
+func (r *RateLimits) clientLimits(ctx context.Context, clientID uuid.UUID) (clients.Limits, error) {
+	client, err := r.clients.Get(ctx, clientID)
+	if errors.Is(err, clients.ErrNotFound) {
+		return clients.Limits{}, nil
+	}
+	if err != nil {
+		return clients.Limits{}, err
+	}
+	return client.Limits, nil
+}
+
+func (r *RateLimits) messageRules(ctx context.Context, key *clients.APIKey, clientID uuid.UUID) ([]ratelimit.Rule, error) {
+	own := clients.Limits{}
+	if key != nil {
+		own = key.ClientLimits
+	} else {
+		var err error
+		if own, err = r.clientLimits(ctx, clientID); err != nil {
+			return nil, err
+		}
+	}
+
+	prefix := "client:" + clientID.String() + ":messages"
+	rules := []ratelimit.Rule{
+		{Key: prefix, Max: limit(own.MessagesPerDay, r.defaults.MessagesPerDay), Window: ratelimit.Day},
+		{Key: prefix, Max: limit(own.MessagesPerMonth, r.defaults.MessagesPerMonth), Window: ratelimit.Month},
+	}
+	if key != nil {
+		prefix := "key:" + key.ID.String() + ":messages"
+		rules = append(rules,
+			ratelimit.Rule{Key: prefix, Max: limit(key.Limits.MessagesPerDay, 0), Window: ratelimit.Day},
+			ratelimit.Rule{Key: prefix, Max: limit(key.Limits.MessagesPerMonth, 0), Window: ratelimit.Month},
+		)
+	}
+	return rules, nil
+}
+
+// Run prunes the counters of ended windows every interval until ctx ends
+func (r *RateLimits) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.limiter.Prune(ctx); err != nil {
+				r.logger.ErrorContext(ctx, "Failed to prune rate limit counters", "error", err)
+			}
+		}
+	}
+}
+
+// limit is own when set, def otherwise
+func limit(own *int64, def int64) int64 {
+	if own != nil {
+		return *own
+	}
+	return def
+}
+
+// setRateHeaders reports the rule closest to its limit in the RateLimit-*
+// headers, and Retry-After when it refused the request
+func setRateHeaders(c *fiber.Ctx, res *ratelimit.Result) {
+	if res == nil {
+		return
+	}
+	reset := strconv.FormatInt(secondsUntil(res.Reset), 10)
+	c.Set("RateLimit-Limit", strconv.FormatInt(res.Rule.Max, 10))
+	c.Set("RateLimit-Remaining", strconv.FormatInt(res.Remaining(), 10))
+	c.Set("RateLimit-Reset", reset)
+	if !res.Allowed {
+		c.Set(fiber.HeaderRetryAfter, reset)
+	}
+}
+
+func secondsUntil(t time.Time) int64 {
+	return max(int64(math.Ceil(time.Until(t).Seconds())), 0)
+}
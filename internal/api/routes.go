@@ -6,28 +6,37 @@ import (
 	_ "sms-gateway/docs"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	fiberSwagger "github.com/swaggo/fiber-swagger"
 
+	"sms-gateway/internal/clients"
 	"sms-gateway/internal/config"
+	"sms-gateway/internal/delivery"
+	"sms-gateway/internal/metrics"
 )
 
-func SetupRoutes(app *fiber.App, logger *slog.Logger, handlers *Handlers, cfg *config.Config) {
+func SetupRoutes(app *fiber.App, logger *slog.Logger, handlers *Handlers, admin *AdminHandlers, dlrAuth *delivery.Authenticator, keys *clients.Service, limits *RateLimits, cfg *config.Config) {
 	SetupMiddleware(app, logger, cfg)
 
 	// Health
 	app.Get("/health", handlers.Health)
 	app.Get("/ready", handlers.Ready)
 
+	// Prometheus
+	app.Get("/metrics", adaptor.HTTPHandler(metrics.Handler()))
+
 	// Docs
 	app.Get("/docs", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{
 			"title": "SMS Gateway API",
 			"endpoints": fiber.Map{
-				"health": "GET /health",
-				"send":   "POST /v1/messages",
-				"get":    "GET /v1/messages/:id",
-				"list":   "GET /v1/messages?client_id=uuid",
-				"client": "GET /v1/me?client_id=uuid",
+				"health":  "GET /health",
+				"metrics": "GET /metrics",
+				"send":    "POST /v1/messages",
+				"get":     "GET /v1/messages/:id",
+				"events":  "GET /v1/messages/:id/events",
+				"list":    "GET /v1/messages?client_id=uuid&status=&to=&from=&reference=&express=&since=&until=&order=&cursor=&limit=",
+				"client":  "GET /v1/me?client_id=uuid",
 			},
 		})
 	})
@@ -35,17 +44,42 @@ func SetupRoutes(app *fiber.App, logger *slog.Logger, handlers *Handlers, cfg *c
 	// Swagger UI
 	app.Get("/swagger/*", fiberSwagger.WrapHandler)
 
-	// API v1
+	// API v1, rate limited per client and key, each route with the API key
+	// scope it requires
 	v1 := app.Group("/v1")
-	v1.Get("/me", handlers.GetClientInfo)
+	clientAuth := ClientAuth(logger, keys, cfg.APIKeysRequired)
+	v1.Get("/me", clientAuth, limits.Requests(), RequireScope(clients.ScopeBilling, clients.ScopeRead), handlers.GetClientInfo)
 
-	msgs := v1.Group("/messages")
-	msgs.Post("/", handlers.SendMessage)
-	msgs.Get("/", handlers.ListMessages)
-	msgs.Get("/:id", handlers.GetMessage)
+	msgs := v1.Group("/messages", clientAuth, limits.Requests())
+	msgs.Post("/", RequireScope(clients.ScopeSend, clients.ScopeSendOTP), handlers.SendMessage) // send:otp only for OTPs
+	msgs.Get("/", RequireScope(clients.ScopeRead), handlers.ListMessages)
+	msgs.Get("/:id", RequireScope(clients.ScopeRead), handlers.GetMessage)
+	msgs.Get("/:id/events", RequireScope(clients.ScopeRead), handlers.GetMessageEvents)
 
 	// Provider webhooks
-	v1.Post("/providers/mock/dlr", handlers.HandleDLR)
+	v1.Post("/providers/:provider/dlr", DLRAuth(logger, dlrAuth), handlers.HandleDLR)
+
+	// Operator API
+	adm := app.Group("/admin", AdminAuth(logger, cfg.AdminToken))
+	adm.Get("/dlq", admin.ListDeadLetters)
+	adm.Post("/dlq/replay", admin.ReplayDeadLetters)
+	adm.Get("/overview", admin.Overview)
+	adm.Get("/pauses", admin.ListPauses)
+	adm.Post("/pause", admin.Pause)
+	adm.Post("/resume", admin.Resume)
+	adm.Post("/providers/:provider/drain", admin.DrainProvider)
+	adm.Post("/messages/:id/requeue", admin.RequeueMessage)
+	adm.Post("/clients", admin.CreateClient)
+	adm.Get("/clients", admin.ListClients)
+	adm.Get("/clients/:id", admin.GetClient)
+	adm.Patch("/clients/:id", admin.UpdateClient)
+	adm.Delete("/clients/:id", admin.DeleteClient)
+	adm.Put("/clients/:id/limits", admin.SetClientLimits)
+	adm.Post("/clients/:id/keys", admin.CreateClientKey)
+	adm.Get("/clients/:id/keys", admin.ListClientKeys)
+	adm.Delete("/clients/:id/keys/:key", admin.RevokeClientKey)
+	adm.Post("/clients/:id/keys/:key/rotate", admin.RotateClientKey)
+	adm.Put("/clients/:id/keys/:key/limits", admin.SetClientKeyLimits)
 
 	// Handle 404 for all other routes
 	app.Use(func(c *fiber.Ctx) error {
@@ -53,13 +87,14 @@ func SetupRoutes(app *fiber.App, logger *slog.Logger, handlers *Handlers, cfg *c
 			"error":   "Not Found",
 			"message": "The requested endpoint does not exist",
 			"available_endpoints": fiber.Map{
-				"health":        "GET /health",
-				"docs":          "GET /docs",
-				"swagger":       "GET /swagger/",
-				"send_sms":      "POST /v1/messages",
-				"get_message":   "GET /v1/messages/:id",
-				"list_messages": "GET /v1/messages?client_id=uuid",
-				"client_info":   "GET /v1/me?client_id=uuid",
+				"health":         "GET /health",
+				"docs":           "GET /docs",
+				"swagger":        "GET /swagger/",
+				"send_sms":       "POST /v1/messages",
+				"get_message":    "GET /v1/messages/:id",
+				"message_events": "GET /v1/messages/:id/events",
+				"list_messages":  "GET /v1/messages?client_id=uuid&status=&to=&from=&reference=&express=&since=&until=&order=&cursor=&limit=",
+				"client_info":    "GET /v1/me?client_id=uuid",
 			},
 		})
 	})
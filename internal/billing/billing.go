@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"sms-gateway/internal/db"
+	"sms-gateway/internal/tracing"
 
 	"github.com/google/uuid"
 )
@@ -20,20 +21,28 @@ func NewService(db *db.PostgresDB, logger *slog.Logger) *Service {
 }
 
 func (s *Service) HoldCredits(ctx context.Context, clientID, messageID uuid.UUID, amount int64) (*CreditLock, error) {
+	ctx, span := tracing.Start(ctx, "billing.HoldCredits")
+	defer span.End()
+
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
-	// Deduct credits
-	result, err := tx.ExecContext(ctx, "UPDATE clients SET credit_cents = credit_cents - $1 WHERE id = $2 AND credit_cents >= $1", amount, clientID)
+	// Deduct credits; the balance may go as far below zero as the credit limit
+	result, err := tx.ExecContext(ctx, `UPDATE clients SET credit_cents = credit_cents - $1
+		WHERE id = $2 AND NOT suspended AND credit_cents + credit_limit_cents >= $1`, amount, clientID)
 	if err != nil {
 		return nil, err
 	}
 
 	if rows, _ := result.RowsAffected(); rows == 0 {
-		return nil, fmt.Errorf("insufficient credits")
+		var suspended bool
+		if err := tx.QueryRowContext(ctx, "SELECT suspended FROM clients WHERE id = $1", clientID).Scan(&suspended); err == nil && suspended {
+			return nil, ErrSuspended
+		}
+		return nil, ErrInsufficientCredits
 	}
 
 	// Create lock
@@ -55,20 +64,26 @@ func (s *Service) HoldCredits(ctx context.Context, clientID, messageID uuid.UUID
 		return nil, err
 	}
 
-	s.logger.Info("credits held", "client", clientID, "amount", amount)
+	s.logger.InfoContext(ctx, "credits held", "client", clientID, "amount", amount)
 	return lock, nil
 }
 
 func (s *Service) CaptureCredits(ctx context.Context, messageID uuid.UUID) error {
+	ctx, span := tracing.Start(ctx, "billing.CaptureCredits")
+	defer span.End()
+
 	_, err := s.db.ExecContext(ctx, "UPDATE credit_locks SET state = 'CAPTURED' WHERE message_id = $1 AND state = 'HELD'", messageID)
 	if err != nil {
 		return err
 	}
-	s.logger.Info("credits captured", "message", messageID)
+	s.logger.InfoContext(ctx, "credits captured", "message", messageID)
 	return nil
 }
 
 func (s *Service) ReleaseCredits(ctx context.Context, messageID uuid.UUID) error {
+	ctx, span := tracing.Start(ctx, "billing.ReleaseCredits")
+	defer span.End()
+
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
@@ -98,10 +113,62 @@ func (s *Service) ReleaseCredits(ctx context.Context, messageID uuid.UUID) error
 		return err
 	}
 
-	s.logger.Info("credits released", "client", lock.ClientID, "amount", lock.Amount)
+	s.logger.InfoContext(ctx, "credits released", "client", lock.ClientID, "amount", lock.Amount)
+	return nil
+}
+
+// RefundCredits gives back the share of a message's charge that covers parts
+// of its ofParts segments, e.g. the parts the provider failed to deliver. A
+// held lock is captured for the rest. Refunds are cumulative, so repeating a
+// call refunds nothing more. It returns sql.ErrNoRows when nothing was held
+// or captured.
+func (s *Service) RefundCredits(ctx context.Context, messageID uuid.UUID, parts, ofParts int) error {
+	ctx, span := tracing.Start(ctx, "billing.RefundCredits")
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var lock CreditLock
+	err = tx.QueryRowContext(ctx, `SELECT id, client_id, amount_cents, refunded_cents FROM credit_locks
+		WHERE message_id = $1 AND state IN ('HELD', 'CAPTURED')
+		ORDER BY created_at DESC LIMIT 1 FOR UPDATE`, messageID).
+		Scan(&lock.ID, &lock.ClientID, &lock.Amount, &lock.Refunded)
+	if err != nil {
+		return err
+	}
+
+	due := refundDue(lock, parts, ofParts)
+	if due > 0 {
+		if _, err := tx.ExecContext(ctx, "UPDATE clients SET credit_cents = credit_cents + $1 WHERE id = $2", due, lock.ClientID); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE credit_locks SET state = 'CAPTURED', refunded_cents = refunded_cents + $1 WHERE id = $2", due, lock.ID)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "credits refunded", "client", lock.ClientID, "message", messageID, "amount", due, "parts", parts, "of", ofParts)
 	return nil
 }
 
+// refundDue is what is still owed back for parts of ofParts segments
+func refundDue(lock CreditLock, parts, ofParts int) int64 {
+	if ofParts <= 0 || parts <= 0 {
+		return 0
+	}
+	parts = min(parts, ofParts)
+	return max(lock.Amount*int64(parts)/int64(ofParts)-lock.Refunded, 0)
+}
+
 func (s *Service) GetCredits(ctx context.Context, clientID uuid.UUID) (int64, error) {
 	var credits int64
 	err := s.db.QueryRowContext(ctx, "SELECT credit_cents FROM clients WHERE id = $1", clientID).Scan(&credits)
@@ -111,12 +178,33 @@ func (s *Service) GetCredits(ctx context.Context, clientID uuid.UUID) (int64, er
 	return credits, err
 }
 
+// CreditTotals sums credit locks by state. Captured amounts are net of refunds.
+func (s *Service) CreditTotals(ctx context.Context) (map[string]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT state, COALESCE(SUM(amount_cents - refunded_cents), 0)
+		FROM credit_locks GROUP BY state`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[string]int64)
+	for rows.Next() {
+		var state string
+		var cents int64
+		if err := rows.Scan(&state, &cents); err != nil {
+			return nil, err
+		}
+		totals[state] = cents
+	}
+	return totals, rows.Err()
+}
+
 func (s *Service) AddCredits(ctx context.Context, clientID uuid.UUID, amount int64) error {
 	_, err := s.db.ExecContext(ctx, "UPDATE clients SET credit_cents = credit_cents + $1 WHERE id = $2", amount, clientID)
 	if err != nil {
 		return err
 	}
-	s.logger.Info("credits added", "client", clientID, "amount", amount)
+	s.logger.InfoContext(ctx, "credits added", "client", clientID, "amount", amount)
 	return nil
 }
 
@@ -125,5 +213,6 @@ type CreditLock struct {
 	ClientID  uuid.UUID `json:"client_id"`
 	MessageID uuid.UUID `json:"message_id"`
 	Amount    int64     `json:"amount"`
+	Refunded  int64     `json:"refunded"`
 	State     string    `json:"state"`
 }
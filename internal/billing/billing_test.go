@@ -1,6 +1,12 @@
 package billing
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"sms-gateway/internal/messages"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/google/uuid"
@@ -23,3 +29,126 @@ func TestCreditLock(t *testing.T) {
 		t.Errorf("Expected amount 100, got %d", lock.Amount)
 	}
 }
+
+func TestPricingCost(t *testing.T) {
+	pricing := NewPricing(5, 2, map[string]int64{"otp": 3})
+
+	tests := []struct {
+		parts    int
+		priority messages.Priority
+		expected int64
+	}{
+		{1, messages.PriorityStandard, 5},
+		{2, messages.PriorityExpress, 14}, // legacy express surcharge
+		{1, messages.PriorityOTP, 8},
+		{3, messages.PriorityBulk, 15},
+	}
+
+	for _, tt := range tests {
+		if got := pricing.Cost(tt.parts, tt.priority); got != tt.expected {
+			t.Errorf("Cost(%d, %s) = %d, want %d", tt.parts, tt.priority, got, tt.expected)
+		}
+	}
+}
+
+func TestMemoryServiceHoldIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	svc := NewMemoryService()
+	clientID := uuid.New()
+	svc.SetCredits(clientID, 50)
+
+	// 100 concurrent holds of 1 against 50 credits: exactly 50 may succeed
+	var held int64
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := svc.HoldCredits(ctx, clientID, uuid.New(), 1); err == nil {
+				atomic.AddInt64(&held, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if held != 50 {
+		t.Errorf("Expected 50 successful holds, got %d", held)
+	}
+	if credits, _ := svc.GetCredits(ctx, clientID); credits != 0 {
+		t.Errorf("Expected 0 credits left, got %d", credits)
+	}
+}
+
+func TestMemoryServiceLockTransitions(t *testing.T) {
+	ctx := context.Background()
+	svc := NewMemoryService()
+	clientID, messageID := uuid.New(), uuid.New()
+	svc.SetCredits(clientID, 10)
+
+	svc.HoldCredits(ctx, clientID, messageID, 4)
+	if err := svc.CaptureCredits(ctx, messageID); err != nil {
+		t.Fatal(err)
+	}
+	// A captured lock can no longer be released
+	if err := svc.ReleaseCredits(ctx, messageID); err == nil {
+		t.Error("Expected error releasing a captured lock")
+	}
+	if credits, _ := svc.GetCredits(ctx, clientID); credits != 6 {
+		t.Errorf("Expected 6 credits, got %d", credits)
+	}
+	if _, err := svc.GetCredits(ctx, uuid.New()); err == nil {
+		t.Error("Expected error for unknown client")
+	}
+}
+
+func TestMemoryServiceRefund(t *testing.T) {
+	ctx := context.Background()
+	svc := NewMemoryService()
+	clientID, messageID := uuid.New(), uuid.New()
+	svc.SetCredits(clientID, 100)
+	svc.HoldCredits(ctx, clientID, messageID, 30)
+	svc.CaptureCredits(ctx, messageID)
+
+	// Refunds are cumulative: asking twice for the same share pays it once
+	svc.RefundCredits(ctx, messageID, 1, 3)
+	svc.RefundCredits(ctx, messageID, 1, 3)
+	if balance, _ := svc.GetCredits(ctx, clientID); balance != 80 {
+		t.Errorf("Expected balance 80 after refunding 1 of 3 parts, got %d", balance)
+	}
+	svc.RefundCredits(ctx, messageID, 3, 3)
+	if balance, _ := svc.GetCredits(ctx, clientID); balance != 100 {
+		t.Errorf("Expected a full refund to restore 100, got %d", balance)
+	}
+	if locks := svc.Locks(messageID); locks[0].State != "CAPTURED" || locks[0].Refunded != 30 {
+		t.Errorf("Expected a captured lock with 30 refunded, got %+v", locks[0])
+	}
+
+	if err := svc.RefundCredits(ctx, uuid.New(), 1, 1); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows without a lock, got %v", err)
+	}
+}
+
+func TestMemoryServiceCreditLimitAndSuspension(t *testing.T) {
+	ctx := context.Background()
+	svc := NewMemoryService()
+	clientID := uuid.New()
+	svc.SetCredits(clientID, 3)
+	svc.SetCreditLimit(clientID, 10)
+
+	// The balance may go down to -10
+	if _, err := svc.HoldCredits(ctx, clientID, uuid.New(), 13); err != nil {
+		t.Fatalf("Expected a hold within the credit limit, got %v", err)
+	}
+	if _, err := svc.HoldCredits(ctx, clientID, uuid.New(), 1); !errors.Is(err, ErrInsufficientCredits) {
+		t.Errorf("Expected ErrInsufficientCredits past the limit, got %v", err)
+	}
+	if credits, _ := svc.GetCredits(ctx, clientID); credits != -10 {
+		t.Errorf("Expected -10 credits, got %d", credits)
+	}
+
+	svc.SetCredits(clientID, 100)
+	svc.SetSuspended(clientID, true)
+	if _, err := svc.HoldCredits(ctx, clientID, uuid.New(), 1); !errors.Is(err, ErrSuspended) {
+		t.Errorf("Expected ErrSuspended, got %v", err)
+	}
+}
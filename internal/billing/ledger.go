@@ -0,0 +1,31 @@
+package billing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInsufficientCredits means the balance plus the credit limit does not cover a hold
+	ErrInsufficientCredits = errors.New("insufficient credits")
+	// ErrSuspended means the client is suspended and may not spend credits
+	ErrSuspended = errors.New("client suspended")
+)
+
+// Ledger is the credit accounting used by the API, worker and delivery
+// services. Service implements it on Postgres and MemoryService in memory.
+type Ledger interface {
+	HoldCredits(ctx context.Context, clientID, messageID uuid.UUID, amount int64) (*CreditLock, error)
+	CaptureCredits(ctx context.Context, messageID uuid.UUID) error
+	ReleaseCredits(ctx context.Context, messageID uuid.UUID) error
+	RefundCredits(ctx context.Context, messageID uuid.UUID, parts, ofParts int) error
+	GetCredits(ctx context.Context, clientID uuid.UUID) (int64, error)
+	AddCredits(ctx context.Context, clientID uuid.UUID, amount int64) error
+}
+
+var (
+	_ Ledger = (*Service)(nil)
+	_ Ledger = (*MemoryService)(nil)
+)
@@ -0,0 +1,166 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// MemoryService is an in-memory Ledger with the same semantics as Service:
+// holds check and deduct the balance atomically, and locks only move from
+// HELD to CAPTURED or RELEASED.
+type MemoryService struct {
+	mu        sync.Mutex
+	credits   map[uuid.UUID]int64
+	limits    map[uuid.UUID]int64
+	suspended map[uuid.UUID]bool
+	locks     []*CreditLock
+}
+
+func NewMemoryService() *MemoryService {
+	return &MemoryService{
+		credits:   make(map[uuid.UUID]int64),
+		limits:    make(map[uuid.UUID]int64),
+		suspended: make(map[uuid.UUID]bool),
+	}
+}
+
+// SetCredits creates the client if needed and sets its balance
+func (s *MemoryService) SetCredits(clientID uuid.UUID, amount int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.credits[clientID] = amount
+}
+
+// SetCreditLimit lets a client's balance go down to -limit, like clients.credit_limit_cents
+func (s *MemoryService) SetCreditLimit(clientID uuid.UUID, limit int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits[clientID] = limit
+}
+
+// SetSuspended suspends or reinstates a client, like clients.suspended
+func (s *MemoryService) SetSuspended(clientID uuid.UUID, suspended bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.suspended[clientID] = suspended
+}
+
+// Locks returns copies of every credit lock taken for a message
+func (s *MemoryService) Locks(messageID uuid.UUID) []CreditLock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []CreditLock
+	for _, lock := range s.locks {
+		if lock.MessageID == messageID {
+			out = append(out, *lock)
+		}
+	}
+	return out
+}
+
+func (s *MemoryService) HoldCredits(ctx context.Context, clientID, messageID uuid.UUID, amount int64) (*CreditLock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	balance, ok := s.credits[clientID]
+	if ok && s.suspended[clientID] {
+		return nil, ErrSuspended
+	}
+	if !ok || balance+s.limits[clientID] < amount {
+		return nil, ErrInsufficientCredits
+	}
+	s.credits[clientID] = balance - amount
+
+	lock := &CreditLock{
+		ID:        uuid.New(),
+		ClientID:  clientID,
+		MessageID: messageID,
+		Amount:    amount,
+		State:     "HELD",
+	}
+	s.locks = append(s.locks, lock)
+
+	copied := *lock
+	return &copied, nil
+}
+
+func (s *MemoryService) CaptureCredits(ctx context.Context, messageID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, lock := range s.locks {
+		if lock.MessageID == messageID && lock.State == "HELD" {
+			lock.State = "CAPTURED"
+		}
+	}
+	return nil
+}
+
+func (s *MemoryService) ReleaseCredits(ctx context.Context, messageID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, lock := range s.locks {
+		if lock.MessageID == messageID && lock.State == "HELD" {
+			s.credits[lock.ClientID] += lock.Amount
+			lock.State = "RELEASED"
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s *MemoryService) RefundCredits(ctx context.Context, messageID uuid.UUID, parts, ofParts int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.locks) - 1; i >= 0; i-- {
+		lock := s.locks[i]
+		if lock.MessageID != messageID || (lock.State != "HELD" && lock.State != "CAPTURED") {
+			continue
+		}
+		due := refundDue(*lock, parts, ofParts)
+		s.credits[lock.ClientID] += due
+		lock.Refunded += due
+		lock.State = "CAPTURED"
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+func (s *MemoryService) GetCredits(ctx context.Context, clientID uuid.UUID) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credits, ok := s.credits[clientID]
+	if !ok {
+		return 0, fmt.Errorf("client not found")
+	}
+	return credits, nil
+}
+
+func (s *MemoryService) CreditTotals(ctx context.Context) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totals := make(map[string]int64)
+	for _, lock := range s.locks {
+		totals[lock.State] += lock.Amount - lock.Refunded
+	}
+	return totals, nil
+}
+
+func (s *MemoryService) AddCredits(ctx context.Context, clientID uuid.UUID, amount int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.credits[clientID]; ok {
+		s.credits[clientID] += amount
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package billing
+
+import "sms-gateway/internal/messages"
+
+// Pricing computes what a message costs: a base price per part plus a
+// per-part surcharge that depends on the message's priority class
+type Pricing struct {
+	PricePerPart int64
+	Surcharges   map[messages.Priority]int64
+}
+
+// NewPricing builds pricing from config. The express class falls back to
+// the legacy express surcharge unless it is set explicitly.
+func NewPricing(pricePerPart, expressSurcharge int64, surcharges map[string]int64) Pricing {
+	p := Pricing{PricePerPart: pricePerPart, Surcharges: make(map[messages.Priority]int64)}
+	p.Surcharges[messages.PriorityExpress] = expressSurcharge
+	for class, cents := range surcharges {
+		p.Surcharges[messages.Priority(class)] = cents
+	}
+	return p
+}
+
+// Cost returns the total price in cents for a message of the given size and class
+func (p Pricing) Cost(parts int, priority messages.Priority) int64 {
+	return int64(parts) * (p.PricePerPart + p.Surcharges[priority])
+}
@@ -0,0 +1,127 @@
+// Package clients manages the accounts that send through the gateway:
+// their callback settings, credit limit, suspension and scoped API keys.
+package clients
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotFound = errors.New("client not found")
+	ErrInUse    = errors.New("client has messages or credit history")
+	ErrInvalid  = errors.New("invalid client")
+)
+
+// Client is a clients row as operators see it. The callback secret is never
+// returned once stored.
+type Client struct {
+	ID                uuid.UUID `json:"id"`
+	Name              string    `json:"name"`
+	CallbackURL       *string   `json:"callback_url,omitempty"`
+	CallbackSecretSet bool      `json:"callback_secret_set"`
+	CreditCents       int64     `json:"credit_cents"`
+	CreditLimitCents  int64     `json:"credit_limit_cents"` // How far below zero the balance may go
+	Suspended         bool      `json:"suspended"`
+	UniqueReferences  bool      `json:"unique_references"` // A reference names at most one message
+	Limits            Limits    `json:"limits"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// CreateRequest is the admin request body for a new client
+type CreateRequest struct {
+	Name             string  `json:"name"`
+	CallbackURL      *string `json:"callback_url"`
+	CallbackSecret   *string `json:"callback_secret"`
+	CreditCents      int64   `json:"credit_cents"` // Opening balance
+	CreditLimitCents int64   `json:"credit_limit_cents"`
+	UniqueReferences bool    `json:"unique_references"`
+	Limits           Limits  `json:"limits"`
+}
+
+// Limits caps the traffic of a client or one of its keys. A nil field falls
+// back to the configured default; zero means unlimited.
+type Limits struct {
+	RequestsPerSecond *int64 `json:"requests_per_second"`
+	MessagesPerDay    *int64 `json:"messages_per_day"`
+	MessagesPerMonth  *int64 `json:"messages_per_month"`
+}
+
+func (l Limits) Validate() error {
+	for _, v := range []*int64{l.RequestsPerSecond, l.MessagesPerDay, l.MessagesPerMonth} {
+		if v != nil && *v < 0 {
+			return fmt.Errorf("%w: limits may not be negative", ErrInvalid)
+		}
+	}
+	return nil
+}
+
+// UpdateRequest changes only the fields that are set. An empty callback URL
+// or secret clears it.
+type UpdateRequest struct {
+	Name             *string `json:"name"`
+	CallbackURL      *string `json:"callback_url"`
+	CallbackSecret   *string `json:"callback_secret"`
+	CreditLimitCents *int64  `json:"credit_limit_cents"`
+	Suspended        *bool   `json:"suspended"`
+	UniqueReferences *bool   `json:"unique_references"`
+}
+
+// Repository is client persistence. Store is the Postgres implementation
+// and MemoryStore the in-memory one used by tests.
+type Repository interface {
+	// Create adds a client together with its first key, which has every scope
+	Create(ctx context.Context, req *CreateRequest, keyHash string) (*Client, *APIKey, error)
+	Get(ctx context.Context, id uuid.UUID) (*Client, error)
+	List(ctx context.Context, limit, offset int) ([]*Client, error)
+	Update(ctx context.Context, id uuid.UUID, req *UpdateRequest) (*Client, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	// SetLimits replaces a client's limits
+	SetLimits(ctx context.Context, id uuid.UUID, limits Limits) (*Client, error)
+
+	CreateKey(ctx context.Context, clientID uuid.UUID, req *KeyRequest, keyHash string) (*APIKey, error)
+	ListKeys(ctx context.Context, clientID uuid.UUID) ([]*APIKey, error)
+	RevokeKey(ctx context.Context, clientID, keyID uuid.UUID) (*APIKey, error)
+	SetKeyLimits(ctx context.Context, clientID, keyID uuid.UUID, limits Limits) (*APIKey, error)
+	// RotateKey issues keyHash with the name, scopes, allowlist and expiry of
+	// keyID. The old key keeps working until now + overlap, or its own
+	// expiry if that comes first.
+	RotateKey(ctx context.Context, clientID, keyID uuid.UUID, keyHash string, overlap time.Duration) (*APIKey, error)
+	// ByKey returns the active key with hash keyHash, with its client's limits
+	ByKey(ctx context.Context, keyHash string) (*APIKey, error)
+	// TouchKey records that a key was used at at
+	TouchKey(ctx context.Context, keyID uuid.UUID, at time.Time) error
+}
+
+var (
+	_ Repository = (*Store)(nil)
+	_ Repository = (*MemoryStore)(nil)
+)
+
+const keyPrefix = "sgw_"
+
+// NewKey returns a random API key and its hash. Only the hash is stored.
+func NewKey() (key, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = keyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, HashKey(key), nil
+}
+
+// HashKey returns what api_keys.key_hash stores for key. Keys are long and
+// random, so a plain SHA-256 is enough and keeps lookups indexable.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
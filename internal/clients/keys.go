@@ -0,0 +1,137 @@
+package clients
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scope is what an API key may be used for
+type Scope string
+
+const (
+	ScopeSend    Scope = "send"     // Any message, OTP included
+	ScopeSendOTP Scope = "send:otp" // OTP messages only
+	ScopeRead    Scope = "read"     // Messages, events and account info
+	ScopeBilling Scope = "billing"  // Credit balance
+)
+
+// Scopes lists every scope, and is what a client's first key gets
+var Scopes = []Scope{ScopeSend, ScopeRead, ScopeBilling}
+
+func (s Scope) Valid() bool {
+	switch s {
+	case ScopeSend, ScopeSendOTP, ScopeRead, ScopeBilling:
+		return true
+	}
+	return false
+}
+
+var (
+	ErrKeyNotFound  = errors.New("api key not found")
+	ErrIPNotAllowed = errors.New("ip address not allowed for api key")
+)
+
+// APIKey is an api_keys row. The key itself is never returned once issued.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	ClientID   uuid.UUID  `json:"client_id"`
+	Name       string     `json:"name"`
+	Scopes     []Scope    `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips"`          // CIDRs; any address when empty
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // Also set when the key is rotated out
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Limits     Limits     `json:"limits"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	ClientLimits Limits `json:"-"` // Set by authentication
+}
+
+// Allows reports whether the key grants scope. send covers send:otp.
+func (k *APIKey) Allows(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope || (s == ScopeSend && scope == ScopeSendOTP) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsIP reports whether the key may be used from ip
+func (k *APIKey) AllowsIP(ip string) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, allowed := range k.AllowedIPs {
+		if prefix, err := netip.ParsePrefix(allowed); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the key is neither revoked nor expired at now
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || k.ExpiresAt.After(now))
+}
+
+// KeyRequest is the admin request body for a new API key
+type KeyRequest struct {
+	Name       string     `json:"name"`
+	Scopes     []Scope    `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips"` // Addresses or CIDRs
+	ExpiresAt  *time.Time `json:"expires_at"`
+	Limits     Limits     `json:"limits"`
+}
+
+// Validate checks the request and normalizes the allowlist to CIDRs
+func (r *KeyRequest) Validate(now time.Time) error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	if len(r.Scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope is required", ErrInvalid)
+	}
+	for _, s := range r.Scopes {
+		if !s.Valid() {
+			return fmt.Errorf("%w: unknown scope %q", ErrInvalid, s)
+		}
+	}
+	for i, raw := range r.AllowedIPs {
+		prefix, err := parsePrefix(raw)
+		if err != nil {
+			return fmt.Errorf("%w: allowed_ips: %q is not an address or CIDR", ErrInvalid, raw)
+		}
+		r.AllowedIPs[i] = prefix.String()
+	}
+	if r.ExpiresAt != nil && !r.ExpiresAt.After(now) {
+		return fmt.Errorf("%w: expires_at must be in the future", ErrInvalid)
+	}
+	return r.Limits.Validate()
+}
+
+// parsePrefix accepts a CIDR or a bare address, which becomes a host prefix
+func parsePrefix(raw string) (netip.Prefix, error) {
+	raw = strings.TrimSpace(raw)
+	if strings.Contains(raw, "/") {
+		prefix, err := netip.ParsePrefix(raw)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(raw)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
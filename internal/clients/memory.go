@@ -0,0 +1,286 @@
+package clients
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore is an in-memory Repository with the same semantics as Store,
+// for hermetic tests
+type MemoryStore struct {
+	mu      sync.Mutex
+	clients map[uuid.UUID]*memoryClient
+	keys    map[uuid.UUID]*memoryKey
+}
+
+type memoryClient struct {
+	Client
+	callbackSecret *string
+}
+
+type memoryKey struct {
+	APIKey
+	hash string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		clients: make(map[uuid.UUID]*memoryClient),
+		keys:    make(map[uuid.UUID]*memoryKey),
+	}
+}
+
+func (s *MemoryStore) Create(ctx context.Context, req *CreateRequest, keyHash string) (*Client, *APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	c := &memoryClient{
+		Client: Client{
+			ID:                uuid.New(),
+			Name:              req.Name,
+			CallbackURL:       req.CallbackURL,
+			CallbackSecretSet: req.CallbackSecret != nil,
+			CreditCents:       req.CreditCents,
+			CreditLimitCents:  req.CreditLimitCents,
+			UniqueReferences:  req.UniqueReferences,
+			Limits:            req.Limits,
+			CreatedAt:         now,
+			UpdatedAt:         now,
+		},
+		callbackSecret: req.CallbackSecret,
+	}
+	s.clients[c.ID] = c
+	k := s.addKey(c.ID, &KeyRequest{Name: "default", Scopes: Scopes}, keyHash)
+	copied := c.Client
+	return &copied, k, nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id uuid.UUID) (*Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := c.Client
+	return &copied, nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, limit, offset int) ([]*Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := make([]*Client, 0, len(s.clients))
+	for _, c := range s.clients {
+		copied := c.Client
+		all = append(all, &copied)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].CreatedAt.Equal(all[j].CreatedAt) {
+			return all[i].CreatedAt.Before(all[j].CreatedAt)
+		}
+		return all[i].ID.String() < all[j].ID.String()
+	})
+	if offset >= len(all) {
+		return nil, nil
+	}
+	all = all[offset:]
+	if len(all) > limit {
+		all = all[:limit]
+	}
+	return all, nil
+}
+
+func (s *MemoryStore) Update(ctx context.Context, id uuid.UUID, req *UpdateRequest) (*Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.clients[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if req.Name != nil {
+		c.Name = *req.Name
+	}
+	if req.CallbackURL != nil {
+		c.CallbackURL = nonEmpty(*req.CallbackURL)
+	}
+	if req.CallbackSecret != nil {
+		c.callbackSecret = nonEmpty(*req.CallbackSecret)
+		c.CallbackSecretSet = c.callbackSecret != nil
+	}
+	if req.CreditLimitCents != nil {
+		c.CreditLimitCents = *req.CreditLimitCents
+	}
+	if req.Suspended != nil {
+		c.Suspended = *req.Suspended
+	}
+	if req.UniqueReferences != nil {
+		c.UniqueReferences = *req.UniqueReferences
+	}
+	c.UpdatedAt = time.Now()
+	copied := c.Client
+	return &copied, nil
+}
+
+func (s *MemoryStore) SetLimits(ctx context.Context, id uuid.UUID, limits Limits) (*Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c.Limits = limits
+	c.UpdatedAt = time.Now()
+	copied := c.Client
+	return &copied, nil
+}
+
+// Delete always succeeds for known clients; the memory store keeps no
+// messages or credit history to block it
+func (s *MemoryStore) Delete(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.clients, id)
+	for keyID, k := range s.keys {
+		if k.ClientID == id {
+			delete(s.keys, keyID)
+		}
+	}
+	return nil
+}
+
+// addKey stores a key; callers hold mu
+func (s *MemoryStore) addKey(clientID uuid.UUID, req *KeyRequest, keyHash string) *APIKey {
+	k := &memoryKey{
+		APIKey: APIKey{
+			ID:         uuid.New(),
+			ClientID:   clientID,
+			Name:       req.Name,
+			Scopes:     append([]Scope(nil), req.Scopes...),
+			AllowedIPs: append([]string{}, req.AllowedIPs...),
+			ExpiresAt:  req.ExpiresAt,
+			Limits:     req.Limits,
+			CreatedAt:  time.Now(),
+		},
+		hash: keyHash,
+	}
+	s.keys[k.ID] = k
+	copied := k.APIKey
+	return &copied
+}
+
+func (s *MemoryStore) CreateKey(ctx context.Context, clientID uuid.UUID, req *KeyRequest, keyHash string) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[clientID]; !ok {
+		return nil, ErrNotFound
+	}
+	return s.addKey(clientID, req, keyHash), nil
+}
+
+func (s *MemoryStore) ListKeys(ctx context.Context, clientID uuid.UUID) ([]*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []*APIKey
+	for _, k := range s.keys {
+		if k.ClientID == clientID {
+			copied := k.APIKey
+			out = append(out, &copied)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].ID.String() < out[j].ID.String()
+	})
+	return out, nil
+}
+
+func (s *MemoryStore) RevokeKey(ctx context.Context, clientID, keyID uuid.UUID) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.keys[keyID]
+	if !ok || k.ClientID != clientID {
+		return nil, ErrKeyNotFound
+	}
+	if k.RevokedAt == nil {
+		now := time.Now()
+		k.RevokedAt = &now
+	}
+	copied := k.APIKey
+	return &copied, nil
+}
+
+func (s *MemoryStore) SetKeyLimits(ctx context.Context, clientID, keyID uuid.UUID, limits Limits) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[keyID]
+	if !ok || k.ClientID != clientID {
+		return nil, ErrKeyNotFound
+	}
+	k.Limits = limits
+	copied := k.APIKey
+	return &copied, nil
+}
+
+func (s *MemoryStore) RotateKey(ctx context.Context, clientID, keyID uuid.UUID, keyHash string, overlap time.Duration) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	old, ok := s.keys[keyID]
+	if !ok || old.ClientID != clientID || !old.Active(now) {
+		return nil, ErrKeyNotFound
+	}
+	k := s.addKey(clientID, &KeyRequest{Name: old.Name, Scopes: old.Scopes, AllowedIPs: old.AllowedIPs,
+		ExpiresAt: old.ExpiresAt, Limits: old.Limits}, keyHash)
+	if expires := now.Add(overlap); old.ExpiresAt == nil || expires.Before(*old.ExpiresAt) {
+		old.ExpiresAt = &expires
+	}
+	return k, nil
+}
+
+func (s *MemoryStore) ByKey(ctx context.Context, keyHash string) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, k := range s.keys {
+		if k.hash == keyHash && k.Active(now) {
+			copied := k.APIKey
+			if c, ok := s.clients[k.ClientID]; ok {
+				copied.ClientLimits = c.Limits
+			}
+			return &copied, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+func (s *MemoryStore) TouchKey(ctx context.Context, keyID uuid.UUID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.keys[keyID]; ok && (k.LastUsedAt == nil || k.LastUsedAt.Before(at)) {
+		k.LastUsedAt = &at
+	}
+	return nil
+}
+
+func nonEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,250 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultKeyOverlap is how long a rotated-out key keeps working
+	DefaultKeyOverlap = 24 * time.Hour
+	// MaxKeyOverlap bounds how long two keys may be valid at once
+	MaxKeyOverlap = 30 * 24 * time.Hour
+	// MaxList caps one page of clients
+	MaxList = 500
+
+	// touchInterval limits last_used_at writes to one per key per interval
+	touchInterval = time.Minute
+)
+
+// Issued is an API key with its plaintext, which is only ever shown here.
+// Client is set when the key came with a new client.
+type Issued struct {
+	Client *Client `json:"client,omitempty"`
+	Key    *APIKey `json:"key"`
+	APIKey string  `json:"api_key"`
+}
+
+// Service validates client changes and issues API keys
+type Service struct {
+	logger *slog.Logger
+	store  Repository
+}
+
+func NewService(logger *slog.Logger, store Repository) *Service {
+	return &Service{logger: logger, store: store}
+}
+
+// Create adds a client with a first API key that has every scope
+func (s *Service) Create(ctx context.Context, req *CreateRequest, actor string) (*Issued, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	if req.CreditCents < 0 || req.CreditLimitCents < 0 {
+		return nil, fmt.Errorf("%w: credit_cents and credit_limit_cents may not be negative", ErrInvalid)
+	}
+	if err := validateCallback(req.CallbackURL); err != nil {
+		return nil, err
+	}
+	if err := req.Limits.Validate(); err != nil {
+		return nil, err
+	}
+	req.CallbackURL = nonEmptyPtr(req.CallbackURL)
+	req.CallbackSecret = nonEmptyPtr(req.CallbackSecret)
+
+	key, hash, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	c, k, err := s.store.Create(ctx, req, hash)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.InfoContext(ctx, "Client created", "client", c.ID, "name", c.Name, "actor", actor)
+	return &Issued{Client: c, Key: k, APIKey: key}, nil
+}
+
+func (s *Service) Get(ctx context.Context, id uuid.UUID) (*Client, error) {
+	return s.store.Get(ctx, id)
+}
+
+func (s *Service) List(ctx context.Context, limit, offset int) ([]*Client, error) {
+	if limit <= 0 || limit > MaxList {
+		limit = MaxList
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return s.store.List(ctx, limit, offset)
+}
+
+// Update changes a client's settings. Suspending a client makes new sends
+// fail; messages already queued are still sent.
+func (s *Service) Update(ctx context.Context, id uuid.UUID, req *UpdateRequest, actor string) (*Client, error) {
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return nil, fmt.Errorf("%w: name may not be empty", ErrInvalid)
+		}
+		req.Name = &name
+	}
+	if req.CreditLimitCents != nil && *req.CreditLimitCents < 0 {
+		return nil, fmt.Errorf("%w: credit_limit_cents may not be negative", ErrInvalid)
+	}
+	if err := validateCallback(req.CallbackURL); err != nil {
+		return nil, err
+	}
+
+	c, err := s.store.Update(ctx, id, req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Suspended != nil {
+		s.logger.WarnContext(ctx, "Client suspension changed", "client", id, "suspended", *req.Suspended, "actor", actor)
+	} else {
+		s.logger.InfoContext(ctx, "Client updated", "client", id, "actor", actor)
+	}
+	return c, nil
+}
+
+// SetLimits replaces a client's request rate and message quotas
+func (s *Service) SetLimits(ctx context.Context, id uuid.UUID, limits Limits, actor string) (*Client, error) {
+	if err := limits.Validate(); err != nil {
+		return nil, err
+	}
+	c, err := s.store.SetLimits(ctx, id, limits)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.InfoContext(ctx, "Client limits changed", "client", id, "actor", actor)
+	return c, nil
+}
+
+func (s *Service) Delete(ctx context.Context, id uuid.UUID, actor string) error {
+	if err := s.store.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.logger.InfoContext(ctx, "Client deleted", "client", id, "actor", actor)
+	return nil
+}
+
+// CreateKey issues another API key for a client
+func (s *Service) CreateKey(ctx context.Context, clientID uuid.UUID, req *KeyRequest, actor string) (*Issued, error) {
+	if err := req.Validate(time.Now()); err != nil {
+		return nil, err
+	}
+	key, hash, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	k, err := s.store.CreateKey(ctx, clientID, req, hash)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.InfoContext(ctx, "API key created", "client", clientID, "key", k.ID, "scopes", k.Scopes, "actor", actor)
+	return &Issued{Key: k, APIKey: key}, nil
+}
+
+// ListKeys lists a client's keys, revoked and expired ones included
+func (s *Service) ListKeys(ctx context.Context, clientID uuid.UUID) ([]*APIKey, error) {
+	if _, err := s.store.Get(ctx, clientID); err != nil {
+		return nil, err
+	}
+	return s.store.ListKeys(ctx, clientID)
+}
+
+// RevokeKey stops a key working at once
+func (s *Service) RevokeKey(ctx context.Context, clientID, keyID uuid.UUID, actor string) (*APIKey, error) {
+	k, err := s.store.RevokeKey(ctx, clientID, keyID)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.WarnContext(ctx, "API key revoked", "client", clientID, "key", keyID, "actor", actor)
+	return k, nil
+}
+
+// SetKeyLimits replaces a key's request rate and message quotas, which apply
+// on top of its client's
+func (s *Service) SetKeyLimits(ctx context.Context, clientID, keyID uuid.UUID, limits Limits, actor string) (*APIKey, error) {
+	if err := limits.Validate(); err != nil {
+		return nil, err
+	}
+	k, err := s.store.SetKeyLimits(ctx, clientID, keyID, limits)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.InfoContext(ctx, "API key limits changed", "client", clientID, "key", keyID, "actor", actor)
+	return k, nil
+}
+
+// RotateKey replaces a key with a new one with the same scopes and
+// allowlist. The old one keeps working for overlap (DefaultKeyOverlap when
+// zero) so the client can roll the new one out.
+func (s *Service) RotateKey(ctx context.Context, clientID, keyID uuid.UUID, overlap time.Duration, actor string) (*Issued, error) {
+	if overlap == 0 {
+		overlap = DefaultKeyOverlap
+	}
+	if overlap < 0 || overlap > MaxKeyOverlap {
+		return nil, fmt.Errorf("%w: overlap must be between 0 and %s", ErrInvalid, MaxKeyOverlap)
+	}
+
+	key, hash, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	k, err := s.store.RotateKey(ctx, clientID, keyID, hash, overlap)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.InfoContext(ctx, "API key rotated", "client", clientID, "key", keyID, "new_key", k.ID,
+		"overlap", overlap.String(), "actor", actor)
+	return &Issued{Key: k, APIKey: key}, nil
+}
+
+// Authenticate returns the active key presented from ip. Keys rotated out
+// are accepted until their overlap ends.
+func (s *Service) Authenticate(ctx context.Context, key, ip string) (*APIKey, error) {
+	if key == "" {
+		return nil, ErrKeyNotFound
+	}
+	k, err := s.store.ByKey(ctx, HashKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if !k.AllowsIP(ip) {
+		return nil, ErrIPNotAllowed
+	}
+
+	now := time.Now()
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= touchInterval {
+		if err := s.store.TouchKey(ctx, k.ID, now); err != nil {
+			s.logger.WarnContext(ctx, "failed to record API key use", "key", k.ID, "error", err)
+		}
+	}
+	return k, nil
+}
+
+func validateCallback(raw *string) error {
+	if raw == nil || *raw == "" {
+		return nil
+	}
+	u, err := url.Parse(*raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("%w: callback_url must be an http or https URL", ErrInvalid)
+	}
+	return nil
+}
+
+func nonEmptyPtr(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	return nonEmpty(*s)
+}
@@ -0,0 +1,239 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newTestService() (*Service, *MemoryStore) {
+	store := NewMemoryStore()
+	return NewService(slog.New(slog.NewTextHandler(os.Stdout, nil)), store), store
+}
+
+func TestCreateValidation(t *testing.T) {
+	service, _ := newTestService()
+	bad := "ftp://example.com/dlr"
+	negative := int64(-1)
+
+	tests := []struct {
+		name string
+		req  CreateRequest
+	}{
+		{"missing name", CreateRequest{Name: "  "}},
+		{"bad callback", CreateRequest{Name: "Acme", CallbackURL: &bad}},
+		{"negative limit", CreateRequest{Name: "Acme", CreditLimitCents: negative}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.Create(context.Background(), &tt.req, "test"); !errors.Is(err, ErrInvalid) {
+				t.Errorf("Expected ErrInvalid, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateIssuesKey(t *testing.T) {
+	ctx := context.Background()
+	service, store := newTestService()
+	callback := "https://acme.example/dlr"
+
+	issued, err := service.Create(ctx, &CreateRequest{Name: "Acme", CallbackURL: &callback, CreditCents: 500}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(issued.APIKey, keyPrefix) {
+		t.Errorf("Expected a %s key, got %q", keyPrefix, issued.APIKey)
+	}
+	for _, scope := range Scopes {
+		if !issued.Key.Allows(scope) {
+			t.Errorf("Expected the first key to have scope %s, got %v", scope, issued.Key.Scopes)
+		}
+	}
+
+	// Only the hash is kept
+	stored := store.keys[issued.Key.ID]
+	if stored.hash == issued.APIKey || stored.hash != HashKey(issued.APIKey) {
+		t.Errorf("Expected the key hash to be stored, got %q", stored.hash)
+	}
+	key, err := service.Authenticate(ctx, issued.APIKey, "192.0.2.1")
+	if err != nil || key.ClientID != issued.Client.ID {
+		t.Errorf("Expected the new key to authenticate, got %v, %v", key, err)
+	}
+	if keys, _ := store.ListKeys(ctx, issued.Client.ID); keys[0].LastUsedAt == nil {
+		t.Error("Expected last_used_at to be recorded")
+	}
+	if _, err := service.Authenticate(ctx, stored.hash, "192.0.2.1"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected the hash itself not to authenticate, got %v", err)
+	}
+}
+
+func TestRotateKeyOverlap(t *testing.T) {
+	ctx := context.Background()
+	service, _ := newTestService()
+	issued, _ := service.Create(ctx, &CreateRequest{Name: "Acme"}, "test")
+	oldKey := issued.APIKey
+
+	rotated, err := service.RotateKey(ctx, issued.Client.ID, issued.Key.ID, 50*time.Millisecond, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.APIKey == oldKey || rotated.Key.ID == issued.Key.ID {
+		t.Fatalf("Expected a new key, got %+v", rotated.Key)
+	}
+	if len(rotated.Key.Scopes) != len(issued.Key.Scopes) {
+		t.Errorf("Expected the new key to keep the scopes, got %v", rotated.Key.Scopes)
+	}
+
+	// Both keys work during the overlap
+	for _, key := range []string{oldKey, rotated.APIKey} {
+		if _, err := service.Authenticate(ctx, key, "192.0.2.1"); err != nil {
+			t.Errorf("Expected key to work during the overlap, got %v", err)
+		}
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := service.Authenticate(ctx, oldKey, "192.0.2.1"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected the old key to stop working after the overlap, got %v", err)
+	}
+	if _, err := service.Authenticate(ctx, rotated.APIKey, "192.0.2.1"); err != nil {
+		t.Errorf("Expected the new key to keep working, got %v", err)
+	}
+
+	if _, err := service.RotateKey(ctx, issued.Client.ID, rotated.Key.ID, -time.Second, "test"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected ErrInvalid for a negative overlap, got %v", err)
+	}
+	if _, err := service.RotateKey(ctx, issued.Client.ID, issued.Key.ID, 0, "test"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected an expired key not to rotate, got %v", err)
+	}
+}
+
+func TestScopedKeys(t *testing.T) {
+	ctx := context.Background()
+	service, _ := newTestService()
+	issued, _ := service.Create(ctx, &CreateRequest{Name: "Acme"}, "test")
+	clientID := issued.Client.ID
+
+	otp, err := service.CreateKey(ctx, clientID, &KeyRequest{Name: "otp service", Scopes: []Scope{ScopeSendOTP},
+		AllowedIPs: []string{"10.1.0.0/16", "192.0.2.7"}}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !otp.Key.Allows(ScopeSendOTP) || otp.Key.Allows(ScopeSend) || otp.Key.Allows(ScopeRead) {
+		t.Errorf("Expected only send:otp, got %v", otp.Key.Scopes)
+	}
+	if otp.Key.AllowedIPs[1] != "192.0.2.7/32" {
+		t.Errorf("Expected a bare address to become a /32, got %v", otp.Key.AllowedIPs)
+	}
+
+	tests := []struct {
+		ip   string
+		want error
+	}{
+		{"10.1.2.3", nil},
+		{"192.0.2.7", nil},
+		{"::ffff:10.1.2.3", nil},
+		{"192.0.2.8", ErrIPNotAllowed},
+	}
+	for _, tt := range tests {
+		if _, err := service.Authenticate(ctx, otp.APIKey, tt.ip); !errors.Is(err, tt.want) {
+			t.Errorf("Expected %v from %s, got %v", tt.want, tt.ip, err)
+		}
+	}
+
+	revoked, err := service.RevokeKey(ctx, clientID, otp.Key.ID, "test")
+	if err != nil || revoked.RevokedAt == nil {
+		t.Fatalf("Expected the key to be revoked, got %+v, %v", revoked, err)
+	}
+	if _, err := service.Authenticate(ctx, otp.APIKey, "10.1.2.3"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected a revoked key to be refused, got %v", err)
+	}
+	if _, err := service.RevokeKey(ctx, uuid.New(), issued.Key.ID, "test"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected another client's key not to be found, got %v", err)
+	}
+
+	keys, _ := service.ListKeys(ctx, clientID)
+	if len(keys) != 2 {
+		t.Errorf("Expected 2 keys listed, got %d", len(keys))
+	}
+}
+
+func TestKeyRequestValidation(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name string
+		req  KeyRequest
+	}{
+		{"missing name", KeyRequest{Scopes: []Scope{ScopeRead}}},
+		{"no scopes", KeyRequest{Name: "dash"}},
+		{"unknown scope", KeyRequest{Name: "dash", Scopes: []Scope{"admin"}}},
+		{"bad address", KeyRequest{Name: "dash", Scopes: []Scope{ScopeRead}, AllowedIPs: []string{"10.0.0"}}},
+		{"expired", KeyRequest{Name: "dash", Scopes: []Scope{ScopeRead}, ExpiresAt: &past}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.req.Validate(time.Now()); !errors.Is(err, ErrInvalid) {
+				t.Errorf("Expected ErrInvalid, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	service, _ := newTestService()
+	callback, secret := "https://acme.example/dlr", "s3cret"
+	issued, _ := service.Create(ctx, &CreateRequest{Name: "Acme", CallbackURL: &callback, CallbackSecret: &secret}, "test")
+
+	suspended, empty, limit := true, "", int64(2500)
+	client, err := service.Update(ctx, issued.Client.ID, &UpdateRequest{Suspended: &suspended, CallbackURL: &empty, CreditLimitCents: &limit}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !client.Suspended || client.CallbackURL != nil || client.CreditLimitCents != 2500 {
+		t.Errorf("Expected suspended, no callback and a 2500 limit, got %+v", client)
+	}
+	if client.Name != "Acme" || !client.CallbackSecretSet {
+		t.Errorf("Expected unset fields to be kept, got %+v", client)
+	}
+
+	if _, err := service.Update(ctx, issued.Client.ID, &UpdateRequest{Name: &empty}, "test"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected ErrInvalid for an empty name, got %v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ctx := context.Background()
+	service, _ := newTestService()
+	issued, _ := service.Create(ctx, &CreateRequest{Name: "Acme"}, "test")
+
+	perSecond, perDay, negative := int64(20), int64(1000), int64(-1)
+	if _, err := service.SetLimits(ctx, issued.Client.ID, Limits{MessagesPerDay: &negative}, "test"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected ErrInvalid for a negative limit, got %v", err)
+	}
+	client, err := service.SetLimits(ctx, issued.Client.ID, Limits{RequestsPerSecond: &perSecond}, "test")
+	if err != nil || *client.Limits.RequestsPerSecond != 20 {
+		t.Fatalf("Expected 20 requests per second, got %+v, %v", client, err)
+	}
+	if _, err := service.SetKeyLimits(ctx, issued.Client.ID, issued.Key.ID, Limits{MessagesPerDay: &perDay}, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Authentication carries the client's limits alongside the key's
+	key, err := service.Authenticate(ctx, issued.APIKey, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ClientLimits.RequestsPerSecond == nil || *key.ClientLimits.RequestsPerSecond != 20 {
+		t.Errorf("Expected the client's 20 requests per second, got %+v", key.ClientLimits)
+	}
+	if key.Limits.MessagesPerDay == nil || *key.Limits.MessagesPerDay != 1000 || key.Limits.RequestsPerSecond != nil {
+		t.Errorf("Expected the key's own 1000 messages per day only, got %+v", key.Limits)
+	}
+}
@@ -0,0 +1,294 @@
+package clients
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sms-gateway/internal/db"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type Store struct {
+	db *db.PostgresDB
+}
+
+func NewStore(db *db.PostgresDB) *Store {
+	return &Store{db: db}
+}
+
+const clientColumns = `id, name, dlr_callback_url, callback_hmac_secret IS NOT NULL, credit_cents, credit_limit_cents,
+	suspended, unique_references, requests_per_second, messages_per_day, messages_per_month, created_at, updated_at`
+
+func scanClient(row interface{ Scan(...any) error }) (*Client, error) {
+	var c Client
+	err := row.Scan(&c.ID, &c.Name, &c.CallbackURL, &c.CallbackSecretSet, &c.CreditCents, &c.CreditLimitCents,
+		&c.Suspended, &c.UniqueReferences, &c.Limits.RequestsPerSecond, &c.Limits.MessagesPerDay, &c.Limits.MessagesPerMonth,
+		&c.CreatedAt, &c.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// keyColumns are qualified so ByKey can join clients
+const keyColumns = `api_keys.id, api_keys.client_id, api_keys.name, api_keys.scopes, api_keys.allowed_ips,
+	api_keys.expires_at, api_keys.last_used_at, api_keys.revoked_at, api_keys.requests_per_second,
+	api_keys.messages_per_day, api_keys.messages_per_month, api_keys.created_at`
+
+// scanKey scans keyColumns followed by extra
+func scanKey(row interface{ Scan(...any) error }, extra ...any) (*APIKey, error) {
+	var k APIKey
+	var scopes []string
+	dest := append([]any{&k.ID, &k.ClientID, &k.Name, pq.Array(&scopes), pq.Array(&k.AllowedIPs),
+		&k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt, &k.Limits.RequestsPerSecond, &k.Limits.MessagesPerDay,
+		&k.Limits.MessagesPerMonth, &k.CreatedAt}, extra...)
+	err := row.Scan(dest...)
+	if err == sql.ErrNoRows {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range scopes {
+		k.Scopes = append(k.Scopes, Scope(s))
+	}
+	if k.AllowedIPs == nil {
+		k.AllowedIPs = []string{}
+	}
+	return &k, nil
+}
+
+func scopeStrings(scopes []Scope) []string {
+	out := make([]string, len(scopes))
+	for i, s := range scopes {
+		out[i] = string(s)
+	}
+	return out
+}
+
+// insertKey adds a key for clientID within tx
+func insertKey(ctx context.Context, tx *sql.Tx, clientID uuid.UUID, req *KeyRequest, keyHash string) (*APIKey, error) {
+	allowed := req.AllowedIPs
+	if allowed == nil {
+		allowed = []string{}
+	}
+	return scanKey(tx.QueryRowContext(ctx, `INSERT INTO api_keys
+		(client_id, name, key_hash, scopes, allowed_ips, expires_at, requests_per_second, messages_per_day, messages_per_month)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING `+keyColumns,
+		clientID, req.Name, keyHash, pq.Array(scopeStrings(req.Scopes)), pq.Array(allowed), req.ExpiresAt,
+		req.Limits.RequestsPerSecond, req.Limits.MessagesPerDay, req.Limits.MessagesPerMonth))
+}
+
+func (s *Store) Create(ctx context.Context, req *CreateRequest, keyHash string) (*Client, *APIKey, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	c, err := scanClient(tx.QueryRowContext(ctx, `INSERT INTO clients
+		(name, dlr_callback_url, callback_hmac_secret, credit_cents, credit_limit_cents, unique_references,
+			requests_per_second, messages_per_day, messages_per_month)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING `+clientColumns,
+		req.Name, req.CallbackURL, req.CallbackSecret, req.CreditCents, req.CreditLimitCents, req.UniqueReferences,
+		req.Limits.RequestsPerSecond, req.Limits.MessagesPerDay, req.Limits.MessagesPerMonth))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	k, err := insertKey(ctx, tx, c.ID, &KeyRequest{Name: "default", Scopes: Scopes}, keyHash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create client key: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return c, k, nil
+}
+
+func (s *Store) Get(ctx context.Context, id uuid.UUID) (*Client, error) {
+	return scanClient(s.db.QueryRowContext(ctx, `SELECT `+clientColumns+` FROM clients WHERE id = $1`, id))
+}
+
+func (s *Store) List(ctx context.Context, limit, offset int) ([]*Client, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+clientColumns+` FROM clients
+		ORDER BY created_at, id LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clients: %w", err)
+	}
+	defer rows.Close()
+
+	var out []*Client
+	for rows.Next() {
+		c, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) Update(ctx context.Context, id uuid.UUID, req *UpdateRequest) (*Client, error) {
+	c, err := scanClient(s.db.QueryRowContext(ctx, `UPDATE clients SET
+			name = COALESCE($2, name),
+			dlr_callback_url = CASE WHEN $3::text IS NULL THEN dlr_callback_url ELSE NULLIF($3, '') END,
+			callback_hmac_secret = CASE WHEN $4::text IS NULL THEN callback_hmac_secret ELSE NULLIF($4, '') END,
+			credit_limit_cents = COALESCE($5, credit_limit_cents),
+			suspended = COALESCE($6, suspended),
+			unique_references = COALESCE($7, unique_references),
+			updated_at = now()
+		WHERE id = $1
+		RETURNING `+clientColumns,
+		id, req.Name, req.CallbackURL, req.CallbackSecret, req.CreditLimitCents, req.Suspended, req.UniqueReferences))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("failed to update client: %w", err)
+	}
+	return c, err
+}
+
+func (s *Store) SetLimits(ctx context.Context, id uuid.UUID, limits Limits) (*Client, error) {
+	c, err := scanClient(s.db.QueryRowContext(ctx, `UPDATE clients
+		SET requests_per_second = $2, messages_per_day = $3, messages_per_month = $4, updated_at = now()
+		WHERE id = $1
+		RETURNING `+clientColumns,
+		id, limits.RequestsPerSecond, limits.MessagesPerDay, limits.MessagesPerMonth))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("failed to set client limits: %w", err)
+	}
+	return c, err
+}
+
+// Delete removes a client that never sent anything; clients with history
+// should be suspended instead
+func (s *Store) Delete(ctx context.Context, id uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM clients WHERE id = $1`, id)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" { // foreign_key_violation
+		return ErrInUse
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete client: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) CreateKey(ctx context.Context, clientID uuid.UUID, req *KeyRequest, keyHash string) (*APIKey, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	k, err := insertKey(ctx, tx, clientID, req, keyHash)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" { // foreign_key_violation
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key: %w", err)
+	}
+	return k, tx.Commit()
+}
+
+func (s *Store) ListKeys(ctx context.Context, clientID uuid.UUID) ([]*APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+keyColumns+` FROM api_keys
+		WHERE client_id = $1 ORDER BY created_at, id`, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	defer rows.Close()
+
+	var out []*APIKey
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) RevokeKey(ctx context.Context, clientID, keyID uuid.UUID) (*APIKey, error) {
+	k, err := scanKey(s.db.QueryRowContext(ctx, `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, now())
+		WHERE id = $1 AND client_id = $2
+		RETURNING `+keyColumns, keyID, clientID))
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return nil, fmt.Errorf("failed to revoke key: %w", err)
+	}
+	return k, err
+}
+
+func (s *Store) RotateKey(ctx context.Context, clientID, keyID uuid.UUID, keyHash string, overlap time.Duration) (*APIKey, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	old, err := scanKey(tx.QueryRowContext(ctx, `SELECT `+keyColumns+` FROM api_keys
+		WHERE id = $1 AND client_id = $2 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())
+		FOR UPDATE`, keyID, clientID))
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to rotate key: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE api_keys
+		SET expires_at = LEAST(COALESCE(expires_at, 'infinity'), now() + $2 * INTERVAL '1 millisecond')
+		WHERE id = $1`, keyID, overlap.Milliseconds()); err != nil {
+		return nil, fmt.Errorf("failed to rotate key: %w", err)
+	}
+	k, err := insertKey(ctx, tx, clientID, &KeyRequest{Name: old.Name, Scopes: old.Scopes,
+		AllowedIPs: old.AllowedIPs, ExpiresAt: old.ExpiresAt, Limits: old.Limits}, keyHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate key: %w", err)
+	}
+	return k, tx.Commit()
+}
+
+func (s *Store) SetKeyLimits(ctx context.Context, clientID, keyID uuid.UUID, limits Limits) (*APIKey, error) {
+	k, err := scanKey(s.db.QueryRowContext(ctx, `UPDATE api_keys
+		SET requests_per_second = $3, messages_per_day = $4, messages_per_month = $5
+		WHERE id = $1 AND client_id = $2
+		RETURNING `+keyColumns,
+		keyID, clientID, limits.RequestsPerSecond, limits.MessagesPerDay, limits.MessagesPerMonth))
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return nil, fmt.Errorf("failed to set key limits: %w", err)
+	}
+	return k, err
+}
+
+func (s *Store) ByKey(ctx context.Context, keyHash string) (*APIKey, error) {
+	var client Limits
+	k, err := scanKey(s.db.QueryRowContext(ctx, `SELECT `+keyColumns+`,
+			clients.requests_per_second, clients.messages_per_day, clients.messages_per_month
+		FROM api_keys JOIN clients ON clients.id = api_keys.client_id
+		WHERE api_keys.key_hash = $1 AND api_keys.revoked_at IS NULL
+			AND (api_keys.expires_at IS NULL OR api_keys.expires_at > now())`, keyHash),
+		&client.RequestsPerSecond, &client.MessagesPerDay, &client.MessagesPerMonth)
+	if err != nil {
+		return nil, err
+	}
+	k.ClientLimits = client
+	return k, nil
+}
+
+func (s *Store) TouchKey(ctx context.Context, keyID uuid.UUID, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $2
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2)`, keyID, at)
+	return err
+}
@@ -19,14 +19,66 @@ type Config struct {
 	// Billing
 	PricePerPartCents     int64 `envconfig:"PRICE_PER_PART_CENTS" default:"5"`
 	ExpressSurchargeCents int64 `envconfig:"EXPRESS_SURCHARGE_CENTS" default:"2"`
+	// Per-part surcharge by priority class, e.g. "otp:3,transactional:1,bulk:0"
+	PrioritySurchargeCents map[string]int64 `envconfig:"PRIORITY_SURCHARGE_CENTS"`
 
 	// Rate Limiting
-	RateLimitEnabled    bool `envconfig:"RATE_LIMIT_ENABLED" default:"false"`
-	RateLimitRPM        int  `envconfig:"RATE_LIMIT_RPM" default:"5000"`       // Requests per minute
-	RateLimitConcurrent int  `envconfig:"RATE_LIMIT_CONCURRENT" default:"100"` // Max concurrent requests
+	RateLimitEnabled    bool   `envconfig:"RATE_LIMIT_ENABLED" default:"false"`
+	RateLimitRPM        int64  `envconfig:"RATE_LIMIT_RPM" default:"5000"`       // Requests per minute per address, for requests that name no client
+	RateLimitConcurrent int    `envconfig:"RATE_LIMIT_CONCURRENT" default:"100"` // Max concurrent requests
+	RateLimitStore      string `envconfig:"RATE_LIMIT_STORE" default:"postgres"` // postgres (shared by replicas) or memory
+	// Defaults for clients without limits of their own; 0 is unlimited
+	RateLimitClientRPS    int64 `envconfig:"RATE_LIMIT_CLIENT_RPS" default:"0"`
+	QuotaMessagesPerDay   int64 `envconfig:"QUOTA_MESSAGES_PER_DAY" default:"0"`
+	QuotaMessagesPerMonth int64 `envconfig:"QUOTA_MESSAGES_PER_MONTH" default:"0"`
+
+	// Admin
+	AdminToken string `envconfig:"ADMIN_TOKEN"` // Bearer token for /admin, admin API disabled when empty
+
+	// Client API keys. When not required, requests without a key act for the
+	// client_id they name, as before keys existed.
+	APIKeysRequired bool `envconfig:"API_KEYS_REQUIRED" default:"false"`
+
+	// Queue
+	QueueBackend     string        `envconfig:"QUEUE_BACKEND" default:"postgres"` // postgres or jetstream
+	NATSURL          string        `envconfig:"NATS_URL" default:"nats://localhost:4222"`
+	QueueRelayPeriod time.Duration `envconfig:"QUEUE_RELAY_INTERVAL" default:"50ms"` // JetStream outbox relay interval
+
+	// Worker
+	WorkerLeaseTTL     time.Duration `envconfig:"WORKER_LEASE_TTL" default:"60s"`     // How long a claimed message stays leased
+	WorkerReapInterval time.Duration `envconfig:"WORKER_REAP_INTERVAL" default:"15s"` // How often expired leases are recovered
+	WorkerStopTimeout  time.Duration `envconfig:"WORKER_STOP_TIMEOUT" default:"30s"`  // Deadline for finishing in-flight sends on shutdown
+	WorkerConcurrency  int           `envconfig:"WORKER_CONCURRENCY"`                 // Total senders incl. reserved, 0 for 10 per CPU
+	WorkerBufferSize   int           `envconfig:"WORKER_BUFFER_SIZE" default:"200"`   // Claimed messages buffered ahead of the senders
+	WorkerBatchSize    int           `envconfig:"WORKER_BATCH_SIZE" default:"20"`     // Messages claimed per poll
+	WorkerPollInterval time.Duration `envconfig:"WORKER_POLL_INTERVAL" default:"50ms"`
+	// Adaptive mode resizes the shared senders between min and max every interval
+	WorkerAdaptive      bool          `envconfig:"WORKER_ADAPTIVE" default:"false"`
+	WorkerMinSenders    int           `envconfig:"WORKER_MIN_SENDERS" default:"4"`
+	WorkerMaxSenders    int           `envconfig:"WORKER_MAX_SENDERS"` // 0 for 4x WORKER_CONCURRENCY
+	WorkerLatencyTarget time.Duration `envconfig:"WORKER_LATENCY_TARGET" default:"500ms"`
+	WorkerAdaptInterval time.Duration `envconfig:"WORKER_ADAPT_INTERVAL" default:"5s"`
+	// Interval at which singleton jobs (retry, reaper) confirm or contest leadership
+	LeaderCheckInterval time.Duration `envconfig:"LEADER_CHECK_INTERVAL" default:"5s"`
+	// Senders dedicated to a priority class; the rest are shared by all classes
+	PriorityReservedWorkers map[string]int `envconfig:"PRIORITY_RESERVED_WORKERS" default:"otp:2,transactional:4,express:2"`
+
+	// Retries
+	RetryInterval time.Duration `envconfig:"RETRY_INTERVAL" default:"1s"` // How often due FAILED_TEMP messages are requeued
+	RetryPolicies string        `envconfig:"RETRY_POLICIES"`              // JSON overrides, see retry.ParsePolicies
+
+	// Delivery reports
+	DLRResolveInterval time.Duration `envconfig:"DLR_RESOLVE_INTERVAL" default:"1s"` // How often parked DLRs are matched to sent messages
+	DLRPendingTTL      time.Duration `envconfig:"DLR_PENDING_TTL" default:"24h"`     // How long a DLR may wait for its provider ID
+	DLRAuth            string        `envconfig:"DLR_AUTH"`                          // JSON per-provider webhook auth or none, see delivery.ParseAuth
 
 	// Observability
-	LogLevel string `envconfig:"LOG_LEVEL" default:"info"`
+	LogLevel            string `envconfig:"LOG_LEVEL" default:"info"`
+	MetricsPort         string `envconfig:"METRICS_PORT" default:"9091"`           // Worker /metrics listener; the API serves /metrics on PORT
+	MetricsClientLabels bool   `envconfig:"METRICS_CLIENT_LABELS" default:"false"` // Per-client send series, only for a few clients
+	// Tracing exporter: otlp (endpoint from OTEL_EXPORTER_OTLP_ENDPOINT), stdout or none
+	TracingExporter    string  `envconfig:"TRACING_EXPORTER" default:"none"`
+	TracingSampleRatio float64 `envconfig:"TRACING_SAMPLE_RATIO" default:"1"` // Fraction of new traces recorded
 }
 
 func Load() (*Config, error) {
@@ -4,12 +4,17 @@ import (
 	"context"
 	"database/sql"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"sms-gateway/internal/tracing"
+
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type PostgresDB struct {
@@ -35,6 +40,56 @@ func NewPostgres(ctx context.Context, url string) (*PostgresDB, error) {
 	return &PostgresDB{DB: db}, nil
 }
 
+// ExecContext shadows sql.DB's to trace the statement as a child of the
+// caller's span, as do QueryContext and QueryRowContext. Statements outside a
+// recorded trace (polling, background jobs) are not traced.
+func (db *PostgresDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if !tracing.Recording(ctx) {
+		return db.DB.ExecContext(ctx, query, args...)
+	}
+	ctx, span := startSpan(ctx, query)
+	res, err := db.DB.ExecContext(ctx, query, args...)
+	tracing.End(span, err)
+	return res, err
+}
+
+func (db *PostgresDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if !tracing.Recording(ctx) {
+		return db.DB.QueryContext(ctx, query, args...)
+	}
+	ctx, span := startSpan(ctx, query)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	tracing.End(span, err)
+	return rows, err
+}
+
+func (db *PostgresDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	if !tracing.Recording(ctx) {
+		return db.DB.QueryRowContext(ctx, query, args...)
+	}
+	ctx, span := startSpan(ctx, query)
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	err := row.Err()
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	tracing.End(span, err)
+	return row
+}
+
+// startSpan names a statement's span after its operation, e.g. "postgres SELECT".
+// Arguments are never recorded.
+func startSpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	query = strings.TrimSpace(query)
+	op, _, _ := strings.Cut(query, " ")
+	return tracing.Start(ctx, "postgres "+strings.ToUpper(op),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", query),
+		))
+}
+
 func (db *PostgresDB) RunMigrations(migrationsPath string) error {
 	driver, err := postgres.WithInstance(db.DB, &postgres.Config{})
 	if err != nil {
@@ -0,0 +1,240 @@
+package delivery
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"sms-gateway/internal/metrics"
+)
+
+// ErrUnauthorized is matched by every rejected webhook callback
+var ErrUnauthorized = errors.New("unauthorized callback")
+
+// AuthError says why a callback was rejected. Reason is a short label
+// suitable for counting.
+type AuthError struct {
+	Provider string
+	Reason   string
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("unauthorized %s callback: %s", e.Provider, e.Reason)
+}
+
+func (e *AuthError) Is(target error) bool {
+	return target == ErrUnauthorized
+}
+
+// AuthPolicy is how one provider authenticates its DLR callbacks. Every
+// configured check must pass.
+type AuthPolicy struct {
+	// HMAC-SHA256 over "<timestamp>.<body>", hex encoded, optionally "sha256=" prefixed
+	HMACSecret      string `json:"hmac_secret"`
+	SignatureHeader string `json:"signature_header"` // Default X-Signature
+	TimestampHeader string `json:"timestamp_header"` // Unix seconds, default X-Timestamp
+	MaxAge          string `json:"max_age"`          // Replay window for signed callbacks, default 5m
+
+	Username string `json:"username"` // HTTP basic auth
+	Password string `json:"password"`
+
+	AllowIPs []string `json:"allow_ips"` // Addresses or CIDRs the provider calls from
+
+	maxAge   time.Duration
+	networks []*net.IPNet
+}
+
+// Callback is what a webhook request carries for authentication
+type Callback struct {
+	IP     string
+	Header func(key string) string
+	Body   []byte
+}
+
+// Authenticator verifies DLR callbacks against per-provider policies and
+// counts the ones it rejects
+type Authenticator struct {
+	policies map[string]*AuthPolicy
+	now      func() time.Time
+}
+
+// AuthDisabled is the DLR_AUTH value that accepts callbacks unauthenticated
+const AuthDisabled = "none"
+
+// ParseAuth reads the DLR_AUTH JSON document, e.g.
+//
+//	{"mock":{"hmac_secret":"s3cret","max_age":"2m"},
+//	 "smpp":{"username":"carrier","password":"pw","allow_ips":["203.0.113.0/24"]}}
+//
+// Callbacks for providers without a policy are rejected, so an empty spec
+// rejects them all. Only AuthDisabled turns authentication off.
+func ParseAuth(spec string) (*Authenticator, error) {
+	a := &Authenticator{now: time.Now}
+	switch spec {
+	case AuthDisabled:
+		return a, nil
+	case "":
+		a.policies = map[string]*AuthPolicy{}
+		return a, nil
+	}
+	if err := json.Unmarshal([]byte(spec), &a.policies); err != nil {
+		return nil, fmt.Errorf("invalid DLR auth: %w", err)
+	}
+
+	for provider, p := range a.policies {
+		if p == nil || (p.HMACSecret == "" && p.Username == "" && len(p.AllowIPs) == 0) {
+			return nil, fmt.Errorf("invalid DLR auth for %s: no checks configured", provider)
+		}
+		if p.SignatureHeader == "" {
+			p.SignatureHeader = "X-Signature"
+		}
+		if p.TimestampHeader == "" {
+			p.TimestampHeader = "X-Timestamp"
+		}
+		p.maxAge = 5 * time.Minute
+		if p.MaxAge != "" {
+			d, err := time.ParseDuration(p.MaxAge)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid DLR auth max_age for %s: %q", provider, p.MaxAge)
+			}
+			p.maxAge = d
+		}
+		for _, addr := range p.AllowIPs {
+			network, err := parseNetwork(addr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DLR auth allow_ips for %s: %w", provider, err)
+			}
+			p.networks = append(p.networks, network)
+		}
+	}
+	return a, nil
+}
+
+// parseNetwork accepts a CIDR or a single address
+func parseNetwork(addr string) (*net.IPNet, error) {
+	if strings.Contains(addr, "/") {
+		_, network, err := net.ParseCIDR(addr)
+		return network, err
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("not an IP address: %q", addr)
+	}
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		ip, bits = ip.To4(), 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// Enabled reports whether callbacks are authenticated at all, i.e. DLR_AUTH
+// is not AuthDisabled
+func (a *Authenticator) Enabled() bool {
+	return a.policies != nil
+}
+
+// Verify checks a callback for provider and returns an *AuthError if it is
+// rejected. Rejections are counted by provider and reason; providers without
+// a policy are counted as unknown since anyone can put anything in the path.
+func (a *Authenticator) Verify(provider string, cb Callback) error {
+	if !a.Enabled() {
+		return nil
+	}
+	reason := a.check(provider, cb)
+	if reason == "" {
+		return nil
+	}
+	label := provider
+	if _, ok := a.policies[provider]; !ok {
+		label = "unknown"
+	}
+	metrics.DLRRejected.WithLabelValues(label, reason).Inc()
+	return &AuthError{Provider: provider, Reason: reason}
+}
+
+func (a *Authenticator) check(provider string, cb Callback) string {
+	p, ok := a.policies[provider]
+	if !ok {
+		return "no_policy"
+	}
+
+	if len(p.networks) > 0 {
+		ip := net.ParseIP(cb.IP)
+		allowed := false
+		for _, network := range p.networks {
+			if ip != nil && network.Contains(ip) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "ip_not_allowed"
+		}
+	}
+
+	if p.Username != "" {
+		user, pass, ok := basicAuth(cb.Header("Authorization"))
+		if !ok {
+			return "missing_credentials"
+		}
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(p.Username)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(p.Password)) == 1
+		if !userOK || !passOK {
+			return "bad_credentials"
+		}
+	}
+
+	if p.HMACSecret != "" {
+		timestamp := cb.Header(p.TimestampHeader)
+		signature := strings.TrimPrefix(cb.Header(p.SignatureHeader), "sha256=")
+		if timestamp == "" || signature == "" {
+			return "missing_signature"
+		}
+		sec, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return "bad_timestamp"
+		}
+		// Signed callbacks are only good for a short while in either direction,
+		// so a captured one cannot be replayed later
+		age := a.now().Sub(time.Unix(sec, 0))
+		if age > p.maxAge || age < -p.maxAge {
+			return "stale_timestamp"
+		}
+		given, err := hex.DecodeString(signature)
+		if err != nil || !hmac.Equal(given, Sign(p.HMACSecret, timestamp, cb.Body)) {
+			return "bad_signature"
+		}
+	}
+	return ""
+}
+
+// Sign returns the HMAC a provider sends for body at timestamp
+func Sign(secret, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// basicAuth parses an HTTP basic Authorization header
+func basicAuth(header string) (user, pass string, ok bool) {
+	const prefix = "Basic "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(header[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
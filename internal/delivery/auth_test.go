@@ -0,0 +1,115 @@
+package delivery
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"sms-gateway/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAuthenticatorVerify(t *testing.T) {
+	auth, err := ParseAuth(`{
+		"mock": {"hmac_secret": "s3cret", "max_age": "1m"},
+		"smpp": {"username": "carrier", "password": "pw", "allow_ips": ["203.0.113.0/24", "198.51.100.7"]}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	auth.now = func() time.Time { return now }
+
+	body := []byte(`{"provider_message_id":"abc","status":"DELIVRD"}`)
+	signed := func(at time.Time, secret string) map[string]string {
+		ts := strconv.FormatInt(at.Unix(), 10)
+		return map[string]string{"X-Timestamp": ts, "X-Signature": "sha256=" + hex.EncodeToString(Sign(secret, ts, body))}
+	}
+	basic := func(user, pass string) map[string]string {
+		return map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))}
+	}
+
+	tests := []struct {
+		name     string
+		provider string
+		ip       string
+		headers  map[string]string
+		reason   string
+	}{
+		{"valid signature", "mock", "10.0.0.1", signed(now, "s3cret"), ""},
+		{"missing signature", "mock", "10.0.0.1", nil, "missing_signature"},
+		{"wrong secret", "mock", "10.0.0.1", signed(now, "guess"), "bad_signature"},
+		{"replayed", "mock", "10.0.0.1", signed(now.Add(-2*time.Minute), "s3cret"), "stale_timestamp"},
+		{"from the future", "mock", "10.0.0.1", signed(now.Add(2*time.Minute), "s3cret"), "stale_timestamp"},
+		{"valid basic auth", "smpp", "203.0.113.9", basic("carrier", "pw"), ""},
+		{"single allowed address", "smpp", "198.51.100.7", basic("carrier", "pw"), ""},
+		{"outside allowlist", "smpp", "192.0.2.1", basic("carrier", "pw"), "ip_not_allowed"},
+		{"wrong password", "smpp", "203.0.113.9", basic("carrier", "nope"), "bad_credentials"},
+		{"no credentials", "smpp", "203.0.113.9", nil, "missing_credentials"},
+		{"unconfigured provider", "other", "10.0.0.1", nil, "no_policy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := auth.Verify(tt.provider, Callback{
+				IP:     tt.ip,
+				Header: func(key string) string { return tt.headers[key] },
+				Body:   body,
+			})
+			if tt.reason == "" {
+				if err != nil {
+					t.Errorf("Expected the callback to pass, got %v", err)
+				}
+				return
+			}
+			var authErr *AuthError
+			if !errors.As(err, &authErr) || !errors.Is(err, ErrUnauthorized) {
+				t.Fatalf("Expected an auth error, got %v", err)
+			}
+			if authErr.Reason != tt.reason {
+				t.Errorf("Expected reason %q, got %q", tt.reason, authErr.Reason)
+			}
+		})
+	}
+
+	if n := testutil.ToFloat64(metrics.DLRRejected.WithLabelValues("mock", "stale_timestamp")); n != 2 {
+		t.Errorf("Expected 2 stale timestamps counted, got %v", n)
+	}
+	if n := testutil.ToFloat64(metrics.DLRRejected.WithLabelValues("unknown", "no_policy")); n != 1 {
+		t.Errorf("Expected the unconfigured provider counted as unknown, got %v", n)
+	}
+}
+
+func TestParseAuth(t *testing.T) {
+	noHeaders := Callback{Header: func(string) string { return "" }}
+	closed, err := ParseAuth("")
+	if err != nil || !closed.Enabled() {
+		t.Fatalf("Expected an empty spec to keep auth on, got %v", err)
+	}
+	if err := closed.Verify("mock", noHeaders); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected callbacks to be rejected without a policy, got %v", err)
+	}
+
+	open, err := ParseAuth(AuthDisabled)
+	if err != nil || open.Enabled() {
+		t.Fatalf("Expected %q to disable auth, got %v", AuthDisabled, err)
+	}
+	if err := open.Verify("mock", noHeaders); err != nil {
+		t.Errorf("Expected callbacks to pass without auth, got %v", err)
+	}
+
+	for _, spec := range []string{
+		`{"mock":{}}`,
+		`{"mock":{"hmac_secret":"x","max_age":"soon"}}`,
+		`{"mock":{"allow_ips":["not-an-ip"]}}`,
+		`not json`,
+	} {
+		if _, err := ParseAuth(spec); err == nil {
+			t.Errorf("Expected %s to be rejected", spec)
+		}
+	}
+}
@@ -2,81 +2,349 @@ package delivery
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"sms-gateway/internal/billing"
+	"sms-gateway/internal/logging"
 	"sms-gateway/internal/messages"
+	"sms-gateway/internal/metrics"
+	"sms-gateway/internal/retry"
+	"sms-gateway/internal/tracing"
 	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Request struct {
 	ProviderMessageID string    `json:"provider_message_id"`
-	Status            string    `json:"status"`
+	Status            string    `json:"status"`               // The provider's own value, e.g. DELIVRD
+	ErrorCode         string    `json:"error_code,omitempty"` // Network error code, e.g. 001
 	Reason            string    `json:"reason,omitempty"`
 	Timestamp         time.Time `json:"timestamp"`
+
+	// Provider selects the normalization table; empty means mock
+	Provider string `json:"-"`
+	// Raw is the report as the provider sent it, kept in the message history
+	Raw json.RawMessage `json:"-"`
 }
 
+// ErrUnknownProvider means there is no normalization table for the provider
+var ErrUnknownProvider = errors.New("unknown provider")
+
 type Service struct {
-	logger  *slog.Logger
-	store   *messages.Store
-	billing *billing.Service
+	logger   *slog.Logger
+	store    messages.Repository
+	machine  *messages.Machine
+	policies *retry.Policies
 }
 
-func NewService(logger *slog.Logger, store *messages.Store, billing *billing.Service) *Service {
+// NewService creates the DLR service. policies decide whether a temporary
+// delivery failure is sent again, as for failed sends in the worker.
+func NewService(logger *slog.Logger, store messages.Repository, billing billing.Ledger, policies *retry.Policies) *Service {
 	return &Service{
-		logger:  logger,
-		store:   store,
-		billing: billing,
+		logger:   logger,
+		store:    store,
+		machine:  messages.NewMachine(logger, store, billing),
+		policies: policies,
 	}
 }
 
+// Process normalizes a delivery report with its provider's mapping, records
+// it and applies it. Intermediate states (ENROUTE, ACCEPTD) only add to the
+// message history. Reports are idempotent
+// by provider ID and status: a repeat is acknowledged and ignored. A report
+// whose provider ID is not stored yet (the worker has not recorded the send)
+// is parked and applied by Resolve once it is. Credits are captured or
+// released by the state machine. A report for a message that is no longer
+// SENT, e.g. FAILED_TEMP arriving after DELIVERED or any report once a retry
+// is under way, is rejected with messages.ErrIllegalTransition.
 func (s *Service) Process(ctx context.Context, req *Request) error {
-	// Find message by provider ID
-	msg, err := s.store.GetByProviderID(ctx, req.ProviderMessageID)
+	provider := req.Provider
+	if provider == "" {
+		provider = "mock"
+	}
+	mapping, ok := Mappings[provider]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownProvider, provider)
+	}
+	outcome := mapping.Normalize(req.Status, req.ErrorCode)
+	status := outcome.Status
+
+	payload := req.Raw
+	if !json.Valid(payload) {
+		payload, _ = json.Marshal(req)
+	}
+	report := &messages.DeliveryReport{
+		Provider:          provider,
+		ProviderMessageID: req.ProviderMessageID,
+		Status:            status,
+		RawStatus:         req.Status,
+		ErrorCode:         req.ErrorCode,
+		ErrorClass:        string(outcome.Error),
+		Payload:           payload,
+	}
+	if reason := describe(req, outcome); reason != "" {
+		report.Reason = &reason
+	}
+
+	fresh, err := s.store.RecordDeliveryReport(ctx, report)
 	if err != nil {
-		return fmt.Errorf("message not found: %w", err)
+		return err
+	}
+	if !fresh {
+		s.logger.InfoContext(ctx, "Duplicate DLR ignored", "provider_message_id", req.ProviderMessageID, "status", status)
+		return nil
 	}
 
-	// Update message status based on DLR
-	var status messages.Status
-	switch req.Status {
-	case "DELIVERED":
-		status = messages.StatusDelivered
-		// Capture held credits
-		if err := s.billing.CaptureCredits(ctx, msg.ID); err != nil {
-			s.logger.Error("failed to capture credits", "error", err)
-		}
-	case "FAILED_PERM":
-		status = messages.StatusFailedPerm
-		// Release held credits
-		if err := s.billing.ReleaseCredits(ctx, msg.ID); err != nil {
-			s.logger.Error("failed to release credits", "error", err)
+	err = s.apply(ctx, report)
+	if errors.Is(err, errUnmatched) {
+		s.logger.InfoContext(ctx, "DLR parked until its provider ID is known",
+			"provider_message_id", req.ProviderMessageID, "status", status)
+		return nil
+	}
+	return err
+}
+
+// errUnmatched means no message or part carries a report's provider ID yet
+var errUnmatched = errors.New("no message has the provider ID")
+
+// Resolve applies parked reports whose provider ID has since been stored and
+// returns how many it applied
+func (s *Service) Resolve(ctx context.Context) (int, error) {
+	reports, err := s.store.MatchedDeliveryReports(ctx, 100)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, report := range reports {
+		if err := s.apply(ctx, report); err == nil {
+			applied++
 		}
-	case "FAILED_TEMP":
-		status = messages.StatusFailedTemp
-	default:
-		status = messages.StatusFailedPerm
-		// Release held credits for unknown status
-		if err := s.billing.ReleaseCredits(ctx, msg.ID); err != nil {
-			s.logger.Error("failed to release credits", "error", err)
+	}
+	return applied, nil
+}
+
+// Run resolves parked reports every interval and drops those still unmatched
+// after ttl, until ctx is cancelled
+func (s *Service) Run(ctx context.Context, interval, ttl time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := s.Resolve(ctx); err != nil {
+				s.logger.ErrorContext(ctx, "Failed to resolve parked DLRs", "error", err)
+			} else if n > 0 {
+				s.logger.InfoContext(ctx, "Applied parked DLRs", "count", n)
+			}
+			if n, err := s.store.ExpireDeliveryReports(ctx, time.Now().Add(-ttl)); err != nil {
+				s.logger.ErrorContext(ctx, "Failed to expire parked DLRs", "error", err)
+			} else if n > 0 {
+				s.logger.WarnContext(ctx, "Dropped DLRs that never matched a message", "count", n)
+			}
 		}
 	}
+}
 
-	// Update message status
-	var errorMsg *string
+// describe is the error recorded on the message for a failure report: the
+// normalized class followed by whatever the provider said
+func describe(req *Request, outcome Outcome) string {
+	if outcome.Error == ErrorNone {
+		return req.Reason
+	}
+	detail := req.Status
+	if req.ErrorCode != "" {
+		detail += " err " + req.ErrorCode
+	}
 	if req.Reason != "" {
-		errorMsg = &req.Reason
+		detail += ", " + req.Reason
+	}
+	return fmt.Sprintf("%s (%s)", outcome.Error, detail)
+}
+
+// apply applies a report to the part carrying its provider ID or, for
+// messages sent before parts were recorded, to the message itself, and marks
+// the report settled. Reports that fail for any other reason than an illegal
+// transition stay pending and are tried again by Resolve.
+func (s *Service) apply(ctx context.Context, report *messages.DeliveryReport) error {
+	if report.Status == messages.StatusSent {
+		return s.applyProgress(ctx, report)
+	}
+
+	parts, err := s.store.TransitionPart(ctx, report.ProviderMessageID, report.Status)
+	var illegal *messages.IllegalTransitionError
+	switch {
+	case err == nil:
+		return s.applyParts(ctx, report, parts)
+	case errors.As(err, &illegal):
+		s.logger.WarnContext(ctx, "Rejected illegal part transition", "provider_message_id", report.ProviderMessageID,
+			"message", illegal.MessageID, "from", illegal.From, "to", illegal.To)
+		s.settle(ctx, report, illegal.MessageID, messages.ReportRejected)
+		return err
+	case !errors.Is(err, messages.ErrPartNotFound):
+		return err
+	}
+
+	msg, err := s.store.GetByProviderID(ctx, report.ProviderMessageID)
+	if err != nil {
+		return errUnmatched
 	}
+	return s.applyMessage(ctx, report, msg, report.Status)
+}
 
-	err = s.store.UpdateStatus(ctx, msg.ID, status, nil, errorMsg)
+// applyProgress records an intermediate report in the message history
+func (s *Service) applyProgress(ctx context.Context, report *messages.DeliveryReport) error {
+	msg, err := s.store.GetByProviderID(ctx, report.ProviderMessageID)
 	if err != nil {
+		return errUnmatched
+	}
+	ctx = logging.WithMessageID(ctx, msg.ID)
+
+	detail := report.RawStatus
+	current := msg.Status
+	ev := &messages.Event{
+		MessageID:  msg.ID,
+		Event:      messages.EventProgress,
+		FromStatus: &current,
+		Status:     current,
+		Actor:      "dlr",
+		Provider:   msg.Provider,
+		Detail:     &detail,
+		Payload:    report.Payload,
+	}
+	if err := s.store.AppendEvent(ctx, ev); err != nil {
+		return err
+	}
+	s.settle(ctx, report, msg.ID, messages.ReportProgress)
+	return nil
+}
+
+// applyParts moves the message once its parts settle it. Reports for single
+// parts of a multipart message are recorded in its history either way.
+func (s *Service) applyParts(ctx context.Context, report *messages.DeliveryReport, parts []*messages.Part) error {
+	msg, err := s.store.GetByID(ctx, parts[0].MessageID)
+	if err != nil {
+		return err
+	}
+	ctx = logging.WithMessageID(ctx, msg.ID)
+
+	if len(parts) > 1 {
+		number := 0
+		for _, p := range parts {
+			if p.ProviderMessageID == report.ProviderMessageID {
+				number = p.Number
+			}
+		}
+		detail := fmt.Sprintf("part %d/%d %s", number, len(parts), report.Status)
+		if report.Reason != nil {
+			detail += ": " + *report.Reason
+		}
+		current := msg.Status
+		ev := &messages.Event{
+			MessageID:  msg.ID,
+			Event:      messages.EventPartReport,
+			FromStatus: &current,
+			Status:     current,
+			Actor:      "dlr",
+			Provider:   msg.Provider,
+			Detail:     &detail,
+			Payload:    report.Payload,
+		}
+		if err := s.store.AppendEvent(ctx, ev); err != nil {
+			s.logger.ErrorContext(ctx, "Failed to record part report", "error", err, "message", msg.ID)
+		}
+	}
+
+	status, settled := messages.Aggregate(parts)
+	if !settled || status == msg.Status {
+		s.settle(ctx, report, msg.ID, messages.ReportApplied)
+		return nil
+	}
+	return s.applyMessage(ctx, report, msg, status)
+}
+
+// applyMessage moves msg to status on behalf of report. A temporary failure
+// counts as an attempt: the message is due for another send after the
+// network class's backoff or, once its attempts are used up, fails for good.
+// Its span joins the message's trace and links to the webhook request that
+// delivered the report.
+func (s *Service) applyMessage(ctx context.Context, report *messages.DeliveryReport, msg *messages.Message, status messages.Status) (err error) {
+	var retryIn *time.Duration
+	if status == messages.StatusFailedTemp {
+		policy := s.policies.For(retry.ClassNetwork, retry.Scope{
+			Priority: string(msg.Priority),
+			Provider: report.Provider,
+			ClientID: msg.ClientID,
+		})
+		if decision := policy.Decide(msg.Attempts+1, 0); decision.Retry {
+			retryIn = &decision.Delay
+		} else {
+			status = messages.StatusFailedPerm
+		}
+	}
+
+	ctx, span := tracing.Start(tracing.WithTraceParent(ctx, msg.TraceParent), "dlr.apply",
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(
+			attribute.String("sms.message_id", msg.ID.String()),
+			attribute.String("sms.provider_message_id", report.ProviderMessageID),
+			attribute.String("sms.status", string(status)),
+			attribute.String("dlr.raw_status", report.RawStatus),
+		))
+	defer func() { tracing.End(span, err) }()
+	ctx = logging.WithMessageID(ctx, msg.ID)
+
+	// Reports settle the attempt the provider holds. A message that has left
+	// SENT is final or on its way to another attempt, possibly claimed by a
+	// worker already, and a late report for the earlier one must not touch it.
+	sent := messages.StatusSent
+	ev := &messages.Event{
+		MessageID:  msg.ID,
+		Event:      messages.EventDelivery,
+		FromStatus: &sent,
+		Status:     status,
+		Actor:      "dlr",
+		Provider:   msg.Provider,
+		Detail:     report.Reason,
+		Payload:    report.Payload,
+	}
+	err = s.machine.Apply(ctx, ev, nil, report.Reason, retryIn)
+	switch {
+	case err == nil:
+		s.settle(ctx, report, msg.ID, messages.ReportApplied)
+		if retryIn != nil {
+			metrics.Retries.WithLabelValues(report.Provider, string(retry.ClassNetwork)).Inc()
+		}
+		if msg.Status == messages.StatusSent {
+			// The message has not changed since the provider took it
+			metrics.DLRLag.WithLabelValues(metrics.Label(msg.Provider), string(status)).
+				Observe(time.Since(msg.UpdatedAt).Seconds())
+		}
+	case errors.Is(err, messages.ErrIllegalTransition):
+		s.settle(ctx, report, msg.ID, messages.ReportRejected)
+		return err
+	default:
 		return fmt.Errorf("failed to update message status: %w", err)
 	}
 
-	s.logger.Info("DLR processed",
-		"provider_message_id", req.ProviderMessageID,
+	s.logger.InfoContext(ctx, "DLR processed",
+		"provider_message_id", report.ProviderMessageID,
 		"message_id", msg.ID,
 		"status", status)
 
 	return nil
 }
+
+func (s *Service) settle(ctx context.Context, report *messages.DeliveryReport, messageID uuid.UUID, outcome string) {
+	if err := s.store.SettleDeliveryReport(ctx, report.ID, messageID, outcome); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to settle DLR", "error", err, "provider_message_id", report.ProviderMessageID)
+	}
+}
@@ -0,0 +1,542 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"sms-gateway/internal/billing"
+	"sms-gateway/internal/messages"
+	"sms-gateway/internal/queue"
+	"sms-gateway/internal/retry"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestProcessSettlesCredits(t *testing.T) {
+	tests := []struct {
+		dlr     string
+		status  messages.Status
+		lock    string
+		balance int64
+	}{
+		{"DELIVERED", messages.StatusDelivered, "CAPTURED", 95},
+		{"FAILED_PERM", messages.StatusFailedPerm, "RELEASED", 100},
+		{"FAILED_TEMP", messages.StatusFailedTemp, "HELD", 95},
+		{"EXPIRED", messages.StatusFailedPerm, "RELEASED", 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dlr, func(t *testing.T) {
+			ctx := context.Background()
+			store := messages.NewMemoryStore()
+			ledger := billing.NewMemoryService()
+			svc := NewService(slog.New(slog.NewTextHandler(os.Stdout, nil)), store, ledger, &retry.Policies{})
+
+			clientID := uuid.New()
+			store.AddClient(clientID)
+			ledger.SetCredits(clientID, 100)
+
+			providerID := "mock_" + uuid.NewString()
+			msg := &messages.Message{ID: uuid.New(), ClientID: clientID, Status: messages.StatusSent, ProviderMessageID: &providerID, CreatedAt: time.Now()}
+			if err := store.Create(ctx, msg); err != nil {
+				t.Fatal(err)
+			}
+			ledger.HoldCredits(ctx, clientID, msg.ID, 5)
+
+			raw := json.RawMessage(`{"provider_message_id":"` + providerID + `","status":"` + tt.dlr + `","extra":1}`)
+			if err := svc.Process(ctx, &Request{ProviderMessageID: providerID, Status: tt.dlr, Raw: raw}); err != nil {
+				t.Fatal(err)
+			}
+
+			events, _ := store.ListEvents(ctx, msg.ID)
+			if len(events) != 1 || events[0].Event != messages.EventDelivery || string(events[0].Payload) != string(raw) {
+				t.Errorf("Expected a delivery event with the raw report, got %+v", events)
+			} else if *events[0].FromStatus != messages.StatusSent || events[0].Status != tt.status {
+				t.Errorf("Expected SENT -> %s, got %s -> %s", tt.status, *events[0].FromStatus, events[0].Status)
+			}
+
+			got, _ := store.GetByID(ctx, msg.ID)
+			if got.Status != tt.status {
+				t.Errorf("Expected status %s, got %s", tt.status, got.Status)
+			}
+			if locks := ledger.Locks(msg.ID); len(locks) != 1 || locks[0].State != tt.lock {
+				t.Errorf("Expected lock %s, got %+v", tt.lock, locks)
+			}
+			if balance, _ := ledger.GetCredits(ctx, clientID); balance != tt.balance {
+				t.Errorf("Expected balance %d, got %d", tt.balance, balance)
+			}
+		})
+	}
+}
+
+func TestProcessRetriesTemporaryFailure(t *testing.T) {
+	ctx := context.Background()
+	store := messages.NewMemoryStore()
+	ledger := billing.NewMemoryService()
+	policies, err := retry.ParsePolicies(`{"default":{"network":{"base":"1ms","cap":"1ms","max_attempts":2}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewService(slog.New(slog.NewTextHandler(os.Stdout, nil)), store, ledger, policies)
+	q := queue.NewMemoryTable(store).Queue("worker-1", "mock", time.Minute)
+
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	ledger.SetCredits(clientID, 100)
+	providerID := "mock_" + uuid.NewString()
+	msg := &messages.Message{ID: uuid.New(), ClientID: clientID, Status: messages.StatusSent, ProviderMessageID: &providerID, CreatedAt: time.Now()}
+	if err := store.Create(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	ledger.HoldCredits(ctx, clientID, msg.ID, 5)
+
+	// Absent subscriber: the message is due for another send
+	if err := svc.Process(ctx, &Request{ProviderMessageID: providerID, Status: "UNDELIV", ErrorCode: "027"}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := store.GetByID(ctx, msg.ID)
+	if got.Status != messages.StatusFailedTemp || got.Attempts != 1 {
+		t.Fatalf("Expected FAILED_TEMP with 1 attempt, got %s with %d", got.Status, got.Attempts)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if n, _ := q.Retry(ctx); n != 1 {
+		t.Fatalf("Expected the message to be requeued, got %d", n)
+	}
+	if got, _ = store.GetByID(ctx, msg.ID); got.Status != messages.StatusQueued {
+		t.Fatalf("Expected QUEUED, got %s", got.Status)
+	}
+
+	// Sent again and failed again, the last attempt fails for good
+	providerID = "mock_" + uuid.NewString()
+	store.Mutate(func(tx *messages.MemoryTx) {
+		m := tx.Messages[msg.ID]
+		m.Status = messages.StatusSent
+		m.ProviderMessageID = &providerID
+	})
+	if err := svc.Process(ctx, &Request{ProviderMessageID: providerID, Status: "FAILED_TEMP"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = store.GetByID(ctx, msg.ID); got.Status != messages.StatusFailedPerm {
+		t.Errorf("Expected FAILED_PERM once attempts are used up, got %s", got.Status)
+	}
+	if balance, _ := ledger.GetCredits(ctx, clientID); balance != 100 {
+		t.Errorf("Expected the credits back, got balance %d", balance)
+	}
+}
+
+func TestProcessLeavesReclaimedMessageAlone(t *testing.T) {
+	ctx := context.Background()
+	store := messages.NewMemoryStore()
+	ledger := billing.NewMemoryService()
+	policies, err := retry.ParsePolicies(`{"default":{"network":{"base":"1ms","cap":"1ms"}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewService(slog.New(slog.NewTextHandler(os.Stdout, nil)), store, ledger, policies)
+	q := queue.NewMemoryTable(store).Queue("worker-1", "mock", time.Minute)
+
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	ledger.SetCredits(clientID, 100)
+	msg := &messages.Message{ID: uuid.New(), ClientID: clientID, Status: messages.StatusQueued, Parts: 1, CreatedAt: time.Now()}
+	if err := store.Create(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	ledger.HoldCredits(ctx, clientID, msg.ID, 5)
+
+	// The first attempt is sent, fails temporarily and is claimed again
+	if claimed, _ := q.Poll(ctx, 10); len(claimed) != 1 {
+		t.Fatalf("Expected the message to be claimed, got %d", len(claimed))
+	}
+	first := "mock_" + uuid.NewString()
+	if err := q.Complete(ctx, msg.ID, queue.Attempt{Provider: "mock", ProviderMessageID: first}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Process(ctx, &Request{ProviderMessageID: first, Status: "FAILED_TEMP"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if n, _ := q.Retry(ctx); n != 1 {
+		t.Fatalf("Expected the message to be requeued, got %d", n)
+	}
+	if claimed, _ := q.Poll(ctx, 10); len(claimed) != 1 {
+		t.Fatalf("Expected the message to be claimed again, got %d", len(claimed))
+	}
+
+	// A late report for the first attempt must not fail the second
+	err = svc.Process(ctx, &Request{ProviderMessageID: first, Status: "FAILED_PERM"})
+	if !errors.Is(err, messages.ErrIllegalTransition) {
+		t.Fatalf("Expected the late report to be rejected, got %v", err)
+	}
+	if got, _ := store.GetByID(ctx, msg.ID); got.Status != messages.StatusSending {
+		t.Errorf("Expected the message to stay SENDING, got %s", got.Status)
+	}
+	if locks := ledger.Locks(msg.ID); len(locks) != 1 || locks[0].State != "HELD" {
+		t.Errorf("Expected the credits to stay held, got %+v", locks)
+	}
+	if err := q.Complete(ctx, msg.ID, queue.Attempt{Provider: "mock", ProviderMessageID: "mock_" + uuid.NewString()}); err != nil {
+		t.Errorf("Expected the second attempt to complete, got %v", err)
+	}
+}
+
+func TestProcessParksEarlyReport(t *testing.T) {
+	ctx := context.Background()
+	store := messages.NewMemoryStore()
+	ledger := billing.NewMemoryService()
+	svc := NewService(slog.New(slog.NewTextHandler(os.Stdout, nil)), store, ledger, &retry.Policies{})
+
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	ledger.SetCredits(clientID, 100)
+	msg := &messages.Message{ID: uuid.New(), ClientID: clientID, Status: messages.StatusSending, CreatedAt: time.Now()}
+	if err := store.Create(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	ledger.HoldCredits(ctx, clientID, msg.ID, 5)
+
+	// The DLR beats the worker recording the send
+	providerID := "mock_" + uuid.NewString()
+	if err := svc.Process(ctx, &Request{ProviderMessageID: providerID, Status: "DELIVERED"}); err != nil {
+		t.Fatalf("Expected the early report to be parked, got %v", err)
+	}
+	if n, _ := svc.Resolve(ctx); n != 0 {
+		t.Fatalf("Expected nothing to resolve before the provider ID is stored, got %d", n)
+	}
+
+	store.Mutate(func(tx *messages.MemoryTx) {
+		m := tx.Messages[msg.ID]
+		m.Status = messages.StatusSent
+		m.ProviderMessageID = &providerID
+	})
+	if n, _ := svc.Resolve(ctx); n != 1 {
+		t.Fatalf("Expected the parked report to be applied, got %d", n)
+	}
+	if n, _ := svc.Resolve(ctx); n != 0 {
+		t.Errorf("Expected an applied report not to be applied again, got %d", n)
+	}
+
+	got, _ := store.GetByID(ctx, msg.ID)
+	if got.Status != messages.StatusDelivered {
+		t.Errorf("Expected DELIVERED, got %s", got.Status)
+	}
+	if locks := ledger.Locks(msg.ID); len(locks) != 1 || locks[0].State != "CAPTURED" {
+		t.Errorf("Expected captured credits, got %+v", locks)
+	}
+}
+
+func TestProcessIgnoresDuplicateReport(t *testing.T) {
+	ctx := context.Background()
+	store := messages.NewMemoryStore()
+	ledger := billing.NewMemoryService()
+	svc := NewService(slog.New(slog.NewTextHandler(os.Stdout, nil)), store, ledger, &retry.Policies{})
+
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	providerID := "mock_" + uuid.NewString()
+	msg := &messages.Message{ID: uuid.New(), ClientID: clientID, Status: messages.StatusSent, ProviderMessageID: &providerID, CreatedAt: time.Now()}
+	if err := store.Create(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := svc.Process(ctx, &Request{ProviderMessageID: providerID, Status: "DELIVERED"}); err != nil {
+			t.Fatalf("Expected report %d to be accepted, got %v", i+1, err)
+		}
+	}
+	if events, _ := store.ListEvents(ctx, msg.ID); len(events) != 1 {
+		t.Errorf("Expected a single delivery event, got %d", len(events))
+	}
+}
+
+func TestRunExpiresUnmatchedReports(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := messages.NewMemoryStore()
+	svc := NewService(slog.New(slog.NewTextHandler(os.Stdout, nil)), store, billing.NewMemoryService(), &retry.Policies{})
+
+	if err := svc.Process(ctx, &Request{ProviderMessageID: "missing", Status: "DELIVERED"}); err != nil {
+		t.Fatal(err)
+	}
+	go svc.Run(ctx, time.Millisecond, time.Millisecond)
+
+	// Once expired, the same report is no longer a duplicate
+	deadline := time.Now().Add(time.Second)
+	for {
+		again := &messages.DeliveryReport{ProviderMessageID: "missing", Status: messages.StatusDelivered}
+		if fresh, _ := store.RecordDeliveryReport(ctx, again); fresh {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the unmatched report to expire")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestExpireKeepsMatchedReports(t *testing.T) {
+	ctx := context.Background()
+	store := messages.NewMemoryStore()
+	ledger := billing.NewMemoryService()
+	svc := NewService(slog.New(slog.NewTextHandler(os.Stdout, nil)), store, ledger, &retry.Policies{})
+
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	msg := &messages.Message{ID: uuid.New(), ClientID: clientID, Status: messages.StatusSending, CreatedAt: time.Now()}
+	if err := store.Create(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	providerID := "mock_" + uuid.NewString()
+	if err := svc.Process(ctx, &Request{ProviderMessageID: providerID, Status: "DELIVERED"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Process(ctx, &Request{ProviderMessageID: "missing", Status: "DELIVERED"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The send is recorded, but the report has not been applied yet when the sweep runs
+	store.Mutate(func(tx *messages.MemoryTx) {
+		m := tx.Messages[msg.ID]
+		m.Status = messages.StatusSent
+		m.ProviderMessageID = &providerID
+	})
+	if n, err := store.ExpireDeliveryReports(ctx, time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Fatalf("Expected only the unmatched report to expire, got %d, %v", n, err)
+	}
+	if n, _ := svc.Resolve(ctx); n != 1 {
+		t.Fatalf("Expected the matched report to survive and be applied, got %d", n)
+	}
+	if got, _ := store.GetByID(ctx, msg.ID); got.Status != messages.StatusDelivered {
+		t.Errorf("Expected DELIVERED, got %s", got.Status)
+	}
+}
+
+func TestProcessRejectsLateReport(t *testing.T) {
+	ctx := context.Background()
+	store := messages.NewMemoryStore()
+	ledger := billing.NewMemoryService()
+	svc := NewService(slog.New(slog.NewTextHandler(os.Stdout, nil)), store, ledger, &retry.Policies{})
+
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	ledger.SetCredits(clientID, 100)
+	providerID := "mock_" + uuid.NewString()
+	msg := &messages.Message{ID: uuid.New(), ClientID: clientID, Status: messages.StatusSent, ProviderMessageID: &providerID, CreatedAt: time.Now()}
+	if err := store.Create(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	ledger.HoldCredits(ctx, clientID, msg.ID, 5)
+
+	if err := svc.Process(ctx, &Request{ProviderMessageID: providerID, Status: "DELIVERED"}); err != nil {
+		t.Fatal(err)
+	}
+	err := svc.Process(ctx, &Request{ProviderMessageID: providerID, Status: "FAILED_TEMP", Reason: "late"})
+	if !errors.Is(err, messages.ErrIllegalTransition) {
+		t.Fatalf("Expected the late report to be rejected, got %v", err)
+	}
+
+	got, _ := store.GetByID(ctx, msg.ID)
+	if got.Status != messages.StatusDelivered || got.LastError != nil {
+		t.Errorf("Expected DELIVERED without error, got %s with %v", got.Status, got.LastError)
+	}
+	if locks := ledger.Locks(msg.ID); len(locks) != 1 || locks[0].State != "CAPTURED" {
+		t.Errorf("Expected captured credits, got %+v", locks)
+	}
+}
+
+func TestProcessAggregatesParts(t *testing.T) {
+	ctx := context.Background()
+	store := messages.NewMemoryStore()
+	ledger := billing.NewMemoryService()
+	svc := NewService(slog.New(slog.NewTextHandler(os.Stdout, nil)), store, ledger, &retry.Policies{})
+
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	ledger.SetCredits(clientID, 100)
+	msg := &messages.Message{ID: uuid.New(), ClientID: clientID, Status: messages.StatusSent, Parts: 3, CreatedAt: time.Now()}
+	if err := store.Create(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	// As the worker leaves a sent 3-part message: charged and one provider ID per part
+	ledger.HoldCredits(ctx, clientID, msg.ID, 15)
+	ledger.CaptureCredits(ctx, msg.ID)
+	store.SaveParts(ctx, msg.ID, []string{"mock_1_1", "mock_1_2", "mock_1_3"})
+
+	reports := []struct {
+		id, status string
+		expected   messages.Status
+	}{
+		{"mock_1_1", "DELIVERED", messages.StatusSent},
+		{"mock_1_2", "FAILED_PERM", messages.StatusSent},
+		{"mock_1_3", "DELIVERED", messages.StatusPartial},
+	}
+	for _, r := range reports {
+		if err := svc.Process(ctx, &Request{ProviderMessageID: r.id, Status: r.status}); err != nil {
+			t.Fatalf("Report for %s: %v", r.id, err)
+		}
+		if got, _ := store.GetByID(ctx, msg.ID); got.Status != r.expected {
+			t.Fatalf("Expected %s after %s %s, got %s", r.expected, r.id, r.status, got.Status)
+		}
+	}
+
+	// One failed part of three is refunded
+	if balance, _ := ledger.GetCredits(ctx, clientID); balance != 90 {
+		t.Errorf("Expected balance 90, got %d", balance)
+	}
+	parts, _ := store.ListParts(ctx, msg.ID)
+	if len(parts) != 3 || parts[1].Status != messages.StatusFailedPerm {
+		t.Errorf("Expected part 2 failed, got %+v", parts)
+	}
+
+	// A late report for a settled part is refused and changes nothing
+	err := svc.Process(ctx, &Request{ProviderMessageID: "mock_1_1", Status: "FAILED_TEMP"})
+	if !errors.Is(err, messages.ErrIllegalTransition) {
+		t.Errorf("Expected the late part report to be rejected, got %v", err)
+	}
+	if balance, _ := ledger.GetCredits(ctx, clientID); balance != 90 {
+		t.Errorf("Expected balance to stay 90, got %d", balance)
+	}
+}
+
+func TestProcessRetriesFailedParts(t *testing.T) {
+	ctx := context.Background()
+	store := messages.NewMemoryStore()
+	ledger := billing.NewMemoryService()
+	policies, err := retry.ParsePolicies(`{"default":{"network":{"base":"1ms","cap":"1ms"}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewService(slog.New(slog.NewTextHandler(os.Stdout, nil)), store, ledger, policies)
+	q := queue.NewMemoryTable(store).Queue("worker-1", "mock", time.Minute)
+
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	ledger.SetCredits(clientID, 100)
+	msg := &messages.Message{ID: uuid.New(), ClientID: clientID, Status: messages.StatusSent, Parts: 2, CreatedAt: time.Now()}
+	if err := store.Create(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	ledger.HoldCredits(ctx, clientID, msg.ID, 10)
+	ledger.CaptureCredits(ctx, msg.ID)
+	store.SaveParts(ctx, msg.ID, []string{"mock_2_1", "mock_2_2"})
+
+	// No part was delivered and one only failed temporarily, so the whole message is sent again
+	if err := svc.Process(ctx, &Request{ProviderMessageID: "mock_2_1", Status: "FAILED_TEMP"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Process(ctx, &Request{ProviderMessageID: "mock_2_2", Status: "FAILED_PERM"}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := store.GetByID(ctx, msg.ID)
+	if got.Status != messages.StatusFailedTemp || got.Attempts != 1 {
+		t.Fatalf("Expected FAILED_TEMP with 1 attempt, got %s with %d", got.Status, got.Attempts)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if n, _ := q.Retry(ctx); n != 1 {
+		t.Fatalf("Expected the message to be requeued, got %d", n)
+	}
+	if balance, _ := ledger.GetCredits(ctx, clientID); balance != 90 {
+		t.Errorf("Expected the charge to stand for the resend, got balance %d", balance)
+	}
+}
+
+func TestProcessRejectsReportsForRetriedAttempt(t *testing.T) {
+	ctx := context.Background()
+	store := messages.NewMemoryStore()
+	ledger := billing.NewMemoryService()
+	svc := NewService(slog.New(slog.NewTextHandler(os.Stdout, nil)), store, ledger, &retry.Policies{})
+
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	ledger.SetCredits(clientID, 100)
+	single := "mock_" + uuid.NewString()
+	msgs := []*messages.Message{
+		{ID: uuid.New(), ClientID: clientID, Status: messages.StatusSent, Parts: 1, ProviderMessageID: &single, CreatedAt: time.Now()},
+		{ID: uuid.New(), ClientID: clientID, Status: messages.StatusSent, Parts: 2, CreatedAt: time.Now()},
+	}
+	for _, msg := range msgs {
+		if err := store.Create(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+		ledger.HoldCredits(ctx, clientID, msg.ID, 5)
+	}
+	store.SaveParts(ctx, msgs[1].ID, []string{"mock_3_1", "mock_3_2"})
+
+	// Both are up for a retry
+	for _, req := range []*Request{
+		{ProviderMessageID: single, Status: "FAILED_TEMP"},
+		{ProviderMessageID: "mock_3_1", Status: "FAILED_TEMP"},
+		{ProviderMessageID: "mock_3_2", Status: "FAILED_PERM"},
+	} {
+		if err := svc.Process(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reports for the attempts given up are stale
+	for _, id := range []string{single, "mock_3_1"} {
+		err := svc.Process(ctx, &Request{ProviderMessageID: id, Status: "DELIVERED"})
+		if !errors.Is(err, messages.ErrIllegalTransition) {
+			t.Errorf("Expected the report for %s to be rejected, got %v", id, err)
+		}
+	}
+	for _, msg := range msgs {
+		if got, _ := store.GetByID(ctx, msg.ID); got.Status != messages.StatusFailedTemp {
+			t.Errorf("Expected FAILED_TEMP, got %s", got.Status)
+		}
+	}
+	if parts, _ := store.ListParts(ctx, msgs[1].ID); parts[0].Status != messages.StatusFailedTemp {
+		t.Errorf("Expected the stale part report to change nothing, got %s", parts[0].Status)
+	}
+	if locks := ledger.Locks(msgs[0].ID); len(locks) != 1 || locks[0].State != "HELD" {
+		t.Errorf("Expected nothing captured for a stale report, got %+v", locks)
+	}
+}
+
+func TestProcessNormalizesProviderReports(t *testing.T) {
+	ctx := context.Background()
+	store := messages.NewMemoryStore()
+	ledger := billing.NewMemoryService()
+	svc := NewService(slog.New(slog.NewTextHandler(os.Stdout, nil)), store, ledger, &retry.Policies{})
+
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	ledger.SetCredits(clientID, 100)
+	providerID := "smpp_" + uuid.NewString()
+	msg := &messages.Message{ID: uuid.New(), ClientID: clientID, Status: messages.StatusSent, ProviderMessageID: &providerID, CreatedAt: time.Now()}
+	if err := store.Create(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	ledger.HoldCredits(ctx, clientID, msg.ID, 5)
+
+	// ENROUTE is intermediate: recorded, but the message stays SENT
+	if err := svc.Process(ctx, &Request{Provider: "smpp", ProviderMessageID: providerID, Status: "ENROUTE"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.GetByID(ctx, msg.ID); got.Status != messages.StatusSent {
+		t.Errorf("Expected ENROUTE to leave the message SENT, got %s", got.Status)
+	}
+	events, _ := store.ListEvents(ctx, msg.ID)
+	if len(events) != 1 || events[0].Event != messages.EventProgress || *events[0].Detail != "ENROUTE" {
+		t.Fatalf("Expected a progress event, got %+v", events)
+	}
+
+	// An absent subscriber is worth another attempt
+	if err := svc.Process(ctx, &Request{Provider: "smpp", ProviderMessageID: providerID, Status: "UNDELIV", ErrorCode: "027"}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := store.GetByID(ctx, msg.ID)
+	if got.Status != messages.StatusFailedTemp {
+		t.Errorf("Expected FAILED_TEMP, got %s", got.Status)
+	}
+	if got.LastError == nil || *got.LastError != "absent (UNDELIV err 027)" {
+		t.Errorf("Expected the normalized error, got %v", got.LastError)
+	}
+
+	if err := svc.Process(ctx, &Request{Provider: "carrier-pigeon", ProviderMessageID: providerID, Status: "DELIVRD"}); !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("Expected an unknown provider error, got %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package delivery
+
+import (
+	"sms-gateway/internal/messages"
+	"strings"
+)
+
+// ErrorClass is the normalized reason a message was not delivered,
+// independent of how a provider spells it
+type ErrorClass string
+
+const (
+	ErrorNone          ErrorClass = ""
+	ErrorExpired       ErrorClass = "expired"        // Validity period ran out before delivery
+	ErrorRejected      ErrorClass = "rejected"       // Refused by the provider or operator
+	ErrorUndeliverable ErrorClass = "undeliverable"  // The network gave up on it
+	ErrorInvalidNumber ErrorClass = "invalid_number" // No such subscriber
+	ErrorBlocked       ErrorClass = "blocked"        // Barred, blacklisted or opted out
+	ErrorAbsent        ErrorClass = "absent"         // Handset off or out of coverage
+	ErrorNetwork       ErrorClass = "network"        // Transient network or system failure
+	ErrorUnknown       ErrorClass = "unknown"
+)
+
+// Temporary reports whether a later attempt may succeed
+func (c ErrorClass) Temporary() bool {
+	return c == ErrorAbsent || c == ErrorNetwork
+}
+
+// Outcome is a provider's raw status translated into our model
+type Outcome struct {
+	Status messages.Status // StatusSent for intermediate states, which settle nothing
+	Error  ErrorClass
+}
+
+// Intermediate reports whether the message is still on its way
+func (o Outcome) Intermediate() bool {
+	return o.Status == messages.StatusSent
+}
+
+// Mapping translates one provider's DLR status values and network error
+// codes. Lookups are case-insensitive.
+type Mapping struct {
+	Statuses map[string]Outcome
+	Errors   map[string]ErrorClass
+	Default  Outcome // For status values not in Statuses
+}
+
+// Normalize maps a raw status and error code. A known error code refines the
+// error class, and a temporary one turns a permanent failure into a temporary
+// one so the message is tried again.
+func (m Mapping) Normalize(status, errorCode string) Outcome {
+	outcome, ok := m.Statuses[strings.ToUpper(strings.TrimSpace(status))]
+	if !ok {
+		outcome = m.Default
+	}
+	if outcome.Status != messages.StatusFailedPerm && outcome.Status != messages.StatusFailedTemp {
+		return outcome
+	}
+
+	if class, ok := m.Errors[strings.ToUpper(strings.TrimSpace(errorCode))]; ok {
+		outcome.Error = class
+	}
+	if outcome.Error.Temporary() {
+		outcome.Status = messages.StatusFailedTemp
+	} else {
+		outcome.Status = messages.StatusFailedPerm
+	}
+	return outcome
+}
+
+// smppStatuses are the stat values of SMPP delivery receipts (SMPP 3.4 appendix B)
+var smppStatuses = map[string]Outcome{
+	"DELIVRD": {Status: messages.StatusDelivered},
+	"ACCEPTD": {Status: messages.StatusSent},
+	"ENROUTE": {Status: messages.StatusSent},
+	"UNDELIV": {Status: messages.StatusFailedPerm, Error: ErrorUndeliverable},
+	"EXPIRED": {Status: messages.StatusFailedPerm, Error: ErrorExpired},
+	"REJECTD": {Status: messages.StatusFailedPerm, Error: ErrorRejected},
+	"DELETED": {Status: messages.StatusFailedPerm, Error: ErrorRejected},
+	"UNKNOWN": {Status: messages.StatusFailedPerm, Error: ErrorUnknown},
+}
+
+// gsmErrors are the GSM MAP error codes carried in the err field of SMPP receipts
+var gsmErrors = map[string]ErrorClass{
+	"001": ErrorInvalidNumber, // Unknown subscriber
+	"005": ErrorInvalidNumber, // Unidentified subscriber
+	"009": ErrorInvalidNumber, // Illegal subscriber
+	"011": ErrorRejected,      // Teleservice not provisioned
+	"012": ErrorInvalidNumber, // Illegal equipment
+	"013": ErrorBlocked,       // Call barred
+	"021": ErrorRejected,      // Facility not supported
+	"027": ErrorAbsent,        // Absent subscriber
+	"031": ErrorNetwork,       // Subscriber busy for MT SMS
+	"032": ErrorNetwork,       // Equipment failure
+	"034": ErrorNetwork,       // System failure
+	"036": ErrorNetwork,       // Unexpected data value
+}
+
+// Mappings holds the normalization tables by provider name, as used in the
+// DLR webhook path
+var Mappings = map[string]Mapping{
+	// The mock provider reports our own statuses, and SMPP ones when asked to
+	"mock": {
+		Statuses: merge(map[string]Outcome{
+			"DELIVERED":   {Status: messages.StatusDelivered},
+			"FAILED_TEMP": {Status: messages.StatusFailedTemp, Error: ErrorNetwork},
+			"FAILED_PERM": {Status: messages.StatusFailedPerm, Error: ErrorUndeliverable},
+		}, smppStatuses),
+		Errors:  gsmErrors,
+		Default: Outcome{Status: messages.StatusFailedPerm, Error: ErrorUnknown},
+	},
+	"smpp": {
+		Statuses: smppStatuses,
+		Errors:   gsmErrors,
+		Default:  Outcome{Status: messages.StatusFailedPerm, Error: ErrorUnknown},
+	},
+}
+
+func merge(tables ...map[string]Outcome) map[string]Outcome {
+	out := make(map[string]Outcome)
+	for _, t := range tables {
+		for k, v := range t {
+			out[k] = v
+		}
+	}
+	return out
+}
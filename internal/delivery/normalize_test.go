@@ -0,0 +1,38 @@
+package delivery
+
+import (
+	"sms-gateway/internal/messages"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		provider, status, code string
+		expected               Outcome
+	}{
+		{"smpp", "DELIVRD", "000", Outcome{Status: messages.StatusDelivered}},
+		{"smpp", "enroute", "", Outcome{Status: messages.StatusSent}},
+		{"smpp", "ACCEPTD", "", Outcome{Status: messages.StatusSent}},
+		{"smpp", "UNDELIV", "027", Outcome{Status: messages.StatusFailedTemp, Error: ErrorAbsent}},
+		{"smpp", "UNDELIV", "001", Outcome{Status: messages.StatusFailedPerm, Error: ErrorInvalidNumber}},
+		{"smpp", "UNDELIV", "999", Outcome{Status: messages.StatusFailedPerm, Error: ErrorUndeliverable}},
+		{"smpp", "EXPIRED", "", Outcome{Status: messages.StatusFailedPerm, Error: ErrorExpired}},
+		{"smpp", "REJECTD", "013", Outcome{Status: messages.StatusFailedPerm, Error: ErrorBlocked}},
+		{"smpp", "BOGUS", "", Outcome{Status: messages.StatusFailedPerm, Error: ErrorUnknown}},
+		{"mock", "DELIVERED", "", Outcome{Status: messages.StatusDelivered}},
+		{"mock", "FAILED_TEMP", "", Outcome{Status: messages.StatusFailedTemp, Error: ErrorNetwork}},
+		{"mock", "FAILED_PERM", "", Outcome{Status: messages.StatusFailedPerm, Error: ErrorUndeliverable}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider+"/"+tt.status+"/"+tt.code, func(t *testing.T) {
+			got := Mappings[tt.provider].Normalize(tt.status, tt.code)
+			if got != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, got)
+			}
+			if got.Intermediate() != (tt.expected.Status == messages.StatusSent) {
+				t.Errorf("Expected intermediate=%v", !got.Intermediate())
+			}
+		})
+	}
+}
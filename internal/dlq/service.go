@@ -0,0 +1,136 @@
+package dlq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sms-gateway/internal/billing"
+	"sms-gateway/internal/messages"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// MaxBatch caps how many messages one listing or replay may touch
+	MaxBatch = 1000
+
+	EventReplayed      = "replay_requeued"
+	EventReplayRefused = "replay_refused"
+)
+
+// ReplayResult is the outcome of replaying a single dead-lettered message
+type ReplayResult struct {
+	MessageID uuid.UUID `json:"message_id"`
+	Outcome   string    `json:"outcome"` // requeued, insufficient_credits, client_suspended, not_failed, error
+	Error     string    `json:"error,omitempty"`
+}
+
+// Service inspects and replays permanently failed (FAILED_PERM) messages
+type Service struct {
+	logger  *slog.Logger
+	store   messages.Repository
+	billing billing.Ledger
+	pricing billing.Pricing
+}
+
+func NewService(logger *slog.Logger, store messages.Repository, billing billing.Ledger, pricing billing.Pricing) *Service {
+	return &Service{
+		logger:  logger,
+		store:   store,
+		billing: billing,
+		pricing: pricing,
+	}
+}
+
+// List returns dead-lettered messages matching the filter
+func (s *Service) List(ctx context.Context, f messages.FailedFilter) ([]*messages.Message, error) {
+	if f.Limit <= 0 || f.Limit > MaxBatch {
+		f.Limit = MaxBatch
+	}
+	return s.store.ListFailed(ctx, f)
+}
+
+// ReplayFiltered replays every dead-lettered message matching the filter
+func (s *Service) ReplayFiltered(ctx context.Context, f messages.FailedFilter, actor string) ([]ReplayResult, error) {
+	msgs, err := s.List(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	return s.replay(ctx, msgs, actor), nil
+}
+
+// Replay requeues the given messages. Credits are held again before a
+// message is requeued so the send is billed exactly like a new one.
+func (s *Service) Replay(ctx context.Context, ids []uuid.UUID, actor string) []ReplayResult {
+	msgs := make([]*messages.Message, 0, len(ids))
+	var results []ReplayResult
+	for _, id := range ids {
+		msg, err := s.store.GetByID(ctx, id)
+		if err != nil {
+			results = append(results, ReplayResult{MessageID: id, Outcome: "error", Error: err.Error()})
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	return append(results, s.replay(ctx, msgs, actor)...)
+}
+
+func (s *Service) replay(ctx context.Context, msgs []*messages.Message, actor string) []ReplayResult {
+	results := make([]ReplayResult, 0, len(msgs))
+	for _, msg := range msgs {
+		results = append(results, s.replayOne(ctx, msg, actor))
+	}
+	return results
+}
+
+func (s *Service) replayOne(ctx context.Context, msg *messages.Message, actor string) ReplayResult {
+	res := ReplayResult{MessageID: msg.ID}
+	if msg.Status != messages.StatusFailedPerm {
+		res.Outcome = "not_failed"
+		return res
+	}
+
+	cost := s.pricing.Cost(msg.Parts, msg.Priority)
+
+	if _, err := s.billing.HoldCredits(ctx, msg.ClientID, msg.ID, cost); err != nil {
+		res.Outcome = "insufficient_credits"
+		if errors.Is(err, billing.ErrSuspended) {
+			res.Outcome = "client_suspended"
+		}
+		res.Error = err.Error()
+		s.record(ctx, msg.ID, EventReplayRefused, messages.StatusFailedPerm, actor, res.Error)
+		return res
+	}
+
+	// The status change and its history entry commit together, with a fresh attempt budget
+	from := messages.StatusFailedPerm
+	detail := fmt.Sprintf("held %d cents", cost)
+	ev := &messages.Event{MessageID: msg.ID, Event: EventReplayed, FromStatus: &from, Status: messages.StatusQueued,
+		Actor: actor, Detail: &detail}
+	if err := s.store.Transition(ctx, ev, nil, msg.LastError, nil); err != nil {
+		// Someone else changed the message in the meantime; give the credits back
+		if relErr := s.billing.ReleaseCredits(ctx, msg.ID); relErr != nil {
+			s.logger.ErrorContext(ctx, "failed to release replay credits", "error", relErr, "message", msg.ID)
+		}
+		if errors.Is(err, messages.ErrIllegalTransition) {
+			res.Outcome = "not_failed"
+			return res
+		}
+		res.Outcome = "error"
+		res.Error = err.Error()
+		return res
+	}
+
+	res.Outcome = "requeued"
+	s.logger.InfoContext(ctx, "dead letter replayed", "message", msg.ID, "client", msg.ClientID, "cost", cost, "actor", actor)
+	return res
+}
+
+func (s *Service) record(ctx context.Context, messageID uuid.UUID, event string, status messages.Status, actor, detail string) {
+	from := messages.StatusFailedPerm // Replays only start from the dead-letter state
+	ev := &messages.Event{MessageID: messageID, Event: event, FromStatus: &from, Status: status, Actor: actor, Detail: &detail}
+	if err := s.store.AppendEvent(ctx, ev); err != nil {
+		s.logger.ErrorContext(ctx, "failed to record replay", "error", err, "message", messageID)
+	}
+}
@@ -0,0 +1,179 @@
+package leader
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Job is a background task that must run on one replica at a time. It runs
+// while this process leads the job and must return once ctx is cancelled.
+type Job func(ctx context.Context)
+
+// Coordinator elects, per named job, a single leader among all processes
+// sharing a Locker. Every interval the leader confirms it still holds the
+// job's lock and the others try to take it, so leadership moves on within an
+// interval of the leader dying or losing its session.
+type Coordinator struct {
+	locker   Locker
+	logger   *slog.Logger
+	id       string
+	interval time.Duration
+
+	mu      sync.Mutex
+	jobs    map[string]Job
+	leading map[string]bool
+	started bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewCoordinator creates a coordinator identified by id in logs
+func NewCoordinator(logger *slog.Logger, locker Locker, id string, interval time.Duration) *Coordinator {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Coordinator{
+		locker:   locker,
+		logger:   logger,
+		id:       id,
+		interval: interval,
+		jobs:     make(map[string]Job),
+		leading:  make(map[string]bool),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Register adds a job. Jobs registered after Start begin campaigning at once.
+func (c *Coordinator) Register(name string, run Job) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.jobs[name] = run
+	if c.started {
+		c.wg.Add(1)
+		go c.campaign(name, run)
+	}
+}
+
+// Start campaigns for every registered job
+func (c *Coordinator) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.started = true
+	for name, run := range c.jobs {
+		c.wg.Add(1)
+		go c.campaign(name, run)
+	}
+}
+
+// Stop cancels the jobs this process leads, waits for them to return and
+// releases their locks so another process can take over straight away
+func (c *Coordinator) Stop() {
+	c.cancel()
+	c.wg.Wait()
+}
+
+// IsLeader reports whether this process currently runs the named job
+func (c *Coordinator) IsLeader(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.leading[name]
+}
+
+func (c *Coordinator) setLeading(name string, leading bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.leading[name] = leading
+}
+
+// campaign competes for one job until the coordinator stops
+func (c *Coordinator) campaign(name string, run Job) {
+	defer c.wg.Done()
+	key := Key(name)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	var term *term
+	for {
+		if term == nil {
+			term = c.tryLead(name, key, run)
+		} else if err := c.confirm(term); err != nil {
+			c.logger.Warn("Lost leadership", "job", name, "coordinator", c.id, "error", err)
+			c.stepDown(name, term)
+			term = nil
+		}
+
+		select {
+		case <-c.ctx.Done():
+			if term != nil {
+				c.stepDown(name, term)
+				c.logger.Info("Released leadership", "job", name, "coordinator", c.id)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// term is one period of leadership over a job
+type term struct {
+	lock   Lock
+	cancel context.CancelFunc
+	done   chan struct{} // Closed when the job returns
+}
+
+// tryLead takes the job's lock and starts the job if it is free
+func (c *Coordinator) tryLead(name string, key int64, run Job) *term {
+	ctx, cancel := context.WithTimeout(c.ctx, c.interval)
+	lock, ok, err := c.locker.TryLock(ctx, key)
+	cancel()
+	if err != nil {
+		c.logger.Error("Leader election failed", "job", name, "coordinator", c.id, "error", err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+
+	jobCtx, jobCancel := context.WithCancel(c.ctx)
+	t := &term{lock: lock, cancel: jobCancel, done: make(chan struct{})}
+	c.setLeading(name, true)
+	c.logger.Info("Acquired leadership", "job", name, "coordinator", c.id)
+
+	go func() {
+		defer close(t.done)
+		run(jobCtx)
+	}()
+	return t
+}
+
+// confirm checks the lock is still held and the job is still running
+func (c *Coordinator) confirm(t *term) error {
+	select {
+	case <-t.done:
+		return context.Canceled // The job gave up; let someone else have it
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.interval)
+	defer cancel()
+	return t.lock.Alive(ctx)
+}
+
+// stepDown stops the job before the lock is released, so two leaders never
+// run it at the same time
+func (c *Coordinator) stepDown(name string, t *term) {
+	t.cancel()
+	<-t.done
+	c.setLeading(name, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.interval)
+	defer cancel()
+	if err := t.lock.Unlock(ctx); err != nil {
+		c.logger.Warn("Failed to release leader lock", "job", name, "coordinator", c.id, "error", err)
+	}
+}
@@ -0,0 +1,190 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tracker records how many coordinators run a job at the same time
+type tracker struct {
+	active  int64
+	maxSeen int64
+	runs    int64
+}
+
+func (tr *tracker) job(ctx context.Context) {
+	atomic.AddInt64(&tr.runs, 1)
+	n := atomic.AddInt64(&tr.active, 1)
+	for {
+		seen := atomic.LoadInt64(&tr.maxSeen)
+		if n <= seen || atomic.CompareAndSwapInt64(&tr.maxSeen, seen, n) {
+			break
+		}
+	}
+	<-ctx.Done()
+	atomic.AddInt64(&tr.active, -1)
+}
+
+func newCoordinators(t *testing.T, locker *MemoryLocker, n int) ([]*Coordinator, []*MemorySession) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	var coords []*Coordinator
+	var sessions []*MemorySession
+	for i := 0; i < n; i++ {
+		session := locker.Session()
+		coords = append(coords, NewCoordinator(logger, session, fmt.Sprintf("replica-%d", i), 10*time.Millisecond))
+		sessions = append(sessions, session)
+	}
+	return coords, sessions
+}
+
+// leaderOf waits for the job to settle on exactly one leader and returns its index
+func leaderOf(t *testing.T, coords []*Coordinator, name string) int {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		leader, count := -1, 0
+		for i, c := range coords {
+			if c.IsLeader(name) {
+				leader = i
+				count++
+			}
+		}
+		if count == 1 {
+			return leader
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected one leader for %s, got %d", name, count)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSingleLeaderPerJob(t *testing.T) {
+	locker := NewMemoryLocker()
+	coords, _ := newCoordinators(t, locker, 5)
+
+	retry, reaper := &tracker{}, &tracker{}
+	for _, c := range coords {
+		c.Register("retry", retry.job)
+		c.Register("reaper", reaper.job)
+		c.Start()
+	}
+	leaderOf(t, coords, "retry")
+	leaderOf(t, coords, "reaper")
+	time.Sleep(100 * time.Millisecond) // Several election rounds
+
+	for name, tr := range map[string]*tracker{"retry": retry, "reaper": reaper} {
+		if runs, active := atomic.LoadInt64(&tr.runs), atomic.LoadInt64(&tr.active); runs != 1 || active != 1 {
+			t.Errorf("%s: expected one stable leader, got %d runs and %d active", name, runs, active)
+		}
+	}
+
+	for _, c := range coords {
+		c.Stop()
+	}
+	for name, tr := range map[string]*tracker{"retry": retry, "reaper": reaper} {
+		if tr.maxSeen != 1 {
+			t.Errorf("%s: expected at most one leader at a time, saw %d", name, tr.maxSeen)
+		}
+		if tr.active != 0 {
+			t.Errorf("%s: expected job stopped, %d still running", name, tr.active)
+		}
+	}
+}
+
+func TestLeadershipHandoverOnStop(t *testing.T) {
+	locker := NewMemoryLocker()
+	coords, _ := newCoordinators(t, locker, 3)
+
+	tr := &tracker{}
+	for _, c := range coords {
+		c.Register("retry", tr.job)
+		c.Start()
+	}
+
+	// Stop leaders one by one; each time a survivor takes over
+	alive := coords
+	for len(alive) > 1 {
+		i := leaderOf(t, alive, "retry")
+		alive[i].Stop()
+		alive = append(alive[:i:i], alive[i+1:]...)
+	}
+	leaderOf(t, alive, "retry")
+	alive[0].Stop()
+
+	if tr.maxSeen != 1 {
+		t.Errorf("Expected at most one leader at a time, saw %d", tr.maxSeen)
+	}
+	if tr.runs < 3 {
+		t.Errorf("Expected a term per replica, got %d", tr.runs)
+	}
+}
+
+func TestLeadershipHandoverOnSessionLoss(t *testing.T) {
+	locker := NewMemoryLocker()
+	coords, sessions := newCoordinators(t, locker, 3)
+
+	var mu sync.Mutex
+	ran := make(map[int]bool)
+	for i, c := range coords {
+		i := i
+		c.Register("reaper", func(ctx context.Context) {
+			mu.Lock()
+			ran[i] = true
+			mu.Unlock()
+			<-ctx.Done()
+		})
+		c.Start()
+	}
+	defer func() {
+		for _, c := range coords {
+			c.Stop()
+		}
+	}()
+
+	// The leader's process dies: its session, and with it the lock, goes away
+	old := leaderOf(t, coords, "reaper")
+	sessions[old].Kill()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		next := -1
+		for i, c := range coords {
+			if i != old && c.IsLeader("reaper") {
+				next = i
+			}
+		}
+		if next >= 0 && !coords[old].IsLeader("reaper") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected leadership to move off the dead session")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJobRegisteredAfterStart(t *testing.T) {
+	coords, _ := newCoordinators(t, NewMemoryLocker(), 2)
+	for _, c := range coords {
+		c.Start()
+	}
+	tr := &tracker{}
+	for _, c := range coords {
+		c.Register("sweeper", tr.job)
+	}
+	leaderOf(t, coords, "sweeper")
+	for _, c := range coords {
+		c.Stop()
+	}
+	if tr.maxSeen != 1 {
+		t.Errorf("Expected one leader, saw %d", tr.maxSeen)
+	}
+}
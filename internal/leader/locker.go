@@ -0,0 +1,90 @@
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+)
+
+// Locker hands out exclusive, session-bound locks. A lock is lost when the
+// session that took it ends, which is what hands leadership over when a
+// process dies.
+type Locker interface {
+	// TryLock takes the lock for key without waiting. It reports false if
+	// another session holds it.
+	TryLock(ctx context.Context, key int64) (Lock, bool, error)
+}
+
+// Lock is a held lock
+type Lock interface {
+	// Alive confirms the lock is still held
+	Alive(ctx context.Context) error
+	// Unlock releases the lock and ends its session
+	Unlock(ctx context.Context) error
+}
+
+// Key maps a job name to its advisory lock key
+func Key(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("sms-gateway:leader:" + name))
+	return int64(h.Sum64())
+}
+
+// PostgresLocker takes Postgres session-level advisory locks. Each lock pins
+// its own connection, so the server releases it as soon as that connection
+// (or the process holding it) goes away.
+type PostgresLocker struct {
+	db *sql.DB
+}
+
+func NewPostgresLocker(db *sql.DB) *PostgresLocker {
+	return &PostgresLocker{db: db}
+}
+
+func (l *PostgresLocker) TryLock(ctx context.Context, key int64) (Lock, bool, error) {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, false, fmt.Errorf("failed to try advisory lock: %w", err)
+	}
+	if !acquired {
+		conn.Close()
+		return nil, false, nil
+	}
+	return &postgresLock{conn: conn, key: key}, true, nil
+}
+
+type postgresLock struct {
+	conn *sql.Conn
+	key  int64
+}
+
+// Alive checks the session is still up; a session-level advisory lock is
+// held for exactly as long as its session
+func (l *postgresLock) Alive(ctx context.Context) error {
+	var held bool
+	err := l.conn.QueryRowContext(ctx, `SELECT EXISTS (
+		SELECT 1 FROM pg_locks
+		WHERE locktype = 'advisory' AND objsubid = 1 AND pid = pg_backend_pid() AND granted
+		  AND ((classid::bigint << 32) | objid::bigint) = $1
+	)`, l.key).Scan(&held)
+	if err != nil {
+		return fmt.Errorf("failed to check advisory lock: %w", err)
+	}
+	if !held {
+		return fmt.Errorf("advisory lock %d no longer held", l.key)
+	}
+	return nil
+}
+
+func (l *postgresLock) Unlock(ctx context.Context) error {
+	defer l.conn.Close()
+	_, err := l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.key)
+	return err
+}
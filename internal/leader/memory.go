@@ -0,0 +1,89 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// MemoryLocker is an in-process Locker for tests. Each Session stands in for
+// one process's database session; killing a session releases its locks the
+// way Postgres does when a connection drops.
+type MemoryLocker struct {
+	mu   sync.Mutex
+	held map[int64]*memoryLock
+}
+
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{held: make(map[int64]*memoryLock)}
+}
+
+// Session returns a Locker whose locks all belong to one session
+func (m *MemoryLocker) Session() *MemorySession {
+	return &MemorySession{locker: m}
+}
+
+// MemorySession is one simulated database session
+type MemorySession struct {
+	locker *MemoryLocker
+	dead   bool // Guarded by locker.mu
+}
+
+var errSessionClosed = errors.New("session closed")
+
+func (s *MemorySession) TryLock(ctx context.Context, key int64) (Lock, bool, error) {
+	m := s.locker
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s.dead {
+		return nil, false, errSessionClosed
+	}
+	if _, taken := m.held[key]; taken {
+		return nil, false, nil
+	}
+	lock := &memoryLock{session: s, key: key}
+	m.held[key] = lock
+	return lock, true, nil
+}
+
+// Kill ends the session, dropping every lock it holds
+func (s *MemorySession) Kill() {
+	m := s.locker
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s.dead = true
+	for key, lock := range m.held {
+		if lock.session == s {
+			delete(m.held, key)
+		}
+	}
+}
+
+type memoryLock struct {
+	session *MemorySession
+	key     int64
+}
+
+func (l *memoryLock) Alive(ctx context.Context) error {
+	m := l.session.locker
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.held[l.key] != l {
+		return errSessionClosed
+	}
+	return nil
+}
+
+func (l *memoryLock) Unlock(ctx context.Context) error {
+	m := l.session.locker
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.held[l.key] == l {
+		delete(m.held, l.key)
+	}
+	return nil
+}
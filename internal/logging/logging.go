@@ -0,0 +1,128 @@
+// Package logging builds the service loggers. Its handler adds the request,
+// client and message IDs carried by the context to every record logged with
+// one of the *Context methods, and redacts phone numbers and message text
+// unless debug logging is on.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	clientIDKey
+	messageIDKey
+)
+
+// WithRequestID returns ctx carrying the ID of the HTTP request being served
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// WithClientID returns ctx carrying the client a request or message belongs to
+func WithClientID(ctx context.Context, id uuid.UUID) context.Context {
+	return context.WithValue(ctx, clientIDKey, id)
+}
+
+// WithMessageID returns ctx carrying the message being handled
+func WithMessageID(ctx context.Context, id uuid.UUID) context.Context {
+	return context.WithValue(ctx, messageIDKey, id)
+}
+
+// RequestID returns the request ID carried by ctx, if any
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// New returns a JSON logger writing to w at the given LOG_LEVEL (debug,
+// info, warn or error)
+func New(w io.Writer, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL %q: %w", level, err)
+	}
+	return slog.New(NewHandler(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: lvl}))), nil
+}
+
+// Handler adds correlation IDs from the context and redacts personal data
+type Handler struct {
+	next slog.Handler
+}
+
+func NewHandler(next slog.Handler) *Handler {
+	return &Handler{next: next}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	if id := RequestID(ctx); id != "" {
+		out.AddAttrs(slog.String("request_id", id))
+	}
+	if id, ok := ctx.Value(clientIDKey).(uuid.UUID); ok {
+		out.AddAttrs(slog.String("client_id", id.String()))
+	}
+	if id, ok := ctx.Value(messageIDKey).(uuid.UUID); ok {
+		out.AddAttrs(slog.String("message_id", id.String()))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		out.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+
+	debug := h.next.Enabled(ctx, slog.LevelDebug)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redact(a, debug))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	debug := h.next.Enabled(context.Background(), slog.LevelDebug)
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redact(a, debug)
+	}
+	return &Handler{next: h.next.WithAttrs(redacted)}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name)}
+}
+
+// redact masks attributes holding phone numbers or message text. Debug
+// logging shows them in full.
+func redact(a slog.Attr, debug bool) slog.Attr {
+	if debug {
+		return a
+	}
+	switch a.Key {
+	case "to", "msisdn", "to_msisdn":
+		return slog.String(a.Key, MaskMSISDN(a.Value.String()))
+	case "text", "body":
+		return slog.String(a.Key, fmt.Sprintf("[redacted %d chars]", len(a.Value.String())))
+	}
+	return a
+}
+
+// MaskMSISDN keeps the country prefix and last two digits of a number:
+// +447700900189 becomes +44********89
+func MaskMSISDN(msisdn string) string {
+	if len(msisdn) <= 5 {
+		return strings.Repeat("*", len(msisdn))
+	}
+	return msisdn[:3] + strings.Repeat("*", len(msisdn)-5) + msisdn[len(msisdn)-2:]
+}
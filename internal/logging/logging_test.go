@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestHandlerAddsContextIDs(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientID, messageID := uuid.New(), uuid.New()
+	ctx := WithMessageID(WithClientID(WithRequestID(context.Background(), "req-1"), clientID), messageID)
+	logger.InfoContext(ctx, "Message queued", "priority", "otp")
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"request_id": "req-1",
+		"client_id":  clientID.String(),
+		"message_id": messageID.String(),
+		"priority":   "otp",
+	}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("Expected %s=%q, got %v", key, value, line[key])
+		}
+	}
+}
+
+func TestHandlerRedacts(t *testing.T) {
+	tests := []struct {
+		level      string
+		to, text   string
+		withAttrTo string
+	}{
+		{"info", "+44********89", "[redacted 11 chars]", "+14*******00"},
+		{"debug", "+447700900189", "hello world", "+14155550100"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := New(&buf, tt.level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			logger.With("msisdn", "+14155550100").Info("OTP delivered", "to", "+447700900189", "text", "hello world")
+
+			var line map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatal(err)
+			}
+			if line["to"] != tt.to || line["text"] != tt.text || line["msisdn"] != tt.withAttrTo {
+				t.Errorf("Expected to=%q text=%q msisdn=%q, got %v %v %v",
+					tt.to, tt.text, tt.withAttrTo, line["to"], line["text"], line["msisdn"])
+			}
+		})
+	}
+}
+
+func TestNewLevel(t *testing.T) {
+	logger, err := New(&bytes.Buffer{}, "warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logger.Enabled(context.Background(), slog.LevelInfo) {
+		t.Error("Expected info to be disabled at LOG_LEVEL=warn")
+	}
+	if _, err := New(&bytes.Buffer{}, "loud"); err == nil {
+		t.Error("Expected an invalid level to be rejected")
+	}
+}
@@ -0,0 +1,563 @@
+package messages
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore is an in-memory Repository with the same semantics as Store,
+// for hermetic tests. Like the messages table it rejects unknown clients,
+// so clients must be registered with AddClient first.
+type MemoryStore struct {
+	mu        sync.Mutex
+	clients   map[uuid.UUID]bool
+	unique    map[uuid.UUID]bool         // Clients with unique references
+	holders   map[referenceKey]uuid.UUID // Message holding each unique reference
+	msgs      map[uuid.UUID]*Message
+	parts     map[uuid.UUID][]*Part
+	events    []Event
+	nextEvent int64
+	reports   []*DeliveryReport
+	lastDLR   int64
+	retry     map[uuid.UUID]time.Time // Like messages.retry_after
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		clients: make(map[uuid.UUID]bool),
+		unique:  make(map[uuid.UUID]bool),
+		holders: make(map[referenceKey]uuid.UUID),
+		msgs:    make(map[uuid.UUID]*Message),
+		parts:   make(map[uuid.UUID][]*Part),
+		retry:   make(map[uuid.UUID]time.Time),
+	}
+}
+
+// AddClient registers a client so messages can reference it
+func (s *MemoryStore) AddClient(clientID uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[clientID] = true
+}
+
+// SetUniqueReferences turns unique references on or off for a client, like
+// clients.unique_references
+func (s *MemoryStore) SetUniqueReferences(clientID uuid.UUID, on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unique[clientID] = on
+}
+
+type referenceKey struct {
+	clientID  uuid.UUID
+	reference string
+}
+
+// MemoryTx is the view of a MemoryStore inside Mutate
+type MemoryTx struct {
+	Messages   map[uuid.UUID]*Message  // May be changed in place, must not be kept
+	RetryAfter map[uuid.UUID]time.Time // When FAILED_TEMP messages are due, likewise
+	store      *MemoryStore
+}
+
+// AppendEvent records ev as part of the transaction
+func (tx *MemoryTx) AppendEvent(ev Event) {
+	tx.store.appendEvent(&ev)
+}
+
+// SaveParts records the message's parts as part of the transaction
+func (tx *MemoryTx) SaveParts(messageID uuid.UUID, providerIDs []string) {
+	tx.store.saveParts(messageID, providerIDs)
+}
+
+// Mutate gives fn exclusive access to every stored message, the in-memory
+// equivalent of a transaction over the messages and message_events tables
+func (s *MemoryStore) Mutate(fn func(tx *MemoryTx)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&MemoryTx{Messages: s.msgs, RetryAfter: s.retry, store: s})
+}
+
+func (s *MemoryStore) Create(ctx context.Context, msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.clients[msg.ClientID] {
+		return fmt.Errorf("failed to create message: insert violates foreign key constraint \"messages_client_id_fkey\"")
+	}
+	if _, exists := s.msgs[msg.ID]; exists {
+		return fmt.Errorf("failed to create message: duplicate key value violates unique constraint \"messages_pkey\"")
+	}
+	if msg.Priority == "" {
+		msg.Priority = PriorityStandard
+	}
+	if msg.Reference != nil && s.unique[msg.ClientID] {
+		key := referenceKey{msg.ClientID, *msg.Reference}
+		if _, taken := s.holders[key]; taken {
+			return ErrDuplicateReference
+		}
+		s.holders[key] = msg.ID
+	}
+	copied := *msg
+	s.msgs[msg.ID] = &copied
+	return nil
+}
+
+func (s *MemoryStore) GetByReference(ctx context.Context, clientID uuid.UUID, reference string) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.holders[referenceKey{clientID, reference}]
+	if !ok {
+		return nil, fmt.Errorf("message not found")
+	}
+	copied := *s.msgs[id]
+	return &copied, nil
+}
+
+func (s *MemoryStore) FreeReference(ctx context.Context, messageID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg, ok := s.msgs[messageID]; ok && msg.Reference != nil {
+		key := referenceKey{msg.ClientID, *msg.Reference}
+		if s.holders[key] == messageID {
+			delete(s.holders, key)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetByID(ctx context.Context, messageID uuid.UUID) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, ok := s.msgs[messageID]
+	if !ok {
+		return nil, fmt.Errorf("message not found")
+	}
+	copied := *msg
+	return &copied, nil
+}
+
+func (s *MemoryStore) GetByProviderID(ctx context.Context, providerMessageID string) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if part := s.partByProviderID(providerMessageID); part != nil {
+		copied := *s.msgs[part.MessageID]
+		return &copied, nil
+	}
+	for _, msg := range s.msgs {
+		if msg.ProviderMessageID != nil && *msg.ProviderMessageID == providerMessageID {
+			copied := *msg
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("message not found with provider_message_id: %s", providerMessageID)
+}
+
+func (s *MemoryStore) ListByClient(ctx context.Context, f MessageFilter) ([]*Message, error) {
+	// before reports whether a comes before b in the listing order
+	before := func(a, b Cursor) bool {
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt) == f.Ascending
+		}
+		x, y := a.ID.String(), b.ID.String()
+		return x != y && (x < y) == f.Ascending
+	}
+	msgs := s.filter(func(m *Message) bool {
+		switch {
+		case m.ClientID != f.ClientID,
+			len(f.Statuses) > 0 && !slices.Contains(f.Statuses, m.Status),
+			f.To != "" && m.To != f.To,
+			f.From != "" && m.From != f.From,
+			f.Reference != "" && (m.Reference == nil || *m.Reference != f.Reference),
+			f.Express != nil && m.Express != *f.Express,
+			f.Since != nil && m.CreatedAt.Before(*f.Since),
+			f.Until != nil && !m.CreatedAt.Before(*f.Until),
+			f.After != nil && !before(*f.After, CursorOf(m)):
+			return false
+		}
+		return true
+	})
+	sort.Slice(msgs, func(i, j int) bool { return before(CursorOf(msgs[i]), CursorOf(msgs[j])) })
+	return page(msgs, f.Limit, 0), nil
+}
+
+func (s *MemoryStore) ListFailed(ctx context.Context, f FailedFilter) ([]*Message, error) {
+	msgs := s.filter(func(m *Message) bool {
+		if m.Status != StatusFailedPerm {
+			return false
+		}
+		if f.Error != "" && (m.LastError == nil || !strings.Contains(strings.ToLower(*m.LastError), strings.ToLower(f.Error))) {
+			return false
+		}
+		if f.Provider != "" && (m.Provider == nil || *m.Provider != f.Provider) {
+			return false
+		}
+		if f.ClientID != nil && m.ClientID != *f.ClientID {
+			return false
+		}
+		if f.From != nil && m.UpdatedAt.Before(*f.From) {
+			return false
+		}
+		if f.To != nil && !m.UpdatedAt.Before(*f.To) {
+			return false
+		}
+		return true
+	})
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].UpdatedAt.After(msgs[j].UpdatedAt) })
+	return page(msgs, f.Limit, 0), nil
+}
+
+func (s *MemoryStore) GetFailedMessagesForRetry(ctx context.Context, limit int) ([]*Message, error) {
+	msgs := s.filter(func(m *Message) bool { return m.Status == StatusFailedTemp })
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].UpdatedAt.Before(msgs[j].UpdatedAt) })
+	return page(msgs, limit, 0), nil
+}
+
+func (s *MemoryStore) GetQueuedMessages(ctx context.Context, limit int) ([]*Message, error) {
+	msgs := s.filter(func(m *Message) bool { return m.Status == StatusQueued })
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].CreatedAt.Before(msgs[j].CreatedAt) })
+	return page(msgs, limit, 0), nil
+}
+
+func (s *MemoryStore) UpdateProvider(ctx context.Context, messageID uuid.UUID, provider string) error {
+	return s.update(messageID, func(m *Message) { m.Provider = &provider })
+}
+
+func (s *MemoryStore) IncrementAttempts(ctx context.Context, messageID uuid.UUID) error {
+	return s.update(messageID, func(m *Message) { m.Attempts++ })
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, messageID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg, ok := s.msgs[messageID]; ok && msg.Reference != nil {
+		key := referenceKey{msg.ClientID, *msg.Reference}
+		if s.holders[key] == messageID {
+			delete(s.holders, key)
+		}
+	}
+	delete(s.msgs, messageID)
+	delete(s.parts, messageID)
+	kept := s.events[:0]
+	for _, ev := range s.events {
+		if ev.MessageID != messageID {
+			kept = append(kept, ev)
+		}
+	}
+	s.events = kept
+	return nil
+}
+
+func (s *MemoryStore) AppendEvent(ctx context.Context, ev *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.msgs[ev.MessageID]; !ok {
+		return fmt.Errorf("failed to append message event: %w", sql.ErrNoRows)
+	}
+	s.appendEvent(ev)
+	return nil
+}
+
+func (s *MemoryStore) Transition(ctx context.Context, ev *Event, providerID *string, lastError *string, retryIn *time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, ok := s.msgs[ev.MessageID]
+	if !ok {
+		return fmt.Errorf("message not found")
+	}
+	from := msg.Status
+	if !containsStatus(allowedFrom(ev), from) {
+		return &IllegalTransitionError{MessageID: ev.MessageID, From: from, To: ev.Status}
+	}
+	ev.FromStatus = &from
+	msg.Status = ev.Status
+	switch {
+	case from == StatusFailedPerm:
+		msg.Attempts = 0
+		delete(s.retry, msg.ID)
+	case retryIn != nil:
+		msg.Attempts++
+		s.retry[msg.ID] = time.Now().Add(*retryIn)
+	}
+	if providerID != nil {
+		id := *providerID
+		msg.ProviderMessageID = &id
+	}
+	if ev.Provider != nil {
+		provider := *ev.Provider
+		msg.Provider = &provider
+	}
+	msg.LastError = lastError
+	msg.UpdatedAt = time.Now()
+	s.appendEvent(ev)
+	return nil
+}
+
+func (s *MemoryStore) ListEvents(ctx context.Context, messageID uuid.UUID) ([]*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reference *string
+	if msg, ok := s.msgs[messageID]; ok {
+		reference = msg.Reference
+	}
+	var out []*Event
+	for _, ev := range s.events {
+		if ev.MessageID == messageID {
+			copied := ev
+			copied.Reference = reference
+			out = append(out, &copied)
+		}
+	}
+	return out, nil
+}
+
+func containsStatus(statuses []Status, s Status) bool {
+	for _, status := range statuses {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) SaveParts(ctx context.Context, messageID uuid.UUID, providerIDs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.msgs[messageID]; !ok {
+		return fmt.Errorf("failed to save message parts: insert violates foreign key constraint \"message_parts_message_id_fkey\"")
+	}
+	s.saveParts(messageID, providerIDs)
+	return nil
+}
+
+// saveParts replaces a message's parts. Caller holds mu.
+func (s *MemoryStore) saveParts(messageID uuid.UUID, providerIDs []string) {
+	parts := make([]*Part, len(providerIDs))
+	for i, id := range providerIDs {
+		parts[i] = &Part{MessageID: messageID, Number: i + 1, ProviderMessageID: id, Status: StatusSent, UpdatedAt: time.Now()}
+	}
+	s.parts[messageID] = parts
+}
+
+func (s *MemoryStore) ListParts(ctx context.Context, messageID uuid.UUID) ([]*Part, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return copyParts(s.parts[messageID]), nil
+}
+
+func (s *MemoryStore) TransitionPart(ctx context.Context, providerMessageID string, to Status) ([]*Part, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	part := s.partByProviderID(providerMessageID)
+	if part == nil {
+		return nil, ErrPartNotFound
+	}
+	if current := s.msgs[part.MessageID].Status; current != StatusSent {
+		return nil, &IllegalTransitionError{MessageID: part.MessageID, From: current, To: to}
+	}
+	if !CanTransition(part.Status, to) {
+		return nil, &IllegalTransitionError{MessageID: part.MessageID, From: part.Status, To: to}
+	}
+	part.Status = to
+	part.UpdatedAt = time.Now()
+	return copyParts(s.parts[part.MessageID]), nil
+}
+
+// partByProviderID finds a part of a stored message. Caller holds mu.
+func (s *MemoryStore) partByProviderID(providerMessageID string) *Part {
+	for id, parts := range s.parts {
+		if _, ok := s.msgs[id]; !ok {
+			continue
+		}
+		for _, p := range parts {
+			if p.ProviderMessageID == providerMessageID {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+func copyParts(parts []*Part) []*Part {
+	var out []*Part
+	for _, p := range parts {
+		copied := *p
+		out = append(out, &copied)
+	}
+	return out
+}
+
+func (s *MemoryStore) RecordDeliveryReport(ctx context.Context, r *DeliveryReport) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.reports {
+		if existing.ProviderMessageID == r.ProviderMessageID && existing.Status == r.Status {
+			return false, nil
+		}
+	}
+	s.lastDLR++
+	r.ID = s.lastDLR
+	r.ReceivedAt = time.Now()
+	copied := *r
+	s.reports = append(s.reports, &copied)
+	return true, nil
+}
+
+func (s *MemoryStore) MatchedDeliveryReports(ctx context.Context, limit int) ([]*DeliveryReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []*DeliveryReport
+	for _, r := range s.reports {
+		if r.AppliedAt != nil {
+			continue
+		}
+		if id, ok := s.reportMessage(r); ok {
+			copied := *r
+			copied.MessageID = &id
+			out = append(out, &copied)
+		}
+		if limit > 0 && len(out) == limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+// reportMessage finds the message a report refers to, through its parts or
+// its own provider ID. Caller holds mu.
+func (s *MemoryStore) reportMessage(r *DeliveryReport) (uuid.UUID, bool) {
+	if r.MessageID != nil {
+		return *r.MessageID, true
+	}
+	if part := s.partByProviderID(r.ProviderMessageID); part != nil {
+		return part.MessageID, true
+	}
+	for _, msg := range s.msgs {
+		if msg.ProviderMessageID != nil && *msg.ProviderMessageID == r.ProviderMessageID {
+			return msg.ID, true
+		}
+	}
+	return uuid.Nil, false
+}
+
+func (s *MemoryStore) SettleDeliveryReport(ctx context.Context, id int64, messageID uuid.UUID, outcome string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.reports {
+		if r.ID == id && r.AppliedAt == nil {
+			now := time.Now()
+			r.MessageID = &messageID
+			r.Outcome = outcome
+			r.AppliedAt = &now
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ExpireDeliveryReports(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired int64
+	kept := s.reports[:0]
+	for _, r := range s.reports {
+		if r.AppliedAt == nil && r.ReceivedAt.Before(before) {
+			if _, matched := s.reportMessage(r); !matched {
+				expired++
+				continue
+			}
+		}
+		kept = append(kept, r)
+	}
+	s.reports = kept
+	return expired, nil
+}
+
+// appendEvent assigns an ID and timestamp and stores a copy. Caller holds mu.
+func (s *MemoryStore) appendEvent(ev *Event) {
+	s.nextEvent++
+	ev.ID = s.nextEvent
+	ev.CreatedAt = time.Now()
+	s.events = append(s.events, *ev)
+}
+
+func (s *MemoryStore) QueueDepth(ctx context.Context) ([]Depth, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[Depth]int64)
+	for _, m := range s.msgs {
+		if containsStatus(pendingStatuses, m.Status) {
+			counts[Depth{Status: m.Status, Priority: m.Priority}]++
+		}
+	}
+	var depths []Depth
+	for d, n := range counts {
+		d.Count = n
+		depths = append(depths, d)
+	}
+	return depths, nil
+}
+
+func (s *MemoryStore) Health(ctx context.Context) error {
+	return nil
+}
+
+// update applies fn to a message; like an UPDATE it is a no-op for unknown IDs
+func (s *MemoryStore) update(messageID uuid.UUID, fn func(m *Message)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg, ok := s.msgs[messageID]; ok {
+		fn(msg)
+		msg.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+// filter returns copies of the messages matching keep
+func (s *MemoryStore) filter(keep func(m *Message) bool) []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []*Message
+	for _, msg := range s.msgs {
+		if keep(msg) {
+			copied := *msg
+			out = append(out, &copied)
+		}
+	}
+	return out
+}
+
+func page(msgs []*Message, limit, offset int) []*Message {
+	if offset >= len(msgs) {
+		return nil
+	}
+	msgs = msgs[offset:]
+	if limit > 0 && limit < len(msgs) {
+		msgs = msgs[:limit]
+	}
+	return msgs
+}
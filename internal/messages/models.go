@@ -1,6 +1,10 @@
 package messages
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
 	"time"
 	"unicode/utf8"
 
@@ -14,11 +18,70 @@ const (
 	StatusSending    Status = "SENDING"
 	StatusSent       Status = "SENT"
 	StatusDelivered  Status = "DELIVERED"
+	StatusPartial    Status = "PARTIALLY_DELIVERED" // Some parts delivered, the rest failed permanently
 	StatusFailedTemp Status = "FAILED_TEMP"
 	StatusFailedPerm Status = "FAILED_PERM"
 	StatusCancelled  Status = "CANCELLED"
 )
 
+// Valid reports whether s is a known status
+func (s Status) Valid() bool {
+	switch s {
+	case StatusQueued, StatusSending, StatusSent, StatusDelivered, StatusPartial,
+		StatusFailedTemp, StatusFailedPerm, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+// Priority is a message's scheduling class. Each class has its own reserved
+// worker capacity, surcharge and retry policy.
+type Priority string
+
+const (
+	PriorityOTP           Priority = "otp"
+	PriorityTransactional Priority = "transactional"
+	PriorityExpress       Priority = "express"
+	PriorityStandard      Priority = "standard"
+	PriorityBulk          Priority = "bulk"
+)
+
+// Priorities lists every class, most urgent first
+var Priorities = []Priority{PriorityOTP, PriorityTransactional, PriorityExpress, PriorityStandard, PriorityBulk}
+
+// Rank returns the class's position in Priorities (0 is served first).
+// Unknown classes rank as standard.
+func (p Priority) Rank() int {
+	for i, prio := range Priorities {
+		if prio == p {
+			return i
+		}
+	}
+	return PriorityStandard.Rank()
+}
+
+// Valid reports whether p is a known priority class
+func (p Priority) Valid() bool {
+	for _, prio := range Priorities {
+		if prio == p {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolvePriority maps a request onto a priority class. Requests that only
+// set the legacy express flag become express; everything else defaults to standard.
+func ResolvePriority(requested Priority, express bool) Priority {
+	if requested != "" {
+		return requested
+	}
+	if express {
+		return PriorityExpress
+	}
+	return PriorityStandard
+}
+
 type Message struct {
 	ID                uuid.UUID `json:"id"`
 	ClientID          uuid.UUID `json:"client_id"`
@@ -33,8 +96,11 @@ type Message struct {
 	Attempts          int       `json:"attempts"`
 	LastError         *string   `json:"last_error,omitempty"`
 	Express           bool      `json:"express"`
+	Priority          Priority  `json:"priority"`
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
+	// TraceParent links the worker's send and DLRs to the trace of the request that created the message
+	TraceParent *string `json:"-"`
 }
 
 type SendRequest struct {
@@ -45,17 +111,151 @@ type SendRequest struct {
 	Reference *string   `json:"reference,omitempty"`
 	OTP       bool      `json:"otp,omitempty"`
 	Express   bool      `json:"express,omitempty"`
+	Priority  Priority  `json:"priority,omitempty" enums:"otp,transactional,express,standard,bulk"`
 }
 
 type SendResponse struct {
 	MessageID uuid.UUID `json:"message_id"`
 	Status    Status    `json:"status"`
 	OTPCode   *string   `json:"otp_code,omitempty"`
+	Duplicate bool      `json:"duplicate,omitempty"` // The reference was already sent; this is that message
 }
 
 type GetResponse struct {
 	*Message
-	Cost int64 `json:"cost"`
+	Cost        int64   `json:"cost"`
+	PartDetails []*Part `json:"part_details,omitempty"`
+}
+
+// Event is one entry in a message's append-only history
+type Event struct {
+	ID         int64           `json:"id"`
+	MessageID  uuid.UUID       `json:"message_id"`
+	Event      string          `json:"event"`
+	FromStatus *Status         `json:"from_status,omitempty"`
+	Status     Status          `json:"status"`
+	Actor      string          `json:"actor"`
+	Provider   *string         `json:"provider,omitempty"`
+	Detail     *string         `json:"detail,omitempty"`
+	Payload    json.RawMessage `json:"payload,omitempty" swaggertype:"object"` // Raw provider payload, e.g. a delivery report
+	// Reference is the client's reference of the message, so delivery updates
+	// can be matched without the message ID. Filled in by ListEvents.
+	Reference *string   `json:"reference,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Event names recorded in the message history
+const (
+	EventCreated      = "created"           // Accepted by the API
+	EventClaimed      = "claimed"           // Leased to a worker for sending
+	EventSent         = "sent"              // Accepted by the provider
+	EventFailed       = "failed"            // Send attempt failed, retried or not
+	EventReleased     = "released"          // Handed back unsent, e.g. on shutdown
+	EventLeaseExpired = "lease_expired"     // Worker lost its claim
+	EventRetryDue     = "retry_due"         // Backoff elapsed, queued again
+	EventRequeued     = "requeued"          // Sent back to the queue by an operator
+	EventDelivery     = "delivery_report"   // Provider delivery report
+	EventPartReport   = "part_report"       // Delivery report for one part of a multipart message
+	EventProgress     = "delivery_progress" // Intermediate delivery report, e.g. ENROUTE
+)
+
+// DeliveryReport is a DLR as received, kept once per provider ID and status.
+// It stays pending (AppliedAt nil) until a message with its provider ID exists.
+type DeliveryReport struct {
+	ID                int64
+	Provider          string
+	ProviderMessageID string
+	Status            Status // Normalized; SENT for intermediate states
+	RawStatus         string // As the provider sent it
+	ErrorCode         string // As the provider sent it
+	ErrorClass        string // Normalized
+	Reason            *string
+	Payload           json.RawMessage
+	MessageID         *uuid.UUID
+	Outcome           string // What applying it did; empty while pending
+	ReceivedAt        time.Time
+	AppliedAt         *time.Time
+}
+
+// Outcomes of applying a delivery report
+const (
+	ReportApplied  = "applied"
+	ReportProgress = "progress" // Intermediate state, recorded in the history only
+	ReportRejected = "rejected" // The state machine refused the transition
+)
+
+// MessageFilter narrows a client's message listing. Zero values match
+// everything. Results are ordered by (created_at, id).
+type MessageFilter struct {
+	ClientID  uuid.UUID
+	Statuses  []Status
+	To        string // Recipient
+	From      string // Sender
+	Reference string
+	Express   *bool
+	Since     *time.Time // Created at or after
+	Until     *time.Time // Created before
+	Ascending bool       // Oldest first; newest first otherwise
+	After     *Cursor    // Continue past this position
+	Limit     int
+}
+
+// Cursor is a position in a message listing. Clients get it as an opaque
+// string.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// CursorOf is the position of msg
+func CursorOf(msg *Message) Cursor {
+	return Cursor{CreatedAt: msg.CreatedAt, ID: msg.ID}
+}
+
+func (c Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()))
+}
+
+// ParseCursor reads a cursor made by Cursor.String
+func ParseCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	at, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return Cursor{}, ErrInvalidCursor
+	}
+	var c Cursor
+	if c.CreatedAt, err = time.Parse(time.RFC3339Nano, at); err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	if c.ID, err = uuid.Parse(id); err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrDuplicateReference is returned by Create when the client has unique
+// references on and already has a message with the reference
+var ErrDuplicateReference = errors.New("duplicate client reference")
+
+// ListResponse is a page of messages. NextCursor is set when there may be more.
+type ListResponse struct {
+	Messages   []*Message `json:"messages"`
+	NextCursor *string    `json:"next_cursor"`
+}
+
+// FailedFilter narrows a dead-letter listing. Zero values match everything.
+type FailedFilter struct {
+	Error    string // Case-insensitive substring of last_error
+	Provider string
+	ClientID *uuid.UUID
+	From     *time.Time // Failed at or after
+	To       *time.Time // Failed before
+	Limit    int
 }
 
 func CalculateParts(text string) int {
@@ -93,3 +293,13 @@ func isGSMExtendedChar(r rune) bool {
 	}
 	return false
 }
+
+// Depth counts the messages of one status and priority
+type Depth struct {
+	Status   Status
+	Priority Priority
+	Count    int64
+}
+
+// pendingStatuses are the statuses a message waits in before a provider takes it
+var pendingStatuses = []Status{StatusQueued, StatusSending, StatusFailedTemp}
@@ -0,0 +1,70 @@
+package messages
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Part is one submitted segment of a message. Every part has its own
+// provider ID and delivery status; the message's status is derived from them.
+type Part struct {
+	MessageID         uuid.UUID `json:"-"`
+	Number            int       `json:"number"` // 1-based segment number
+	ProviderMessageID string    `json:"provider_message_id"`
+	Status            Status    `json:"status"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// ErrPartNotFound means no part carries the provider ID
+var ErrPartNotFound = errors.New("message part not found")
+
+// Aggregate derives a message's status from its parts. ok is false while
+// outstanding parts leave the outcome open:
+//   - every part delivered: DELIVERED
+//   - every part failed permanently: FAILED_PERM
+//   - only delivered and permanently failed parts: PARTIALLY_DELIVERED, with
+//     the failed parts refunded
+//   - every part failed and some only temporarily: FAILED_TEMP, so the whole
+//     message is sent again once the retry policy's delay has passed, or
+//     fails for good when its attempts are used up (see delivery.Service)
+func Aggregate(parts []*Part) (status Status, ok bool) {
+	var delivered, failedPerm, failedTemp int
+	for _, p := range parts {
+		switch p.Status {
+		case StatusDelivered:
+			delivered++
+		case StatusFailedPerm:
+			failedPerm++
+		case StatusFailedTemp:
+			failedTemp++
+		}
+	}
+
+	n := len(parts)
+	switch {
+	case n == 0:
+		return "", false
+	case delivered == n:
+		return StatusDelivered, true
+	case failedPerm == n:
+		return StatusFailedPerm, true
+	case delivered+failedPerm == n:
+		return StatusPartial, true
+	case delivered == 0 && failedTemp+failedPerm == n:
+		return StatusFailedTemp, true
+	}
+	return "", false
+}
+
+// FailedParts counts the parts that failed permanently
+func FailedParts(parts []*Part) int {
+	failed := 0
+	for _, p := range parts {
+		if p.Status == StatusFailedPerm {
+			failed++
+		}
+	}
+	return failed
+}
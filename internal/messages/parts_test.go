@@ -0,0 +1,35 @@
+package messages
+
+import "testing"
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		name    string
+		parts   []Status
+		status  Status
+		settled bool
+	}{
+		{"single delivered", []Status{StatusDelivered}, StatusDelivered, true},
+		{"single temp failure", []Status{StatusFailedTemp}, StatusFailedTemp, true},
+		{"all delivered", []Status{StatusDelivered, StatusDelivered, StatusDelivered}, StatusDelivered, true},
+		{"waiting on a part", []Status{StatusDelivered, StatusSent, StatusDelivered}, "", false},
+		{"all failed", []Status{StatusFailedPerm, StatusFailedPerm}, StatusFailedPerm, true},
+		{"partial", []Status{StatusDelivered, StatusFailedPerm, StatusDelivered}, StatusPartial, true},
+		{"temp failure among failures", []Status{StatusFailedPerm, StatusFailedTemp}, StatusFailedTemp, true},
+		{"temp failure after a delivery", []Status{StatusDelivered, StatusFailedTemp}, "", false},
+		{"no parts", nil, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var parts []*Part
+			for i, s := range tt.parts {
+				parts = append(parts, &Part{Number: i + 1, Status: s})
+			}
+			status, settled := Aggregate(parts)
+			if status != tt.status || settled != tt.settled {
+				t.Errorf("Expected %q (settled %v), got %q (settled %v)", tt.status, tt.settled, status, settled)
+			}
+		})
+	}
+}
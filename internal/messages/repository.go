@@ -0,0 +1,43 @@
+package messages
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Repository is the message persistence used by the API, delivery and
+// dead-letter services. Store is the Postgres implementation and
+// MemoryStore the in-memory one used by tests.
+type Repository interface {
+	Create(ctx context.Context, msg *Message) error
+	GetByID(ctx context.Context, messageID uuid.UUID) (*Message, error)
+	GetByReference(ctx context.Context, clientID uuid.UUID, reference string) (*Message, error)
+	FreeReference(ctx context.Context, messageID uuid.UUID) error
+	GetByProviderID(ctx context.Context, providerMessageID string) (*Message, error)
+	ListByClient(ctx context.Context, f MessageFilter) ([]*Message, error)
+	ListFailed(ctx context.Context, f FailedFilter) ([]*Message, error)
+	GetFailedMessagesForRetry(ctx context.Context, limit int) ([]*Message, error)
+	GetQueuedMessages(ctx context.Context, limit int) ([]*Message, error)
+	UpdateProvider(ctx context.Context, messageID uuid.UUID, provider string) error
+	IncrementAttempts(ctx context.Context, messageID uuid.UUID) error
+	Delete(ctx context.Context, messageID uuid.UUID) error
+	AppendEvent(ctx context.Context, ev *Event) error
+	Transition(ctx context.Context, ev *Event, providerID *string, lastError *string, retryIn *time.Duration) error
+	ListEvents(ctx context.Context, messageID uuid.UUID) ([]*Event, error)
+	SaveParts(ctx context.Context, messageID uuid.UUID, providerIDs []string) error
+	ListParts(ctx context.Context, messageID uuid.UUID) ([]*Part, error)
+	TransitionPart(ctx context.Context, providerMessageID string, to Status) ([]*Part, error)
+	RecordDeliveryReport(ctx context.Context, r *DeliveryReport) (bool, error)
+	MatchedDeliveryReports(ctx context.Context, limit int) ([]*DeliveryReport, error)
+	SettleDeliveryReport(ctx context.Context, id int64, messageID uuid.UUID, outcome string) error
+	ExpireDeliveryReports(ctx context.Context, before time.Time) (int64, error)
+	QueueDepth(ctx context.Context) ([]Depth, error)
+	Health(ctx context.Context) error
+}
+
+var (
+	_ Repository = (*Store)(nil)
+	_ Repository = (*MemoryStore)(nil)
+)
@@ -0,0 +1,184 @@
+package messages
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Effect is the credit side effect of a status transition
+type Effect string
+
+const (
+	EffectNone    Effect = ""
+	EffectCapture Effect = "capture" // The client pays for the message
+	EffectRelease Effect = "release" // The held credits go back to the client
+	EffectRefund  Effect = "refund"  // The client pays for the delivered parts only
+)
+
+// transitions lists every legal status change and its side effect. Anything
+// not listed is rejected, so a late report can never move a message out of
+// a final state.
+var transitions = map[Status]map[Status]Effect{
+	StatusQueued: {
+		StatusSending:   EffectNone,    // Claimed by a worker
+		StatusSent:      EffectCapture, // Sent inline on the OTP path
+		StatusCancelled: EffectRelease,
+	},
+	StatusSending: {
+		StatusSent:       EffectCapture,
+		StatusFailedTemp: EffectNone,
+		StatusFailedPerm: EffectRelease,
+		StatusQueued:     EffectNone, // Released on shutdown or reaped after its lease expired
+	},
+	StatusSent: {
+		StatusDelivered:  EffectCapture,
+		StatusPartial:    EffectRefund,
+		StatusFailedTemp: EffectNone,
+		StatusFailedPerm: EffectRelease,
+	},
+	StatusFailedTemp: {
+		StatusQueued:     EffectNone, // Retry is due
+		StatusDelivered:  EffectCapture,
+		StatusPartial:    EffectRefund,
+		StatusFailedPerm: EffectRelease,
+	},
+	StatusFailedPerm: {
+		StatusQueued: EffectNone, // Replayed from the dead-letter queue with fresh credits
+	},
+	// DELIVERED, PARTIALLY_DELIVERED and CANCELLED are final
+}
+
+// CanTransition reports whether a message may move from one status to another
+func CanTransition(from, to Status) bool {
+	_, ok := transitions[from][to]
+	return ok
+}
+
+// EffectOf returns the side effect of a legal transition
+func EffectOf(from, to Status) Effect {
+	return transitions[from][to]
+}
+
+// Final reports whether no transition leaves s
+func (s Status) Final() bool {
+	return len(transitions[s]) == 0
+}
+
+// Sources returns the statuses a message may move to `to` from
+func Sources(to Status) []Status {
+	var from []Status
+	for _, s := range []Status{StatusQueued, StatusSending, StatusSent, StatusDelivered, StatusPartial, StatusFailedTemp, StatusFailedPerm, StatusCancelled} {
+		if CanTransition(s, to) {
+			from = append(from, s)
+		}
+	}
+	return from
+}
+
+// ErrIllegalTransition is matched by every IllegalTransitionError
+var ErrIllegalTransition = errors.New("illegal status transition")
+
+// IllegalTransitionError is returned when a compare-and-set transition finds
+// the message in a status it may not leave for the requested one
+type IllegalTransitionError struct {
+	MessageID uuid.UUID
+	From      Status
+	To        Status
+}
+
+func (e *IllegalTransitionError) Error() string {
+	return fmt.Sprintf("illegal status transition %s -> %s for message %s", e.From, e.To, e.MessageID)
+}
+
+func (e *IllegalTransitionError) Is(target error) bool {
+	return target == ErrIllegalTransition
+}
+
+// Settler applies the credit side effects of transitions; billing.Ledger satisfies it
+type Settler interface {
+	CaptureCredits(ctx context.Context, messageID uuid.UUID) error
+	ReleaseCredits(ctx context.Context, messageID uuid.UUID) error
+	RefundCredits(ctx context.Context, messageID uuid.UUID, parts, ofParts int) error
+}
+
+// Settle applies the side effect of a transition that has been made. A
+// release after the credits were captured (when the provider accepted the
+// message) refunds them instead. Refunds cover failedParts of parts
+// segments and are only due for transitions to PARTIALLY_DELIVERED.
+func Settle(ctx context.Context, ledger Settler, messageID uuid.UUID, from, to Status, failedParts, parts int) error {
+	switch EffectOf(from, to) {
+	case EffectCapture:
+		return ledger.CaptureCredits(ctx, messageID)
+	case EffectRelease:
+		err := ledger.ReleaseCredits(ctx, messageID)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ledger.RefundCredits(ctx, messageID, 1, 1)
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	case EffectRefund:
+		return ledger.RefundCredits(ctx, messageID, failedParts, parts)
+	}
+	return nil
+}
+
+// Machine moves messages between statuses with compare-and-set updates and
+// applies each transition's side effect once the update has been made
+type Machine struct {
+	logger *slog.Logger
+	store  Repository
+	ledger Settler
+}
+
+func NewMachine(logger *slog.Logger, store Repository, ledger Settler) *Machine {
+	return &Machine{logger: logger, store: store, ledger: ledger}
+}
+
+// Apply moves ev.MessageID to ev.Status and records ev. When ev.FromStatus is
+// set the message must be in exactly that status, otherwise in any status the
+// transition is legal from. Illegal transitions are logged and returned as an
+// *IllegalTransitionError without changing the message. retryIn schedules
+// another attempt of a message moved to FAILED_TEMP, see Repository.Transition.
+func (m *Machine) Apply(ctx context.Context, ev *Event, providerID *string, lastError *string, retryIn *time.Duration) error {
+	if err := m.store.Transition(ctx, ev, providerID, lastError, retryIn); err != nil {
+		var illegal *IllegalTransitionError
+		if errors.As(err, &illegal) {
+			m.logger.WarnContext(ctx, "Rejected illegal status transition",
+				"message", ev.MessageID, "from", illegal.From, "to", illegal.To,
+				"event", ev.Event, "actor", ev.Actor)
+		}
+		return err
+	}
+
+	var failed, parts int
+	if EffectOf(*ev.FromStatus, ev.Status) == EffectRefund {
+		all, err := m.store.ListParts(ctx, ev.MessageID)
+		if err != nil {
+			m.logger.ErrorContext(ctx, "Failed to load parts for refund", "error", err, "message", ev.MessageID)
+		}
+		failed, parts = FailedParts(all), len(all)
+	}
+	if err := Settle(ctx, m.ledger, ev.MessageID, *ev.FromStatus, ev.Status, failed, parts); err != nil {
+		m.logger.ErrorContext(ctx, "Failed to settle credits", "error", err, "message", ev.MessageID,
+			"from", *ev.FromStatus, "to", ev.Status)
+	}
+	return nil
+}
+
+// allowedFrom returns the statuses Transition may move ev's message from
+func allowedFrom(ev *Event) []Status {
+	if ev.FromStatus != nil {
+		if !CanTransition(*ev.FromStatus, ev.Status) {
+			return nil
+		}
+		return []Status{*ev.FromStatus}
+	}
+	return Sources(ev.Status)
+}
@@ -0,0 +1,151 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to Status
+		legal    bool
+		effect   Effect
+	}{
+		{StatusQueued, StatusSending, true, EffectNone},
+		{StatusQueued, StatusSent, true, EffectCapture},
+		{StatusSending, StatusSent, true, EffectCapture},
+		{StatusSending, StatusFailedTemp, true, EffectNone},
+		{StatusSending, StatusFailedPerm, true, EffectRelease},
+		{StatusSending, StatusQueued, true, EffectNone},
+		{StatusSent, StatusDelivered, true, EffectCapture},
+		{StatusSent, StatusFailedPerm, true, EffectRelease},
+		{StatusFailedTemp, StatusQueued, true, EffectNone},
+		{StatusFailedPerm, StatusQueued, true, EffectNone},
+		{StatusDelivered, StatusFailedTemp, false, EffectNone},
+		{StatusDelivered, StatusQueued, false, EffectNone},
+		{StatusCancelled, StatusQueued, false, EffectNone},
+		{StatusQueued, StatusDelivered, false, EffectNone},
+		{StatusSent, StatusSending, false, EffectNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			if got := CanTransition(tt.from, tt.to); got != tt.legal {
+				t.Errorf("Expected legal=%v, got %v", tt.legal, got)
+			}
+			if got := EffectOf(tt.from, tt.to); got != tt.effect {
+				t.Errorf("Expected effect %q, got %q", tt.effect, got)
+			}
+		})
+	}
+
+	if !StatusDelivered.Final() || !StatusCancelled.Final() || StatusFailedPerm.Final() {
+		t.Error("Expected only DELIVERED and CANCELLED to be final")
+	}
+}
+
+type settlerSpy struct {
+	captured, released []uuid.UUID
+	refunds            [][2]int
+}
+
+func (s *settlerSpy) CaptureCredits(ctx context.Context, messageID uuid.UUID) error {
+	s.captured = append(s.captured, messageID)
+	return nil
+}
+
+func (s *settlerSpy) ReleaseCredits(ctx context.Context, messageID uuid.UUID) error {
+	s.released = append(s.released, messageID)
+	return nil
+}
+
+func (s *settlerSpy) RefundCredits(ctx context.Context, messageID uuid.UUID, parts, ofParts int) error {
+	s.refunds = append(s.refunds, [2]int{parts, ofParts})
+	return nil
+}
+
+func TestMachineApply(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	ledger := &settlerSpy{}
+	machine := NewMachine(slog.New(slog.NewTextHandler(os.Stdout, nil)), store, ledger)
+
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	msg := &Message{ID: uuid.New(), ClientID: clientID, Status: StatusSent}
+	if err := store.Create(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// SENT -> DELIVERED captures
+	if err := machine.Apply(ctx, &Event{MessageID: msg.ID, Event: EventDelivery, Status: StatusDelivered, Actor: "dlr"}, nil, nil, nil); err != nil {
+		t.Fatalf("Expected DELIVERED to apply, got %v", err)
+	}
+	if len(ledger.captured) != 1 || len(ledger.released) != 0 {
+		t.Errorf("Expected one capture, got %d captures and %d releases", len(ledger.captured), len(ledger.released))
+	}
+
+	// A late FAILED_TEMP must not overwrite DELIVERED
+	err := machine.Apply(ctx, &Event{MessageID: msg.ID, Event: EventDelivery, Status: StatusFailedTemp, Actor: "dlr"}, nil, nil, nil)
+	var illegal *IllegalTransitionError
+	if !errors.As(err, &illegal) || !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("Expected an illegal transition, got %v", err)
+	}
+	if illegal.From != StatusDelivered || illegal.To != StatusFailedTemp {
+		t.Errorf("Expected DELIVERED -> FAILED_TEMP, got %s -> %s", illegal.From, illegal.To)
+	}
+	got, _ := store.GetByID(ctx, msg.ID)
+	if got.Status != StatusDelivered {
+		t.Errorf("Expected DELIVERED to stick, got %s", got.Status)
+	}
+	if events, _ := store.ListEvents(ctx, msg.ID); len(events) != 1 {
+		t.Errorf("Expected the rejected transition to leave no event, got %d", len(events))
+	}
+	if len(ledger.captured) != 1 || len(ledger.released) != 0 {
+		t.Error("Expected the rejected transition to have no side effect")
+	}
+
+	// A pinned FromStatus is compared exactly
+	queued := StatusQueued
+	err = machine.Apply(ctx, &Event{MessageID: msg.ID, Event: EventSent, FromStatus: &queued, Status: StatusSent, Actor: "api"}, nil, nil, nil)
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("Expected QUEUED -> SENT to be refused for a DELIVERED message, got %v", err)
+	}
+}
+
+func TestMachineRefundsFailedParts(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	ledger := &settlerSpy{}
+	machine := NewMachine(slog.New(slog.NewTextHandler(os.Stdout, nil)), store, ledger)
+
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	msg := &Message{ID: uuid.New(), ClientID: clientID, Status: StatusSent, Parts: 3}
+	if err := store.Create(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	store.SaveParts(ctx, msg.ID, []string{"p1", "p2", "p3"})
+	store.TransitionPart(ctx, "p1", StatusDelivered)
+	store.TransitionPart(ctx, "p2", StatusFailedPerm)
+	parts, _ := store.TransitionPart(ctx, "p3", StatusDelivered)
+
+	status, ok := Aggregate(parts)
+	if !ok || status != StatusPartial {
+		t.Fatalf("Expected PARTIALLY_DELIVERED, got %q (settled %v)", status, ok)
+	}
+	if err := machine.Apply(ctx, &Event{MessageID: msg.ID, Event: EventDelivery, Status: status, Actor: "dlr"}, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(ledger.refunds) != 1 || ledger.refunds[0] != [2]int{1, 3} {
+		t.Errorf("Expected a refund of 1 of 3 parts, got %v", ledger.refunds)
+	}
+	if !StatusPartial.Final() {
+		t.Error("Expected PARTIALLY_DELIVERED to be final")
+	}
+}
@@ -3,12 +3,17 @@ package messages
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"sms-gateway/internal/db"
+	"sms-gateway/internal/tracing"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type Store struct {
@@ -26,26 +31,63 @@ func (s *Store) DB() *sql.DB {
 }
 
 func (s *Store) Create(ctx context.Context, msg *Message) error {
-	query := `INSERT INTO messages (id, client_id, to_msisdn, from_sender, text, parts, status, client_reference, express, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
-
-	_, err := s.db.ExecContext(ctx, query, msg.ID, msg.ClientID, msg.To, msg.From, msg.Text, msg.Parts, msg.Status, msg.Reference, msg.Express, msg.CreatedAt, msg.UpdatedAt)
+	// A referenced message claims its reference when the client has unique
+	// references on; idx_messages_client_reference_unique does the rest
+	query := `INSERT INTO messages (id, client_id, to_msisdn, from_sender, text, parts, status, client_reference, express, priority, created_at, updated_at, trace_parent, unique_reference)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13,
+			$8::text IS NOT NULL AND COALESCE((SELECT unique_references FROM clients WHERE id = $2), false))`
+
+	_, err := s.db.ExecContext(ctx, query, msg.ID, msg.ClientID, msg.To, msg.From, msg.Text, msg.Parts, msg.Status, msg.Reference, msg.Express, msg.Priority, msg.CreatedAt, msg.UpdatedAt, msg.TraceParent)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "idx_messages_client_reference_unique" {
+		return ErrDuplicateReference
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create message: %w", err)
 	}
 
-	s.logger.Info("message created", "id", msg.ID, "to", msg.To)
+	s.logger.InfoContext(ctx, "message created", "id", msg.ID, "to", msg.To)
+	return nil
+}
+
+// GetByReference returns the message holding reference for a client with
+// unique references
+func (s *Store) GetByReference(ctx context.Context, clientID uuid.UUID, reference string) (*Message, error) {
+	query := `SELECT id, client_id, to_msisdn, from_sender, text, parts, status, client_reference, provider, provider_message_id, attempts, last_error, express, priority, created_at, updated_at, trace_parent
+		FROM messages WHERE client_id = $1 AND client_reference = $2 AND unique_reference`
+
+	var msg Message
+	err := s.db.QueryRowContext(ctx, query, clientID, reference).Scan(
+		&msg.ID, &msg.ClientID, &msg.To, &msg.From, &msg.Text, &msg.Parts, &msg.Status, &msg.Reference,
+		&msg.Provider, &msg.ProviderMessageID, &msg.Attempts, &msg.LastError, &msg.Express, &msg.Priority, &msg.CreatedAt, &msg.UpdatedAt, &msg.TraceParent)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("message not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+	return &msg, nil
+}
+
+// FreeReference lets another message of the client use the reference a
+// message holds, e.g. when it was given up before it was sent
+func (s *Store) FreeReference(ctx context.Context, messageID uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE messages SET unique_reference = false WHERE id = $1", messageID)
+	if err != nil {
+		return fmt.Errorf("failed to free message reference: %w", err)
+	}
 	return nil
 }
 
 func (s *Store) GetByID(ctx context.Context, messageID uuid.UUID) (*Message, error) {
-	query := `SELECT id, client_id, to_msisdn, from_sender, text, parts, status, client_reference, provider, provider_message_id, attempts, last_error, express, created_at, updated_at
+	query := `SELECT id, client_id, to_msisdn, from_sender, text, parts, status, client_reference, provider, provider_message_id, attempts, last_error, express, priority, created_at, updated_at, trace_parent
 		FROM messages WHERE id = $1`
 
 	var msg Message
 	err := s.db.QueryRowContext(ctx, query, messageID).Scan(
 		&msg.ID, &msg.ClientID, &msg.To, &msg.From, &msg.Text, &msg.Parts, &msg.Status, &msg.Reference,
-		&msg.Provider, &msg.ProviderMessageID, &msg.Attempts, &msg.LastError, &msg.Express, &msg.CreatedAt, &msg.UpdatedAt)
+		&msg.Provider, &msg.ProviderMessageID, &msg.Attempts, &msg.LastError, &msg.Express, &msg.Priority, &msg.CreatedAt, &msg.UpdatedAt, &msg.TraceParent)
 
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("message not found")
@@ -57,11 +99,52 @@ func (s *Store) GetByID(ctx context.Context, messageID uuid.UUID) (*Message, err
 	return &msg, nil
 }
 
-func (s *Store) ListByClient(ctx context.Context, clientID uuid.UUID, limit, offset int) ([]*Message, error) {
-	query := `SELECT id, client_id, to_msisdn, from_sender, text, parts, status, client_reference, provider, provider_message_id, attempts, last_error, express, created_at, updated_at
-		FROM messages WHERE client_id = $1 ORDER BY created_at DESC LIMIT $2 OFFSET $3`
+// ListByClient pages through a client's messages by (created_at, id).
+// Only the filters that are set become conditions, so each combination gets
+// a plan that can use the per-client indexes.
+func (s *Store) ListByClient(ctx context.Context, f MessageFilter) ([]*Message, error) {
+	where := []string{"client_id = $1"}
+	args := []any{f.ClientID}
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, strings.ReplaceAll(cond, "?", fmt.Sprintf("$%d", len(args))))
+	}
+	if len(f.Statuses) > 0 {
+		add("status = ANY(?)", pq.Array(statusStrings(f.Statuses)))
+	}
+	if f.To != "" {
+		add("to_msisdn = ?", f.To)
+	}
+	if f.From != "" {
+		add("from_sender = ?", f.From)
+	}
+	if f.Reference != "" {
+		add("client_reference = ?", f.Reference)
+	}
+	if f.Express != nil {
+		add("express = ?", *f.Express)
+	}
+	if f.Since != nil {
+		add("created_at >= ?", *f.Since)
+	}
+	if f.Until != nil {
+		add("created_at < ?", *f.Until)
+	}
+	order, op := "DESC", "<"
+	if f.Ascending {
+		order, op = "ASC", ">"
+	}
+	if f.After != nil {
+		args = append(args, f.After.CreatedAt, f.After.ID)
+		where = append(where, fmt.Sprintf("(created_at, id) %s ($%d, $%d)", op, len(args)-1, len(args)))
+	}
+	args = append(args, f.Limit)
 
-	rows, err := s.db.QueryContext(ctx, query, clientID, limit, offset)
+	query := `SELECT id, client_id, to_msisdn, from_sender, text, parts, status, client_reference, provider, provider_message_id, attempts, last_error, express, priority, created_at, updated_at
+		FROM messages WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY created_at ` + order + `, id ` + order + fmt.Sprintf(" LIMIT $%d", len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list messages: %w", err)
 	}
@@ -71,14 +154,14 @@ func (s *Store) ListByClient(ctx context.Context, clientID uuid.UUID, limit, off
 	for rows.Next() {
 		var msg Message
 		err := rows.Scan(&msg.ID, &msg.ClientID, &msg.To, &msg.From, &msg.Text, &msg.Parts, &msg.Status, &msg.Reference,
-			&msg.Provider, &msg.ProviderMessageID, &msg.Attempts, &msg.LastError, &msg.Express, &msg.CreatedAt, &msg.UpdatedAt)
+			&msg.Provider, &msg.ProviderMessageID, &msg.Attempts, &msg.LastError, &msg.Express, &msg.Priority, &msg.CreatedAt, &msg.UpdatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan message: %w", err)
 		}
 		messages = append(messages, &msg)
 	}
 
-	return messages, nil
+	return messages, rows.Err()
 }
 
 func (s *Store) Delete(ctx context.Context, messageID uuid.UUID) error {
@@ -86,18 +169,32 @@ func (s *Store) Delete(ctx context.Context, messageID uuid.UUID) error {
 	if err != nil {
 		return fmt.Errorf("failed to delete message: %w", err)
 	}
-	s.logger.Info("message deleted", "id", messageID)
+	s.logger.InfoContext(ctx, "message deleted", "id", messageID)
 	return nil
 }
 
-func (s *Store) Health(ctx context.Context) error {
-	return s.db.PingContext(ctx)
+// QueueDepth counts messages waiting for a send or a retry by status and priority
+func (s *Store) QueueDepth(ctx context.Context) ([]Depth, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT status, priority, count(*) FROM messages
+		WHERE status = ANY($1) GROUP BY status, priority`, pq.Array(statusStrings(pendingStatuses)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to count queued messages: %w", err)
+	}
+	defer rows.Close()
+
+	var depths []Depth
+	for rows.Next() {
+		var d Depth
+		if err := rows.Scan(&d.Status, &d.Priority, &d.Count); err != nil {
+			return nil, err
+		}
+		depths = append(depths, d)
+	}
+	return depths, rows.Err()
 }
 
-func (s *Store) UpdateStatus(ctx context.Context, messageID uuid.UUID, status Status, providerID *string, lastError *string) error {
-	query := `UPDATE messages SET status = $2, provider_message_id = COALESCE($3, provider_message_id), last_error = $4, updated_at = $5 WHERE id = $1`
-	_, err := s.db.ExecContext(ctx, query, messageID, status, providerID, lastError, time.Now())
-	return err
+func (s *Store) Health(ctx context.Context) error {
+	return s.db.PingContext(ctx)
 }
 
 func (s *Store) IncrementAttempts(ctx context.Context, messageID uuid.UUID) error {
@@ -105,14 +202,18 @@ func (s *Store) IncrementAttempts(ctx context.Context, messageID uuid.UUID) erro
 	return err
 }
 
+// GetByProviderID finds the message carrying a provider ID itself or on one of its parts
 func (s *Store) GetByProviderID(ctx context.Context, providerMessageID string) (*Message, error) {
-	query := `SELECT id, client_id, to_msisdn, from_sender, text, parts, status, client_reference, provider, provider_message_id, attempts, last_error, express, created_at, updated_at
-		FROM messages WHERE provider_message_id = $1`
+	query := `SELECT id, client_id, to_msisdn, from_sender, text, parts, status, client_reference, provider, provider_message_id, attempts, last_error, express, priority, created_at, updated_at, trace_parent
+		FROM messages
+		WHERE id = COALESCE(
+			(SELECT message_id FROM message_parts WHERE provider_message_id = $1 LIMIT 1),
+			(SELECT id FROM messages WHERE provider_message_id = $1 LIMIT 1))`
 
 	var msg Message
 	err := s.db.QueryRowContext(ctx, query, providerMessageID).Scan(
 		&msg.ID, &msg.ClientID, &msg.To, &msg.From, &msg.Text, &msg.Parts, &msg.Status, &msg.Reference,
-		&msg.Provider, &msg.ProviderMessageID, &msg.Attempts, &msg.LastError, &msg.Express, &msg.CreatedAt, &msg.UpdatedAt)
+		&msg.Provider, &msg.ProviderMessageID, &msg.Attempts, &msg.LastError, &msg.Express, &msg.Priority, &msg.CreatedAt, &msg.UpdatedAt, &msg.TraceParent)
 
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("message not found with provider_message_id: %s", providerMessageID)
@@ -132,7 +233,7 @@ func (s *Store) UpdateProvider(ctx context.Context, messageID uuid.UUID, provide
 // GetFailedMessagesForRetry retrieves messages that are temporarily failed and ready for retry
 func (s *Store) GetFailedMessagesForRetry(ctx context.Context, limit int) ([]*Message, error) {
 	// Get messages with FAILED_TEMP status, ordered by updated_at for fair retry processing
-	query := `SELECT id, client_id, to_msisdn, from_sender, text, parts, status, client_reference, provider, provider_message_id, attempts, last_error, express, created_at, updated_at
+	query := `SELECT id, client_id, to_msisdn, from_sender, text, parts, status, client_reference, provider, provider_message_id, attempts, last_error, express, priority, created_at, updated_at
 		FROM messages 
 		WHERE status = $1 
 		ORDER BY updated_at ASC 
@@ -148,7 +249,7 @@ func (s *Store) GetFailedMessagesForRetry(ctx context.Context, limit int) ([]*Me
 	for rows.Next() {
 		var msg Message
 		err := rows.Scan(&msg.ID, &msg.ClientID, &msg.To, &msg.From, &msg.Text, &msg.Parts, &msg.Status, &msg.Reference,
-			&msg.Provider, &msg.ProviderMessageID, &msg.Attempts, &msg.LastError, &msg.Express, &msg.CreatedAt, &msg.UpdatedAt)
+			&msg.Provider, &msg.ProviderMessageID, &msg.Attempts, &msg.LastError, &msg.Express, &msg.Priority, &msg.CreatedAt, &msg.UpdatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan message for retry: %w", err)
 		}
@@ -162,15 +263,15 @@ func (s *Store) GetFailedMessagesForRetry(ctx context.Context, limit int) ([]*Me
 	return messages, nil
 }
 
-// GetQueuedMessages retrieves messages that are in QUEUED status for republishing to NATS
+// GetQueuedMessages retrieves messages that are in QUEUED status, oldest first
 func (s *Store) GetQueuedMessages(ctx context.Context, limit int) ([]*Message, error) {
 	query := `SELECT id, client_id, to_msisdn, from_sender, text, parts, status, client_reference, 
-			  provider, provider_message_id, attempts, last_error, express, created_at, updated_at
+			  provider, provider_message_id, attempts, last_error, express, priority, created_at, updated_at
 			  FROM messages 
 			  WHERE status = $1 
 			  ORDER BY created_at ASC 
 			  LIMIT $2`
-			  
+
 	rows, err := s.db.QueryContext(ctx, query, StatusQueued, limit)
 	if err != nil {
 		return nil, err
@@ -183,7 +284,7 @@ func (s *Store) GetQueuedMessages(ctx context.Context, limit int) ([]*Message, e
 		err := rows.Scan(
 			&msg.ID, &msg.ClientID, &msg.To, &msg.From, &msg.Text, &msg.Parts, &msg.Status,
 			&msg.Reference, &msg.Provider, &msg.ProviderMessageID, &msg.Attempts, &msg.LastError,
-			&msg.Express, &msg.CreatedAt, &msg.UpdatedAt,
+			&msg.Express, &msg.Priority, &msg.CreatedAt, &msg.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -193,3 +294,321 @@ func (s *Store) GetQueuedMessages(ctx context.Context, limit int) ([]*Message, e
 
 	return messages, nil
 }
+
+// ListFailed returns permanently failed messages, most recently failed first
+func (s *Store) ListFailed(ctx context.Context, f FailedFilter) ([]*Message, error) {
+	query := `SELECT id, client_id, to_msisdn, from_sender, text, parts, status, client_reference, provider, provider_message_id, attempts, last_error, express, priority, created_at, updated_at
+		FROM messages
+		WHERE status = 'FAILED_PERM'
+		  AND ($1 = '' OR last_error ILIKE '%' || $1 || '%')
+		  AND ($2 = '' OR provider = $2)
+		  AND ($3::uuid IS NULL OR client_id = $3)
+		  AND ($4::timestamptz IS NULL OR updated_at >= $4)
+		  AND ($5::timestamptz IS NULL OR updated_at < $5)
+		ORDER BY updated_at DESC
+		LIMIT $6`
+
+	rows, err := s.db.QueryContext(ctx, query, f.Error, f.Provider, f.ClientID, f.From, f.To, f.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list failed messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		var msg Message
+		err := rows.Scan(&msg.ID, &msg.ClientID, &msg.To, &msg.From, &msg.Text, &msg.Parts, &msg.Status, &msg.Reference,
+			&msg.Provider, &msg.ProviderMessageID, &msg.Attempts, &msg.LastError, &msg.Express, &msg.Priority, &msg.CreatedAt, &msg.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan failed message: %w", err)
+		}
+		messages = append(messages, &msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// AppendEvent records an entry in the message history
+func (s *Store) AppendEvent(ctx context.Context, ev *Event) error {
+	err := s.db.QueryRowContext(ctx, `INSERT INTO message_events (message_id, event, from_status, status, actor, provider, detail, payload)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at`,
+		ev.MessageID, ev.Event, ev.FromStatus, ev.Status, ev.Actor, ev.Provider, ev.Detail, jsonParam(ev.Payload)).Scan(&ev.ID, &ev.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to append message event: %w", err)
+	}
+	return nil
+}
+
+// Transition moves a message to ev.Status and records ev in the same
+// statement, filling in ev.FromStatus with the status it replaced. The update
+// is a compare-and-set against the statuses the state machine allows, so a
+// message that has moved on returns an *IllegalTransitionError unchanged.
+// A replay out of FAILED_PERM starts a fresh attempt budget. A non-nil
+// retryIn counts a failed attempt and makes the message due for Retry after
+// that long.
+func (s *Store) Transition(ctx context.Context, ev *Event, providerID *string, lastError *string, retryIn *time.Duration) error {
+	var retryMs *int64
+	if retryIn != nil {
+		ms := retryIn.Milliseconds()
+		retryMs = &ms
+	}
+	from := allowedFrom(ev)
+	err := s.db.QueryRowContext(ctx, `
+		WITH prev AS (
+			SELECT id, status FROM messages WHERE id = $1 FOR UPDATE
+		),
+		moved AS (
+			UPDATE messages m
+			SET status = $2, provider_message_id = COALESCE($3, m.provider_message_id),
+				provider = COALESCE($4, m.provider), last_error = $5, updated_at = NOW(),
+				attempts = CASE WHEN prev.status = 'FAILED_PERM' THEN 0
+					WHEN $11::bigint IS NOT NULL THEN m.attempts + 1 ELSE m.attempts END,
+				retry_after = CASE WHEN prev.status = 'FAILED_PERM' THEN NULL
+					WHEN $11::bigint IS NOT NULL THEN NOW() + $11::bigint * INTERVAL '1 millisecond' ELSE m.retry_after END
+			FROM prev WHERE m.id = prev.id AND prev.status = ANY($10)
+			RETURNING m.id, prev.status AS from_status
+		)
+		INSERT INTO message_events (message_id, event, from_status, status, actor, provider, detail, payload)
+		SELECT id, $6, from_status, $2, $7, $4, $8, $9 FROM moved
+		RETURNING id, from_status, created_at`,
+		ev.MessageID, ev.Status, providerID, ev.Provider, lastError, ev.Event, ev.Actor, ev.Detail, jsonParam(ev.Payload),
+		pq.Array(statusStrings(from)), retryMs,
+	).Scan(&ev.ID, &ev.FromStatus, &ev.CreatedAt)
+	if err == sql.ErrNoRows {
+		// Either the message is gone or it is in a status we may not move it from
+		var current Status
+		if err := s.db.QueryRowContext(ctx, `SELECT status FROM messages WHERE id = $1`, ev.MessageID).Scan(&current); err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("message not found")
+			}
+			return fmt.Errorf("failed to transition message: %w", err)
+		}
+		return &IllegalTransitionError{MessageID: ev.MessageID, From: current, To: ev.Status}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to transition message: %w", err)
+	}
+	return nil
+}
+
+// nullString stores empty strings as NULL
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func statusStrings(statuses []Status) []string {
+	out := make([]string, len(statuses))
+	for i, s := range statuses {
+		out[i] = string(s)
+	}
+	return out
+}
+
+// ListEvents returns the history of a message, oldest first
+func (s *Store) ListEvents(ctx context.Context, messageID uuid.UUID) ([]*Event, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT e.id, e.message_id, e.event, e.from_status, e.status, e.actor, e.provider, e.detail,
+			e.payload, m.client_reference, e.created_at
+		FROM message_events e JOIN messages m ON m.id = e.message_id
+		WHERE e.message_id = $1 ORDER BY e.id`, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list message events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*Event
+	for rows.Next() {
+		var ev Event
+		var payload []byte
+		if err := rows.Scan(&ev.ID, &ev.MessageID, &ev.Event, &ev.FromStatus, &ev.Status, &ev.Actor,
+			&ev.Provider, &ev.Detail, &payload, &ev.Reference, &ev.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message event: %w", err)
+		}
+		ev.Payload = payload
+		events = append(events, &ev)
+	}
+	return events, rows.Err()
+}
+
+// SaveParts records the provider ID of every submitted segment, in order,
+// as SENT. A resend replaces the parts of the previous attempt.
+func (s *Store) SaveParts(ctx context.Context, messageID uuid.UUID, providerIDs []string) error {
+	_, err := s.db.ExecContext(ctx, `
+		WITH cleared AS (
+			DELETE FROM message_parts WHERE message_id = $1 AND part_no > cardinality($2::text[])
+		)
+		INSERT INTO message_parts (message_id, part_no, provider_message_id, status)
+		SELECT $1, ord, pid, 'SENT' FROM unnest($2::text[]) WITH ORDINALITY AS p(pid, ord)
+		ON CONFLICT (message_id, part_no) DO UPDATE
+		SET provider_message_id = EXCLUDED.provider_message_id, status = 'SENT', updated_at = NOW()`,
+		messageID, pq.Array(providerIDs))
+	if err != nil {
+		return fmt.Errorf("failed to save message parts: %w", err)
+	}
+	return nil
+}
+
+// ListParts returns a message's parts in segment order
+func (s *Store) ListParts(ctx context.Context, messageID uuid.UUID) ([]*Part, error) {
+	return s.listParts(ctx, s.db.DB, messageID)
+}
+
+// TransitionPart moves the part with the given provider ID to status and
+// returns every part of its message as they stand afterwards. The message row
+// is locked while this happens, so of several reports racing for the same
+// message exactly one sees the final set of parts. It returns
+// ErrPartNotFound when no part has the provider ID and an
+// *IllegalTransitionError when the part may not move to status or its message
+// is no longer SENT, i.e. the report is for an attempt that has been settled.
+func (s *Store) TransitionPart(ctx context.Context, providerMessageID string, to Status) ([]*Part, error) {
+	ctx, span := tracing.Start(ctx, "messages.TransitionPart")
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to transition message part: %w", err)
+	}
+	defer tx.Rollback()
+
+	var messageID uuid.UUID
+	var number int
+	var from, current Status
+	err = tx.QueryRowContext(ctx, `SELECT p.message_id, p.part_no, p.status, m.status
+		FROM message_parts p JOIN messages m ON m.id = p.message_id
+		WHERE p.provider_message_id = $1
+		FOR UPDATE OF m, p`, providerMessageID).Scan(&messageID, &number, &from, &current)
+	if err == sql.ErrNoRows {
+		return nil, ErrPartNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to transition message part: %w", err)
+	}
+	if current != StatusSent {
+		return nil, &IllegalTransitionError{MessageID: messageID, From: current, To: to}
+	}
+	if !CanTransition(from, to) {
+		return nil, &IllegalTransitionError{MessageID: messageID, From: from, To: to}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE message_parts SET status = $3, updated_at = NOW()
+		WHERE message_id = $1 AND part_no = $2`, messageID, number, to); err != nil {
+		return nil, fmt.Errorf("failed to transition message part: %w", err)
+	}
+	parts, err := s.listParts(ctx, tx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to transition message part: %w", err)
+	}
+	return parts, nil
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (s *Store) listParts(ctx context.Context, q queryer, messageID uuid.UUID) ([]*Part, error) {
+	rows, err := q.QueryContext(ctx, `SELECT message_id, part_no, provider_message_id, status, updated_at
+		FROM message_parts WHERE message_id = $1 ORDER BY part_no`, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list message parts: %w", err)
+	}
+	defer rows.Close()
+
+	var parts []*Part
+	for rows.Next() {
+		var p Part
+		if err := rows.Scan(&p.MessageID, &p.Number, &p.ProviderMessageID, &p.Status, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message part: %w", err)
+		}
+		parts = append(parts, &p)
+	}
+	return parts, rows.Err()
+}
+
+// RecordDeliveryReport stores a DLR as pending. It returns false, storing
+// nothing, when the same provider ID and status have been reported before.
+func (s *Store) RecordDeliveryReport(ctx context.Context, r *DeliveryReport) (bool, error) {
+	err := s.db.QueryRowContext(ctx, `INSERT INTO delivery_reports
+			(provider_message_id, status, reason, payload, provider, raw_status, error_code, error_class)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (provider_message_id, status) DO NOTHING
+		RETURNING id, received_at`,
+		r.ProviderMessageID, r.Status, r.Reason, jsonParam(r.Payload),
+		nullString(r.Provider), nullString(r.RawStatus), nullString(r.ErrorCode), nullString(r.ErrorClass),
+	).Scan(&r.ID, &r.ReceivedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to record delivery report: %w", err)
+	}
+	return true, nil
+}
+
+// MatchedDeliveryReports returns pending reports whose provider ID now
+// belongs to a message or one of its parts, oldest first, with MessageID filled in
+func (s *Store) MatchedDeliveryReports(ctx context.Context, limit int) ([]*DeliveryReport, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT r.id, r.provider_message_id, r.status, r.reason, r.payload,
+			COALESCE(p.message_id, m.id), r.received_at,
+			COALESCE(r.provider, ''), COALESCE(r.raw_status, ''), COALESCE(r.error_code, ''), COALESCE(r.error_class, '')
+		FROM delivery_reports r
+		LEFT JOIN message_parts p ON p.provider_message_id = r.provider_message_id
+		LEFT JOIN messages m ON m.provider_message_id = r.provider_message_id
+		WHERE r.applied_at IS NULL AND (p.message_id IS NOT NULL OR m.id IS NOT NULL)
+		ORDER BY r.received_at, r.id
+		LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending delivery reports: %w", err)
+	}
+	defer rows.Close()
+
+	var reports []*DeliveryReport
+	for rows.Next() {
+		var r DeliveryReport
+		var payload []byte
+		if err := rows.Scan(&r.ID, &r.ProviderMessageID, &r.Status, &r.Reason, &payload, &r.MessageID, &r.ReceivedAt,
+			&r.Provider, &r.RawStatus, &r.ErrorCode, &r.ErrorClass); err != nil {
+			return nil, fmt.Errorf("failed to scan delivery report: %w", err)
+		}
+		r.Payload = payload
+		reports = append(reports, &r)
+	}
+	return reports, rows.Err()
+}
+
+// SettleDeliveryReport marks a pending report as applied to messageID with the
+// given outcome. The first outcome recorded for a report sticks.
+func (s *Store) SettleDeliveryReport(ctx context.Context, id int64, messageID uuid.UUID, outcome string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE delivery_reports SET message_id = $2, outcome = $3, applied_at = NOW()
+		WHERE id = $1 AND applied_at IS NULL`, id, messageID, outcome)
+	if err != nil {
+		return fmt.Errorf("failed to settle delivery report: %w", err)
+	}
+	return nil
+}
+
+// ExpireDeliveryReports drops pending reports received before the cutoff
+// that never matched a message. Matched reports still waiting to be applied
+// are kept.
+func (s *Store) ExpireDeliveryReports(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM delivery_reports r
+		WHERE r.applied_at IS NULL AND r.received_at < $1 AND r.message_id IS NULL
+			AND NOT EXISTS (SELECT 1 FROM message_parts p WHERE p.provider_message_id = r.provider_message_id)
+			AND NOT EXISTS (SELECT 1 FROM messages m WHERE m.provider_message_id = r.provider_message_id)`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire delivery reports: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// jsonParam passes a JSON document as text, or NULL when empty
+func jsonParam(doc json.RawMessage) any {
+	if len(doc) == 0 {
+		return nil
+	}
+	return string(doc)
+}
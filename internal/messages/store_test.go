@@ -1,6 +1,8 @@
 package messages
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -48,3 +50,114 @@ func TestMessage(t *testing.T) {
 		t.Errorf("Expected 1 part, got %d", msg.Parts)
 	}
 }
+
+func TestResolvePriority(t *testing.T) {
+	tests := []struct {
+		requested Priority
+		express   bool
+		expected  Priority
+	}{
+		{"", false, PriorityStandard},
+		{"", true, PriorityExpress},
+		{PriorityBulk, false, PriorityBulk},
+		{PriorityTransactional, true, PriorityTransactional},
+	}
+
+	for _, tt := range tests {
+		if got := ResolvePriority(tt.requested, tt.express); got != tt.expected {
+			t.Errorf("ResolvePriority(%q, %v) = %s, want %s", tt.requested, tt.express, got, tt.expected)
+		}
+	}
+
+	if PriorityOTP.Rank() >= PriorityBulk.Rank() {
+		t.Error("OTP should rank ahead of bulk")
+	}
+	if Priority("vip").Valid() {
+		t.Error("Unknown priority should be invalid")
+	}
+}
+
+func TestMemoryListByClient(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	store.AddClient(uuid.New())
+
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		status := StatusQueued
+		if i%2 == 1 {
+			status = StatusDelivered
+		}
+		msg := &Message{ID: uuid.New(), ClientID: clientID, To: "+15551234567", From: "TEST", Text: "hi",
+			Parts: 1, Status: status, Express: i == 4, CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+		if i == 2 {
+			ref := "order-2"
+			msg.Reference = &ref
+		}
+		if err := store.Create(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Newest first, two at a time
+	var seen []time.Time
+	filter := MessageFilter{ClientID: clientID, Limit: 2}
+	for {
+		page, err := store.ListByClient(ctx, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, msg := range page {
+			seen = append(seen, msg.CreatedAt)
+		}
+		if len(page) < filter.Limit {
+			break
+		}
+		cursor, err := ParseCursor(CursorOf(page[len(page)-1]).String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter.After = &cursor
+	}
+	if len(seen) != 5 {
+		t.Fatalf("Expected 5 messages across pages, got %d", len(seen))
+	}
+	for i := 1; i < len(seen); i++ {
+		if !seen[i].Before(seen[i-1]) {
+			t.Errorf("Expected descending order, got %v after %v", seen[i], seen[i-1])
+		}
+	}
+
+	yes := true
+	since := base.Add(time.Minute)
+	tests := []struct {
+		name     string
+		filter   MessageFilter
+		expected int
+	}{
+		{"status", MessageFilter{Statuses: []Status{StatusDelivered}}, 2},
+		{"reference", MessageFilter{Reference: "order-2"}, 1},
+		{"express", MessageFilter{Express: &yes}, 1},
+		{"since", MessageFilter{Since: &since}, 4},
+		{"recipient", MessageFilter{To: "+15550000000"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filter.ClientID = clientID
+			tt.filter.Limit = 10
+			msgs, err := store.ListByClient(ctx, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(msgs) != tt.expected {
+				t.Errorf("Expected %d messages, got %d", tt.expected, len(msgs))
+			}
+		})
+	}
+
+	if _, err := ParseCursor("not-a-cursor"); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Package metrics holds the Prometheus collectors shared by the API and the
+// worker. Labels are kept to bounded sets (routes, statuses, priorities,
+// providers, error classes); per-client series are opt-in.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry is what /metrics serves
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPDuration also gives the request rate through its _count series
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sms_http_request_duration_seconds",
+		Help:    "API request latency by route pattern, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	SendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sms_send_duration_seconds",
+		Help:    "Provider send latency by provider and outcome (sent or failed).",
+		Buckets: []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"provider", "outcome"})
+
+	Retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_send_retries_total",
+		Help: "Failed sends scheduled for another attempt, by provider and error class.",
+	}, []string{"provider", "class"})
+
+	DLRLag = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sms_dlr_lag_seconds",
+		Help:    "Time from SENT to the delivery report that settled the message, by provider and final status.",
+		Buckets: []float64{1, 5, 15, 30, 60, 300, 900, 3600, 4 * 3600, 24 * 3600},
+	}, []string{"provider", "status"})
+
+	DLRRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_dlr_rejected_callbacks_total",
+		Help: "Provider webhooks that failed authentication, by provider and reason.",
+	}, []string{"provider", "reason"})
+
+	// The worker's adaptive sender pool, updated on every sizing decision
+	WorkerSenders = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sms_worker_senders",
+		Help: "Shared senders running in the worker pool.",
+	})
+	WorkerBusySenders = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sms_worker_busy_senders",
+		Help: "Shared senders busy sending when the pool was last sized.",
+	})
+	WorkerBuffered = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sms_worker_buffered_messages",
+		Help: "Claimed messages waiting for a sender when the pool was last sized.",
+	})
+	WorkerLatency = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sms_worker_send_latency_seconds",
+		Help: "Moving average of provider send latency the pool is sized by.",
+	})
+	WorkerResizes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_worker_pool_resizes_total",
+		Help: "Adaptive sizing decisions that changed the pool, by direction (up or down).",
+	}, []string{"direction"})
+
+	// ClientSends is only registered with EnableClientLabels
+	ClientSends = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_client_sends_total",
+		Help: "Sends by client and outcome.",
+	}, []string{"client", "outcome"})
+)
+
+var clientLabels bool
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPDuration, SendDuration, Retries, DLRLag, DLRRejected,
+		WorkerSenders, WorkerBusySenders, WorkerBuffered, WorkerLatency, WorkerResizes,
+	)
+}
+
+// EnableClientLabels registers the per-client series. Only worth it with a
+// small number of clients.
+func EnableClientLabels() {
+	Registry.MustRegister(ClientSends)
+	clientLabels = true
+}
+
+// ClientLabels reports whether per-client series are collected
+func ClientLabels() bool {
+	return clientLabels
+}
+
+// RegisterDB exposes the connection pool stats of db
+func RegisterDB(db *sql.DB) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, "postgres"))
+}
+
+// Handler serves the registry in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Label returns s, or "unknown" for an empty or missing value
+func Label(s *string) string {
+	if s == nil || *s == "" {
+		return "unknown"
+	}
+	return *s
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"sms-gateway/internal/messages"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DepthSource counts unfinished messages; messages.Repository satisfies it
+type DepthSource interface {
+	QueueDepth(ctx context.Context) ([]messages.Depth, error)
+}
+
+// CreditSource sums credit locks by state; both billing services satisfy it
+type CreditSource interface {
+	CreditTotals(ctx context.Context) (map[string]int64, error)
+}
+
+var (
+	queueDepthDesc = prometheus.NewDesc("sms_queue_depth",
+		"Messages not yet handed to a provider or awaiting retry, by status and priority.",
+		[]string{"status", "priority"}, nil)
+	creditsDesc = prometheus.NewDesc("sms_credits_cents",
+		"Credits in locks by state: HELD is reserved for unsent messages, CAPTURED is charged net of refunds.",
+		[]string{"state"}, nil)
+)
+
+// stateCollector reads queue depth and credit totals from the database when
+// scraped, so every replica reports the same numbers
+type stateCollector struct {
+	logger  *slog.Logger
+	depth   DepthSource
+	credits CreditSource
+}
+
+// RegisterState exposes queue depth and credit totals. Either source may be nil.
+func RegisterState(logger *slog.Logger, depth DepthSource, credits CreditSource) {
+	Registry.MustRegister(&stateCollector{logger: logger, depth: depth, credits: credits})
+}
+
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+	ch <- creditsDesc
+}
+
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if c.depth != nil {
+		depths, err := c.depth.QueueDepth(ctx)
+		if err != nil {
+			c.logger.Error("Failed to collect queue depth", "error", err)
+		}
+		for _, d := range depths {
+			ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue,
+				float64(d.Count), string(d.Status), string(d.Priority))
+		}
+	}
+
+	if c.credits != nil {
+		totals, err := c.credits.CreditTotals(ctx)
+		if err != nil {
+			c.logger.Error("Failed to collect credit totals", "error", err)
+		}
+		for state, cents := range totals {
+			ch <- prometheus.MustNewConstMetric(creditsDesc, prometheus.GaugeValue, float64(cents), state)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"sms-gateway/internal/billing"
+	"sms-gateway/internal/messages"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStateCollector(t *testing.T) {
+	ctx := context.Background()
+	store := messages.NewMemoryStore()
+	ledger := billing.NewMemoryService()
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	ledger.SetCredits(clientID, 100)
+
+	for _, m := range []struct {
+		status   messages.Status
+		priority messages.Priority
+	}{
+		{messages.StatusQueued, messages.PriorityOTP},
+		{messages.StatusQueued, messages.PriorityOTP},
+		{messages.StatusQueued, messages.PriorityBulk},
+		{messages.StatusFailedTemp, messages.PriorityStandard},
+		{messages.StatusDelivered, messages.PriorityStandard}, // Done, not counted
+	} {
+		msg := &messages.Message{ID: uuid.New(), ClientID: clientID, Status: m.status, Priority: m.priority}
+		if err := store.Create(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+		ledger.HoldCredits(ctx, clientID, msg.ID, 5)
+		if m.status == messages.StatusDelivered {
+			ledger.CaptureCredits(ctx, msg.ID)
+		}
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&stateCollector{logger: slog.New(slog.NewTextHandler(os.Stdout, nil)), depth: store, credits: ledger})
+
+	expected := `
+# HELP sms_credits_cents Credits in locks by state: HELD is reserved for unsent messages, CAPTURED is charged net of refunds.
+# TYPE sms_credits_cents gauge
+sms_credits_cents{state="CAPTURED"} 5
+sms_credits_cents{state="HELD"} 20
+# HELP sms_queue_depth Messages not yet handed to a provider or awaiting retry, by status and priority.
+# TYPE sms_queue_depth gauge
+sms_queue_depth{priority="bulk",status="QUEUED"} 1
+sms_queue_depth{priority="otp",status="QUEUED"} 2
+sms_queue_depth{priority="standard",status="FAILED_TEMP"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
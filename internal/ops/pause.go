@@ -0,0 +1,116 @@
+// Package ops backs the operator API: a live overview of the queue and
+// providers, and the pauses every worker honours when it claims messages.
+package ops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scope is what a pause applies to
+type Scope string
+
+const (
+	ScopeGlobal   Scope = "global"   // Nothing is sent
+	ScopeClient   Scope = "client"   // Target is a client ID
+	ScopeProvider Scope = "provider" // Target is a provider name
+)
+
+// Mode tells a plain pause from a drain. Both stop new claims; a drain is
+// reported as finished once the provider has no sends in flight.
+type Mode string
+
+const (
+	ModePause Mode = "pause"
+	ModeDrain Mode = "drain"
+)
+
+var ErrInvalidPause = errors.New("invalid pause")
+
+// Pause stops workers from claiming messages within its scope. Messages
+// stay QUEUED and are claimed again once the pause is lifted.
+type Pause struct {
+	Scope     Scope     `json:"scope"`
+	Target    string    `json:"target,omitempty"`
+	Mode      Mode      `json:"mode"`
+	Reason    string    `json:"reason,omitempty"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Validate checks the scope and target and defaults the mode
+func (p *Pause) Validate() error {
+	switch p.Scope {
+	case ScopeGlobal:
+		if p.Target != "" {
+			return fmt.Errorf("%w: a global pause takes no target", ErrInvalidPause)
+		}
+	case ScopeClient:
+		id, err := uuid.Parse(p.Target)
+		if err != nil {
+			return fmt.Errorf("%w: client target must be a client ID", ErrInvalidPause)
+		}
+		p.Target = id.String()
+	case ScopeProvider:
+		if p.Target == "" {
+			return fmt.Errorf("%w: provider target is required", ErrInvalidPause)
+		}
+	default:
+		return fmt.Errorf("%w: scope must be global, client or provider", ErrInvalidPause)
+	}
+	if p.Mode == "" {
+		p.Mode = ModePause
+	}
+	switch {
+	case p.Mode != ModePause && p.Mode != ModeDrain:
+		return fmt.Errorf("%w: mode must be pause or drain", ErrInvalidPause)
+	case p.Mode == ModeDrain && p.Scope != ScopeProvider:
+		return fmt.Errorf("%w: only providers can be drained", ErrInvalidPause)
+	}
+	return nil
+}
+
+// Pauses is the set of pauses in force
+type Pauses []Pause
+
+// Holds reports whether a message of clientID sent through provider is
+// held back by any of the pauses
+func (ps Pauses) Holds(clientID uuid.UUID, provider string) bool {
+	for _, p := range ps {
+		switch {
+		case p.Scope == ScopeGlobal,
+			p.Scope == ScopeClient && p.Target == clientID.String(),
+			p.Scope == ScopeProvider && p.Target == provider:
+			return true
+		}
+	}
+	return false
+}
+
+// Find returns the pause for scope and target, if any
+func (ps Pauses) Find(scope Scope, target string) *Pause {
+	for i := range ps {
+		if ps[i].Scope == scope && ps[i].Target == target {
+			return &ps[i]
+		}
+	}
+	return nil
+}
+
+// Controls is where pauses are kept. Store keeps them in the send_pauses
+// table that queue.Queue checks on every claim; queue.MemoryTable keeps them
+// next to its in-memory queue.
+type Controls interface {
+	// Pauses lists the pauses in force
+	Pauses(ctx context.Context) (Pauses, error)
+	// Pause adds p, replacing any pause with the same scope and target
+	Pause(ctx context.Context, p Pause) error
+	// Resume lifts a pause and reports whether there was one
+	Resume(ctx context.Context, scope Scope, target string) (bool, error)
+	// InFlight counts messages being sent through provider
+	InFlight(ctx context.Context, provider string) (int64, error)
+}
@@ -0,0 +1,274 @@
+package ops
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sms-gateway/internal/dlq"
+	"sms-gateway/internal/messages"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultWindow is how far back throughput and provider health look
+	DefaultWindow = 5 * time.Minute
+	// MaxWindow keeps the event scans bounded
+	MaxWindow = 24 * time.Hour
+	// TopN caps the per-client and error code lists
+	TopN = 20
+
+	// degradedRate is the failure share at which a provider with at least
+	// degradedMinAttempts attempts in the window is reported as degraded
+	degradedRate        = 0.5
+	degradedMinAttempts = 10
+)
+
+// Provider states in the overview
+const (
+	ProviderOK       = "ok"
+	ProviderIdle     = "idle"     // No attempts in the window
+	ProviderDegraded = "degraded" // Most attempts in the window failed
+	ProviderPaused   = "paused"
+	ProviderDraining = "draining" // Drained, but sends are still in flight
+	ProviderDrained  = "drained"
+)
+
+// Throughput sums provider activity over the window
+type Throughput struct {
+	Sent          int64   `json:"sent"`
+	Failed        int64   `json:"failed"`
+	Delivered     int64   `json:"delivered"`
+	SentPerMinute float64 `json:"sent_per_minute"`
+}
+
+// ProviderHealth is a provider's activity and what operators should make of it
+type ProviderHealth struct {
+	ProviderStats
+	State     string  `json:"state"`
+	ErrorRate float64 `json:"error_rate"` // Failed share of send attempts
+}
+
+// Overview is the operator view of the running system
+type Overview struct {
+	Window                 string           `json:"window"`
+	Depth                  []messages.Depth `json:"depth"`
+	ByClient               []ClientDepth    `json:"depth_by_client"`
+	OldestQueuedAgeSeconds float64          `json:"oldest_queued_age_seconds"`
+	Throughput             Throughput       `json:"throughput"`
+	Providers              []ProviderHealth `json:"providers"`
+	TopErrors              []ErrorCount     `json:"top_errors"`
+	Pauses                 Pauses           `json:"pauses"`
+}
+
+// DrainStatus says whether a drained provider still has sends in flight
+type DrainStatus struct {
+	Provider string `json:"provider"`
+	InFlight int64  `json:"in_flight"`
+	Drained  bool   `json:"drained"`
+}
+
+// RequeueResult is the outcome of requeueing a single message
+type RequeueResult struct {
+	MessageID uuid.UUID       `json:"message_id"`
+	From      messages.Status `json:"from"`
+	Outcome   string          `json:"outcome"` // requeued, insufficient_credits, client_suspended, not_requeueable, error
+	Error     string          `json:"error,omitempty"`
+}
+
+// Service answers the operator API
+type Service struct {
+	logger   *slog.Logger
+	controls Controls
+	stats    StatsSource
+	store    messages.Repository
+	machine  *messages.Machine
+	dlq      *dlq.Service
+}
+
+func NewService(logger *slog.Logger, controls Controls, stats StatsSource, store messages.Repository, machine *messages.Machine, dlq *dlq.Service) *Service {
+	return &Service{
+		logger:   logger,
+		controls: controls,
+		stats:    stats,
+		store:    store,
+		machine:  machine,
+		dlq:      dlq,
+	}
+}
+
+// Overview reports queue depth, throughput and provider health over window
+func (s *Service) Overview(ctx context.Context, window time.Duration) (*Overview, error) {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	if window > MaxWindow {
+		window = MaxWindow
+	}
+
+	depth, err := s.store.QueueDepth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := s.stats.Stats(ctx, window, TopN)
+	if err != nil {
+		return nil, err
+	}
+	pauses, err := s.controls.Pauses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &Overview{
+		Window:                 window.String(),
+		Depth:                  depth,
+		ByClient:               stats.ByClient,
+		OldestQueuedAgeSeconds: stats.OldestQueuedAge.Seconds(),
+		TopErrors:              stats.TopErrors,
+		Pauses:                 pauses,
+	}
+	seen := make(map[string]bool)
+	for _, p := range stats.Providers {
+		out.Throughput.Sent += p.Sent
+		out.Throughput.Failed += p.Failed
+		out.Throughput.Delivered += p.Delivered
+		out.Providers = append(out.Providers, health(p, pauses.Find(ScopeProvider, p.Provider)))
+		seen[p.Provider] = true
+	}
+	// Paused providers are listed even when they have been quiet
+	for _, p := range pauses {
+		if p.Scope == ScopeProvider && !seen[p.Target] {
+			out.Providers = append(out.Providers, health(ProviderStats{Provider: p.Target}, &p))
+		}
+	}
+	out.Throughput.SentPerMinute = float64(out.Throughput.Sent) / window.Minutes()
+
+	// Empty lists rather than nulls keep the JSON easy to consume
+	if out.Depth == nil {
+		out.Depth = []messages.Depth{}
+	}
+	if out.ByClient == nil {
+		out.ByClient = []ClientDepth{}
+	}
+	if out.Providers == nil {
+		out.Providers = []ProviderHealth{}
+	}
+	if out.TopErrors == nil {
+		out.TopErrors = []ErrorCount{}
+	}
+	if out.Pauses == nil {
+		out.Pauses = Pauses{}
+	}
+	return out, nil
+}
+
+// health classifies a provider by its pause, if any, and its failure rate
+func health(p ProviderStats, pause *Pause) ProviderHealth {
+	h := ProviderHealth{ProviderStats: p, State: ProviderOK}
+	attempts := p.Sent + p.Failed
+	if attempts > 0 {
+		h.ErrorRate = float64(p.Failed) / float64(attempts)
+	}
+
+	switch {
+	case pause != nil && pause.Mode == ModeDrain && p.InFlight > 0:
+		h.State = ProviderDraining
+	case pause != nil && pause.Mode == ModeDrain:
+		h.State = ProviderDrained
+	case pause != nil:
+		h.State = ProviderPaused
+	case attempts == 0:
+		h.State = ProviderIdle
+	case attempts >= degradedMinAttempts && h.ErrorRate >= degradedRate:
+		h.State = ProviderDegraded
+	}
+	return h
+}
+
+// Pauses lists the pauses in force
+func (s *Service) Pauses(ctx context.Context) (Pauses, error) {
+	return s.controls.Pauses(ctx)
+}
+
+// Pause stops workers claiming messages within p's scope. Sends already
+// claimed finish normally.
+func (s *Service) Pause(ctx context.Context, p Pause) (*Pause, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	p.CreatedAt = time.Now()
+	if err := s.controls.Pause(ctx, p); err != nil {
+		return nil, err
+	}
+	s.logger.WarnContext(ctx, "Sending paused", "scope", p.Scope, "target", p.Target, "mode", p.Mode, "reason", p.Reason, "actor", p.Actor)
+	return &p, nil
+}
+
+// Resume lifts the pause for scope and target and reports whether there was one
+func (s *Service) Resume(ctx context.Context, scope Scope, target, actor string) (bool, error) {
+	p := Pause{Scope: scope, Target: target}
+	if err := p.Validate(); err != nil {
+		return false, err
+	}
+	resumed, err := s.controls.Resume(ctx, p.Scope, p.Target)
+	if err != nil {
+		return false, err
+	}
+	if resumed {
+		s.logger.InfoContext(ctx, "Sending resumed", "scope", p.Scope, "target", p.Target, "actor", actor)
+	}
+	return resumed, nil
+}
+
+// Drain stops new sends through provider and reports the ones still in
+// flight. Call it again to follow progress; resume the provider when done.
+func (s *Service) Drain(ctx context.Context, provider, reason, actor string) (*DrainStatus, error) {
+	if _, err := s.Pause(ctx, Pause{Scope: ScopeProvider, Target: provider, Mode: ModeDrain, Reason: reason, Actor: actor}); err != nil {
+		return nil, err
+	}
+	inFlight, err := s.controls.InFlight(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+	return &DrainStatus{Provider: provider, InFlight: inFlight, Drained: inFlight == 0}, nil
+}
+
+// ErrNotFound is returned for requeues of unknown messages
+var ErrNotFound = errors.New("message not found")
+
+// Requeue sends a failed message back to QUEUED. A FAILED_TEMP message
+// skips the rest of its backoff and keeps its held credits; a FAILED_PERM
+// one is replayed like a dead letter, holding its credits again.
+func (s *Service) Requeue(ctx context.Context, messageID uuid.UUID, actor string) (*RequeueResult, error) {
+	msg, err := s.store.GetByID(ctx, messageID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	res := &RequeueResult{MessageID: messageID, From: msg.Status}
+
+	switch msg.Status {
+	case messages.StatusFailedPerm:
+		replayed := s.dlq.Replay(ctx, []uuid.UUID{messageID}, actor)[0]
+		res.Outcome, res.Error = replayed.Outcome, replayed.Error
+		if res.Outcome == "not_failed" {
+			res.Outcome = "not_requeueable"
+		}
+	case messages.StatusFailedTemp:
+		from := messages.StatusFailedTemp
+		ev := &messages.Event{MessageID: messageID, Event: messages.EventRequeued, FromStatus: &from,
+			Status: messages.StatusQueued, Actor: actor}
+		switch err := s.machine.Apply(ctx, ev, nil, msg.LastError, nil); {
+		case errors.Is(err, messages.ErrIllegalTransition):
+			res.Outcome = "not_requeueable"
+		case err != nil:
+			res.Outcome, res.Error = "error", err.Error()
+		default:
+			res.Outcome = "requeued"
+			s.logger.InfoContext(ctx, "Message requeued", "message", messageID, "client", msg.ClientID, "actor", actor)
+		}
+	default:
+		res.Outcome = "not_requeueable"
+	}
+	return res, nil
+}
@@ -0,0 +1,276 @@
+package ops
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sms-gateway/internal/billing"
+	"sms-gateway/internal/dlq"
+	"sms-gateway/internal/messages"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fakeControls keeps pauses in memory; queue.MemoryTable is the real
+// in-memory implementation but importing it here would be a cycle
+type fakeControls struct {
+	pauses   Pauses
+	inFlight map[string]int64
+}
+
+func (f *fakeControls) Pauses(ctx context.Context) (Pauses, error) { return f.pauses, nil }
+
+func (f *fakeControls) Pause(ctx context.Context, p Pause) error {
+	if existing := f.pauses.Find(p.Scope, p.Target); existing != nil {
+		*existing = p
+		return nil
+	}
+	f.pauses = append(f.pauses, p)
+	return nil
+}
+
+func (f *fakeControls) Resume(ctx context.Context, scope Scope, target string) (bool, error) {
+	for i, p := range f.pauses {
+		if p.Scope == scope && p.Target == target {
+			f.pauses = append(f.pauses[:i], f.pauses[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeControls) InFlight(ctx context.Context, provider string) (int64, error) {
+	return f.inFlight[provider], nil
+}
+
+type fakeStats struct {
+	stats Stats
+}
+
+func (f *fakeStats) Stats(ctx context.Context, window time.Duration, limit int) (*Stats, error) {
+	copied := f.stats
+	return &copied, nil
+}
+
+type testEnv struct {
+	service  *Service
+	store    *messages.MemoryStore
+	ledger   *billing.MemoryService
+	controls *fakeControls
+	stats    *fakeStats
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	env := &testEnv{
+		store:    messages.NewMemoryStore(),
+		ledger:   billing.NewMemoryService(),
+		controls: &fakeControls{inFlight: make(map[string]int64)},
+		stats:    &fakeStats{},
+	}
+	pricing := billing.NewPricing(5, 2, nil)
+	machine := messages.NewMachine(logger, env.store, env.ledger)
+	replay := dlq.NewService(logger, env.store, env.ledger, pricing)
+	env.service = NewService(logger, env.controls, env.stats, env.store, machine, replay)
+	return env
+}
+
+func TestPauseValidate(t *testing.T) {
+	clientID := uuid.New()
+
+	tests := []struct {
+		name  string
+		pause Pause
+		valid bool
+	}{
+		{"global", Pause{Scope: ScopeGlobal}, true},
+		{"global with target", Pause{Scope: ScopeGlobal, Target: "x"}, false},
+		{"client", Pause{Scope: ScopeClient, Target: clientID.String()}, true},
+		{"client not an ID", Pause{Scope: ScopeClient, Target: "acme"}, false},
+		{"provider", Pause{Scope: ScopeProvider, Target: "smpp"}, true},
+		{"provider without target", Pause{Scope: ScopeProvider}, false},
+		{"drain provider", Pause{Scope: ScopeProvider, Target: "smpp", Mode: ModeDrain}, true},
+		{"drain client", Pause{Scope: ScopeClient, Target: clientID.String(), Mode: ModeDrain}, false},
+		{"unknown mode", Pause{Scope: ScopeGlobal, Mode: "stop"}, false},
+		{"unknown scope", Pause{Scope: "region"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.pause.Validate()
+			if tt.valid && err != nil {
+				t.Errorf("Expected valid, got %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidPause) {
+				t.Errorf("Expected ErrInvalidPause, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPausesHolds(t *testing.T) {
+	paused, other := uuid.New(), uuid.New()
+	pauses := Pauses{
+		{Scope: ScopeClient, Target: paused.String()},
+		{Scope: ScopeProvider, Target: "smpp", Mode: ModeDrain},
+	}
+
+	if !pauses.Holds(paused, "mock") {
+		t.Error("Expected the paused client to be held")
+	}
+	if !pauses.Holds(other, "smpp") {
+		t.Error("Expected the drained provider to be held")
+	}
+	if pauses.Holds(other, "mock") {
+		t.Error("Expected other clients on other providers to be sent")
+	}
+	if !append(pauses, Pause{Scope: ScopeGlobal}).Holds(other, "mock") {
+		t.Error("Expected a global pause to hold everything")
+	}
+}
+
+func TestOverviewProviderHealth(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	env.stats.stats = Stats{
+		OldestQueuedAge: 90 * time.Second,
+		Providers: []ProviderStats{
+			{Provider: "mock", Sent: 90, Failed: 10, Delivered: 80},
+			{Provider: "flaky", Sent: 4, Failed: 16},
+			{Provider: "smpp", Sent: 6, InFlight: 2},
+			{Provider: "quiet"},
+		},
+	}
+	env.controls.pauses = Pauses{
+		{Scope: ScopeProvider, Target: "smpp", Mode: ModeDrain},
+		{Scope: ScopeProvider, Target: "backup", Mode: ModePause},
+	}
+
+	overview, err := env.service.Overview(ctx, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if overview.Throughput.Sent != 100 || overview.Throughput.Failed != 26 || overview.Throughput.Delivered != 80 {
+		t.Errorf("Expected 100 sent, 26 failed, 80 delivered, got %+v", overview.Throughput)
+	}
+	if overview.Throughput.SentPerMinute != 10 {
+		t.Errorf("Expected 10 sent per minute, got %v", overview.Throughput.SentPerMinute)
+	}
+	if overview.OldestQueuedAgeSeconds != 90 {
+		t.Errorf("Expected oldest queued age 90s, got %v", overview.OldestQueuedAgeSeconds)
+	}
+
+	expected := map[string]string{
+		"mock":   ProviderOK,
+		"flaky":  ProviderDegraded,
+		"smpp":   ProviderDraining,
+		"quiet":  ProviderIdle,
+		"backup": ProviderPaused,
+	}
+	if len(overview.Providers) != len(expected) {
+		t.Fatalf("Expected %d providers, got %+v", len(expected), overview.Providers)
+	}
+	for _, p := range overview.Providers {
+		if p.State != expected[p.Provider] {
+			t.Errorf("Expected %s to be %s, got %s", p.Provider, expected[p.Provider], p.State)
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	env.controls.inFlight["smpp"] = 3
+
+	status, err := env.service.Drain(ctx, "smpp", "carrier maintenance", "admin:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.InFlight != 3 || status.Drained {
+		t.Errorf("Expected 3 in flight and not drained, got %+v", status)
+	}
+	if p := env.controls.pauses.Find(ScopeProvider, "smpp"); p == nil || p.Mode != ModeDrain {
+		t.Fatalf("Expected a drain pause, got %+v", env.controls.pauses)
+	}
+
+	env.controls.inFlight["smpp"] = 0
+	if status, _ := env.service.Drain(ctx, "smpp", "", "admin:test"); !status.Drained {
+		t.Errorf("Expected drained once nothing is in flight, got %+v", status)
+	}
+	if len(env.controls.pauses) != 1 {
+		t.Errorf("Expected draining again to replace the pause, got %+v", env.controls.pauses)
+	}
+
+	if resumed, err := env.service.Resume(ctx, ScopeProvider, "smpp", "admin:test"); err != nil || !resumed {
+		t.Errorf("Resume() = %v, %v; want true", resumed, err)
+	}
+	if resumed, _ := env.service.Resume(ctx, ScopeProvider, "smpp", "admin:test"); resumed {
+		t.Error("Expected a second resume to find nothing")
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	clientID := uuid.New()
+	env.store.AddClient(clientID)
+	env.ledger.SetCredits(clientID, 100)
+
+	create := func(status messages.Status) uuid.UUID {
+		lastError := "provider timeout"
+		msg := &messages.Message{ID: uuid.New(), ClientID: clientID, Status: status, Parts: 1, Attempts: 3,
+			Priority: messages.PriorityStandard, LastError: &lastError}
+		if err := env.store.Create(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg.ID
+	}
+
+	// FAILED_TEMP keeps the credits it holds and skips its backoff
+	temp := create(messages.StatusFailedTemp)
+	env.ledger.HoldCredits(ctx, clientID, temp, 5)
+	res, err := env.service.Requeue(ctx, temp, "admin:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Outcome != "requeued" || res.From != messages.StatusFailedTemp {
+		t.Errorf("Expected FAILED_TEMP to be requeued, got %+v", res)
+	}
+	if credits, _ := env.ledger.GetCredits(ctx, clientID); credits != 95 {
+		t.Errorf("Expected no new hold for a FAILED_TEMP requeue, got %d credits", credits)
+	}
+	events, _ := env.store.ListEvents(ctx, temp)
+	if last := events[len(events)-1]; last.Event != messages.EventRequeued || last.Actor != "admin:test" {
+		t.Errorf("Expected a requeued event by admin:test, got %+v", last)
+	}
+
+	// FAILED_PERM is replayed like a dead letter, holding credits again
+	perm := create(messages.StatusFailedPerm)
+	if res, _ := env.service.Requeue(ctx, perm, "admin:test"); res.Outcome != "requeued" {
+		t.Errorf("Expected FAILED_PERM to be requeued, got %+v", res)
+	}
+	if credits, _ := env.ledger.GetCredits(ctx, clientID); credits != 90 {
+		t.Errorf("Expected a fresh hold for a FAILED_PERM requeue, got %d credits", credits)
+	}
+	events, _ = env.store.ListEvents(ctx, perm)
+	if len(events) != 1 || events[0].Event != dlq.EventReplayed || *events[0].FromStatus != messages.StatusFailedPerm {
+		t.Errorf("Expected the replay recorded with its status change, got %+v", events)
+	}
+	if msg, _ := env.store.GetByID(ctx, perm); msg.Status != messages.StatusQueued || msg.Attempts != 0 || msg.LastError == nil {
+		t.Errorf("Expected QUEUED with a fresh attempt budget and the last error kept, got %+v", msg)
+	}
+
+	delivered := create(messages.StatusDelivered)
+	if res, _ := env.service.Requeue(ctx, delivered, "admin:test"); res.Outcome != "not_requeueable" {
+		t.Errorf("Expected a delivered message to be refused, got %+v", res)
+	}
+
+	if _, err := env.service.Requeue(ctx, uuid.New(), "admin:test"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
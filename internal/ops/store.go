@@ -0,0 +1,211 @@
+package ops
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sms-gateway/internal/db"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ClientDepth is one client's share of the unfinished messages
+type ClientDepth struct {
+	ClientID uuid.UUID `json:"client_id"`
+	Name     string    `json:"name"`
+	Queued   int64     `json:"queued"`
+	Sending  int64     `json:"sending"`
+	Retrying int64     `json:"retrying"` // FAILED_TEMP awaiting their backoff
+}
+
+// ProviderStats counts one provider's send attempts and reports over a window
+type ProviderStats struct {
+	Provider   string     `json:"provider"`
+	Sent       int64      `json:"sent"`
+	Failed     int64      `json:"failed"`
+	Delivered  int64      `json:"delivered"`
+	InFlight   int64      `json:"in_flight"`
+	LastSentAt *time.Time `json:"last_sent_at,omitempty"`
+}
+
+// ErrorCount is how often a provider reported an error code over a window
+type ErrorCount struct {
+	Provider string `json:"provider"`
+	Code     string `json:"code"`
+	Class    string `json:"class"`
+	Count    int64  `json:"count"`
+}
+
+// Stats is the raw material of the overview
+type Stats struct {
+	ByClient        []ClientDepth
+	OldestQueuedAge time.Duration
+	Providers       []ProviderStats
+	TopErrors       []ErrorCount
+}
+
+// StatsSource reads Stats; Store is the Postgres implementation
+type StatsSource interface {
+	Stats(ctx context.Context, window time.Duration, limit int) (*Stats, error)
+}
+
+// Store keeps pauses in the send_pauses table and reads operator stats
+// from the messages, message_events and delivery_reports tables
+type Store struct {
+	db *db.PostgresDB
+}
+
+func NewStore(db *db.PostgresDB) *Store {
+	return &Store{db: db}
+}
+
+var (
+	_ Controls    = (*Store)(nil)
+	_ StatsSource = (*Store)(nil)
+)
+
+func (s *Store) Pauses(ctx context.Context) (Pauses, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT scope, target, mode, COALESCE(reason, ''), actor, created_at
+		FROM send_pauses ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pauses: %w", err)
+	}
+	defer rows.Close()
+
+	var pauses Pauses
+	for rows.Next() {
+		var p Pause
+		if err := rows.Scan(&p.Scope, &p.Target, &p.Mode, &p.Reason, &p.Actor, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		pauses = append(pauses, p)
+	}
+	return pauses, rows.Err()
+}
+
+func (s *Store) Pause(ctx context.Context, p Pause) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO send_pauses (scope, target, mode, reason, actor)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		ON CONFLICT (scope, target) DO UPDATE
+		SET mode = EXCLUDED.mode, reason = EXCLUDED.reason, actor = EXCLUDED.actor, created_at = now()`,
+		p.Scope, p.Target, p.Mode, p.Reason, p.Actor)
+	if err != nil {
+		return fmt.Errorf("failed to pause: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) Resume(ctx context.Context, scope Scope, target string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM send_pauses WHERE scope = $1 AND target = $2`, scope, target)
+	if err != nil {
+		return false, fmt.Errorf("failed to resume: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+func (s *Store) InFlight(ctx context.Context, provider string) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx, `SELECT count(*) FROM messages WHERE status = 'SENDING' AND provider = $1`, provider).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count in-flight messages: %w", err)
+	}
+	return n, nil
+}
+
+// Stats reads the per-client backlog (the limit largest), the age of the
+// oldest queued message, and per-provider activity and error codes over the
+// last window
+func (s *Store) Stats(ctx context.Context, window time.Duration, limit int) (*Stats, error) {
+	stats := &Stats{}
+	since := time.Now().Add(-window)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT m.client_id, c.name,
+			count(*) FILTER (WHERE m.status = 'QUEUED'),
+			count(*) FILTER (WHERE m.status = 'SENDING'),
+			count(*) FILTER (WHERE m.status = 'FAILED_TEMP')
+		FROM messages m JOIN clients c ON c.id = m.client_id
+		WHERE m.status IN ('QUEUED', 'SENDING', 'FAILED_TEMP')
+		GROUP BY m.client_id, c.name
+		ORDER BY count(*) DESC
+		LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count backlog by client: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d ClientDepth
+		if err := rows.Scan(&d.ClientID, &d.Name, &d.Queued, &d.Sending, &d.Retrying); err != nil {
+			return nil, err
+		}
+		stats.ByClient = append(stats.ByClient, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var oldest sql.NullFloat64
+	err = s.db.QueryRowContext(ctx, `SELECT EXTRACT(EPOCH FROM now() - min(created_at))::float8
+		FROM messages WHERE status = 'QUEUED'`).Scan(&oldest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oldest queued message: %w", err)
+	}
+	stats.OldestQueuedAge = time.Duration(oldest.Float64 * float64(time.Second))
+
+	rows, err = s.db.QueryContext(ctx, `
+		WITH activity AS (
+			SELECT provider,
+				count(*) FILTER (WHERE event = 'sent') AS sent,
+				count(*) FILTER (WHERE event = 'failed') AS failed,
+				count(*) FILTER (WHERE status = 'DELIVERED') AS delivered,
+				max(created_at) FILTER (WHERE event = 'sent') AS last_sent_at
+			FROM message_events
+			WHERE event IN ('sent', 'failed', 'delivery_report') AND created_at >= $1 AND provider IS NOT NULL
+			GROUP BY provider
+		),
+		inflight AS (
+			SELECT provider, count(*) AS in_flight FROM messages
+			WHERE status = 'SENDING' AND provider IS NOT NULL
+			GROUP BY provider
+		)
+		SELECT COALESCE(a.provider, i.provider), COALESCE(a.sent, 0), COALESCE(a.failed, 0),
+			COALESCE(a.delivered, 0), COALESCE(i.in_flight, 0), a.last_sent_at
+		FROM activity a FULL JOIN inflight i ON i.provider = a.provider
+		ORDER BY 1`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read provider activity: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p ProviderStats
+		if err := rows.Scan(&p.Provider, &p.Sent, &p.Failed, &p.Delivered, &p.InFlight, &p.LastSentAt); err != nil {
+			return nil, err
+		}
+		stats.Providers = append(stats.Providers, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, `
+		SELECT COALESCE(provider, ''), error_code, COALESCE(error_class, ''), count(*)
+		FROM delivery_reports
+		WHERE error_code IS NOT NULL AND received_at >= $1
+		GROUP BY 1, 2, 3
+		ORDER BY 4 DESC
+		LIMIT $2`, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count error codes: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e ErrorCount
+		if err := rows.Scan(&e.Provider, &e.Code, &e.Class, &e.Count); err != nil {
+			return nil, err
+		}
+		stats.TopErrors = append(stats.TopErrors, e)
+	}
+	return stats, rows.Err()
+}
@@ -5,7 +5,11 @@ import (
 	"fmt"
 	"log/slog"
 	"sms-gateway/internal/providers/mock"
+	"sms-gateway/internal/tracing"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // OTPService handles OTP messages with delivery guarantee
@@ -24,7 +28,7 @@ func NewOTPService(logger *slog.Logger, provider *mock.Provider) *OTPService {
 }
 
 // SendOTPImmediate tries to send OTP immediately, returns error if can't deliver
-func (s *OTPService) SendOTPImmediate(ctx context.Context, to, from, text string) (*OTPResult, error) {
+func (s *OTPService) SendOTPImmediate(ctx context.Context, to, from, text string, parts int) (*OTPResult, error) {
 	// Create timeout context for immediate delivery
 	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
@@ -33,33 +37,46 @@ func (s *OTPService) SendOTPImmediate(ctx context.Context, to, from, text string
 		ToMSISDN:   to,
 		FromSender: from,
 		Text:       text,
+		Parts:      parts,
 	}
 
 	// Try to send immediately with timeout
+	ctx, span := tracing.Start(ctx, "sms.send", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("sms.provider", s.provider.GetName()),
+			attribute.String("sms.priority", "otp"),
+			attribute.Int("sms.parts", parts),
+		))
 	result := s.provider.SendSMS(ctx, msg)
+	span.SetAttributes(attribute.String("sms.provider_message_id", result.ProviderMessageID))
+	tracing.End(span, result.Error)
 
 	// Check if context timed out
 	if ctx.Err() == context.DeadlineExceeded {
-		s.logger.Warn("OTP delivery timeout", "to", to, "timeout", s.timeout)
+		s.logger.WarnContext(ctx, "OTP delivery timeout", "to", to, "timeout", s.timeout)
 		return nil, fmt.Errorf("OTP delivery timeout - operator not responding within %v", s.timeout)
 	}
 
 	// Check for immediate delivery failure
 	if result.Error != nil {
-		s.logger.Warn("OTP delivery failed", "to", to, "error", result.Error)
+		s.logger.WarnContext(ctx, "OTP delivery failed", "to", to, "error", result.Error)
 		return nil, fmt.Errorf("OTP delivery failed: %w", result.Error)
 	}
 
 	// Success - OTP was accepted by provider immediately
-	s.logger.Info("OTP delivered immediately", "to", to, "provider_id", result.ProviderMessageID)
+	s.logger.InfoContext(ctx, "OTP delivered immediately", "to", to, "provider_id", result.ProviderMessageID)
 
 	return &OTPResult{
+		Provider:          s.provider.GetName(),
 		ProviderMessageID: result.ProviderMessageID,
+		PartIDs:           result.PartIDs,
 		Status:            "SENT_IMMEDIATELY",
 	}, nil
 }
 
 type OTPResult struct {
-	ProviderMessageID string `json:"provider_message_id"`
-	Status            string `json:"status"`
+	Provider          string   `json:"provider"`
+	ProviderMessageID string   `json:"provider_message_id"`
+	PartIDs           []string `json:"part_ids"`
+	Status            string   `json:"status"`
 }
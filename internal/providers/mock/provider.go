@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"sms-gateway/internal/retry"
 	"time"
 
 	"github.com/google/uuid"
@@ -23,10 +24,12 @@ type Message struct {
 	ToMSISDN   string
 	FromSender string
 	Text       string
+	Parts      int // Segments, each submitted upstream on its own
 }
 
 type SendResult struct {
 	ProviderMessageID string
+	PartIDs           []string // One per segment, in order; DLRs refer to these
 	Status            Status
 	Error             error
 }
@@ -37,6 +40,7 @@ type Provider struct {
 	tempFailRate float64
 	permFailRate float64
 	latencyMs    int
+	onSend       func(msg *Message)
 }
 
 func NewProvider() *Provider {
@@ -49,6 +53,28 @@ func NewProvider() *Provider {
 	}
 }
 
+// Options overrides the simulated outcome rates and latency. Rates that sum
+// to less than 1 leave the remainder as permanent failures.
+type Options struct {
+	SuccessRate  float64
+	TempFailRate float64
+	Latency      time.Duration
+	OnSend       func(msg *Message) // Called after every simulated send
+}
+
+// NewProviderWithOptions returns a mock provider with fixed behaviour, e.g.
+// SuccessRate 1 for a provider that never fails
+func NewProviderWithOptions(opts Options) *Provider {
+	return &Provider{
+		name:         "mock",
+		successRate:  opts.SuccessRate,
+		tempFailRate: opts.TempFailRate,
+		permFailRate: 1 - opts.SuccessRate - opts.TempFailRate,
+		latencyMs:    int(opts.Latency.Milliseconds()),
+		onSend:       opts.OnSend,
+	}
+}
+
 func (p *Provider) GetName() string {
 	return p.name
 }
@@ -56,6 +82,9 @@ func (p *Provider) GetName() string {
 func (p *Provider) SendSMS(ctx context.Context, msg *Message) *SendResult {
 	// Simulate latency
 	time.Sleep(time.Duration(p.latencyMs) * time.Millisecond)
+	if p.onSend != nil {
+		p.onSend(msg)
+	}
 
 	providerID := fmt.Sprintf("mock_%d", time.Now().UnixNano())
 
@@ -65,23 +94,37 @@ func (p *Provider) SendSMS(ctx context.Context, msg *Message) *SendResult {
 	if r < p.successRate {
 		return &SendResult{
 			ProviderMessageID: providerID,
+			PartIDs:           partIDs(providerID, msg.Parts),
 			Status:            StatusSent,
 		}
 	} else if r < p.successRate+p.tempFailRate {
 		return &SendResult{
 			ProviderMessageID: providerID,
 			Status:            StatusFailedTemp,
-			Error:             fmt.Errorf("temporary network error"),
+			Error:             &retry.Error{Class: retry.ClassNetwork, Err: fmt.Errorf("temporary network error")},
 		}
 	} else {
 		return &SendResult{
 			ProviderMessageID: providerID,
 			Status:            StatusFailedPerm,
-			Error:             fmt.Errorf("invalid phone number"),
+			Error:             &retry.Error{Class: retry.ClassPermanent, Err: fmt.Errorf("invalid phone number")},
 		}
 	}
 }
 
+// partIDs names the submission of each segment. A single segment is known by
+// the message's provider ID.
+func partIDs(providerID string, parts int) []string {
+	if parts <= 1 {
+		return []string{providerID}
+	}
+	ids := make([]string, parts)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%s_%d", providerID, i+1)
+	}
+	return ids
+}
+
 func (p *Provider) SimulateDLR(ctx context.Context, providerMessageID string, status Status) {
 	// This would normally be called by the provider via webhook
 	// For testing, we can simulate DLR callbacks
@@ -0,0 +1,51 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sms-gateway/internal/messages"
+	"sms-gateway/internal/retry"
+
+	"github.com/google/uuid"
+)
+
+// Backend is the contract between the worker and the queue. Message state
+// always lives in Postgres; backends differ in how claims are dispatched.
+type Backend interface {
+	// WorkerID returns the identity recorded on claimed messages
+	WorkerID() string
+	// Poll claims up to limit messages, moving them to SENDING
+	Poll(ctx context.Context, limit int) ([]*messages.Message, error)
+	// Complete marks a claimed message as sent. It returns ErrLeaseLost if
+	// this worker no longer holds the claim.
+	Complete(ctx context.Context, messageID uuid.UUID, attempt Attempt) error
+	// Fail records a failed send and schedules or abandons the retry. It
+	// returns ErrLeaseLost if this worker no longer holds the claim.
+	Fail(ctx context.Context, messageID uuid.UUID, attempt Attempt, errorMsg string, decision retry.Decision) error
+	// Release gives unprocessed claims back without counting an attempt
+	Release(ctx context.Context, messageIDs []uuid.UUID) (int64, error)
+	// Reap requeues claims whose lease expired
+	Reap(ctx context.Context, maxAttempts int) ([]Reaped, error)
+	// Retry requeues FAILED_TEMP messages that are due
+	Retry(ctx context.Context) (int64, error)
+	// Weights returns non-default per-client scheduling weights
+	Weights(ctx context.Context) (map[uuid.UUID]int, error)
+}
+
+// ErrLeaseLost means the claim expired or was taken over before the send was
+// settled, so the message's status was left alone
+var ErrLeaseLost = errors.New("message is no longer claimed by this worker")
+
+// Attempt describes the provider send that settled a claim. It is stored on
+// the message and in its history.
+type Attempt struct {
+	Provider          string
+	ProviderMessageID string   // The provider's ID for the send, which its DLRs refer to
+	PartIDs           []string // The provider's ID for each submitted segment, in order
+}
+
+var (
+	_ Backend = (*Queue)(nil)
+	_ Backend = (*JetStream)(nil)
+	_ Backend = (*Memory)(nil)
+)
@@ -5,14 +5,20 @@ import (
 	"database/sql"
 	"log/slog"
 	"sms-gateway/internal/messages"
+	"sms-gateway/internal/retry"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 // Queue implements reliable SMS queue using PostgreSQL only
 type Queue struct {
-	db     *sql.DB
-	logger *slog.Logger
+	db       *sql.DB
+	logger   *slog.Logger
+	workerID string
+	provider string
+	leaseTTL time.Duration
 }
 
 // Result represents processing result
@@ -22,31 +28,85 @@ type Result struct {
 	Error     error
 }
 
-// New creates a database queue
-func New(store *messages.Store, logger *slog.Logger) *Queue {
+// Reaped describes a message whose lease expired before it was completed
+type Reaped struct {
+	MessageID uuid.UUID
+	Status    messages.Status
+}
+
+// New creates a database queue. Every claim made through it is leased to
+// workerID for leaseTTL; claims that outlive their lease are recovered by Reap.
+// Claims are recorded against provider, the one the worker sends through,
+// and skip anything held back by a global, client or provider pause in
+// send_pauses.
+// Each status update is a compare-and-set on the status it leaves, limited to
+// the transitions messages.CanTransition allows; the caller applies the
+// credit side effect with messages.Settle.
+func New(store *messages.Store, logger *slog.Logger, workerID, provider string, leaseTTL time.Duration) *Queue {
 	return &Queue{
-		db:     store.DB(),
-		logger: logger,
+		db:       store.DB(),
+		logger:   logger,
+		workerID: workerID,
+		provider: provider,
+		leaseTTL: leaseTTL,
 	}
 }
 
-// Poll atomically claims messages for processing
+// WorkerID returns the identity recorded on claimed messages
+func (q *Queue) WorkerID() string {
+	return q.workerID
+}
+
+// Poll atomically claims messages for processing. Claims are shared fairly
+// between clients: each client's queued messages are ranked by turn
+// (position / queue_weight), so a client with a large backlog only gets its
+// weighted share of every batch. More urgent priority classes still come first.
+// Paused clients are left out; a global or provider pause claims nothing.
 func (q *Queue) Poll(ctx context.Context, limit int) ([]*messages.Message, error) {
-	// Simple, fast atomic update - claims messages in one query
 	query := `
-		UPDATE messages 
-		SET status = 'SENDING', updated_at = NOW()
-		WHERE id IN (
-			SELECT id FROM messages 
-			WHERE status = 'QUEUED'
-			ORDER BY express DESC, created_at ASC
+		WITH active AS (
+			SELECT c.id, c.queue_weight FROM clients c
+			WHERE EXISTS (
+				SELECT 1 FROM messages m WHERE m.client_id = c.id AND m.status = 'QUEUED'
+			)
+			AND NOT ` + pausedClause("c.id") + `
+		),
+		candidates AS (
+			SELECT m.id, m.priority_rank, m.created_at,
+				(row_number() OVER (PARTITION BY a.id ORDER BY m.priority_rank ASC, m.created_at ASC) - 1)::float8
+					/ a.queue_weight AS turn
+			FROM active a
+			CROSS JOIN LATERAL (
+				SELECT id, priority_rank, created_at FROM messages
+				WHERE client_id = a.id AND status = 'QUEUED'
+				ORDER BY priority_rank ASC, created_at ASC
+				LIMIT $1
+			) m
+		),
+		claimed AS (
+			SELECT m.id FROM messages m
+			JOIN candidates c ON c.id = m.id
+			WHERE m.status = 'QUEUED'
+			ORDER BY c.priority_rank ASC, c.turn ASC, c.created_at ASC
 			LIMIT $1
-			FOR UPDATE SKIP LOCKED
+			FOR UPDATE OF m SKIP LOCKED
+		),
+		updated AS (
+			UPDATE messages
+			SET status = 'SENDING', locked_by = $2,
+				lease_expires_at = NOW() + $3 * INTERVAL '1 millisecond',
+				provider = COALESCE($4, provider), updated_at = NOW()
+			WHERE id IN (SELECT id FROM claimed)
+			RETURNING id, client_id, to_msisdn, from_sender, text, parts,
+					  client_reference, express, priority, attempts, trace_parent
+		),
+		events AS (
+			INSERT INTO message_events (message_id, event, from_status, status, actor)
+			SELECT id, 'claimed', 'QUEUED', 'SENDING', $2 FROM updated
 		)
-		RETURNING id, client_id, to_msisdn, from_sender, text, parts, 
-				  client_reference, express, attempts`
+		SELECT * FROM updated`
 
-	rows, err := q.db.QueryContext(ctx, query, limit)
+	rows, err := q.db.QueryContext(ctx, query, limit, q.workerID, q.leaseTTL.Milliseconds(), nullString(q.provider))
 	if err != nil {
 		return nil, err
 	}
@@ -55,43 +115,335 @@ func (q *Queue) Poll(ctx context.Context, limit int) ([]*messages.Message, error
 	var msgs []*messages.Message
 	for rows.Next() {
 		msg := &messages.Message{Status: messages.StatusSending}
-		rows.Scan(&msg.ID, &msg.ClientID, &msg.To, &msg.From, &msg.Text, &msg.Parts,
-			&msg.Reference, &msg.Express, &msg.Attempts)
+		if err := rows.Scan(&msg.ID, &msg.ClientID, &msg.To, &msg.From, &msg.Text, &msg.Parts,
+			&msg.Reference, &msg.Express, &msg.Priority, &msg.Attempts, &msg.TraceParent); err != nil {
+			return nil, err
+		}
 		msgs = append(msgs, msg)
 	}
-	return msgs, nil
+	return msgs, rows.Err()
 }
 
-// Complete marks message as sent
-func (q *Queue) Complete(ctx context.Context, messageID uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx,
-		`UPDATE messages SET status = 'SENT', updated_at = NOW() 
-		 WHERE id = $1 AND status = 'SENDING'`, messageID)
-	return err
+// Weights returns the queue weight of every client that is not on the default weight of 1
+func (q *Queue) Weights(ctx context.Context) (map[uuid.UUID]int, error) {
+	rows, err := q.db.QueryContext(ctx, `SELECT id, queue_weight FROM clients WHERE queue_weight <> 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	weights := make(map[uuid.UUID]int)
+	for rows.Next() {
+		var id uuid.UUID
+		var weight int
+		if err := rows.Scan(&id, &weight); err != nil {
+			return nil, err
+		}
+		weights[id] = weight
+	}
+	return weights, rows.Err()
 }
 
-// Fail marks message as failed with retry logic
-func (q *Queue) Fail(ctx context.Context, messageID uuid.UUID, errorMsg string) error {
-	_, err := q.db.ExecContext(ctx, `
-		UPDATE messages 
-		SET status = CASE WHEN attempts >= 2 THEN 'FAILED_PERM' ELSE 'FAILED_TEMP' END,
-			attempts = attempts + 1,
-			last_error = $2,
-			retry_after = CASE WHEN attempts >= 2 THEN NULL ELSE NOW() + INTERVAL '30 seconds' END,
-			updated_at = NOW()
-		WHERE id = $1 AND status = 'SENDING'`, messageID, errorMsg)
-	return err
+// Complete marks message as sent and records the provider ID of each part,
+// replacing the parts of any earlier attempt
+func (q *Queue) Complete(ctx context.Context, messageID uuid.UUID, attempt Attempt) error {
+	result, err := q.db.ExecContext(ctx, `
+		WITH done AS (
+			UPDATE messages
+			SET status = 'SENT', provider = COALESCE($3, provider),
+				provider_message_id = COALESCE($4, provider_message_id),
+				locked_by = NULL, lease_expires_at = NULL, updated_at = NOW()
+			WHERE id = $1 AND status = 'SENDING' AND locked_by = $2
+			RETURNING id
+		),
+		cleared AS (
+			DELETE FROM message_parts
+			WHERE message_id IN (SELECT id FROM done) AND part_no > cardinality($5::text[])
+		),
+		parts AS (
+			INSERT INTO message_parts (message_id, part_no, provider_message_id, status)
+			SELECT done.id, p.ord, p.pid, 'SENT'
+			FROM done, unnest($5::text[]) WITH ORDINALITY AS p(pid, ord)
+			ON CONFLICT (message_id, part_no) DO UPDATE
+			SET provider_message_id = EXCLUDED.provider_message_id, status = 'SENT', updated_at = NOW()
+		)
+		INSERT INTO message_events (message_id, event, from_status, status, actor, provider)
+		SELECT id, 'sent', 'SENDING', 'SENT', $2, $3 FROM done`,
+		messageID, q.workerID, nullString(attempt.Provider), nullString(attempt.ProviderMessageID), pq.Array(attempt.PartIDs))
+	return settled(result, err)
+}
+
+// Fail records a failed send. The message is scheduled for another attempt
+// after decision.Delay, or fails permanently when decision.Retry is false.
+func (q *Queue) Fail(ctx context.Context, messageID uuid.UUID, attempt Attempt, errorMsg string, decision retry.Decision) error {
+	status := messages.StatusFailedPerm
+	var delayMs *int64
+	if decision.Retry {
+		status = messages.StatusFailedTemp
+		ms := decision.Delay.Milliseconds()
+		delayMs = &ms
+	}
+
+	result, err := q.db.ExecContext(ctx, `
+		WITH failed AS (
+			UPDATE messages
+			SET status = $2,
+				attempts = attempts + 1,
+				last_error = $3,
+				retry_after = NOW() + $4::bigint * INTERVAL '1 millisecond',
+				provider = COALESCE($6, provider),
+				provider_message_id = COALESCE($7, provider_message_id),
+				locked_by = NULL,
+				lease_expires_at = NULL,
+				updated_at = NOW()
+			WHERE id = $1 AND status = 'SENDING' AND locked_by = $5
+			RETURNING id
+		)
+		INSERT INTO message_events (message_id, event, from_status, status, actor, provider, detail)
+		SELECT id, 'failed', 'SENDING', $2, $5, $6, $3 FROM failed`,
+		messageID, status, errorMsg, delayMs, q.workerID, nullString(attempt.Provider), nullString(attempt.ProviderMessageID))
+	return settled(result, err)
+}
+
+// settled maps a compare-and-set on a claimed message that matched nothing to ErrLeaseLost
+func settled(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Release gives unprocessed claims back to the queue without counting an attempt
+func (q *Queue) Release(ctx context.Context, messageIDs []uuid.UUID) (int64, error) {
+	if len(messageIDs) == 0 {
+		return 0, nil
+	}
+	result, err := q.db.ExecContext(ctx, `
+		WITH released AS (
+			UPDATE messages
+			SET status = 'QUEUED', locked_by = NULL, lease_expires_at = NULL, updated_at = NOW()
+			WHERE id = ANY($1) AND status = 'SENDING' AND locked_by = $2
+			RETURNING id
+		)
+		INSERT INTO message_events (message_id, event, from_status, status, actor)
+		SELECT id, 'released', 'SENDING', 'QUEUED', $2 FROM released`,
+		pq.Array(uuidStrings(messageIDs)), q.workerID)
+	if err != nil {
+		return 0, err
+	}
+	count, _ := result.RowsAffected()
+	return count, nil
+}
+
+// Reap returns messages whose lease has expired to the queue, counting the
+// lost claim as an attempt. Messages that reach maxAttempts fail permanently.
+func (q *Queue) Reap(ctx context.Context, maxAttempts int) ([]Reaped, error) {
+	rows, err := q.db.QueryContext(ctx, `
+		WITH reaped AS (
+			UPDATE messages
+			SET status = CASE WHEN attempts + 1 >= $1 THEN 'FAILED_PERM' ELSE 'QUEUED' END,
+				attempts = attempts + 1,
+				last_error = 'lease expired (claimed by ' || COALESCE(locked_by, 'unknown') || ')',
+				locked_by = NULL,
+				lease_expires_at = NULL,
+				updated_at = NOW()
+			WHERE id IN (
+				SELECT id FROM messages
+				WHERE status = 'SENDING' AND lease_expires_at <= NOW()
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, status, last_error
+		),
+		events AS (
+			INSERT INTO message_events (message_id, event, from_status, status, actor, detail)
+			SELECT id, 'lease_expired', 'SENDING', status, $2, last_error FROM reaped
+		)
+		SELECT id, status FROM reaped`, maxAttempts, q.workerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reaped []Reaped
+	for rows.Next() {
+		var r Reaped
+		if err := rows.Scan(&r.MessageID, &r.Status); err != nil {
+			return nil, err
+		}
+		reaped = append(reaped, r)
+	}
+	return reaped, rows.Err()
 }
 
 // Retry moves failed messages back to queue
 func (q *Queue) Retry(ctx context.Context) (int64, error) {
 	result, err := q.db.ExecContext(ctx, `
-		UPDATE messages 
-		SET status = 'QUEUED', updated_at = NOW()
-		WHERE status = 'FAILED_TEMP' AND retry_after <= NOW()`)
+		WITH due AS (
+			UPDATE messages
+			SET status = 'QUEUED', updated_at = NOW()
+			WHERE status = 'FAILED_TEMP' AND retry_after <= NOW()
+			RETURNING id
+		)
+		INSERT INTO message_events (message_id, event, from_status, status, actor)
+		SELECT id, 'retry_due', 'FAILED_TEMP', 'QUEUED', $1 FROM due`, q.workerID)
 	if err != nil {
 		return 0, err
 	}
 	count, _ := result.RowsAffected()
 	return count, nil
 }
+
+// nullString maps "" to NULL
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func uuidStrings(ids []uuid.UUID) []string {
+	out := make([]string, len(ids))
+	for i, id := range ids {
+		out[i] = id.String()
+	}
+	return out
+}
+
+// OutboxEntry is a pending notification that a message became QUEUED
+type OutboxEntry struct {
+	ID        int64
+	MessageID uuid.UUID
+	Priority  messages.Priority
+}
+
+// pausedClause is true when a message of the client in clientColumn is held
+// back by a pause. $4 must be the worker's provider.
+func pausedClause(clientColumn string) string {
+	return `EXISTS (
+				SELECT 1 FROM send_pauses p
+				WHERE p.scope = 'global'
+					OR (p.scope = 'client' AND p.target = ` + clientColumn + `::text)
+					OR (p.scope = 'provider' AND p.target = $4)
+			)`
+}
+
+// Claim moves the given messages from QUEUED to SENDING under this worker's
+// lease. Messages that are no longer QUEUED or are held back by a pause are
+// skipped.
+func (q *Queue) Claim(ctx context.Context, messageIDs []uuid.UUID) ([]*messages.Message, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+	rows, err := q.db.QueryContext(ctx, `
+		WITH updated AS (
+			UPDATE messages
+			SET status = 'SENDING', locked_by = $2,
+				lease_expires_at = NOW() + $3 * INTERVAL '1 millisecond',
+				provider = COALESCE($4, provider), updated_at = NOW()
+			WHERE id = ANY($1) AND status = 'QUEUED'
+				AND NOT `+pausedClause("messages.client_id")+`
+			RETURNING id, client_id, to_msisdn, from_sender, text, parts,
+					  client_reference, express, priority, attempts, trace_parent
+		),
+		events AS (
+			INSERT INTO message_events (message_id, event, from_status, status, actor)
+			SELECT id, 'claimed', 'QUEUED', 'SENDING', $2 FROM updated
+		)
+		SELECT * FROM updated`,
+		pq.Array(uuidStrings(messageIDs)), q.workerID, q.leaseTTL.Milliseconds(), nullString(q.provider))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []*messages.Message
+	for rows.Next() {
+		msg := &messages.Message{Status: messages.StatusSending}
+		if err := rows.Scan(&msg.ID, &msg.ClientID, &msg.To, &msg.From, &msg.Text, &msg.Parts,
+			&msg.Reference, &msg.Express, &msg.Priority, &msg.Attempts, &msg.TraceParent); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, rows.Err()
+}
+
+// Queued returns those of messageIDs that are still QUEUED, e.g. because a
+// pause kept Claim from taking them
+func (q *Queue) Queued(ctx context.Context, messageIDs []uuid.UUID) ([]uuid.UUID, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+	rows, err := q.db.QueryContext(ctx, `SELECT id FROM messages WHERE id = ANY($1) AND status = 'QUEUED'`,
+		pq.Array(uuidStrings(messageIDs)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// PendingOutbox returns the oldest outbox entries not yet published
+func (q *Queue) PendingOutbox(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	rows, err := q.db.QueryContext(ctx,
+		`SELECT id, message_id, priority FROM queue_outbox ORDER BY id LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []OutboxEntry
+	for rows.Next() {
+		var e OutboxEntry
+		if err := rows.Scan(&e.ID, &e.MessageID, &e.Priority); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// DeleteOutbox removes entries once they have been published
+func (q *Queue) DeleteOutbox(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := q.db.ExecContext(ctx, `DELETE FROM queue_outbox WHERE id = ANY($1)`, pq.Array(ids))
+	return err
+}
+
+// EnableOutbox switches the outbox trigger on or off. When it is first
+// switched on, messages already QUEUED are backfilled into the outbox.
+func (q *Queue) EnableOutbox(ctx context.Context, enabled bool) error {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE queue_settings SET outbox_enabled = $1 WHERE outbox_enabled <> $1`, enabled)
+	if err != nil {
+		return err
+	}
+	if changed, _ := result.RowsAffected(); changed > 0 && enabled {
+		_, err = tx.ExecContext(ctx, `INSERT INTO queue_outbox (message_id, priority)
+			SELECT id, priority FROM messages WHERE status = 'QUEUED' ORDER BY priority_rank, created_at`)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
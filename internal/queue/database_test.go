@@ -0,0 +1,176 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"sms-gateway/internal/db"
+	"sms-gateway/internal/messages"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newTestQueueDB connects to the database in TEST_DATABASE_URL, migrated to
+// the latest schema, and adds a client to queue messages for. Tests using it
+// are skipped when it is unset.
+func newTestQueueDB(t *testing.T) (*messages.Store, uuid.UUID) {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	pg, err := db.NewPostgres(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pg.Close() })
+	if err := pg.RunMigrations("../../migrations"); err != nil {
+		t.Fatal(err)
+	}
+
+	var clientID uuid.UUID
+	if err := pg.QueryRowContext(ctx, `INSERT INTO clients (name) VALUES ($1) RETURNING id`, t.Name()).Scan(&clientID); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pg.ExecContext(ctx, `DELETE FROM message_events WHERE message_id IN (SELECT id FROM messages WHERE client_id = $1)`, clientID)
+		pg.ExecContext(ctx, `DELETE FROM messages WHERE client_id = $1`, clientID)
+		pg.ExecContext(ctx, `DELETE FROM clients WHERE id = $1`, clientID)
+	})
+	return messages.NewStore(pg, slog.New(slog.NewTextHandler(io.Discard, nil))), clientID
+}
+
+func newTestQueueMessage(t *testing.T, store *messages.Store, clientID uuid.UUID, attempts int) uuid.UUID {
+	t.Helper()
+	msg := &messages.Message{ID: uuid.New(), ClientID: clientID, To: "+15551234567", From: "TEST", Text: "hi",
+		Parts: 1, Status: messages.StatusQueued, Priority: messages.PriorityStandard, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if err := store.Create(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.DB().Exec(`UPDATE messages SET attempts = $2 WHERE id = $1`, msg.ID, attempts); err != nil {
+		t.Fatal(err)
+	}
+	return msg.ID
+}
+
+// claimedOnly polls until the claim of id is among those taken, releasing
+// the rest so other tests' messages are left alone
+func claimedOnly(t *testing.T, q *Queue, id uuid.UUID) {
+	t.Helper()
+	ctx := context.Background()
+	msgs, err := q.Poll(ctx, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var others []uuid.UUID
+	found := false
+	for _, msg := range msgs {
+		if msg.ID == id {
+			found = true
+		} else {
+			others = append(others, msg.ID)
+		}
+	}
+	q.Release(ctx, others)
+	if !found {
+		t.Fatalf("Expected %s to be claimed", id)
+	}
+}
+
+func TestQueueLeaseExpiry(t *testing.T) {
+	ctx := context.Background()
+	store, clientID := newTestQueueDB(t)
+	id := newTestQueueMessage(t, store, clientID, 0)
+
+	dead := New(store, slog.Default(), "dead-"+uuid.NewString(), "mock", 50*time.Millisecond)
+	live := New(store, slog.Default(), "live-"+uuid.NewString(), "mock", time.Minute)
+	claimedOnly(t, dead, id)
+
+	// Nothing is reaped while the lease holds
+	reaped, err := live.Reap(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range reaped {
+		if r.MessageID == id {
+			t.Fatalf("Expected a live lease to be left alone, got %v", r)
+		}
+	}
+
+	// Once the dead worker's lease runs out its claim goes back to the queue
+	time.Sleep(100 * time.Millisecond)
+	if reaped, err = live.Reap(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	if !containsReaped(reaped, id, messages.StatusQueued) {
+		t.Fatalf("Expected the expired claim to be requeued, got %v", reaped)
+	}
+	msg, _ := store.GetByID(ctx, id)
+	if msg.Status != messages.StatusQueued || msg.Attempts != 1 {
+		t.Errorf("Expected QUEUED with 1 attempt, got %s with %d", msg.Status, msg.Attempts)
+	}
+
+	// The dead worker can no longer settle it
+	if err := dead.Complete(ctx, id, Attempt{}); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost after reaping, got %v", err)
+	}
+}
+
+func TestQueueReapMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	store, clientID := newTestQueueDB(t)
+	id := newTestQueueMessage(t, store, clientID, 2)
+
+	q := New(store, slog.Default(), "dead-"+uuid.NewString(), "mock", time.Millisecond)
+	claimedOnly(t, q, id)
+	time.Sleep(10 * time.Millisecond)
+
+	// attempts + 1 reaches the maximum, so the claim fails for good
+	reaped, err := q.Reap(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsReaped(reaped, id, messages.StatusFailedPerm) {
+		t.Fatalf("Expected the claim to fail permanently, got %v", reaped)
+	}
+	msg, _ := store.GetByID(ctx, id)
+	if msg.Status != messages.StatusFailedPerm || msg.Attempts != 3 || msg.LastError == nil {
+		t.Errorf("Expected FAILED_PERM with 3 attempts and an error, got %s with %d", msg.Status, msg.Attempts)
+	}
+}
+
+func TestQueueRelease(t *testing.T) {
+	ctx := context.Background()
+	store, clientID := newTestQueueDB(t)
+	id := newTestQueueMessage(t, store, clientID, 0)
+
+	owner := New(store, slog.Default(), "owner-"+uuid.NewString(), "mock", time.Minute)
+	other := New(store, slog.Default(), "other-"+uuid.NewString(), "mock", time.Minute)
+	claimedOnly(t, owner, id)
+
+	// Only the lease holder may give a claim back
+	if n, err := other.Release(ctx, []uuid.UUID{id}); err != nil || n != 0 {
+		t.Fatalf("Expected a foreign release to do nothing, got %d, %v", n, err)
+	}
+	if n, err := owner.Release(ctx, []uuid.UUID{id}); err != nil || n != 1 {
+		t.Fatalf("Expected the claim to be released, got %d, %v", n, err)
+	}
+	msg, _ := store.GetByID(ctx, id)
+	if msg.Status != messages.StatusQueued || msg.Attempts != 0 {
+		t.Errorf("Expected QUEUED with no attempt counted, got %s with %d", msg.Status, msg.Attempts)
+	}
+}
+
+func containsReaped(reaped []Reaped, id uuid.UUID, status messages.Status) bool {
+	for _, r := range reaped {
+		if r.MessageID == id && r.Status == status {
+			return true
+		}
+	}
+	return false
+}
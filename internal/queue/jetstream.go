@@ -0,0 +1,315 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sms-gateway/internal/messages"
+	"sms-gateway/internal/retry"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	streamName    = "SMS_QUEUE"
+	subjectPrefix = "sms.queue."
+
+	// heldRedelivery is how soon notifications for messages held back by a
+	// pause come round again
+	heldRedelivery = 5 * time.Second
+)
+
+// State is the Postgres side of the JetStream backend. Message state and the
+// transactional outbox live in the database; the stream only carries
+// dispatch notifications, so DB state stays the source of truth.
+type State interface {
+	Backend
+	Claim(ctx context.Context, messageIDs []uuid.UUID) ([]*messages.Message, error)
+	Queued(ctx context.Context, messageIDs []uuid.UUID) ([]uuid.UUID, error)
+	PendingOutbox(ctx context.Context, limit int) ([]OutboxEntry, error)
+	DeleteOutbox(ctx context.Context, ids []int64) error
+	EnableOutbox(ctx context.Context, enabled bool) error
+}
+
+// JetStream dispatches queued messages through a NATS JetStream work queue.
+// A relay publishes outbox rows to the stream (de-duplicated by outbox ID),
+// and Poll turns stream messages into DB claims. Stream messages are acked
+// once the claim reaches a final state or is handed back to the database.
+type JetStream struct {
+	state     State
+	js        jetstream.JetStream
+	consumers []jetstream.Consumer // Most urgent priority first
+	logger    *slog.Logger
+
+	mu       sync.Mutex
+	inflight map[uuid.UUID]jetstream.Msg
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewJetStream sets up the stream and one durable consumer per priority
+// class, switches the outbox on and starts the relay. Unacked notifications
+// are redelivered after ackWait.
+func NewJetStream(ctx context.Context, nc *nats.Conn, state State, logger *slog.Logger, ackWait, relayInterval time.Duration) (*JetStream, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       streamName,
+		Subjects:   []string{subjectPrefix + ">"},
+		Retention:  jetstream.WorkQueuePolicy,
+		Storage:    jetstream.FileStorage,
+		Duplicates: 2 * time.Minute,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+
+	j := &JetStream{
+		state:    state,
+		js:       js,
+		logger:   logger,
+		inflight: make(map[uuid.UUID]jetstream.Msg),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	for _, class := range messages.Priorities {
+		cons, err := js.CreateOrUpdateConsumer(ctx, streamName, jetstream.ConsumerConfig{
+			Durable:       "sms-worker-" + string(class),
+			FilterSubject: subjectPrefix + string(class),
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			AckWait:       ackWait,
+			MaxAckPending: 10000,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create consumer for %s: %w", class, err)
+		}
+		j.consumers = append(j.consumers, cons)
+	}
+
+	if err := state.EnableOutbox(ctx, true); err != nil {
+		return nil, fmt.Errorf("failed to enable outbox: %w", err)
+	}
+
+	go j.relayLoop(relayInterval)
+	return j, nil
+}
+
+// Close stops the outbox relay
+func (j *JetStream) Close() {
+	close(j.stop)
+	<-j.done
+}
+
+func (j *JetStream) relayLoop(interval time.Duration) {
+	defer close(j.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			if _, err := j.Relay(context.Background()); err != nil {
+				j.logger.Error("Outbox relay failed", "error", err)
+			}
+		}
+	}
+}
+
+// Relay publishes pending outbox entries and deletes the ones the stream
+// acknowledged. Entries are published with their outbox ID as the message
+// ID, so a relay that dies before deleting cannot create duplicates.
+func (j *JetStream) Relay(ctx context.Context) (int, error) {
+	entries, err := j.state.PendingOutbox(ctx, 500)
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+
+	published := make([]int64, 0, len(entries))
+	for _, e := range entries {
+		_, err := j.js.Publish(ctx, subjectPrefix+string(e.Priority), []byte(e.MessageID.String()),
+			jetstream.WithMsgID(strconv.FormatInt(e.ID, 10)))
+		if err != nil {
+			j.logger.Error("Failed to publish outbox entry", "error", err, "message", e.MessageID)
+			break
+		}
+		published = append(published, e.ID)
+	}
+
+	if err := j.state.DeleteOutbox(ctx, published); err != nil {
+		return 0, err
+	}
+	return len(published), nil
+}
+
+func (j *JetStream) WorkerID() string {
+	return j.state.WorkerID()
+}
+
+// Poll fetches notifications, most urgent class first, and claims the
+// corresponding rows. Notifications for rows that are no longer QUEUED
+// (duplicates, cancelled or deleted messages) are acked and dropped; those
+// for rows a pause kept QUEUED are redelivered later.
+func (j *JetStream) Poll(ctx context.Context, limit int) ([]*messages.Message, error) {
+	fetched := make(map[uuid.UUID]jetstream.Msg)
+	var ids []uuid.UUID
+
+	for _, cons := range j.consumers {
+		if len(fetched) >= limit {
+			break
+		}
+		batch, err := cons.FetchNoWait(limit - len(fetched))
+		if err != nil {
+			return nil, err
+		}
+		for msg := range batch.Messages() {
+			id, err := uuid.ParseBytes(msg.Data())
+			if err != nil {
+				j.logger.Warn("Dropping malformed queue notification", "subject", msg.Subject())
+				msg.Term()
+				continue
+			}
+			if prev, dup := fetched[id]; dup {
+				prev.Ack()
+			} else {
+				ids = append(ids, id)
+			}
+			fetched[id] = msg
+		}
+		if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+			return nil, err
+		}
+	}
+
+	claimed, err := j.state.Claim(ctx, ids)
+	if err != nil {
+		// Leave the notifications unacked so they are redelivered
+		for _, msg := range fetched {
+			msg.Nak()
+		}
+		return nil, err
+	}
+
+	j.mu.Lock()
+	for _, msg := range claimed {
+		j.inflight[msg.ID] = fetched[msg.ID]
+		delete(fetched, msg.ID)
+	}
+	j.mu.Unlock()
+
+	if len(fetched) > 0 {
+		left := make([]uuid.UUID, 0, len(fetched))
+		for id := range fetched {
+			left = append(left, id)
+		}
+		held, err := j.state.Queued(ctx, left)
+		if err != nil {
+			j.logger.WarnContext(ctx, "Failed to check unclaimed notifications, redelivering them", "error", err)
+			held = left
+		}
+		for _, id := range held {
+			if msg, ok := fetched[id]; ok {
+				msg.NakWithDelay(heldRedelivery)
+				delete(fetched, id)
+			}
+		}
+	}
+	for _, stale := range fetched {
+		stale.Ack()
+	}
+	return claimed, nil
+}
+
+func (j *JetStream) Complete(ctx context.Context, messageID uuid.UUID, attempt Attempt) error {
+	err := j.state.Complete(ctx, messageID, attempt)
+	j.settle(messageID, err)
+	return err
+}
+
+func (j *JetStream) Fail(ctx context.Context, messageID uuid.UUID, attempt Attempt, errorMsg string, decision retry.Decision) error {
+	// A retry goes back through QUEUED and the outbox, so this notification is done
+	err := j.state.Fail(ctx, messageID, attempt, errorMsg, decision)
+	j.settle(messageID, err)
+	return err
+}
+
+func (j *JetStream) Release(ctx context.Context, messageIDs []uuid.UUID) (int64, error) {
+	n, err := j.state.Release(ctx, messageIDs)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range messageIDs {
+		j.ack(id)
+	}
+	return n, nil
+}
+
+// Reap also drops the notifications of this worker's own claims that were
+// reaped; going back to QUEUED gives them a new one
+func (j *JetStream) Reap(ctx context.Context, maxAttempts int) ([]Reaped, error) {
+	reaped, err := j.state.Reap(ctx, maxAttempts)
+	for _, r := range reaped {
+		j.settle(r.MessageID, ErrLeaseLost)
+	}
+	return reaped, err
+}
+
+func (j *JetStream) Retry(ctx context.Context) (int64, error) {
+	return j.state.Retry(ctx)
+}
+
+func (j *JetStream) Weights(ctx context.Context) (map[uuid.UUID]int, error) {
+	return j.state.Weights(ctx)
+}
+
+// settle finishes the notification of a claim once the database has the
+// outcome of err. A lost lease means the claim was reaped or taken over, and
+// the database notifies its new state itself, so the notification is
+// terminated. After any other error it is redelivered and sorted out by Poll.
+func (j *JetStream) settle(messageID uuid.UUID, err error) {
+	switch {
+	case err == nil:
+		j.ack(messageID)
+	case errors.Is(err, ErrLeaseLost):
+		if msg, ok := j.take(messageID); ok {
+			if err := msg.Term(); err != nil {
+				j.logger.Warn("Failed to terminate queue notification", "error", err, "message", messageID)
+			}
+		}
+	default:
+		if msg, ok := j.take(messageID); ok {
+			if err := msg.Nak(); err != nil {
+				j.logger.Warn("Failed to nak queue notification", "error", err, "message", messageID)
+			}
+		}
+	}
+}
+
+func (j *JetStream) ack(messageID uuid.UUID) {
+	if msg, ok := j.take(messageID); ok {
+		if err := msg.Ack(); err != nil {
+			j.logger.Warn("Failed to ack queue notification", "error", err, "message", messageID)
+		}
+	}
+}
+
+// take removes and returns the notification of an in-flight claim
+func (j *JetStream) take(messageID uuid.UUID) (jetstream.Msg, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	msg, ok := j.inflight[messageID]
+	delete(j.inflight, messageID)
+	return msg, ok
+}
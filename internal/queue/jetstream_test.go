@@ -0,0 +1,383 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sms-gateway/internal/messages"
+	"sms-gateway/internal/retry"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// fakeState keeps message state and the outbox in memory, mirroring the
+// Postgres trigger: every transition into QUEUED appends an outbox entry
+type fakeState struct {
+	mu         sync.Mutex
+	msgs       map[uuid.UUID]*messages.Message
+	outbox     []OutboxEntry
+	nextID     int64
+	failDelete int                // Number of upcoming DeleteOutbox calls that should fail
+	held       map[uuid.UUID]bool // Messages a pause keeps from being claimed
+	reaped     []Reaped           // Returned by the next Reap
+}
+
+func newFakeState() *fakeState {
+	return &fakeState{msgs: make(map[uuid.UUID]*messages.Message), held: make(map[uuid.UUID]bool)}
+}
+
+func (f *fakeState) enqueue(msg *messages.Message) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	msg.Status = messages.StatusQueued
+	f.msgs[msg.ID] = msg
+	f.nextID++
+	f.outbox = append(f.outbox, OutboxEntry{ID: f.nextID, MessageID: msg.ID, Priority: msg.Priority})
+}
+
+func (f *fakeState) status(id uuid.UUID) messages.Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.msgs[id].Status
+}
+
+func (f *fakeState) WorkerID() string { return "test-worker" }
+
+func (f *fakeState) Poll(ctx context.Context, limit int) ([]*messages.Message, error) {
+	return nil, errors.New("not used")
+}
+
+func (f *fakeState) Claim(ctx context.Context, ids []uuid.UUID) ([]*messages.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []*messages.Message
+	for _, id := range ids {
+		if msg, ok := f.msgs[id]; ok && msg.Status == messages.StatusQueued && !f.held[id] {
+			msg.Status = messages.StatusSending
+			copied := *msg
+			out = append(out, &copied)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeState) Queued(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []uuid.UUID
+	for _, id := range ids {
+		if msg, ok := f.msgs[id]; ok && msg.Status == messages.StatusQueued {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeState) Complete(ctx context.Context, id uuid.UUID, attempt Attempt) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.msgs[id].Status != messages.StatusSending {
+		return ErrLeaseLost
+	}
+	f.msgs[id].Status = messages.StatusSent
+	return nil
+}
+
+func (f *fakeState) Fail(ctx context.Context, id uuid.UUID, attempt Attempt, errorMsg string, decision retry.Decision) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.msgs[id].Status = messages.StatusFailedTemp
+	return nil
+}
+
+func (f *fakeState) Release(ctx context.Context, ids []uuid.UUID) (int64, error) {
+	var n int64
+	for _, id := range ids {
+		if f.status(id) == messages.StatusSending {
+			f.enqueue(f.msgs[id])
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeState) Reap(ctx context.Context, maxAttempts int) ([]Reaped, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reaped := f.reaped
+	f.reaped = nil
+	return reaped, nil
+}
+
+func (f *fakeState) Retry(ctx context.Context) (int64, error)               { return 0, nil }
+func (f *fakeState) Weights(ctx context.Context) (map[uuid.UUID]int, error) { return nil, nil }
+func (f *fakeState) EnableOutbox(ctx context.Context, enabled bool) error   { return nil }
+
+func (f *fakeState) PendingOutbox(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]OutboxEntry(nil), f.outbox...), nil
+}
+
+func (f *fakeState) DeleteOutbox(ctx context.Context, ids []int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failDelete > 0 {
+		f.failDelete--
+		return errors.New("delete failed")
+	}
+	done := make(map[int64]bool)
+	for _, id := range ids {
+		done[id] = true
+	}
+	kept := f.outbox[:0]
+	for _, e := range f.outbox {
+		if !done[e.ID] {
+			kept = append(kept, e)
+		}
+	}
+	f.outbox = kept
+	return nil
+}
+
+func startJetStream(t *testing.T) (*JetStream, *fakeState) {
+	t.Helper()
+
+	srv, err := server.NewServer(&server.Options{Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats-server not ready")
+	}
+	t.Cleanup(srv.Shutdown)
+
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+
+	state := newFakeState()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	js, err := NewJetStream(context.Background(), nc, state, logger, 30*time.Second, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(js.Close)
+	return js, state
+}
+
+func newQueuedMessage(priority messages.Priority) *messages.Message {
+	return &messages.Message{ID: uuid.New(), ClientID: uuid.New(), Priority: priority}
+}
+
+func streamMsgs(t *testing.T, js *JetStream) uint64 {
+	t.Helper()
+	stream, err := js.js.Stream(context.Background(), streamName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := stream.Info(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.State.Msgs
+}
+
+func TestJetStreamRoundTrip(t *testing.T) {
+	js, state := startJetStream(t)
+	ctx := context.Background()
+
+	msg := newQueuedMessage(messages.PriorityStandard)
+	state.enqueue(msg)
+
+	if n, err := js.Relay(ctx); err != nil || n != 1 {
+		t.Fatalf("Relay() = %d, %v; want 1 published", n, err)
+	}
+
+	claimed, err := js.Poll(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != msg.ID {
+		t.Fatalf("Expected to claim %s, got %v", msg.ID, claimed)
+	}
+	if state.status(msg.ID) != messages.StatusSending {
+		t.Errorf("Expected SENDING in DB, got %s", state.status(msg.ID))
+	}
+
+	if err := js.Complete(ctx, msg.ID, Attempt{Provider: "mock"}); err != nil {
+		t.Fatal(err)
+	}
+	if state.status(msg.ID) != messages.StatusSent {
+		t.Errorf("Expected SENT in DB, got %s", state.status(msg.ID))
+	}
+
+	// Work-queue retention drops the notification once it is acked
+	deadline := time.Now().Add(2 * time.Second)
+	for streamMsgs(t, js) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Acked notification still in stream")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJetStreamRelayDeduplicates(t *testing.T) {
+	js, state := startJetStream(t)
+	ctx := context.Background()
+
+	msg := newQueuedMessage(messages.PriorityStandard)
+	state.enqueue(msg)
+
+	// The relay publishes but dies before deleting the outbox row...
+	state.failDelete = 1
+	if _, err := js.Relay(ctx); err == nil {
+		t.Fatal("Expected relay error")
+	}
+	// ...so the next run publishes the same entry again
+	if _, err := js.Relay(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := streamMsgs(t, js); n != 1 {
+		t.Errorf("Expected 1 message in stream after duplicate publish, got %d", n)
+	}
+}
+
+func TestJetStreamPriorityAndStale(t *testing.T) {
+	js, state := startJetStream(t)
+	ctx := context.Background()
+
+	bulk := newQueuedMessage(messages.PriorityBulk)
+	otp := newQueuedMessage(messages.PriorityOTP)
+	stale := newQueuedMessage(messages.PriorityStandard)
+	state.enqueue(bulk)
+	state.enqueue(stale)
+	state.enqueue(otp)
+	if _, err := js.Relay(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another worker already sent this one; its notification must be dropped
+	state.mu.Lock()
+	state.msgs[stale.ID].Status = messages.StatusSent
+	state.mu.Unlock()
+
+	claimed, err := js.Poll(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != otp.ID {
+		t.Fatalf("Expected OTP message first, got %v", claimed)
+	}
+
+	claimed, err = js.Poll(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != bulk.ID {
+		t.Fatalf("Expected only the bulk message, got %v", claimed)
+	}
+}
+
+func TestJetStreamReleaseRepublishes(t *testing.T) {
+	js, state := startJetStream(t)
+	ctx := context.Background()
+
+	msg := newQueuedMessage(messages.PriorityStandard)
+	state.enqueue(msg)
+	js.Relay(ctx)
+
+	if claimed, _ := js.Poll(ctx, 10); len(claimed) != 1 {
+		t.Fatal("Expected a claim")
+	}
+	if n, err := js.Release(ctx, []uuid.UUID{msg.ID}); err != nil || n != 1 {
+		t.Fatalf("Release() = %d, %v", n, err)
+	}
+
+	// Going back to QUEUED writes a new outbox row, which is relayed again
+	if n, err := js.Relay(ctx); err != nil || n != 1 {
+		t.Fatalf("Relay() = %d, %v; want 1 republished", n, err)
+	}
+	claimed, err := js.Poll(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != msg.ID {
+		t.Fatalf("Expected released message to be claimable again, got %v", claimed)
+	}
+}
+
+func TestJetStreamKeepsHeldNotifications(t *testing.T) {
+	js, state := startJetStream(t)
+	ctx := context.Background()
+
+	msg := newQueuedMessage(messages.PriorityStandard)
+	state.enqueue(msg)
+	state.held[msg.ID] = true
+	js.Relay(ctx)
+
+	claimed, err := js.Poll(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 0 {
+		t.Fatalf("Expected a paused message not to be claimed, got %v", claimed)
+	}
+
+	// The notification is nacked for later rather than dropped
+	time.Sleep(100 * time.Millisecond)
+	if n := streamMsgs(t, js); n != 1 {
+		t.Errorf("Expected the held notification to stay in the stream, got %d messages", n)
+	}
+}
+
+func TestJetStreamDropsLostClaims(t *testing.T) {
+	js, state := startJetStream(t)
+	ctx := context.Background()
+
+	takenOver := newQueuedMessage(messages.PriorityStandard)
+	reaped := newQueuedMessage(messages.PriorityStandard)
+	state.enqueue(takenOver)
+	state.enqueue(reaped)
+	js.Relay(ctx)
+	if claimed, _ := js.Poll(ctx, 10); len(claimed) != 2 {
+		t.Fatalf("Expected 2 claims, got %d", len(claimed))
+	}
+
+	// Another replica's reaper took this claim back before the send settled
+	state.mu.Lock()
+	state.msgs[takenOver.ID].Status = messages.StatusQueued
+	state.mu.Unlock()
+	if err := js.Complete(ctx, takenOver.ID, Attempt{}); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("Expected ErrLeaseLost, got %v", err)
+	}
+
+	// This worker's own reaper took back the other one
+	state.mu.Lock()
+	state.reaped = []Reaped{{MessageID: reaped.ID, Status: messages.StatusQueued}}
+	state.mu.Unlock()
+	if _, err := js.Reap(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	js.mu.Lock()
+	left := len(js.inflight)
+	js.mu.Unlock()
+	if left != 0 {
+		t.Errorf("Expected no notifications left in flight, got %d", left)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := streamMsgs(t, js); n != 0 {
+		t.Errorf("Expected the lost claims' notifications to leave the stream, got %d messages", n)
+	}
+}
@@ -0,0 +1,367 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sms-gateway/internal/messages"
+	"sms-gateway/internal/ops"
+	"sms-gateway/internal/retry"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryTable holds the leases of the messages table, client queue weights and
+// send pauses for a MemoryStore, which keeps the retry times itself. Queues
+// created from the same table behave like workers sharing one database: every
+// operation runs under the store's lock, so a message is only ever claimed by
+// one of them.
+type MemoryTable struct {
+	store *messages.MemoryStore
+
+	// Guarded by the store lock, i.e. only touched inside store.Mutate
+	leases map[uuid.UUID]memoryLease
+
+	mu      sync.Mutex
+	weights map[uuid.UUID]int
+	pauses  ops.Pauses
+}
+
+var _ ops.Controls = (*MemoryTable)(nil)
+
+type memoryLease struct {
+	owner   string
+	expires time.Time
+}
+
+func NewMemoryTable(store *messages.MemoryStore) *MemoryTable {
+	return &MemoryTable{
+		store:   store,
+		leases:  make(map[uuid.UUID]memoryLease),
+		weights: make(map[uuid.UUID]int),
+	}
+}
+
+// SetWeight sets a client's queue weight, like clients.queue_weight
+func (t *MemoryTable) SetWeight(clientID uuid.UUID, weight int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.weights[clientID] = weight
+}
+
+// LockedBy returns the worker holding the lease on a message, if any
+func (t *MemoryTable) LockedBy(messageID uuid.UUID) string {
+	var owner string
+	t.store.Mutate(func(*messages.MemoryTx) {
+		owner = t.leases[messageID].owner
+	})
+	return owner
+}
+
+// Queue returns a Backend that claims from this table as workerID, sending
+// through provider
+func (t *MemoryTable) Queue(workerID, provider string, leaseTTL time.Duration) *Memory {
+	return &Memory{table: t, workerID: workerID, provider: provider, leaseTTL: leaseTTL}
+}
+
+// Pauses lists the pauses in force, like the send_pauses table
+func (t *MemoryTable) Pauses(ctx context.Context) (ops.Pauses, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append(ops.Pauses(nil), t.pauses...), nil
+}
+
+func (t *MemoryTable) Pause(ctx context.Context, p ops.Pause) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if existing := t.pauses.Find(p.Scope, p.Target); existing != nil {
+		*existing = p
+		return nil
+	}
+	t.pauses = append(t.pauses, p)
+	return nil
+}
+
+func (t *MemoryTable) Resume(ctx context.Context, scope ops.Scope, target string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, p := range t.pauses {
+		if p.Scope == scope && p.Target == target {
+			t.pauses = append(t.pauses[:i], t.pauses[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (t *MemoryTable) InFlight(ctx context.Context, provider string) (int64, error) {
+	var n int64
+	t.store.Mutate(func(tx *messages.MemoryTx) {
+		for _, msg := range tx.Messages {
+			if msg.Status == messages.StatusSending && msg.Provider != nil && *msg.Provider == provider {
+				n++
+			}
+		}
+	})
+	return n, nil
+}
+
+// holds reports whether a pause keeps a worker sending through provider
+// from claiming clientID's messages
+func (t *MemoryTable) holds(clientID uuid.UUID, provider string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pauses.Holds(clientID, provider)
+}
+
+func (t *MemoryTable) weight(clientID uuid.UUID) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if w, ok := t.weights[clientID]; ok {
+		return w
+	}
+	return 1
+}
+
+// Memory is an in-memory Backend with the same claim, lease and retry
+// semantics as Queue, for hermetic tests
+type Memory struct {
+	table    *MemoryTable
+	workerID string
+	provider string
+	leaseTTL time.Duration
+}
+
+func (q *Memory) WorkerID() string {
+	return q.workerID
+}
+
+// Poll claims up to limit QUEUED messages in the same fair order as Queue.Poll
+func (q *Memory) Poll(ctx context.Context, limit int) ([]*messages.Message, error) {
+	type candidate struct {
+		msg  *messages.Message
+		turn float64
+	}
+
+	var claimed []*messages.Message
+	q.table.store.Mutate(func(tx *messages.MemoryTx) {
+		byClient := make(map[uuid.UUID][]*messages.Message)
+		for _, msg := range tx.Messages {
+			if msg.Status == messages.StatusQueued && !q.table.holds(msg.ClientID, q.provider) {
+				byClient[msg.ClientID] = append(byClient[msg.ClientID], msg)
+			}
+		}
+
+		var candidates []candidate
+		for clientID, queued := range byClient {
+			sort.Slice(queued, func(i, j int) bool { return queuedBefore(queued[i], queued[j]) })
+			weight := float64(q.table.weight(clientID))
+			for i, msg := range queued {
+				candidates = append(candidates, candidate{msg: msg, turn: float64(i) / weight})
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			a, b := candidates[i], candidates[j]
+			if a.msg.Priority.Rank() != b.msg.Priority.Rank() {
+				return a.msg.Priority.Rank() < b.msg.Priority.Rank()
+			}
+			if a.turn != b.turn {
+				return a.turn < b.turn
+			}
+			return a.msg.CreatedAt.Before(b.msg.CreatedAt)
+		})
+
+		for _, c := range candidates {
+			if len(claimed) >= limit {
+				break
+			}
+			claimed = append(claimed, q.claim(tx, c.msg))
+		}
+	})
+	return claimed, nil
+}
+
+// Claim moves the given messages from QUEUED to SENDING under this worker's
+// lease, skipping those held back by a pause
+func (q *Memory) Claim(ctx context.Context, messageIDs []uuid.UUID) ([]*messages.Message, error) {
+	var claimed []*messages.Message
+	q.table.store.Mutate(func(tx *messages.MemoryTx) {
+		for _, id := range messageIDs {
+			msg, ok := tx.Messages[id]
+			if ok && msg.Status == messages.StatusQueued && !q.table.holds(msg.ClientID, q.provider) {
+				claimed = append(claimed, q.claim(tx, msg))
+			}
+		}
+	})
+	return claimed, nil
+}
+
+// Queued returns those of messageIDs that are still QUEUED
+func (q *Memory) Queued(ctx context.Context, messageIDs []uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	q.table.store.Mutate(func(tx *messages.MemoryTx) {
+		for _, id := range messageIDs {
+			if msg, ok := tx.Messages[id]; ok && msg.Status == messages.StatusQueued {
+				ids = append(ids, id)
+			}
+		}
+	})
+	return ids, nil
+}
+
+// claim leases msg to this worker and returns a copy. Caller holds the store lock.
+func (q *Memory) claim(tx *messages.MemoryTx, msg *messages.Message) *messages.Message {
+	now := time.Now()
+	if q.provider != "" {
+		provider := q.provider
+		msg.Provider = &provider
+	}
+	q.move(tx, msg, messages.StatusSending, messages.Event{Event: messages.EventClaimed})
+	q.table.leases[msg.ID] = memoryLease{owner: q.workerID, expires: now.Add(q.leaseTTL)}
+	copied := *msg
+	return &copied
+}
+
+// move changes msg's status and records the transition. Caller holds the store lock.
+func (q *Memory) move(tx *messages.MemoryTx, msg *messages.Message, status messages.Status, ev messages.Event) {
+	from := msg.Status
+	msg.Status = status
+	msg.UpdatedAt = time.Now()
+
+	ev.MessageID = msg.ID
+	ev.FromStatus = &from
+	ev.Status = status
+	if ev.Actor == "" {
+		ev.Actor = q.workerID
+	}
+	tx.AppendEvent(ev)
+}
+
+// owned reports whether msg is SENDING under this worker's lease. Caller holds the store lock.
+func (q *Memory) owned(msg *messages.Message) bool {
+	return msg.Status == messages.StatusSending && q.table.leases[msg.ID].owner == q.workerID
+}
+
+func (q *Memory) Complete(ctx context.Context, messageID uuid.UUID, attempt Attempt) error {
+	err := ErrLeaseLost
+	q.table.store.Mutate(func(tx *messages.MemoryTx) {
+		if msg, ok := tx.Messages[messageID]; ok && q.owned(msg) {
+			err = nil
+			provider := nullString(attempt.Provider)
+			if provider != nil {
+				msg.Provider = provider
+			}
+			if id := nullString(attempt.ProviderMessageID); id != nil {
+				msg.ProviderMessageID = id
+			}
+			tx.SaveParts(messageID, attempt.PartIDs)
+			q.move(tx, msg, messages.StatusSent, messages.Event{Event: messages.EventSent, Provider: provider})
+			delete(q.table.leases, messageID)
+		}
+	})
+	return err
+}
+
+func (q *Memory) Fail(ctx context.Context, messageID uuid.UUID, attempt Attempt, errorMsg string, decision retry.Decision) error {
+	err := ErrLeaseLost
+	q.table.store.Mutate(func(tx *messages.MemoryTx) {
+		msg, ok := tx.Messages[messageID]
+		if !ok || !q.owned(msg) {
+			return
+		}
+		err = nil
+		status := messages.StatusFailedPerm
+		delete(tx.RetryAfter, messageID)
+		if decision.Retry {
+			status = messages.StatusFailedTemp
+			tx.RetryAfter[messageID] = time.Now().Add(decision.Delay)
+		}
+		provider := nullString(attempt.Provider)
+		if provider != nil {
+			msg.Provider = provider
+		}
+		if id := nullString(attempt.ProviderMessageID); id != nil {
+			msg.ProviderMessageID = id
+		}
+		msg.Attempts++
+		msg.LastError = &errorMsg
+		q.move(tx, msg, status, messages.Event{Event: messages.EventFailed, Provider: provider, Detail: &errorMsg})
+		delete(q.table.leases, messageID)
+	})
+	return err
+}
+
+func (q *Memory) Release(ctx context.Context, messageIDs []uuid.UUID) (int64, error) {
+	var count int64
+	q.table.store.Mutate(func(tx *messages.MemoryTx) {
+		for _, id := range messageIDs {
+			if msg, ok := tx.Messages[id]; ok && q.owned(msg) {
+				q.move(tx, msg, messages.StatusQueued, messages.Event{Event: messages.EventReleased})
+				delete(q.table.leases, id)
+				count++
+			}
+		}
+	})
+	return count, nil
+}
+
+func (q *Memory) Reap(ctx context.Context, maxAttempts int) ([]Reaped, error) {
+	var reaped []Reaped
+	q.table.store.Mutate(func(tx *messages.MemoryTx) {
+		now := time.Now()
+		for _, msg := range tx.Messages {
+			l, ok := q.table.leases[msg.ID]
+			if msg.Status != messages.StatusSending || !ok || l.expires.After(now) {
+				continue
+			}
+			msg.Attempts++
+			status := messages.StatusQueued
+			if msg.Attempts >= maxAttempts {
+				status = messages.StatusFailedPerm
+			}
+			lastError := fmt.Sprintf("lease expired (claimed by %s)", l.owner)
+			msg.LastError = &lastError
+			q.move(tx, msg, status, messages.Event{Event: messages.EventLeaseExpired, Detail: &lastError})
+			delete(q.table.leases, msg.ID)
+			reaped = append(reaped, Reaped{MessageID: msg.ID, Status: msg.Status})
+		}
+	})
+	return reaped, nil
+}
+
+func (q *Memory) Retry(ctx context.Context) (int64, error) {
+	var count int64
+	q.table.store.Mutate(func(tx *messages.MemoryTx) {
+		now := time.Now()
+		for _, msg := range tx.Messages {
+			due, ok := tx.RetryAfter[msg.ID]
+			if msg.Status == messages.StatusFailedTemp && ok && !due.After(now) {
+				q.move(tx, msg, messages.StatusQueued, messages.Event{Event: messages.EventRetryDue})
+				count++
+			}
+		}
+	})
+	return count, nil
+}
+
+func (q *Memory) Weights(ctx context.Context) (map[uuid.UUID]int, error) {
+	q.table.mu.Lock()
+	defer q.table.mu.Unlock()
+
+	weights := make(map[uuid.UUID]int)
+	for id, w := range q.table.weights {
+		if w != 1 {
+			weights[id] = w
+		}
+	}
+	return weights, nil
+}
+
+func queuedBefore(a, b *messages.Message) bool {
+	if a.Priority.Rank() != b.Priority.Rank() {
+		return a.Priority.Rank() < b.Priority.Rank()
+	}
+	return a.CreatedAt.Before(b.CreatedAt)
+}
@@ -0,0 +1,217 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sms-gateway/internal/messages"
+	"sms-gateway/internal/ops"
+	"sms-gateway/internal/retry"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newMemoryMessage(t *testing.T, store *messages.MemoryStore, clientID uuid.UUID, priority messages.Priority) uuid.UUID {
+	t.Helper()
+	msg := &messages.Message{ID: uuid.New(), ClientID: clientID, Status: messages.StatusQueued, Priority: priority, CreatedAt: time.Now()}
+	if err := store.Create(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg.ID
+}
+
+func TestMemoryPollClaimsOnce(t *testing.T) {
+	store := messages.NewMemoryStore()
+	table := NewMemoryTable(store)
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	for i := 0; i < 500; i++ {
+		newMemoryMessage(t, store, clientID, messages.PriorityStandard)
+	}
+
+	// Concurrent workers must never claim the same message
+	var mu sync.Mutex
+	seen := make(map[uuid.UUID]string)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		q := table.Queue(uuid.NewString(), "mock", time.Minute)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				msgs, _ := q.Poll(context.Background(), 7)
+				if len(msgs) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, msg := range msgs {
+					if owner, dup := seen[msg.ID]; dup {
+						t.Errorf("Message %s claimed by %s and %s", msg.ID, owner, q.WorkerID())
+					}
+					seen[msg.ID] = q.WorkerID()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != 500 {
+		t.Errorf("Expected 500 claims, got %d", len(seen))
+	}
+}
+
+func TestMemoryPollFairOrder(t *testing.T) {
+	store := messages.NewMemoryStore()
+	table := NewMemoryTable(store)
+	noisy, heavy, quiet := uuid.New(), uuid.New(), uuid.New()
+	for _, id := range []uuid.UUID{noisy, heavy, quiet} {
+		store.AddClient(id)
+	}
+	table.SetWeight(heavy, 2)
+
+	for i := 0; i < 20; i++ {
+		newMemoryMessage(t, store, noisy, messages.PriorityStandard)
+		newMemoryMessage(t, store, heavy, messages.PriorityStandard)
+	}
+	newMemoryMessage(t, store, quiet, messages.PriorityStandard)
+	otp := newMemoryMessage(t, store, noisy, messages.PriorityOTP)
+
+	msgs, _ := table.Queue("w", "mock", time.Minute).Poll(context.Background(), 5)
+	if len(msgs) != 5 || msgs[0].ID != otp {
+		t.Fatalf("Expected the OTP message first, got %v", msgs)
+	}
+
+	counts := make(map[uuid.UUID]int)
+	for _, msg := range msgs[1:] {
+		counts[msg.ClientID]++
+	}
+	// Turn 0: noisy, heavy, quiet; turn 0.5: heavy
+	if counts[quiet] != 1 || counts[heavy] != 2 || counts[noisy] != 1 {
+		t.Errorf("Expected quiet:1 heavy:2 noisy:1, got %v", counts)
+	}
+}
+
+func TestMemoryLeaseOwnership(t *testing.T) {
+	ctx := context.Background()
+	store := messages.NewMemoryStore()
+	table := NewMemoryTable(store)
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	id := newMemoryMessage(t, store, clientID, messages.PriorityStandard)
+
+	owner := table.Queue("owner", "mock", time.Millisecond)
+	other := table.Queue("other", "mock", time.Minute)
+	if msgs, _ := owner.Poll(ctx, 1); len(msgs) != 1 {
+		t.Fatal("Expected a claim")
+	}
+
+	// Only the lease holder may settle the claim
+	if err := other.Complete(ctx, id, Attempt{}); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost for foreign Complete, got %v", err)
+	}
+	if msg, _ := store.GetByID(ctx, id); msg.Status != messages.StatusSending {
+		t.Fatalf("Expected SENDING after foreign Complete, got %s", msg.Status)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	reaped, _ := other.Reap(ctx, 3)
+	if len(reaped) != 1 || reaped[0].Status != messages.StatusQueued {
+		t.Fatalf("Expected expired lease to be requeued, got %v", reaped)
+	}
+
+	// A stale Fail from the original owner is ignored once the lease is gone
+	if err := owner.Fail(ctx, id, Attempt{}, "late", retry.Decision{}); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost for stale Fail, got %v", err)
+	}
+	msg, _ := store.GetByID(ctx, id)
+	if msg.Status != messages.StatusQueued || msg.Attempts != 1 {
+		t.Errorf("Expected QUEUED with 1 attempt, got %s with %d", msg.Status, msg.Attempts)
+	}
+}
+
+func TestMemoryPollHonoursPauses(t *testing.T) {
+	ctx := context.Background()
+	store := messages.NewMemoryStore()
+	table := NewMemoryTable(store)
+	paused, other := uuid.New(), uuid.New()
+	store.AddClient(paused)
+	store.AddClient(other)
+	held := newMemoryMessage(t, store, paused, messages.PriorityStandard)
+	free := newMemoryMessage(t, store, other, messages.PriorityStandard)
+
+	table.Pause(ctx, ops.Pause{Scope: ops.ScopeClient, Target: paused.String()})
+	table.Pause(ctx, ops.Pause{Scope: ops.ScopeProvider, Target: "smpp"})
+
+	// Workers on another replica see the same pauses
+	if msgs, _ := table.Queue("smpp-worker", "smpp", time.Minute).Poll(ctx, 10); len(msgs) != 0 {
+		t.Fatalf("Expected a paused provider to claim nothing, got %v", msgs)
+	}
+	q := table.Queue("mock-worker", "mock", time.Minute)
+	msgs, _ := q.Poll(ctx, 10)
+	if len(msgs) != 1 || msgs[0].ID != free {
+		t.Fatalf("Expected only the unpaused client's message, got %v", msgs)
+	}
+	if *msgs[0].Provider != "mock" {
+		t.Errorf("Expected the claim to record provider mock, got %v", *msgs[0].Provider)
+	}
+	if n, _ := table.InFlight(ctx, "mock"); n != 1 {
+		t.Errorf("Expected 1 in flight through mock, got %d", n)
+	}
+
+	// Claiming by ID (the JetStream path) is held back too
+	if claimed, _ := q.Claim(ctx, []uuid.UUID{held}); len(claimed) != 0 {
+		t.Errorf("Expected Claim to skip a paused client, got %v", claimed)
+	}
+
+	table.Resume(ctx, ops.ScopeClient, paused.String())
+	table.Pause(ctx, ops.Pause{Scope: ops.ScopeGlobal})
+	if msgs, _ := q.Poll(ctx, 10); len(msgs) != 0 {
+		t.Fatalf("Expected a global pause to claim nothing, got %v", msgs)
+	}
+
+	table.Resume(ctx, ops.ScopeGlobal, "")
+	if msgs, _ := q.Poll(ctx, 10); len(msgs) != 1 || msgs[0].ID != held {
+		t.Errorf("Expected the resumed client's message, got %v", msgs)
+	}
+}
+
+func TestMemoryReapAndRelease(t *testing.T) {
+	ctx := context.Background()
+	store := messages.NewMemoryStore()
+	table := NewMemoryTable(store)
+	clientID := uuid.New()
+	store.AddClient(clientID)
+	released := newMemoryMessage(t, store, clientID, messages.PriorityStandard)
+	exhausted := newMemoryMessage(t, store, clientID, messages.PriorityStandard)
+	store.Mutate(func(tx *messages.MemoryTx) { tx.Messages[exhausted].Attempts = 2 })
+
+	dead := table.Queue("dead", "mock", time.Millisecond)
+	if msgs, _ := dead.Poll(ctx, 2); len(msgs) != 2 {
+		t.Fatalf("Expected 2 claims, got %d", len(msgs))
+	}
+
+	// Release gives a claim back without counting an attempt, and only for its holder
+	live := table.Queue("live", "mock", time.Minute)
+	if n, _ := live.Release(ctx, []uuid.UUID{released}); n != 0 {
+		t.Errorf("Expected a foreign release to do nothing, got %d", n)
+	}
+	if n, _ := dead.Release(ctx, []uuid.UUID{released}); n != 1 {
+		t.Errorf("Expected the claim to be released, got %d", n)
+	}
+	if msg, _ := store.GetByID(ctx, released); msg.Status != messages.StatusQueued || msg.Attempts != 0 {
+		t.Errorf("Expected QUEUED with no attempt counted, got %s with %d", msg.Status, msg.Attempts)
+	}
+
+	// The dead worker's remaining claim has used its last attempt
+	time.Sleep(5 * time.Millisecond)
+	reaped, _ := live.Reap(ctx, 3)
+	if len(reaped) != 1 || reaped[0].MessageID != exhausted || reaped[0].Status != messages.StatusFailedPerm {
+		t.Fatalf("Expected the exhausted claim to fail permanently, got %v", reaped)
+	}
+	if msg, _ := store.GetByID(ctx, exhausted); msg.Attempts != 3 || msg.LastError == nil {
+		t.Errorf("Expected 3 attempts and a lease error, got %d", msg.Attempts)
+	}
+}
@@ -0,0 +1,152 @@
+// Package ratelimit counts requests and messages in fixed windows held in a
+// shared Store, so every API replica enforces the same limits.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrLimited is returned when a rule refuses a take
+var ErrLimited = errors.New("rate limit exceeded")
+
+// Window is the period a counter covers. Day and month windows follow the
+// UTC calendar; shorter ones are aligned to the epoch.
+type Window int
+
+const (
+	Second Window = iota
+	Minute
+	Day
+	Month
+)
+
+func (w Window) String() string {
+	switch w {
+	case Second:
+		return "second"
+	case Minute:
+		return "minute"
+	case Day:
+		return "day"
+	default:
+		return "month"
+	}
+}
+
+// Bounds returns the window containing now
+func (w Window) Bounds(now time.Time) (start, end time.Time) {
+	now = now.UTC()
+	switch w {
+	case Second:
+		start = now.Truncate(time.Second)
+		return start, start.Add(time.Second)
+	case Minute:
+		start = now.Truncate(time.Minute)
+		return start, start.Add(time.Minute)
+	case Day:
+		start = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 0, 1)
+	default:
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+}
+
+// Rule allows Max units per Window for Key. Max 0 is unlimited.
+type Rule struct {
+	Key    string
+	Max    int64
+	Window Window
+}
+
+// Result is where a rule's counter stands after a take
+type Result struct {
+	Rule    Rule
+	Count   int64
+	Allowed bool
+	Reset   time.Time // End of the window
+}
+
+func (r Result) Remaining() int64 {
+	if r.Count >= r.Rule.Max {
+		return 0
+	}
+	return r.Rule.Max - r.Count
+}
+
+// Store keeps window counters
+type Store interface {
+	// Add adds n to key's counter for the window [start, end) unless that
+	// would take it past limit, and returns the count and whether it was added.
+	// Negative n gives units back.
+	Add(ctx context.Context, key string, start, end time.Time, n, limit int64) (count int64, ok bool, err error)
+	// Prune drops counters for windows that ended before before
+	Prune(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Limiter applies rules against a Store
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+func New(store Store) *Limiter {
+	return &Limiter{store: store, now: time.Now}
+}
+
+// Take counts n units against every limited rule. If any refuses, the units
+// counted against the others are given back and ErrLimited is returned with
+// the refusing rule's result. Otherwise the result is the rule closest to its
+// limit, or nil when no rule is limited.
+func (l *Limiter) Take(ctx context.Context, rules []Rule, n int64) (*Result, error) {
+	now := l.now()
+	var tightest *Result
+	for i, rule := range rules {
+		if rule.Max <= 0 {
+			continue
+		}
+		start, end := rule.Window.Bounds(now)
+		count, ok, err := l.store.Add(ctx, storeKey(rule), start, end, n, rule.Max)
+		if err != nil {
+			l.undo(ctx, rules[:i], now, n)
+			return nil, err
+		}
+		res := &Result{Rule: rule, Count: count, Allowed: ok, Reset: end}
+		if !ok {
+			l.undo(ctx, rules[:i], now, n)
+			return res, ErrLimited
+		}
+		if tightest == nil || res.Remaining() < tightest.Remaining() {
+			tightest = res
+		}
+	}
+	return tightest, nil
+}
+
+// Give returns n units taken against rules, e.g. for a message that was
+// counted but then not accepted
+func (l *Limiter) Give(ctx context.Context, rules []Rule, n int64) {
+	l.undo(ctx, rules, l.now(), n)
+}
+
+func (l *Limiter) undo(ctx context.Context, rules []Rule, now time.Time, n int64) {
+	for _, rule := range rules {
+		if rule.Max <= 0 {
+			continue
+		}
+		start, end := rule.Window.Bounds(now)
+		l.store.Add(ctx, storeKey(rule), start, end, -n, 0)
+	}
+}
+
+// storeKey keeps counters of different windows for one key apart
+func storeKey(r Rule) string {
+	return r.Key + "/" + r.Window.String()
+}
+
+// Prune drops the counters of windows that have ended
+func (l *Limiter) Prune(ctx context.Context) (int64, error) {
+	return l.store.Prune(ctx, l.now())
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWindowBounds(t *testing.T) {
+	now := time.Date(2026, 1, 31, 23, 59, 59, 500, time.FixedZone("UTC+2", 2*60*60))
+	tests := []struct {
+		window     Window
+		start, end time.Time
+	}{
+		{Second, time.Date(2026, 1, 31, 21, 59, 59, 0, time.UTC), time.Date(2026, 1, 31, 22, 0, 0, 0, time.UTC)},
+		{Minute, time.Date(2026, 1, 31, 21, 59, 0, 0, time.UTC), time.Date(2026, 1, 31, 22, 0, 0, 0, time.UTC)},
+		{Day, time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{Month, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.window.String(), func(t *testing.T) {
+			start, end := tt.window.Bounds(now)
+			if !start.Equal(tt.start) || !end.Equal(tt.end) {
+				t.Errorf("Expected [%s, %s), got [%s, %s)", tt.start, tt.end, start, end)
+			}
+		})
+	}
+}
+
+func TestTake(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	limiter := New(store)
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+
+	rules := []Rule{
+		{Key: "client", Max: 3, Window: Day},
+		{Key: "key", Max: 2, Window: Day},
+		{Key: "unlimited", Window: Day},
+	}
+	for i := 1; i <= 2; i++ {
+		res, err := limiter.Take(ctx, rules, 1)
+		if err != nil {
+			t.Fatalf("Take %d: expected to be allowed, got %v", i, err)
+		}
+		// The key rule is the tighter one
+		if res.Rule.Key != "key" || res.Remaining() != int64(2-i) {
+			t.Errorf("Take %d: expected key with %d remaining, got %s with %d", i, 2-i, res.Rule.Key, res.Remaining())
+		}
+	}
+
+	res, err := limiter.Take(ctx, rules, 1)
+	if !errors.Is(err, ErrLimited) || res.Rule.Key != "key" || res.Allowed {
+		t.Fatalf("Expected the key rule to refuse, got %+v, %v", res, err)
+	}
+	if !res.Reset.Equal(time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the refusal to reset at midnight, got %s", res.Reset)
+	}
+
+	// The refused take was given back to the client rule, so one remains
+	if res, err := limiter.Take(ctx, rules[:1], 1); err != nil || res.Remaining() != 0 {
+		t.Errorf("Expected the client rule to allow one more, got %+v, %v", res, err)
+	}
+	limiter.Give(ctx, rules[:1], 1)
+	if res, err := limiter.Take(ctx, rules[:1], 1); err != nil || res.Count != 3 {
+		t.Errorf("Expected a given back unit to be reusable, got %+v, %v", res, err)
+	}
+
+	// A new window starts from zero, and ended ones are pruned
+	now = now.AddDate(0, 0, 1)
+	if _, err := limiter.Take(ctx, rules, 1); err != nil {
+		t.Errorf("Expected a new day to be allowed, got %v", err)
+	}
+	if n, _ := limiter.Prune(ctx); n != 2 {
+		t.Errorf("Expected 2 counters pruned, got %d", n)
+	}
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PostgresStore keeps counters in the rate_limits table, shared by every
+// replica using the database
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Add(ctx context.Context, key string, start, end time.Time, n, limit int64) (int64, bool, error) {
+	if limit > 0 && n > limit {
+		return 0, false, nil
+	}
+	// The WHERE only guards the update, so the first take in a window always
+	// lands; n <= limit makes that safe
+	var count int64
+	err := s.db.QueryRowContext(ctx, `INSERT INTO rate_limits (key, window_start, window_end, count)
+		VALUES ($1, $2, $3, GREATEST($4, 0))
+		ON CONFLICT (key, window_start) DO UPDATE SET count = GREATEST(rate_limits.count + $4, 0)
+			WHERE $5 <= 0 OR rate_limits.count + $4 <= $5
+		RETURNING count`, key, start, end, n, limit).Scan(&count)
+	if err == sql.ErrNoRows {
+		// Refused: report where the counter stands
+		err = s.db.QueryRowContext(ctx, `SELECT count FROM rate_limits WHERE key = $1 AND window_start = $2`,
+			key, start).Scan(&count)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to read rate limit: %w", err)
+		}
+		return count, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to count rate limit: %w", err)
+	}
+	return count, true, nil
+}
+
+func (s *PostgresStore) Prune(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM rate_limits WHERE window_end < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MemoryStore keeps counters in process. It suits a single replica and tests.
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[memoryKey]*memoryCounter
+}
+
+type memoryKey struct {
+	key   string
+	start time.Time
+}
+
+type memoryCounter struct {
+	end   time.Time
+	count int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: make(map[memoryKey]*memoryCounter)}
+}
+
+func (s *MemoryStore) Add(ctx context.Context, key string, start, end time.Time, n, limit int64) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := memoryKey{key: key, start: start}
+	c, ok := s.counters[k]
+	if !ok {
+		c = &memoryCounter{end: end}
+		s.counters[k] = c
+	}
+	if limit > 0 && c.count+n > limit {
+		return c.count, false, nil
+	}
+	c.count = max(c.count+n, 0)
+	return c.count, true, nil
+}
+
+func (s *MemoryStore) Prune(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pruned int64
+	for k, c := range s.counters {
+		if c.end.Before(before) {
+			delete(s.counters, k)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
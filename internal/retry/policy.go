@@ -0,0 +1,209 @@
+package retry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrorClass groups send failures that share a retry policy
+type ErrorClass string
+
+const (
+	ClassThrottled ErrorClass = "throttled"
+	ClassNetwork   ErrorClass = "network"
+	ClassUpstream  ErrorClass = "upstream_5xx"
+	ClassTimeout   ErrorClass = "timeout" // provider timeouts and expired worker leases
+	ClassPermanent ErrorClass = "permanent"
+)
+
+// Error is returned by providers that know why a send failed
+type Error struct {
+	Class      ErrorClass
+	RetryAfter time.Duration // Suggested delay from the upstream, zero if none
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Classify returns the error class of err, treating unknown errors as network failures
+func Classify(err error) ErrorClass {
+	var re *Error
+	if errors.As(err, &re) && re.Class != "" {
+		return re.Class
+	}
+	return ClassNetwork
+}
+
+// SuggestedDelay returns the retry-after value a provider attached to err
+func SuggestedDelay(err error) time.Duration {
+	var re *Error
+	if errors.As(err, &re) {
+		return re.RetryAfter
+	}
+	return 0
+}
+
+// Duration is a time.Duration that reads "30s"-style strings from JSON
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Policy describes exponential backoff for one error class
+type Policy struct {
+	BaseDelay   Duration `json:"base"`
+	Multiplier  float64  `json:"multiplier"`
+	Jitter      float64  `json:"jitter"` // Fraction of the delay randomised in both directions
+	MaxDelay    Duration `json:"cap"`
+	MaxAttempts int      `json:"max_attempts"` // Total sends including the first one
+}
+
+// Decision tells the queue what to do with a failed send
+type Decision struct {
+	Retry bool
+	Delay time.Duration
+}
+
+// Decide applies the policy to a send that just failed on the given attempt (1-based)
+func (p Policy) Decide(attempt int, suggested time.Duration) Decision {
+	if attempt >= p.MaxAttempts {
+		return Decision{Retry: false}
+	}
+	return Decision{Retry: true, Delay: p.Delay(attempt, suggested)}
+}
+
+// Delay returns the backoff before the next attempt. A provider's suggested
+// retry-after is honoured as a lower bound, even above the cap.
+func (p Policy) Delay(attempt int, suggested time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 1
+	}
+
+	d := float64(p.BaseDelay) * math.Pow(mult, float64(attempt-1))
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	delay := time.Duration(d)
+	if p.MaxDelay > 0 && delay > time.Duration(p.MaxDelay) {
+		delay = time.Duration(p.MaxDelay)
+	}
+	if suggested > delay {
+		delay = suggested
+	}
+	return delay
+}
+
+// merge fills the zero fields of p from fallback
+func (p Policy) merge(fallback Policy) Policy {
+	if p.BaseDelay == 0 {
+		p.BaseDelay = fallback.BaseDelay
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = fallback.Multiplier
+	}
+	if p.Jitter == 0 {
+		p.Jitter = fallback.Jitter
+	}
+	if p.MaxDelay == 0 {
+		p.MaxDelay = fallback.MaxDelay
+	}
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = fallback.MaxAttempts
+	}
+	return p
+}
+
+// DefaultPolicies are used for any class not configured explicitly
+var DefaultPolicies = map[ErrorClass]Policy{
+	ClassThrottled: {BaseDelay: Duration(5 * time.Second), Multiplier: 2, Jitter: 0.2, MaxDelay: Duration(5 * time.Minute), MaxAttempts: 8},
+	ClassNetwork:   {BaseDelay: Duration(2 * time.Second), Multiplier: 2, Jitter: 0.2, MaxDelay: Duration(2 * time.Minute), MaxAttempts: 5},
+	ClassUpstream:  {BaseDelay: Duration(10 * time.Second), Multiplier: 3, Jitter: 0.2, MaxDelay: Duration(10 * time.Minute), MaxAttempts: 5},
+	ClassTimeout:   {BaseDelay: Duration(30 * time.Second), Multiplier: 2, Jitter: 0.1, MaxDelay: Duration(5 * time.Minute), MaxAttempts: 3},
+	ClassPermanent: {MaxAttempts: 1},
+}
+
+// Policies resolves the policy for a class, optionally overridden per
+// message priority, provider or client
+type Policies struct {
+	Default    map[ErrorClass]Policy               `json:"default"`
+	Priorities map[string]map[ErrorClass]Policy    `json:"priorities"`
+	Providers  map[string]map[ErrorClass]Policy    `json:"providers"`
+	Clients    map[uuid.UUID]map[ErrorClass]Policy `json:"clients"`
+}
+
+// Scope identifies the message a policy is resolved for
+type Scope struct {
+	Priority string
+	Provider string
+	ClientID uuid.UUID
+}
+
+// ParsePolicies reads overrides from the RETRY_POLICIES JSON document, e.g.
+//
+//	{"default":{"network":{"base":"1s","multiplier":2,"cap":"1m","max_attempts":4}},
+//	 "priorities":{"bulk":{"network":{"max_attempts":2}}},
+//	 "providers":{"mock":{"throttled":{"max_attempts":10}}},
+//	 "clients":{"<uuid>":{"upstream_5xx":{"base":"30s"}}}}
+//
+// Fields left out fall back to the next broader level.
+func ParsePolicies(spec string) (*Policies, error) {
+	p := &Policies{}
+	if spec != "" {
+		if err := json.Unmarshal([]byte(spec), p); err != nil {
+			return nil, fmt.Errorf("invalid retry policies: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// For returns the effective policy, preferring client over provider over
+// priority over defaults
+func (p *Policies) For(class ErrorClass, scope Scope) Policy {
+	policy := DefaultPolicies[class]
+	if policy.MaxAttempts == 0 {
+		policy = DefaultPolicies[ClassNetwork]
+	}
+	if override, ok := p.Default[class]; ok {
+		policy = override.merge(policy)
+	}
+	if override, ok := p.Priorities[scope.Priority][class]; ok {
+		policy = override.merge(policy)
+	}
+	if override, ok := p.Providers[scope.Provider][class]; ok {
+		policy = override.merge(policy)
+	}
+	if override, ok := p.Clients[scope.ClientID][class]; ok {
+		policy = override.merge(policy)
+	}
+	return policy
+}
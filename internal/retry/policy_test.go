@@ -0,0 +1,103 @@
+package retry
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	p := Policy{BaseDelay: Duration(time.Second), Multiplier: 2, MaxDelay: Duration(10 * time.Second), MaxAttempts: 5}
+
+	tests := []struct {
+		attempt   int
+		suggested time.Duration
+		expected  time.Duration
+	}{
+		{1, 0, time.Second},
+		{2, 0, 2 * time.Second},
+		{3, 0, 4 * time.Second},
+		{5, 0, 10 * time.Second},                // capped
+		{1, 30 * time.Second, 30 * time.Second}, // provider suggestion wins
+	}
+
+	for _, tt := range tests {
+		if got := p.Delay(tt.attempt, tt.suggested); got != tt.expected {
+			t.Errorf("Delay(%d, %v) = %v, want %v", tt.attempt, tt.suggested, got, tt.expected)
+		}
+	}
+}
+
+func TestPolicyJitterBounds(t *testing.T) {
+	p := Policy{BaseDelay: Duration(10 * time.Second), Multiplier: 1, Jitter: 0.5, MaxAttempts: 3}
+
+	for i := 0; i < 100; i++ {
+		d := p.Delay(1, 0)
+		if d < 5*time.Second || d > 15*time.Second {
+			t.Fatalf("Delay out of jitter bounds: %v", d)
+		}
+	}
+}
+
+func TestPolicyDecide(t *testing.T) {
+	p := Policy{BaseDelay: Duration(time.Second), Multiplier: 2, MaxAttempts: 3}
+
+	if d := p.Decide(2, 0); !d.Retry {
+		t.Error("Expected retry after attempt 2 of 3")
+	}
+	if d := p.Decide(3, 0); d.Retry {
+		t.Error("Expected no retry after final attempt")
+	}
+	if d := DefaultPolicies[ClassPermanent].Decide(1, 0); d.Retry {
+		t.Error("Permanent errors should never be retried")
+	}
+}
+
+func TestPoliciesOverrides(t *testing.T) {
+	clientID := uuid.New()
+	spec := fmt.Sprintf(`{
+		"default": {"network": {"base": "1s", "max_attempts": 4}},
+		"priorities": {"bulk": {"network": {"max_attempts": 2}}},
+		"providers": {"mock": {"network": {"max_attempts": 6}}},
+		"clients": {"%s": {"network": {"base": "3s"}}}
+	}`, clientID)
+
+	policies, err := ParsePolicies(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := policies.For(ClassNetwork, Scope{Priority: "standard", Provider: "other", ClientID: uuid.New()})
+	if p.BaseDelay != Duration(time.Second) || p.MaxAttempts != 4 {
+		t.Errorf("Unexpected default override: %+v", p)
+	}
+	if p.Multiplier != DefaultPolicies[ClassNetwork].Multiplier {
+		t.Errorf("Expected multiplier to fall back to built-in default, got %v", p.Multiplier)
+	}
+
+	p = policies.For(ClassNetwork, Scope{Priority: "bulk"})
+	if p.MaxAttempts != 2 {
+		t.Errorf("Expected bulk priority override, got %+v", p)
+	}
+
+	p = policies.For(ClassNetwork, Scope{Priority: "bulk", Provider: "mock", ClientID: clientID})
+	if p.BaseDelay != Duration(3*time.Second) || p.MaxAttempts != 6 {
+		t.Errorf("Unexpected client/provider override: %+v", p)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	err := fmt.Errorf("send: %w", &Error{Class: ClassThrottled, RetryAfter: time.Minute, Err: fmt.Errorf("429")})
+
+	if Classify(err) != ClassThrottled {
+		t.Errorf("Expected throttled, got %s", Classify(err))
+	}
+	if SuggestedDelay(err) != time.Minute {
+		t.Errorf("Expected 1m suggested delay, got %v", SuggestedDelay(err))
+	}
+	if Classify(fmt.Errorf("boom")) != ClassNetwork {
+		t.Error("Unknown errors should classify as network")
+	}
+}
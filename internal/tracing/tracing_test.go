@@ -0,0 +1,47 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceParentRoundTrip(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	if tp := TraceParent(context.Background()); tp != nil {
+		t.Fatalf("Expected no traceparent outside a span, got %q", *tp)
+	}
+
+	// The request that creates the message
+	ctx, request := Start(context.Background(), "POST /v1/messages")
+	stored := TraceParent(ctx)
+	request.End()
+	if stored == nil {
+		t.Fatal("Expected a traceparent inside a span")
+	}
+
+	// Later, in another process, the send continues the same trace
+	_, send := Start(WithTraceParent(context.Background(), stored), "sms.send")
+	send.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	if spans[1].SpanContext().TraceID() != spans[0].SpanContext().TraceID() {
+		t.Error("Expected the send to join the request's trace")
+	}
+	if spans[1].Parent().SpanID() != spans[0].SpanContext().SpanID() {
+		t.Error("Expected the request span to be the send's parent")
+	}
+
+	if ctx := WithTraceParent(context.Background(), nil); trace.SpanContextFromContext(ctx).IsValid() {
+		t.Error("Expected no parent without a traceparent")
+	}
+}
@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"sync"
+	"time"
+)
+
+// pool runs the shared senders. Shrinking retires senders once they finish
+// the message in hand; growing starts new ones.
+type pool struct {
+	mu     sync.Mutex
+	retire []chan struct{} // One per running sender
+	start  func(retire <-chan struct{})
+}
+
+func newPool(start func(retire <-chan struct{})) *pool {
+	return &pool{start: start}
+}
+
+// Resize grows or shrinks the pool to n senders and returns the old size
+func (p *pool) Resize(n int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	old := len(p.retire)
+	for len(p.retire) < n {
+		retire := make(chan struct{})
+		p.retire = append(p.retire, retire)
+		p.start(retire)
+	}
+	for len(p.retire) > n {
+		last := len(p.retire) - 1
+		close(p.retire[last])
+		p.retire = p.retire[:last]
+	}
+	return old
+}
+
+// Size returns the number of running senders
+func (p *pool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.retire)
+}
+
+// sizer decides how many shared senders the adaptive mode should run
+type sizer struct {
+	min           int
+	max           int
+	latencyTarget time.Duration // Provider latency above which the pool stops growing and backs off
+}
+
+// next returns the pool size for the coming interval, given the current size,
+// how many claimed messages are waiting, how many senders are busy and the
+// recent provider latency
+func (s sizer) next(current, buffered, busy int, latency time.Duration) int {
+	n := current
+	switch {
+	case latency > s.latencyTarget:
+		// The provider is slowing down; more concurrency would only add to its load
+		n = current - max(1, current/4)
+	case buffered > 0 && busy >= current:
+		// Every sender is busy and work is waiting
+		n = current + max(1, current/2)
+	case buffered == 0 && busy < current/2:
+		// Mostly idle
+		n = current - max(1, current/4)
+	}
+	return min(max(n, s.min), s.max)
+}
+
+// ewma is an exponentially weighted moving average of provider latency
+type ewma struct {
+	mu    sync.Mutex
+	value time.Duration
+}
+
+func (e *ewma) Observe(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.value == 0 {
+		e.value = d
+		return
+	}
+	e.value = (e.value*4 + d) / 5
+}
+
+func (e *ewma) Value() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.value
+}
@@ -0,0 +1,119 @@
+package worker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sms-gateway/internal/messages"
+	"sms-gateway/internal/metrics"
+	"sms-gateway/internal/providers/mock"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSizerNext(t *testing.T) {
+	s := sizer{min: 2, max: 20, latencyTarget: 500 * time.Millisecond}
+
+	tests := []struct {
+		name     string
+		current  int
+		buffered int
+		busy     int
+		latency  time.Duration
+		expected int
+	}{
+		{"saturated grows", 8, 50, 8, 100 * time.Millisecond, 12},
+		{"grow capped at max", 16, 50, 16, 100 * time.Millisecond, 20},
+		{"slow provider backs off", 8, 50, 8, time.Second, 6},
+		{"idle shrinks", 8, 0, 1, 100 * time.Millisecond, 6},
+		{"shrink floored at min", 2, 0, 0, 100 * time.Millisecond, 2},
+		{"steady stays", 8, 0, 6, 100 * time.Millisecond, 8},
+		{"spare senders stay", 8, 5, 4, 100 * time.Millisecond, 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.next(tt.current, tt.buffered, tt.busy, tt.latency); got != tt.expected {
+				t.Errorf("Expected %d senders, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestPoolResize(t *testing.T) {
+	var running int64
+	p := newPool(func(retire <-chan struct{}) {
+		atomic.AddInt64(&running, 1)
+		go func() {
+			<-retire
+			atomic.AddInt64(&running, -1)
+		}()
+	})
+
+	if old := p.Resize(5); old != 0 {
+		t.Errorf("Expected old size 0, got %d", old)
+	}
+	p.Resize(2)
+	if p.Size() != 2 {
+		t.Errorf("Expected size 2, got %d", p.Size())
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt64(&running) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 2 running senders, got %d", atomic.LoadInt64(&running))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWorkerAdaptiveGrowsUnderBacklog(t *testing.T) {
+	env := newTestEnv(t)
+	env.ledger.SetCredits(env.client, 5000)
+	ids := env.enqueue(t, 300)
+
+	cfg := testConfig()
+	cfg.WorkerConcurrency = 2
+	cfg.WorkerAdaptive = true
+	cfg.WorkerMinSenders = 1
+	cfg.WorkerMaxSenders = 32
+	cfg.WorkerLatencyTarget = time.Second
+	cfg.WorkerAdaptInterval = 20 * time.Millisecond
+
+	provider := mock.NewProviderWithOptions(mock.Options{SuccessRate: 1, Latency: 10 * time.Millisecond})
+	w := startTestWorkerWithConfig(t, env, provider, cfg)
+	waitForStatus(t, env.store, ids, messages.StatusSent)
+
+	stats := w.Stats()
+	if stats.ScaleUps == 0 {
+		t.Errorf("Expected the pool to grow under backlog, got %+v", stats)
+	}
+	if ups := testutil.ToFloat64(metrics.WorkerResizes.WithLabelValues("up")); ups < float64(stats.ScaleUps) {
+		t.Errorf("Expected at least %d scale-ups in metrics, got %v", stats.ScaleUps, ups)
+	}
+
+	// Once the backlog is gone the pool shrinks back towards the minimum
+	deadline := time.Now().Add(3 * time.Second)
+	for w.Stats().Senders > 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the idle pool to shrink, got %+v", w.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := w.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The last decision is what the gauges show
+	if senders := testutil.ToFloat64(metrics.WorkerSenders); senders != 1 {
+		t.Errorf("Expected the senders gauge at 1, got %v", senders)
+	}
+	if testutil.ToFloat64(metrics.WorkerResizes.WithLabelValues("down")) == 0 {
+		t.Error("Expected scale-downs in metrics")
+	}
+	if testutil.ToFloat64(metrics.WorkerLatency) <= 0 {
+		t.Error("Expected the observed latency in metrics")
+	}
+}
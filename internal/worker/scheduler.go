@@ -0,0 +1,195 @@
+package worker
+
+import (
+	"sms-gateway/internal/messages"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// scheduler buffers claimed messages between the poller and the senders and
+// hands them out in weighted round-robin order across clients, so one client's
+// backlog cannot monopolise the senders. Each priority class has its own
+// level; more urgent classes are always served first.
+type scheduler struct {
+	mu       sync.Mutex
+	levels   []*level // Indexed by messages.Priority.Rank()
+	weight   func(clientID uuid.UUID) int
+	size     int
+	capacity int
+	changed  chan struct{} // Closed and replaced whenever the buffer changes
+}
+
+// level is one priority class with its own round-robin ring of client lanes
+type level struct {
+	lanes map[uuid.UUID]*lane
+	ring  []*lane
+	pos   int
+}
+
+// lane holds one client's messages in FIFO order
+type lane struct {
+	clientID uuid.UUID
+	msgs     []*messages.Message
+	credit   int // Messages this lane may still send in the current turn
+}
+
+func newScheduler(capacity int, weight func(clientID uuid.UUID) int) *scheduler {
+	s := &scheduler{
+		weight:   weight,
+		capacity: capacity,
+		changed:  make(chan struct{}),
+	}
+	for range messages.Priorities {
+		s.levels = append(s.levels, &level{lanes: make(map[uuid.UUID]*lane)})
+	}
+	return s
+}
+
+// Push buffers a message, blocking while the buffer is full. It returns
+// false if stop was closed before there was room.
+func (s *scheduler) Push(msg *messages.Message, stop <-chan struct{}) bool {
+	for {
+		s.mu.Lock()
+		if s.size < s.capacity {
+			s.levels[msg.Priority.Rank()].push(msg)
+			s.size++
+			s.signal()
+			s.mu.Unlock()
+			return true
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-stop:
+			return false
+		}
+	}
+}
+
+// Next blocks until a message of one of the given classes is available and
+// returns the next one in fair order; nil classes means any class. It
+// returns false once stop is closed.
+func (s *scheduler) Next(classes []messages.Priority, stop <-chan struct{}) (*messages.Message, bool) {
+	for {
+		// A stopped caller takes nothing more, even if messages are waiting
+		select {
+		case <-stop:
+			return nil, false
+		default:
+		}
+
+		s.mu.Lock()
+		if msg := s.pop(classes); msg != nil {
+			s.size--
+			s.signal()
+			s.mu.Unlock()
+			return msg, true
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-stop:
+			return nil, false
+		}
+	}
+}
+
+// Drain removes and returns everything still buffered
+func (s *scheduler) Drain() []*messages.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []*messages.Message
+	for msg := s.pop(nil); msg != nil; msg = s.pop(nil) {
+		out = append(out, msg)
+	}
+	s.size = 0
+	s.signal()
+	return out
+}
+
+// Len returns the number of buffered messages
+func (s *scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Free returns the number of messages that can be pushed without blocking
+func (s *scheduler) Free() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.capacity - s.size
+}
+
+// signal wakes every goroutine blocked in Push or Next. Caller holds mu.
+func (s *scheduler) signal() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// pop returns the next message from the most urgent allowed class. Caller holds mu.
+func (s *scheduler) pop(classes []messages.Priority) *messages.Message {
+	if classes == nil {
+		for _, l := range s.levels {
+			if msg := l.pop(s.weight); msg != nil {
+				return msg
+			}
+		}
+		return nil
+	}
+	for _, class := range classes {
+		if msg := s.levels[class.Rank()].pop(s.weight); msg != nil {
+			return msg
+		}
+	}
+	return nil
+}
+
+func (l *level) push(msg *messages.Message) {
+	ln, ok := l.lanes[msg.ClientID]
+	if !ok {
+		ln = &lane{clientID: msg.ClientID}
+		l.lanes[msg.ClientID] = ln
+		l.ring = append(l.ring, ln)
+	}
+	ln.msgs = append(ln.msgs, msg)
+}
+
+// pop serves the lane at the current ring position, moving on once the lane
+// has used up its weight for this turn or has nothing left
+func (l *level) pop(weight func(uuid.UUID) int) *messages.Message {
+	if len(l.ring) == 0 {
+		return nil
+	}
+	if l.pos >= len(l.ring) {
+		l.pos = 0
+	}
+
+	ln := l.ring[l.pos]
+	if ln.credit <= 0 {
+		ln.credit = weight(ln.clientID)
+		if ln.credit < 1 {
+			ln.credit = 1
+		}
+	}
+
+	msg := ln.msgs[0]
+	ln.msgs[0] = nil
+	ln.msgs = ln.msgs[1:]
+	ln.credit--
+
+	if len(ln.msgs) == 0 {
+		// Lane is empty: drop it from the ring, the next lane slides into pos
+		delete(l.lanes, ln.clientID)
+		l.ring = append(l.ring[:l.pos], l.ring[l.pos+1:]...)
+	} else if ln.credit == 0 {
+		l.pos++
+	}
+	return msg
+}
@@ -0,0 +1,157 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"sms-gateway/internal/messages"
+
+	"github.com/google/uuid"
+)
+
+func newTestMessage(clientID uuid.UUID, priority messages.Priority) *messages.Message {
+	return &messages.Message{ID: uuid.New(), ClientID: clientID, Priority: priority, CreatedAt: time.Now()}
+}
+
+func TestSchedulerNoisyTenant(t *testing.T) {
+	noisy := uuid.New()
+	quiet := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	stop := make(chan struct{})
+
+	s := newScheduler(2000, func(uuid.UUID) int { return 1 })
+
+	// The noisy tenant bulk-submits first, quiet tenants trickle in behind it
+	for i := 0; i < 1000; i++ {
+		s.Push(newTestMessage(noisy, messages.PriorityStandard), stop)
+	}
+	quietLeft := 0
+	for _, id := range quiet {
+		for i := 0; i < 5; i++ {
+			s.Push(newTestMessage(id, messages.PriorityStandard), stop)
+			quietLeft++
+		}
+	}
+
+	// With equal weights every quiet message is served within one round of
+	// all active lanes per message, no matter how deep the noisy backlog is
+	bound := 5 * (len(quiet) + 1)
+	noisyServed := 0
+	for i := 0; quietLeft > 0; i++ {
+		if i >= bound {
+			t.Fatalf("%d quiet messages still waiting after %d dispatches", quietLeft, bound)
+		}
+		msg, ok := s.Next(nil, stop)
+		if !ok {
+			t.Fatal("scheduler stopped unexpectedly")
+		}
+		if msg.ClientID == noisy {
+			noisyServed++
+		} else {
+			quietLeft--
+		}
+	}
+
+	if s.Len() != 1000-noisyServed {
+		t.Errorf("Expected %d noisy messages still buffered, got %d", 1000-noisyServed, s.Len())
+	}
+}
+
+func TestSchedulerWeights(t *testing.T) {
+	heavy, light := uuid.New(), uuid.New()
+	stop := make(chan struct{})
+
+	s := newScheduler(100, func(id uuid.UUID) int {
+		if id == heavy {
+			return 3
+		}
+		return 1
+	})
+
+	for i := 0; i < 12; i++ {
+		s.Push(newTestMessage(heavy, messages.PriorityStandard), stop)
+		s.Push(newTestMessage(light, messages.PriorityStandard), stop)
+	}
+
+	counts := map[uuid.UUID]int{}
+	for i := 0; i < 8; i++ {
+		msg, _ := s.Next(nil, stop)
+		counts[msg.ClientID]++
+	}
+
+	if counts[heavy] != 6 || counts[light] != 2 {
+		t.Errorf("Expected 3:1 split (6/2), got heavy=%d light=%d", counts[heavy], counts[light])
+	}
+}
+
+func TestSchedulerPriorityOrder(t *testing.T) {
+	client := uuid.New()
+	stop := make(chan struct{})
+
+	s := newScheduler(100, func(uuid.UUID) int { return 1 })
+	for _, p := range []messages.Priority{
+		messages.PriorityBulk, messages.PriorityStandard, messages.PriorityExpress,
+		messages.PriorityTransactional, messages.PriorityOTP,
+	} {
+		s.Push(newTestMessage(client, p), stop)
+	}
+
+	for _, want := range messages.Priorities {
+		msg, _ := s.Next(nil, stop)
+		if msg.Priority != want {
+			t.Errorf("Expected %s next, got %s", want, msg.Priority)
+		}
+	}
+}
+
+func TestSchedulerReservedClasses(t *testing.T) {
+	client := uuid.New()
+	stop := make(chan struct{})
+
+	s := newScheduler(100, func(uuid.UUID) int { return 1 })
+	for i := 0; i < 50; i++ {
+		s.Push(newTestMessage(client, messages.PriorityBulk), stop)
+	}
+
+	// A sender reserved for OTP must never pick up bulk traffic: it waits
+	// until stopped and comes back empty-handed
+	const wait = 50 * time.Millisecond
+	timer := time.AfterFunc(wait, func() { close(stop) })
+	defer timer.Stop()
+	start := time.Now()
+	if msg, ok := s.Next([]messages.Priority{messages.PriorityOTP}, stop); ok {
+		t.Fatalf("OTP sender received %s message", msg.Priority)
+	}
+	if waited := time.Since(start); waited < wait {
+		t.Errorf("Expected OTP sender to block until stopped, returned after %v", waited)
+	}
+	if s.Len() != 50 {
+		t.Errorf("Expected the bulk messages to stay buffered, got %d", s.Len())
+	}
+
+	s.Push(newTestMessage(client, messages.PriorityOTP), make(chan struct{}))
+	msg, ok := s.Next([]messages.Priority{messages.PriorityOTP}, make(chan struct{}))
+	if !ok || msg.Priority != messages.PriorityOTP {
+		t.Error("Expected OTP sender to receive the OTP message")
+	}
+}
+
+func TestSchedulerStopAndDrain(t *testing.T) {
+	stop := make(chan struct{})
+	s := newScheduler(2, func(uuid.UUID) int { return 1 })
+
+	s.Push(newTestMessage(uuid.New(), messages.PriorityStandard), stop)
+	s.Push(newTestMessage(uuid.New(), messages.PriorityStandard), stop)
+	close(stop)
+
+	// Buffer is full, so Push must give up once stopped
+	if s.Push(newTestMessage(uuid.New(), messages.PriorityStandard), stop) {
+		t.Error("Push should fail after stop when the buffer is full")
+	}
+
+	if left := s.Drain(); len(left) != 2 {
+		t.Errorf("Expected 2 drained messages, got %d", len(left))
+	}
+	if s.Len() != 0 {
+		t.Errorf("Expected empty scheduler after drain, got %d", s.Len())
+	}
+}
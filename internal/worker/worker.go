@@ -2,32 +2,65 @@ package worker
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"os"
 	"runtime"
 	"sms-gateway/internal/billing"
 	"sms-gateway/internal/config"
+	"sms-gateway/internal/leader"
+	"sms-gateway/internal/logging"
 	"sms-gateway/internal/messages"
+	"sms-gateway/internal/metrics"
 	"sms-gateway/internal/providers/mock"
 	"sms-gateway/internal/queue"
+	"sms-gateway/internal/retry"
+	"sms-gateway/internal/tracing"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Worker processes SMS messages using database polling and Go channels
 type Worker struct {
 	logger   *slog.Logger
-	billing  *billing.Service
-	queue    *queue.Queue
+	billing  billing.Ledger
+	queue    queue.Backend
 	provider *mock.Provider
 
+	// Claimed messages wait in the fair scheduler until a sender is free
+	jobs    *scheduler
+	weights atomic.Pointer[map[uuid.UUID]int]
+
+	// Senders shared by all classes; adaptive mode resizes them
+	shared     *pool
+	sizer      *sizer // nil unless adaptive
+	latency    ewma
+	busy       int64 // Shared senders currently sending
+	scaleUps   int64
+	scaleDowns int64
+
 	// Go channels - proper way to share memory by communicating
-	jobs    chan *messages.Message
 	results chan result
-	stop    chan struct{}
-	wg      sync.WaitGroup
+	stop    chan struct{}  // Closed first on shutdown: no more polling or maintenance
+	halt    chan struct{}  // Closed second: senders take no new messages
+	loops   sync.WaitGroup // Poller and maintenance loops
+	senders sync.WaitGroup
+	done    chan struct{} // Closed once every result has been written back
+
+	concurrency   int
+	batchSize     int
+	pollInterval  time.Duration
+	adaptInterval time.Duration
+	reapInterval  time.Duration
+	retryInterval time.Duration
+	policies      *retry.Policies
+	reserved      map[messages.Priority]int // Senders dedicated to one priority class
+	coordinator   *leader.Coordinator       // Runs singleton jobs on one replica; nil runs them locally
 
 	// Atomic counters
 	processed int64
@@ -35,67 +68,259 @@ type Worker struct {
 }
 
 type result struct {
-	id      uuid.UUID
-	success bool
-	err     error
+	msg        *messages.Message
+	success    bool
+	err        error
+	providerID string
+	partIDs    []string
 }
 
 // New creates a worker with optimal configuration
-func New(logger *slog.Logger, store *messages.Store, billing *billing.Service,
-	provider *mock.Provider, cfg *config.Config) *Worker {
+func New(logger *slog.Logger, backend queue.Backend, billing billing.Ledger,
+	provider *mock.Provider, cfg *config.Config) (*Worker, error) {
+
+	policies, err := retry.ParsePolicies(cfg.RetryPolicies)
+	if err != nil {
+		return nil, err
+	}
+
+	reserved := make(map[messages.Priority]int)
+	for class, n := range cfg.PriorityReservedWorkers {
+		priority := messages.Priority(class)
+		if !priority.Valid() {
+			return nil, fmt.Errorf("unknown priority class %q in reserved workers", class)
+		}
+		reserved[priority] = n
+	}
+
+	concurrency := cfg.WorkerConcurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU() * 10 // I/O bound work
+	}
+	bufferSize := cfg.WorkerBufferSize
+	if bufferSize <= 0 {
+		bufferSize = 200
+	}
+	batchSize := cfg.WorkerBatchSize
+	if batchSize <= 0 {
+		batchSize = 20
+	}
+	pollInterval := cfg.WorkerPollInterval
+	if pollInterval <= 0 {
+		pollInterval = 50 * time.Millisecond
+	}
+	reapInterval := cfg.WorkerReapInterval
+	if reapInterval <= 0 {
+		reapInterval = 15 * time.Second
+	}
+	retryInterval := cfg.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = time.Second
+	}
+
+	w := &Worker{
+		logger:        logger,
+		billing:       billing,
+		queue:         backend,
+		provider:      provider,
+		results:       make(chan result, bufferSize),
+		stop:          make(chan struct{}),
+		halt:          make(chan struct{}),
+		done:          make(chan struct{}),
+		concurrency:   concurrency,
+		batchSize:     batchSize,
+		pollInterval:  pollInterval,
+		adaptInterval: cfg.WorkerAdaptInterval,
+		reapInterval:  reapInterval,
+		retryInterval: retryInterval,
+		policies:      policies,
+		reserved:      reserved,
+	}
 
-	return &Worker{
-		logger:   logger,
-		billing:  billing,
-		queue:    queue.New(store, logger),
-		provider: provider,
-		jobs:     make(chan *messages.Message, 200),
-		results:  make(chan result, 200),
-		stop:     make(chan struct{}),
+	if cfg.WorkerAdaptive {
+		maxSenders := cfg.WorkerMaxSenders
+		if maxSenders <= 0 {
+			maxSenders = 4 * concurrency
+		}
+		minSenders := max(cfg.WorkerMinSenders, 1)
+		if minSenders > maxSenders {
+			return nil, fmt.Errorf("worker min senders (%d) exceeds max senders (%d)", minSenders, maxSenders)
+		}
+		if w.adaptInterval <= 0 {
+			return nil, fmt.Errorf("worker adapt interval must be positive")
+		}
+		w.sizer = &sizer{min: minSenders, max: maxSenders, latencyTarget: cfg.WorkerLatencyTarget}
 	}
+
+	w.jobs = newScheduler(bufferSize, w.clientWeight)
+	return w, nil
+}
+
+// Coordinate hands the singleton jobs (retry scheduling and lease reaping)
+// to c, so only the replica it elects runs them. Call before Start.
+func (w *Worker) Coordinate(c *leader.Coordinator) {
+	w.coordinator = c
+}
+
+// clientWeight returns the client's share of senders in the fair scheduler
+func (w *Worker) clientWeight(clientID uuid.UUID) int {
+	if weights := w.weights.Load(); weights != nil {
+		if weight, ok := (*weights)[clientID]; ok {
+			return weight
+		}
+	}
+	return 1
+}
+
+// NewID identifies this process on the claims it makes
+func NewID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "worker"
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), uuid.NewString()[:8])
 }
 
 // Start the worker pool
 func (w *Worker) Start(ctx context.Context) error {
-	workers := runtime.NumCPU() * 10 // I/O bound work
-	w.logger.Info("Starting SMS Worker", "workers", workers)
-
-	// Start workers
-	for i := 0; i < workers; i++ {
-		w.wg.Add(1)
-		go w.worker(ctx)
+	// Reserved senders only serve their own class, so bulk traffic can
+	// never occupy every sender
+	shared := w.concurrency
+	for _, class := range messages.Priorities {
+		for i := 0; i < w.reserved[class]; i++ {
+			w.senders.Add(1)
+			go w.worker(ctx, []messages.Priority{class}, w.halt)
+		}
+		shared -= w.reserved[class]
+	}
+	if shared < 1 {
+		shared = 1
+	}
+	if w.sizer != nil {
+		shared = min(max(shared, w.sizer.min), w.sizer.max)
 	}
+	w.logger.Info("Starting SMS Worker", "shared_workers", shared, "reserved_workers", w.reserved,
+		"adaptive", w.sizer != nil, "worker_id", w.queue.WorkerID())
+
+	// Start shared workers
+	w.shared = newPool(func(retire <-chan struct{}) {
+		w.senders.Add(1)
+		go w.worker(ctx, nil, retire)
+	})
+	w.shared.Resize(shared)
+
+	// Start result processor; it runs until every sender has reported back
+	go w.processResults(ctx)
+	go func() {
+		w.senders.Wait()
+		close(w.results)
+	}()
 
 	// Start poller
-	w.wg.Add(1)
+	w.loops.Add(1)
 	go w.poll(ctx)
 
-	// Start result processor
-	w.wg.Add(1)
-	go w.processResults(ctx)
+	// Start client weight refresher
+	w.loops.Add(1)
+	go w.weightsLoop(ctx)
 
-	// Start retry processor
-	w.wg.Add(1)
-	go w.retryLoop(ctx)
+	// Retry scheduling and lease reaping only need one replica; with a
+	// coordinator they run wherever it elects, otherwise here
+	if w.coordinator != nil {
+		w.coordinator.Register("retry", w.retryLoop)
+		w.coordinator.Register("reaper", w.reapLoop)
+	} else {
+		w.loops.Add(2)
+		go func() {
+			defer w.loops.Done()
+			w.retryLoop(ctx)
+		}()
+		go func() {
+			defer w.loops.Done()
+			w.reapLoop(ctx)
+		}()
+	}
+
+	// Start pool sizing
+	if w.sizer != nil {
+		w.loops.Add(1)
+		go w.adaptLoop()
+	}
 
 	// Start metrics
-	w.wg.Add(1)
+	w.loops.Add(1)
 	go w.metrics(ctx)
 
 	return nil
 }
 
-// Stop gracefully shuts down
-func (w *Worker) Stop() error {
+// Stop shuts down in phases: stop polling, give back claims that never
+// reached a sender, let in-flight sends finish and write their results back.
+// If ctx expires first, Stop returns its error; sends still in flight keep
+// their lease and are recovered by the reaper rather than sent twice.
+func (w *Worker) Stop(ctx context.Context) error {
+	// Phase 1: claim nothing new
 	close(w.stop)
-	w.wg.Wait()
+	if err := wait(ctx, w.loops.Wait); err != nil {
+		w.logger.Error("Timed out stopping poller", "error", err)
+		return err
+	}
+
+	// Phase 2: senders finish the message in hand and take no more, so
+	// whatever is still buffered has not been sent and can be given back
+	close(w.halt)
+	w.shared.Resize(0)
+	if err := w.release(ctx, w.jobs.Drain()); err != nil {
+		return err
+	}
+
+	// Phase 3: wait for in-flight sends and their results to reach the DB
+	if err := wait(ctx, func() { <-w.done }); err != nil {
+		w.logger.Error("Timed out waiting for in-flight sends", "error", err)
+		return err
+	}
+	w.logger.Info("Worker stopped", "processed", atomic.LoadInt64(&w.processed),
+		"failed", atomic.LoadInt64(&w.failed))
+	return nil
+}
+
+// release gives claims back to the queue without counting an attempt
+func (w *Worker) release(ctx context.Context, msgs []*messages.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.ID
+	}
+	released, err := w.queue.Release(ctx, ids)
+	if err != nil {
+		w.logger.Error("Failed to release claims", "error", err, "pending", len(ids))
+		return err
+	}
+	w.logger.Info("Released unprocessed claims", "count", released)
 	return nil
 }
 
+// wait runs fn, which blocks until some work is done, giving up when ctx expires
+func wait(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // poll continuously fetches messages from database
 func (w *Worker) poll(ctx context.Context) {
-	defer w.wg.Done()
-	ticker := time.NewTicker(50 * time.Millisecond) // Fast polling
+	defer w.loops.Done()
+	ticker := time.NewTicker(w.pollInterval)
 	defer ticker.Stop()
 
 	for {
@@ -105,17 +330,21 @@ func (w *Worker) poll(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			msgs, err := w.queue.Poll(ctx, 20) // Batch size
+			limit := min(w.batchSize, w.jobs.Free())
+			if limit == 0 {
+				continue
+			}
+			msgs, err := w.queue.Poll(ctx, limit)
 			if err != nil {
 				w.logger.Error("Poll failed", "error", err)
 				continue
 			}
 
-			// Send to workers via channels
-			for _, msg := range msgs {
-				select {
-				case w.jobs <- msg:
-				case <-w.stop:
+			// Hand over to the fair scheduler
+			for i, msg := range msgs {
+				if !w.jobs.Push(msg, w.stop) {
+					// Shutting down: the rest of the batch was never buffered
+					w.release(context.Background(), msgs[i:])
 					return
 				}
 			}
@@ -123,71 +352,168 @@ func (w *Worker) poll(ctx context.Context) {
 	}
 }
 
-// worker processes individual messages
-func (w *Worker) worker(ctx context.Context) {
-	defer w.wg.Done()
+// worker processes individual messages of the given priority classes (nil
+// for any) until stop is closed
+func (w *Worker) worker(ctx context.Context, classes []messages.Priority, stop <-chan struct{}) {
+	defer w.senders.Done()
 
 	for {
-		select {
-		case <-w.stop:
+		msg, ok := w.jobs.Next(classes, stop)
+		if !ok {
 			return
-		case msg := <-w.jobs:
-			// Send SMS
-			mockMsg := &mock.Message{
-				ID:         msg.ID,
-				ToMSISDN:   msg.To,
-				FromSender: msg.From,
-				Text:       msg.Text,
-			}
-			providerResult := w.provider.SendSMS(ctx, mockMsg)
+		}
+		if classes == nil {
+			atomic.AddInt64(&w.busy, 1)
+		}
 
-			// Send result via channel
-			success := providerResult.Status == mock.StatusSent
-			var err error
-			if providerResult.Error != nil {
-				err = providerResult.Error
-			}
+		// Send SMS
+		mockMsg := &mock.Message{
+			ID:         msg.ID,
+			ToMSISDN:   msg.To,
+			FromSender: msg.From,
+			Text:       msg.Text,
+			Parts:      msg.Parts,
+		}
+		// The send joins the trace of the request that queued the message
+		msgCtx := logging.WithClientID(logging.WithMessageID(ctx, msg.ID), msg.ClientID)
+		sendCtx, span := tracing.Start(tracing.WithTraceParent(msgCtx, msg.TraceParent), "sms.send",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("sms.message_id", msg.ID.String()),
+				attribute.String("sms.provider", w.provider.GetName()),
+				attribute.String("sms.priority", string(msg.Priority)),
+				attribute.Int("sms.parts", msg.Parts),
+				attribute.Int("sms.attempt", msg.Attempts+1),
+			))
+		sendStart := time.Now()
+		providerResult := w.provider.SendSMS(sendCtx, mockMsg)
+		w.latency.Observe(time.Since(sendStart))
+		span.SetAttributes(attribute.String("sms.provider_message_id", providerResult.ProviderMessageID))
+		tracing.End(span, providerResult.Error)
 
-			select {
-			case w.results <- result{id: msg.ID, success: success, err: err}:
-			case <-w.stop:
-				return
-			}
+		// Send result via channel
+		success := providerResult.Status == mock.StatusSent
+		outcome := "sent"
+		if !success {
+			outcome = "failed"
+		}
+		metrics.SendDuration.WithLabelValues(w.provider.GetName(), outcome).Observe(time.Since(sendStart).Seconds())
+		if metrics.ClientLabels() {
+			metrics.ClientSends.WithLabelValues(msg.ClientID.String(), outcome).Inc()
+		}
+		var err error
+		if providerResult.Error != nil {
+			err = providerResult.Error
+		}
+
+		// Never drop a result: the send has happened and must be recorded
+		w.results <- result{msg: msg, success: success, err: err, providerID: providerResult.ProviderMessageID, partIDs: providerResult.PartIDs}
+		if classes == nil {
+			atomic.AddInt64(&w.busy, -1)
 		}
 	}
 }
 
-// processResults handles job results
+// processResults writes job results back until the senders have all exited
 func (w *Worker) processResults(ctx context.Context) {
-	defer w.wg.Done()
+	defer close(w.done)
+
+	for res := range w.results {
+		// Logs about the message carry its ID and client
+		msgCtx := logging.WithClientID(logging.WithMessageID(ctx, res.msg.ID), res.msg.ClientID)
+		if res.success {
+			w.complete(msgCtx, res.msg, res.providerID, res.partIDs)
+			atomic.AddInt64(&w.processed, 1)
+		} else {
+			w.fail(msgCtx, res.msg, res.providerID, res.err)
+			atomic.AddInt64(&w.failed, 1)
+		}
+	}
+}
+
+// complete records a successful send and captures its credits
+func (w *Worker) complete(ctx context.Context, msg *messages.Message, providerID string, partIDs []string) {
+	attempt := w.attempt(providerID)
+	attempt.PartIDs = partIDs
+	if err := w.queue.Complete(ctx, msg.ID, attempt); err != nil {
+		// With the lease lost the message stays with whoever holds it now, and so do its credits
+		w.logger.ErrorContext(ctx, "Failed to record send", "error", err)
+		return
+	}
+	w.settle(ctx, msg.ID, messages.StatusSending, messages.StatusSent)
+}
+
+// attempt describes a send through this worker's provider
+func (w *Worker) attempt(providerID string) queue.Attempt {
+	return queue.Attempt{Provider: w.provider.GetName(), ProviderMessageID: providerID}
+}
+
+// settle applies the side effect of a transition the queue has made
+func (w *Worker) settle(ctx context.Context, messageID uuid.UUID, from, to messages.Status) {
+	// Sends settle whole messages; part refunds only follow delivery reports
+	if err := messages.Settle(ctx, w.billing, messageID, from, to, 0, 0); err != nil {
+		w.logger.ErrorContext(ctx, "Failed to settle credits", "error", err, "message", messageID, "from", from, "to", to)
+	}
+}
+
+// fail applies the retry policy for the error's class to a failed send
+func (w *Worker) fail(ctx context.Context, msg *messages.Message, providerID string, err error) {
+	if err == nil {
+		err = fmt.Errorf("send failed")
+	}
+	class := retry.Classify(err)
+	policy := w.policies.For(class, retry.Scope{
+		Priority: string(msg.Priority),
+		Provider: w.provider.GetName(),
+		ClientID: msg.ClientID,
+	})
+	decision := policy.Decide(msg.Attempts+1, retry.SuggestedDelay(err))
+
+	if err := w.queue.Fail(ctx, msg.ID, w.attempt(providerID), err.Error(), decision); err != nil {
+		w.logger.ErrorContext(ctx, "Failed to record send failure", "error", err)
+		return
+	}
+	to := messages.StatusFailedPerm
+	if decision.Retry {
+		to = messages.StatusFailedTemp
+		metrics.Retries.WithLabelValues(w.provider.GetName(), string(class)).Inc()
+	}
+	w.settle(ctx, msg.ID, messages.StatusSending, to)
+}
+
+// weightsLoop keeps the per-client scheduling weights in sync with the clients table
+func (w *Worker) weightsLoop(ctx context.Context) {
+	defer w.loops.Done()
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
 
 	for {
+		weights, err := w.queue.Weights(ctx)
+		if err != nil {
+			w.logger.Error("Failed to load client weights", "error", err)
+		} else {
+			w.weights.Store(&weights)
+		}
+
 		select {
 		case <-w.stop:
 			return
-		case res := <-w.results:
-			if res.success {
-				w.queue.Complete(ctx, res.id)
-				w.billing.CaptureCredits(ctx, res.id)
-				atomic.AddInt64(&w.processed, 1)
-			} else {
-				w.queue.Fail(ctx, res.id, res.err.Error())
-				atomic.AddInt64(&w.failed, 1)
-			}
+		case <-ticker.C:
 		}
 	}
 }
 
-// retryLoop handles message retries
+// retryLoop handles message retries until the worker stops or ctx is cancelled
 func (w *Worker) retryLoop(ctx context.Context) {
-	defer w.wg.Done()
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(w.retryInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-w.stop:
 			return
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
 			count, _ := w.queue.Retry(ctx)
 			if count > 0 {
@@ -197,9 +523,107 @@ func (w *Worker) retryLoop(ctx context.Context) {
 	}
 }
 
+// reapLoop recovers messages whose lease expired, e.g. after a worker crash
+func (w *Worker) reapLoop(ctx context.Context) {
+	ticker := time.NewTicker(w.reapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			maxAttempts := w.policies.For(retry.ClassTimeout, retry.Scope{Provider: w.provider.GetName()}).MaxAttempts
+			reaped, err := w.queue.Reap(ctx, maxAttempts)
+			if err != nil {
+				w.logger.Error("Reap failed", "error", err)
+				continue
+			}
+			for _, r := range reaped {
+				w.settle(ctx, r.MessageID, messages.StatusSending, r.Status)
+			}
+			if len(reaped) > 0 {
+				w.logger.Info("Reaped expired leases", "count", len(reaped))
+			}
+		}
+	}
+}
+
+// adaptLoop resizes the shared senders to the buffered backlog and provider latency
+func (w *Worker) adaptLoop() {
+	defer w.loops.Done()
+	ticker := time.NewTicker(w.adaptInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.adapt()
+		}
+	}
+}
+
+// adapt makes one sizing decision and publishes what it was based on
+func (w *Worker) adapt() {
+	current := w.shared.Size()
+	buffered := w.jobs.Len()
+	busy := int(atomic.LoadInt64(&w.busy))
+	latency := w.latency.Value()
+
+	metrics.WorkerBusySenders.Set(float64(busy))
+	metrics.WorkerBuffered.Set(float64(buffered))
+	metrics.WorkerLatency.Set(latency.Seconds())
+
+	target := w.sizer.next(current, buffered, busy, latency)
+	metrics.WorkerSenders.Set(float64(target))
+	if target == current {
+		return
+	}
+	if target > current {
+		atomic.AddInt64(&w.scaleUps, 1)
+		metrics.WorkerResizes.WithLabelValues("up").Inc()
+	} else {
+		atomic.AddInt64(&w.scaleDowns, 1)
+		metrics.WorkerResizes.WithLabelValues("down").Inc()
+	}
+	w.shared.Resize(target)
+	w.logger.Info("Resized sender pool", "from", current, "to", target,
+		"buffered", buffered, "busy", busy, "latency_ms", latency.Milliseconds())
+}
+
+// Stats is a snapshot of the worker's counters and pool sizing
+type Stats struct {
+	Processed  int64
+	Failed     int64
+	Senders    int   // Shared senders running
+	Busy       int   // Shared senders currently sending
+	Buffered   int   // Claimed messages waiting for a sender
+	ScaleUps   int64 // Adaptive decisions that grew the pool
+	ScaleDowns int64 // Adaptive decisions that shrank the pool
+	Latency    time.Duration
+}
+
+// Stats returns the current counters
+func (w *Worker) Stats() Stats {
+	return Stats{
+		Processed:  atomic.LoadInt64(&w.processed),
+		Failed:     atomic.LoadInt64(&w.failed),
+		Senders:    w.shared.Size(),
+		Busy:       int(atomic.LoadInt64(&w.busy)),
+		Buffered:   w.jobs.Len(),
+		ScaleUps:   atomic.LoadInt64(&w.scaleUps),
+		ScaleDowns: atomic.LoadInt64(&w.scaleDowns),
+		Latency:    w.latency.Value(),
+	}
+}
+
 // metrics reports performance
 func (w *Worker) metrics(ctx context.Context) {
-	defer w.wg.Done()
+	defer w.loops.Done()
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
@@ -208,16 +632,21 @@ func (w *Worker) metrics(ctx context.Context) {
 		case <-w.stop:
 			return
 		case <-ticker.C:
-			processed := atomic.LoadInt64(&w.processed)
-			failed := atomic.LoadInt64(&w.failed)
-			total := processed + failed
+			stats := w.Stats()
+			total := stats.Processed + stats.Failed
 
 			if total > 0 {
-				successRate := float64(processed) / float64(total) * 100
+				successRate := float64(stats.Processed) / float64(total) * 100
 				w.logger.Info("Worker Stats",
-					"processed", processed,
-					"failed", failed,
-					"success_rate", successRate)
+					"processed", stats.Processed,
+					"failed", stats.Failed,
+					"success_rate", successRate,
+					"senders", stats.Senders,
+					"busy", stats.Busy,
+					"buffered", stats.Buffered,
+					"scale_ups", stats.ScaleUps,
+					"scale_downs", stats.ScaleDowns,
+					"latency_ms", stats.Latency.Milliseconds())
 			}
 		}
 	}
@@ -0,0 +1,382 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sms-gateway/internal/billing"
+	"sms-gateway/internal/config"
+	"sms-gateway/internal/leader"
+	"sms-gateway/internal/messages"
+	"sms-gateway/internal/providers/mock"
+	"sms-gateway/internal/queue"
+	"sms-gateway/internal/tracing"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+type testEnv struct {
+	store  *messages.MemoryStore
+	table  *queue.MemoryTable
+	ledger *billing.MemoryService
+	client uuid.UUID
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	env := &testEnv{
+		store:  messages.NewMemoryStore(),
+		ledger: billing.NewMemoryService(),
+		client: uuid.New(),
+	}
+	env.table = queue.NewMemoryTable(env.store)
+	env.store.AddClient(env.client)
+	env.ledger.SetCredits(env.client, 1000)
+	return env
+}
+
+// enqueue creates n QUEUED messages with credits held, as the API does
+func (e *testEnv) enqueue(t *testing.T, n int) []uuid.UUID {
+	t.Helper()
+	ctx := context.Background()
+	var ids []uuid.UUID
+	for i := 0; i < n; i++ {
+		msg := newTestMessage(e.client, messages.PriorityStandard)
+		msg.Status = messages.StatusQueued
+		if err := e.store.Create(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.ledger.HoldCredits(ctx, e.client, msg.ID, 5); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		WorkerLeaseTTL:     time.Minute,
+		WorkerReapInterval: time.Second,
+		RetryInterval:      10 * time.Millisecond,
+	}
+}
+
+func startTestWorker(t *testing.T, env *testEnv, provider *mock.Provider) *Worker {
+	t.Helper()
+	return startTestWorkerWithConfig(t, env, provider, testConfig())
+}
+
+func startTestWorkerWithConfig(t *testing.T, env *testEnv, provider *mock.Provider, cfg *config.Config) *Worker {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	w, err := New(logger, env.table.Queue("test-worker", "mock", time.Minute), env.ledger, provider, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// waitForStatus polls until every message has the given status
+func waitForStatus(t *testing.T, store *messages.MemoryStore, ids []uuid.UUID, status messages.Status) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, id := range ids {
+		for {
+			msg, err := store.GetByID(context.Background(), id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Status == status {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %s for %s, got %s", status, id, msg.Status)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestWorkerSendsAndCaptures(t *testing.T) {
+	env := newTestEnv(t)
+	ids := env.enqueue(t, 50)
+
+	w := startTestWorker(t, env, mock.NewProviderWithOptions(mock.Options{SuccessRate: 1}))
+	waitForStatus(t, env.store, ids, messages.StatusSent)
+	if err := w.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range ids {
+		locks := env.ledger.Locks(id)
+		if len(locks) != 1 || locks[0].State != "CAPTURED" {
+			t.Fatalf("Expected captured credits for %s, got %+v", id, locks)
+		}
+		// DLRs find the message by the provider's ID
+		msg, _ := env.store.GetByID(context.Background(), id)
+		if msg.ProviderMessageID == nil || *msg.ProviderMessageID == "" {
+			t.Fatalf("Expected the provider message ID to be stored for %s", id)
+		}
+		if found, err := env.store.GetByProviderID(context.Background(), *msg.ProviderMessageID); err != nil || found.ID != id {
+			t.Errorf("Expected lookup by provider ID to find %s, got %v", id, err)
+		}
+		if parts, _ := env.store.ListParts(context.Background(), id); len(parts) != max(msg.Parts, 1) || parts[0].Status != messages.StatusSent {
+			t.Errorf("Expected %d SENT parts for %s, got %d", max(msg.Parts, 1), id, len(parts))
+		}
+	}
+	if credits, _ := env.ledger.GetCredits(context.Background(), env.client); credits != 750 {
+		t.Errorf("Expected 750 credits left, got %d", credits)
+	}
+
+	// The history shows the claim and the send, with who did what
+	events, _ := env.store.ListEvents(context.Background(), ids[0])
+	if len(events) != 2 {
+		t.Fatalf("Expected claimed and sent events, got %+v", events)
+	}
+	claimed, sent := events[0], events[1]
+	if claimed.Event != messages.EventClaimed || *claimed.FromStatus != messages.StatusQueued || claimed.Actor != "test-worker" {
+		t.Errorf("Unexpected claim event %+v", claimed)
+	}
+	if sent.Event != messages.EventSent || sent.Status != messages.StatusSent || sent.Provider == nil || *sent.Provider != "mock" {
+		t.Errorf("Unexpected send event %+v", sent)
+	}
+}
+
+func TestWorkerDefaultsIntervals(t *testing.T) {
+	env := newTestEnv(t)
+	ids := env.enqueue(t, 5)
+
+	// Unset or negative intervals would make the tickers panic on Start
+	cfg := &config.Config{WorkerLeaseTTL: time.Minute, WorkerReapInterval: -time.Second}
+	w := startTestWorkerWithConfig(t, env, mock.NewProviderWithOptions(mock.Options{SuccessRate: 1}), cfg)
+	if w.reapInterval != 15*time.Second || w.retryInterval != time.Second {
+		t.Errorf("Expected default reap and retry intervals, got %v and %v", w.reapInterval, w.retryInterval)
+	}
+	waitForStatus(t, env.store, ids, messages.StatusSent)
+	if err := w.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWorkerSendJoinsMessageTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	env := newTestEnv(t)
+
+	// Queued inside an API request's span
+	ctx, request := tracing.Start(context.Background(), "POST /v1/messages")
+	request.End()
+	msg := newTestMessage(env.client, messages.PriorityStandard)
+	msg.Status = messages.StatusQueued
+	msg.TraceParent = tracing.TraceParent(ctx)
+	if err := env.store.Create(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	ids := []uuid.UUID{msg.ID}
+
+	w := startTestWorker(t, env, mock.NewProviderWithOptions(mock.Options{SuccessRate: 1}))
+	waitForStatus(t, env.store, ids, messages.StatusSent)
+	if err := w.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, span := range recorder.Ended() {
+		if span.Name() == "sms.send" {
+			if span.Parent().SpanID() != request.SpanContext().SpanID() {
+				t.Errorf("Expected the send to be a child of the request span")
+			}
+			return
+		}
+	}
+	t.Error("Expected an sms.send span")
+}
+
+func TestWorkerPermanentFailureReleases(t *testing.T) {
+	env := newTestEnv(t)
+	ids := env.enqueue(t, 10)
+
+	w := startTestWorker(t, env, mock.NewProviderWithOptions(mock.Options{}))
+	waitForStatus(t, env.store, ids, messages.StatusFailedPerm)
+	if err := w.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range ids {
+		locks := env.ledger.Locks(id)
+		if len(locks) != 1 || locks[0].State != "RELEASED" {
+			t.Fatalf("Expected released credits for %s, got %+v", id, locks)
+		}
+	}
+	if credits, _ := env.ledger.GetCredits(context.Background(), env.client); credits != 1000 {
+		t.Errorf("Expected all 1000 credits back, got %d", credits)
+	}
+}
+
+// sendCounter records how often the provider was asked to send each message
+type sendCounter struct {
+	mu    sync.Mutex
+	sends map[uuid.UUID]int
+}
+
+func (c *sendCounter) record(msg *mock.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sends[msg.ID]++
+}
+
+func (c *sendCounter) count(id uuid.UUID) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sends[id]
+}
+
+func TestWorkerStopFinishesInFlight(t *testing.T) {
+	env := newTestEnv(t)
+	ids := env.enqueue(t, 150)
+	sends := &sendCounter{sends: make(map[uuid.UUID]int)}
+	provider := mock.NewProviderWithOptions(mock.Options{SuccessRate: 1, Latency: 100 * time.Millisecond, OnSend: sends.record})
+
+	// Stop while sends are in flight and more claims are buffered
+	w := startTestWorker(t, env, provider)
+	time.Sleep(150 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every message is either sent and charged, or back in the queue untouched
+	var sent, queued int
+	for _, id := range ids {
+		msg, _ := env.store.GetByID(context.Background(), id)
+		locks := env.ledger.Locks(id)
+		switch msg.Status {
+		case messages.StatusSent:
+			sent++
+			if sends.count(id) != 1 || locks[0].State != "CAPTURED" {
+				t.Errorf("Sent message %s: %d sends, lock %s", id, sends.count(id), locks[0].State)
+			}
+		case messages.StatusQueued:
+			queued++
+			if sends.count(id) != 0 || locks[0].State != "HELD" || msg.Attempts != 0 {
+				t.Errorf("Requeued message %s: %d sends, lock %s, %d attempts", id, sends.count(id), locks[0].State, msg.Attempts)
+			}
+		default:
+			t.Errorf("Message %s left in %s after shutdown", id, msg.Status)
+		}
+	}
+	if sent == 0 || queued == 0 {
+		t.Fatalf("Expected shutdown mid-batch, got %d sent and %d queued", sent, queued)
+	}
+
+	// A fresh worker picks up exactly what was given back
+	w = startTestWorker(t, env, provider)
+	waitForStatus(t, env.store, ids, messages.StatusSent)
+	if err := w.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if n := sends.count(id); n != 1 {
+			t.Errorf("Expected message %s to be sent once, got %d", id, n)
+		}
+	}
+}
+
+func TestWorkerStopDeadline(t *testing.T) {
+	env := newTestEnv(t)
+	ids := env.enqueue(t, 5)
+	provider := mock.NewProviderWithOptions(mock.Options{SuccessRate: 1, Latency: time.Second})
+
+	w := startTestWorker(t, env, provider)
+	waitForStatus(t, env.store, ids, messages.StatusSending)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := w.Stop(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+
+	// In-flight sends keep their lease for the reaper instead of being requeued
+	for _, id := range ids {
+		if owner := env.table.LockedBy(id); owner != "test-worker" {
+			t.Errorf("Expected %s to stay leased to test-worker, got %q", id, owner)
+		}
+	}
+}
+
+func TestWorkerSingletonJobsRunOnLeader(t *testing.T) {
+	env := newTestEnv(t)
+	ids := env.enqueue(t, 10)
+
+	cfg := testConfig()
+	cfg.RetryPolicies = `{"default":{"network":{"base":"1ms","cap":"1ms","max_attempts":3}}}`
+	provider := mock.NewProviderWithOptions(mock.Options{TempFailRate: 1})
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	locker := leader.NewMemoryLocker()
+
+	// Two replicas share the queue; only the elected one may requeue retries
+	var workers []*Worker
+	var coords []*leader.Coordinator
+	for i := 0; i < 2; i++ {
+		id := fmt.Sprintf("replica-%d", i)
+		coord := leader.NewCoordinator(logger, locker.Session(), id, 10*time.Millisecond)
+		w, err := New(logger, env.table.Queue(id, "mock", time.Minute), env.ledger, provider, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Coordinate(coord)
+		if err := w.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		coord.Start()
+		workers = append(workers, w)
+		coords = append(coords, coord)
+	}
+
+	// Without a retry loop running somewhere, messages would stay FAILED_TEMP
+	waitForStatus(t, env.store, ids, messages.StatusFailedPerm)
+
+	// Each attempt is in the history: claim, fail, retry, ... final failure
+	var sequence []string
+	events, _ := env.store.ListEvents(context.Background(), ids[0])
+	for _, ev := range events {
+		sequence = append(sequence, ev.Event+":"+string(ev.Status))
+	}
+	expected := "claimed:SENDING failed:FAILED_TEMP retry_due:QUEUED claimed:SENDING failed:FAILED_TEMP retry_due:QUEUED claimed:SENDING failed:FAILED_PERM"
+	if got := strings.Join(sequence, " "); got != expected {
+		t.Errorf("Expected history %q, got %q", expected, got)
+	}
+
+	leaders := 0
+	for _, c := range coords {
+		if c.IsLeader("retry") {
+			leaders++
+		}
+	}
+	if leaders != 1 {
+		t.Errorf("Expected one retry leader, got %d", leaders)
+	}
+
+	for i, w := range workers {
+		coords[i].Stop()
+		if err := w.Stop(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,4 @@
+-- Remove lease columns and related indexes
+DROP INDEX IF EXISTS idx_messages_lease_expires_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS locked_by;
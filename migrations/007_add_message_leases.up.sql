@@ -0,0 +1,10 @@
+-- Add lease columns so claims by crashed workers can be recovered
+ALTER TABLE messages ADD COLUMN locked_by text;
+ALTER TABLE messages ADD COLUMN lease_expires_at timestamptz;
+
+-- Rows claimed before leases existed are expired immediately so the reaper picks them up
+UPDATE messages SET lease_expires_at = NOW() WHERE status = 'SENDING';
+
+-- Add index for efficient lease reaping
+CREATE INDEX idx_messages_lease_expires_at ON messages (lease_expires_at)
+WHERE status = 'SENDING';
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_messages_failed_perm;
+DROP INDEX IF EXISTS idx_message_events_message_id;
+DROP TABLE IF EXISTS message_events;
@@ -0,0 +1,16 @@
+-- Append-only history of what happened to each message
+CREATE TABLE message_events (
+    id bigserial PRIMARY KEY,
+    message_id uuid NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    event text NOT NULL,
+    status text NOT NULL,
+    actor text NOT NULL,
+    detail text,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_message_events_message_id ON message_events (message_id, id);
+
+-- Add index for dead-letter inspection
+CREATE INDEX idx_messages_failed_perm ON messages (updated_at DESC)
+WHERE status = 'FAILED_PERM';
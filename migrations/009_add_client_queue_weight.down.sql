@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_messages_client_queue;
+ALTER TABLE clients DROP COLUMN IF EXISTS queue_weight;
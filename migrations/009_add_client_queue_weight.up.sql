@@ -0,0 +1,6 @@
+-- Per-client share of worker capacity for fair queue scheduling
+ALTER TABLE clients ADD COLUMN queue_weight int NOT NULL DEFAULT 1 CHECK (queue_weight > 0);
+
+-- Add index for per-client fair polling with express priority
+CREATE INDEX idx_messages_client_queue ON messages (client_id, express DESC, created_at ASC)
+WHERE status = 'QUEUED';
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_messages_client_queue;
+CREATE INDEX idx_messages_client_queue ON messages (client_id, express DESC, created_at ASC)
+WHERE status = 'QUEUED';
+ALTER TABLE messages DROP COLUMN IF EXISTS priority_rank;
+ALTER TABLE messages DROP COLUMN IF EXISTS priority;
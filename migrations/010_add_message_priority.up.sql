@@ -0,0 +1,22 @@
+-- Priority classes replace the express flag for scheduling
+ALTER TABLE messages ADD COLUMN priority text NOT NULL DEFAULT 'standard'
+    CHECK (priority IN ('otp', 'transactional', 'express', 'standard', 'bulk'));
+
+-- Lower rank is served first; derived so the poll query can use an index
+ALTER TABLE messages ADD COLUMN priority_rank smallint GENERATED ALWAYS AS (
+    CASE priority
+        WHEN 'otp' THEN 0
+        WHEN 'transactional' THEN 1
+        WHEN 'express' THEN 2
+        WHEN 'standard' THEN 3
+        ELSE 4
+    END
+) STORED;
+
+-- Existing express messages map onto the express class
+UPDATE messages SET priority = 'express' WHERE express = true;
+
+-- Replace the express-ordered polling index with a priority-ordered one
+DROP INDEX IF EXISTS idx_messages_client_queue;
+CREATE INDEX idx_messages_client_queue ON messages (client_id, priority_rank ASC, created_at ASC)
+WHERE status = 'QUEUED';
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS trg_messages_enqueue_outbox ON messages;
+DROP FUNCTION IF EXISTS messages_enqueue_outbox();
+DROP TABLE IF EXISTS queue_settings;
+DROP TABLE IF EXISTS queue_outbox;
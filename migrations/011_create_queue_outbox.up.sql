@@ -0,0 +1,30 @@
+-- Transactional outbox feeding the JetStream queue backend
+CREATE TABLE queue_outbox (
+    id bigserial PRIMARY KEY,
+    message_id uuid NOT NULL,
+    priority text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- Single-row switch; the JetStream backend turns the outbox on at startup
+CREATE TABLE queue_settings (
+    id boolean PRIMARY KEY DEFAULT true CHECK (id),
+    outbox_enabled boolean NOT NULL DEFAULT false
+);
+INSERT INTO queue_settings (id) VALUES (true);
+
+-- Every transition into QUEUED writes an outbox row in the same transaction
+CREATE FUNCTION messages_enqueue_outbox() RETURNS trigger AS $$
+BEGIN
+    IF NEW.status = 'QUEUED'
+       AND (TG_OP = 'INSERT' OR OLD.status IS DISTINCT FROM 'QUEUED')
+       AND (SELECT outbox_enabled FROM queue_settings) THEN
+        INSERT INTO queue_outbox (message_id, priority) VALUES (NEW.id, NEW.priority);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_messages_enqueue_outbox
+AFTER INSERT OR UPDATE OF status ON messages
+FOR EACH ROW EXECUTE FUNCTION messages_enqueue_outbox();
@@ -0,0 +1,4 @@
+ALTER TABLE message_events
+    DROP COLUMN IF EXISTS payload,
+    DROP COLUMN IF EXISTS provider,
+    DROP COLUMN IF EXISTS from_status;
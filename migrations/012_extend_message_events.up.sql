@@ -0,0 +1,5 @@
+-- Record the full transition and what the provider told us
+ALTER TABLE message_events
+    ADD COLUMN from_status text,
+    ADD COLUMN provider text,
+    ADD COLUMN payload jsonb;
@@ -0,0 +1,2 @@
+-- Drop the DLR log
+DROP TABLE IF EXISTS delivery_reports;
@@ -0,0 +1,16 @@
+-- Every DLR we receive, once per provider ID and status. Reports that arrive
+-- before their provider ID is stored wait here unapplied until it appears.
+CREATE TABLE delivery_reports (
+    id bigserial PRIMARY KEY,
+    provider_message_id text NOT NULL,
+    status text NOT NULL,
+    reason text,
+    payload jsonb,
+    message_id uuid REFERENCES messages(id) ON DELETE CASCADE,
+    outcome text,
+    received_at timestamptz NOT NULL DEFAULT now(),
+    applied_at timestamptz,
+    UNIQUE (provider_message_id, status)
+);
+
+CREATE INDEX idx_delivery_reports_pending ON delivery_reports (received_at) WHERE applied_at IS NULL;
@@ -0,0 +1,9 @@
+-- Back to message-level delivery status
+ALTER TABLE credit_locks DROP COLUMN IF EXISTS refunded_cents;
+
+UPDATE messages SET status = 'DELIVERED' WHERE status = 'PARTIALLY_DELIVERED';
+ALTER TABLE messages DROP CONSTRAINT messages_status_check;
+ALTER TABLE messages ADD CONSTRAINT messages_status_check
+    CHECK (status IN ('QUEUED', 'SENDING', 'SENT', 'DELIVERED', 'FAILED_TEMP', 'FAILED_PERM', 'CANCELLED'));
+
+DROP TABLE IF EXISTS message_parts;
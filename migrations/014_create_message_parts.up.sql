@@ -0,0 +1,19 @@
+-- One row per submitted segment; a multipart message's status is derived from its parts
+CREATE TABLE message_parts (
+    message_id uuid NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    part_no int NOT NULL CHECK (part_no > 0),
+    provider_message_id text NOT NULL,
+    status text NOT NULL,
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (message_id, part_no)
+);
+
+CREATE INDEX idx_message_parts_provider_message_id ON message_parts (provider_message_id);
+
+-- Some parts delivered, the rest failed permanently
+ALTER TABLE messages DROP CONSTRAINT messages_status_check;
+ALTER TABLE messages ADD CONSTRAINT messages_status_check
+    CHECK (status IN ('QUEUED', 'SENDING', 'SENT', 'DELIVERED', 'PARTIALLY_DELIVERED', 'FAILED_TEMP', 'FAILED_PERM', 'CANCELLED'));
+
+-- Failed parts are refunded from the captured amount
+ALTER TABLE credit_locks ADD COLUMN refunded_cents bigint NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+-- Drop the raw DLR values
+ALTER TABLE delivery_reports
+    DROP COLUMN IF EXISTS error_class,
+    DROP COLUMN IF EXISTS error_code,
+    DROP COLUMN IF EXISTS raw_status,
+    DROP COLUMN IF EXISTS provider;
@@ -0,0 +1,6 @@
+-- Keep what the provider actually sent next to the normalized status
+ALTER TABLE delivery_reports
+    ADD COLUMN provider text,
+    ADD COLUMN raw_status text,
+    ADD COLUMN error_code text,
+    ADD COLUMN error_class text;
@@ -0,0 +1,2 @@
+-- Drop the stored trace context
+ALTER TABLE messages DROP COLUMN IF EXISTS trace_parent;
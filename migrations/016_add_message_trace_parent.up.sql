@@ -0,0 +1,2 @@
+-- W3C traceparent of the request that created the message, so sends and DLRs join its trace
+ALTER TABLE messages ADD COLUMN trace_parent text;
@@ -0,0 +1,4 @@
+-- Drop operator pauses and overview indexes
+DROP INDEX IF EXISTS idx_delivery_reports_errors;
+DROP INDEX IF EXISTS idx_message_events_activity;
+DROP TABLE IF EXISTS send_pauses;
@@ -0,0 +1,18 @@
+-- Operator pauses, checked by every worker's claim query. target is empty
+-- for the global scope, a client ID or a provider name otherwise.
+CREATE TABLE send_pauses (
+    scope text NOT NULL CHECK (scope IN ('global', 'client', 'provider')),
+    target text NOT NULL DEFAULT '',
+    mode text NOT NULL DEFAULT 'pause' CHECK (mode IN ('pause', 'drain')),
+    reason text,
+    actor text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (scope, target)
+);
+
+-- Recent activity for the operator overview
+CREATE INDEX idx_message_events_activity ON message_events (created_at)
+WHERE event IN ('sent', 'failed', 'delivery_report');
+
+CREATE INDEX idx_delivery_reports_errors ON delivery_reports (received_at)
+WHERE error_code IS NOT NULL;
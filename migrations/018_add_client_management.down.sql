@@ -0,0 +1,8 @@
+-- Drop client management columns
+ALTER TABLE clients
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS api_key_rotated_at,
+    DROP COLUMN IF EXISTS previous_api_key_expires_at,
+    DROP COLUMN IF EXISTS previous_api_key_hash,
+    DROP COLUMN IF EXISTS suspended,
+    DROP COLUMN IF EXISTS credit_limit_cents;
@@ -0,0 +1,8 @@
+-- Admin-managed clients: credit limit, suspension and API key rotation
+ALTER TABLE clients
+    ADD COLUMN credit_limit_cents bigint NOT NULL DEFAULT 0 CHECK (credit_limit_cents >= 0),
+    ADD COLUMN suspended boolean NOT NULL DEFAULT false,
+    ADD COLUMN previous_api_key_hash text UNIQUE,
+    ADD COLUMN previous_api_key_expires_at timestamptz,
+    ADD COLUMN api_key_rotated_at timestamptz,
+    ADD COLUMN updated_at timestamptz NOT NULL DEFAULT now();
@@ -0,0 +1,16 @@
+-- Restore one key column per client from its oldest active key
+ALTER TABLE clients
+    ADD COLUMN api_key_hash text UNIQUE,
+    ADD COLUMN previous_api_key_hash text UNIQUE,
+    ADD COLUMN previous_api_key_expires_at timestamptz,
+    ADD COLUMN api_key_rotated_at timestamptz;
+
+UPDATE clients c SET api_key_hash = COALESCE((
+    SELECT k.key_hash FROM api_keys k
+    WHERE k.client_id = c.id AND k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > now())
+    ORDER BY k.created_at LIMIT 1
+), 'unset-' || c.id::text);
+
+ALTER TABLE clients ALTER COLUMN api_key_hash SET NOT NULL;
+
+DROP TABLE IF EXISTS api_keys;
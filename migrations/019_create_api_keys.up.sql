@@ -0,0 +1,32 @@
+-- Scoped API keys, several per client, replacing clients.api_key_hash
+CREATE TABLE api_keys (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    client_id uuid NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    name text NOT NULL,
+    key_hash text NOT NULL UNIQUE,
+    scopes text[] NOT NULL CHECK (cardinality(scopes) > 0),
+    allowed_ips text[] NOT NULL DEFAULT '{}', -- CIDRs; any address when empty
+    expires_at timestamptz,
+    last_used_at timestamptz,
+    revoked_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_api_keys_client ON api_keys (client_id, created_at);
+
+-- Existing keys keep working with every scope; a key still in its rotation
+-- overlap keeps its expiry
+INSERT INTO api_keys (client_id, name, key_hash, scopes, created_at)
+SELECT id, 'default', api_key_hash, '{send,read,billing}', COALESCE(api_key_rotated_at, created_at)
+FROM clients;
+
+INSERT INTO api_keys (client_id, name, key_hash, scopes, expires_at, created_at)
+SELECT id, 'default', previous_api_key_hash, '{send,read,billing}', previous_api_key_expires_at, created_at
+FROM clients
+WHERE previous_api_key_hash IS NOT NULL AND previous_api_key_expires_at > now();
+
+ALTER TABLE clients
+    DROP COLUMN api_key_hash,
+    DROP COLUMN previous_api_key_hash,
+    DROP COLUMN previous_api_key_expires_at,
+    DROP COLUMN api_key_rotated_at;
@@ -0,0 +1,12 @@
+-- Drop rate limits
+ALTER TABLE api_keys
+    DROP COLUMN IF EXISTS messages_per_month,
+    DROP COLUMN IF EXISTS messages_per_day,
+    DROP COLUMN IF EXISTS requests_per_second;
+
+ALTER TABLE clients
+    DROP COLUMN IF EXISTS messages_per_month,
+    DROP COLUMN IF EXISTS messages_per_day,
+    DROP COLUMN IF EXISTS requests_per_second;
+
+DROP TABLE IF EXISTS rate_limits;
@@ -0,0 +1,22 @@
+-- Rate limit counters shared by API replicas, and per-client and per-key limits.
+-- Counters are short-lived, so the table skips the WAL; a crash only resets them.
+CREATE UNLOGGED TABLE rate_limits (
+    key text NOT NULL,
+    window_start timestamptz NOT NULL,
+    window_end timestamptz NOT NULL,
+    count bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (key, window_start)
+);
+
+CREATE INDEX idx_rate_limits_window_end ON rate_limits (window_end);
+
+-- NULL falls back to the configured default, 0 is unlimited
+ALTER TABLE clients
+    ADD COLUMN requests_per_second bigint CHECK (requests_per_second >= 0),
+    ADD COLUMN messages_per_day bigint CHECK (messages_per_day >= 0),
+    ADD COLUMN messages_per_month bigint CHECK (messages_per_month >= 0);
+
+ALTER TABLE api_keys
+    ADD COLUMN requests_per_second bigint CHECK (requests_per_second >= 0),
+    ADD COLUMN messages_per_day bigint CHECK (messages_per_day >= 0),
+    ADD COLUMN messages_per_month bigint CHECK (messages_per_month >= 0);
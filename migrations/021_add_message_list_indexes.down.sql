@@ -0,0 +1,6 @@
+-- Restore the original listing index
+DROP INDEX IF EXISTS idx_messages_client_reference_created_id;
+DROP INDEX IF EXISTS idx_messages_client_to_created_id;
+DROP INDEX IF EXISTS idx_messages_client_status_created_id;
+DROP INDEX IF EXISTS idx_messages_client_created_id;
+CREATE INDEX idx_messages_client_id_created_at ON messages (client_id, created_at);
@@ -0,0 +1,8 @@
+-- Keyset pagination for GET /v1/messages: every listing index ends in
+-- (created_at, id) so a page is an index range scan however deep it is.
+DROP INDEX IF EXISTS idx_messages_client_id_created_at;
+CREATE INDEX idx_messages_client_created_id ON messages (client_id, created_at, id);
+CREATE INDEX idx_messages_client_status_created_id ON messages (client_id, status, created_at, id);
+CREATE INDEX idx_messages_client_to_created_id ON messages (client_id, to_msisdn, created_at, id);
+CREATE INDEX idx_messages_client_reference_created_id ON messages (client_id, client_reference, created_at, id)
+    WHERE client_reference IS NOT NULL;
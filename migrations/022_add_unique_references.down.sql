@@ -0,0 +1,4 @@
+-- Drop per-client reference uniqueness
+DROP INDEX IF EXISTS idx_messages_client_reference_unique;
+ALTER TABLE messages DROP COLUMN IF EXISTS unique_reference;
+ALTER TABLE clients DROP COLUMN IF EXISTS unique_references;
@@ -0,0 +1,7 @@
+-- Opt-in per-client uniqueness of client_reference, so a reference can serve
+-- as a natural deduplication key. Messages remember whether their client had
+-- it on when they were sent, so turning it on never conflicts with history.
+ALTER TABLE clients ADD COLUMN unique_references boolean NOT NULL DEFAULT false;
+ALTER TABLE messages ADD COLUMN unique_reference boolean NOT NULL DEFAULT false;
+CREATE UNIQUE INDEX idx_messages_client_reference_unique ON messages (client_id, client_reference)
+    WHERE unique_reference;
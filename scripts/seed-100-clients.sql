@@ -1,12 +1,11 @@
 -- Seed script to create 100 test clients for massive load testing
 -- Using a more compact approach with generate_series
 
-INSERT INTO clients (id, name, credit_cents, api_key_hash, dlr_callback_url, created_at) 
+INSERT INTO clients (id, name, credit_cents, dlr_callback_url, created_at) 
 SELECT 
     ('550e8400-e29b-41d4-a716-4466544' || LPAD(i::text, 4, '0'))::uuid,
     'Load Test Client ' || LPAD(i::text, 3, '0'),
     50000, -- 50,000 credits = $500 per client
-    'hash_load_' || LPAD(i::text, 3, '0'),
     'https://httpbin.org/post',
     NOW()
 FROM generate_series(1, 100) AS i
@@ -5,19 +5,25 @@
 INSERT INTO clients (
     id, 
     name,
-    api_key_hash,
     credit_cents,
     dlr_callback_url
 ) VALUES (
     '550e8400-e29b-41d4-a716-446655440000',
     'Demo Client',
-    'demo-api-key', -- Simple demo API key
     500000, -- 5000.00 in cents (enough for 100,000 messages at 5 cents each - ensuring 100% success)
     'https://httpbin.org/post'
 ) ON CONFLICT (id) DO UPDATE SET 
     credit_cents = 500000,
-    name = 'Demo Client',
-    api_key_hash = 'demo-api-key';
+    name = 'Demo Client';
+
+-- Demo API key "demo-api-key" with every scope
+INSERT INTO api_keys (client_id, name, key_hash, scopes)
+VALUES (
+    '550e8400-e29b-41d4-a716-446655440000',
+    'demo',
+    encode(sha256('demo-api-key'::bytea), 'hex'),
+    '{send,read,billing}'
+) ON CONFLICT (key_hash) DO NOTHING;
 
 -- Display the created client
 SELECT 